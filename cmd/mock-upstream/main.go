@@ -0,0 +1,57 @@
+// Command mock-upstream serves the energy-charts price API from generated or
+// fixture data for local development. Point the service at it with
+// -upstream-url:
+//
+//	go run ./cmd/mock-upstream -listen localhost:2003 &
+//	go run . -upstream-url http://localhost:2003
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/mockupstream"
+)
+
+func main() {
+	fs := flag.NewFlagSet("mock-upstream", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:2003", "`address` to serve on")
+	fixture := fs.String("fixture", "", "`file` of prices in the shape of the API's responses to serve instead of generated ones")
+	var cfg mockupstream.Config
+	fs.DurationVar(&cfg.Latency, "latency", 0, "`duration` to delay every response by")
+	fs.Float64Var(&cfg.ErrorRate, "error-rate", 0, "`fraction` of requests to fail, from 0 to 1")
+	fs.IntVar(&cfg.ErrorStatus, "error-status", http.StatusServiceUnavailable, "HTTP `status` of failed requests")
+	fs.DurationVar(&cfg.PublishAt, "publish-at", 13*time.Hour, "`duration` after Europe/Berlin midnight from which the next day's prices are served")
+	fs.BoolVar(&cfg.MissingTomorrow, "missing-tomorrow", false, "never serve the next day's prices")
+	fs.BoolVar(&cfg.Deprecated, "deprecated", false, "mark responses as of a deprecated API")
+	fs.StringVar(&cfg.Unit, "unit", "EUR / MWh", "`unit` of the served prices, e.g. ct / kWh")
+	fs.Parse(os.Args[1:])
+
+	if err := run(*listen, *fixture, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(listen, fixture string, cfg mockupstream.Config) error {
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return fmt.Errorf("invalid error rate %g: must be between 0 and 1", cfg.ErrorRate)
+	}
+	if fixture != "" {
+		prices, err := mockupstream.LoadFixture(fixture)
+		if err != nil {
+			return err
+		}
+		cfg.Fixture = prices
+	}
+	mock, err := mockupstream.New(cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving mock upstream on %s", listen)
+	return http.ListenAndServe(listen, mock)
+}
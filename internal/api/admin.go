@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// admin allows requests bearing the configured admin token and logs them for
+// auditing. The admin routes are only registered if a token is configured or
+// the service is read-only, which answers them with 403 whatever the token.
+func (s *server) admin(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.readOnly {
+			writeError(w, http.StatusForbidden, codeForbidden, "the admin endpoints are disabled, the service is read-only")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.adminToken)) != 1 {
+			log.Printf("admin: rejected %s %s from %s", r.Method, r.URL.RequestURI(), clientIP(r.Context()))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "missing or invalid admin token")
+			return
+		}
+		log.Printf("admin: %s %s from %s", r.Method, r.URL.RequestURI(), clientIP(r.Context()))
+		next(w, r)
+	})
+}
+
+// handleCacheInspect lists the cached slots in [start, end) together with
+// where and when they were last changed.
+func (s *server) handleCacheInspect(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseRange(r.URL.Query(), s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if !s.checkRange(w, start, end) {
+		return
+	}
+
+	writeJSON(w, s.fieldSlots(s.store.points(start, end), adminSlotFields))
+}
+
+// handleCacheDelete removes the cached slots in [start, end), which are
+// fetched again by the next refresh. Both bounds are required, and requests
+// removing more than the configured number of slots are refused.
+func (s *server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseRange(r.URL.Query(), s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if start.IsZero() || end.IsZero() {
+		writeError(w, http.StatusBadRequest, codeInvalidRange, "start and end are required")
+		return
+	}
+
+	if n := len(s.store.points(start, end)); n > s.cfg.adminMaxDelete {
+		writeError(w, http.StatusBadRequest, codeInvalidRange, fmt.Sprintf(
+			"range contains %d slots, at most %d can be removed at once", n, s.cfg.adminMaxDelete,
+		))
+		return
+	}
+	removed := s.store.remove(start, end)
+	log.Printf("admin: removed %d slots from %s to %s", removed, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+
+	writeJSON(w, struct {
+		Removed int `json:"removed"`
+	}{removed})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// timeWeightedAverage returns the mean price over [start, end), weighting
+// each slot of points by how much of the interval it covers, together with
+// the covered duration. Slots only partly inside the interval count with
+// the part inside it. The average is meaningless if covered is zero.
+func timeWeightedAverage(points []pricePoint, start, end time.Time) (avg float64, covered time.Duration) {
+	var sum float64
+	for _, p := range points {
+		from, to := p.Start, p.Start.Add(p.Duration)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if !from.Before(to) {
+			continue
+		}
+		d := to.Sub(from)
+		sum += p.Price * d.Seconds()
+		covered += d
+	}
+	if covered == 0 {
+		return 0, 0
+	}
+	return sum / covered.Seconds(), covered
+}
+
+// handleAverage reports the time-weighted average price between start and
+// end, which don't have to fall on slot boundaries.
+func (s *server) handleAverage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if start.IsZero() || end.IsZero() {
+		writeError(w, http.StatusBadRequest, codeInvalidRange, "start and end are required")
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	// The slot containing start begins up to one slot before it.
+	avg, covered := timeWeightedAverage(s.store.points(start.Add(-maxSlotDuration), end), start, end)
+	res := struct {
+		Start          time.Time `json:"start"`
+		End            time.Time `json:"end"`
+		Unit           string    `json:"unit"`
+		Average        *float64  `json:"average"`
+		CoveredSeconds float64   `json:"covered_seconds"`
+		Complete       bool      `json:"complete"`
+	}{
+		Start:          start.UTC(),
+		End:            end.UTC(),
+		Unit:           unit,
+		CoveredSeconds: covered.Seconds(),
+		Complete:       covered == end.Sub(start),
+	}
+	if covered > 0 {
+		avg /= div
+		res.Average = &avg
+	}
+	writeJSON(w, res)
+}
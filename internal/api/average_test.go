@@ -0,0 +1,88 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeWeightedAverage(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, time.January, 13, h, m, 0, 0, time.UTC) }
+	// Two quarter-hours, a gap and an hour.
+	points := []pricePoint{
+		{Start: at(0, 0), Duration: 15 * time.Minute, Price: 10},
+		{Start: at(0, 15), Duration: 15 * time.Minute, Price: 20},
+		{Start: at(1, 0), Duration: time.Hour, Price: 30},
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		avg        float64
+		covered    time.Duration
+	}{
+		{"all", at(0, 0), at(2, 0), (10*15 + 20*15 + 30*60) / 90.0, 90 * time.Minute},
+		{"slot edges", at(0, 15), at(0, 30), 20, 15 * time.Minute},
+		{"ending at a slot start", at(0, 0), at(0, 15), 10, 15 * time.Minute},
+		{"starting at a slot end", at(0, 30), at(1, 30), 30, 30 * time.Minute},
+		{"within a slot", at(1, 10), at(1, 20), 30, 10 * time.Minute},
+		{"across the gap", at(0, 5), at(1, 30), (10*10 + 20*15 + 30*30) / 55.0, 55 * time.Minute},
+		{"in the gap", at(0, 30), at(1, 0), 0, 0},
+		{"empty", at(1, 0), at(1, 0), 0, 0},
+		{"after", at(2, 0), at(3, 0), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avg, covered := timeWeightedAverage(points, tt.start, tt.end)
+			if math.Abs(avg-tt.avg) > 1e-9 || covered != tt.covered {
+				t.Errorf("average %v over %s, want %v over %s", avg, covered, tt.avg, tt.covered)
+			}
+		})
+	}
+}
+
+// TestAverage requests the averages of ranges starting and ending on and
+// between the edges of the cached slots, which are priced by their local
+// hour on the first day up to 223 at the end of the last.
+func TestAverage(t *testing.T) {
+	s := newTestServer(t, "-no-ondemand")
+	type average struct {
+		Average        *float64 `json:"average"`
+		CoveredSeconds float64  `json:"covered_seconds"`
+		Complete       bool     `json:"complete"`
+	}
+	none := math.NaN()
+	tests := []struct {
+		name   string
+		query  string
+		avg    float64
+		covers float64
+		full   bool
+	}{
+		// 13:40 to 16:10 local time, 20 minutes at 13, an hour each at 14
+		// and 15 and 10 minutes at 16.
+		{"between edges", "start=2026-01-12T12:40:00Z&end=2026-01-12T15:10:00Z", 2160.0 / 150, 9000, true},
+		{"on edges", "start=2026-01-12T12:00:00Z&end=2026-01-12T14:00:00Z", 13.5, 7200, true},
+		{"one slot", "start=2026-01-12T12:00:00Z&end=2026-01-12T13:00:00Z", 13, 3600, true},
+		{"within a slot", "start=2026-01-12T12:10:00Z&end=2026-01-12T12:20:00Z", 13, 600, true},
+		{"in ct/kWh", "start=2026-01-12T12:00:00Z&end=2026-01-12T14:00:00Z&unit=ct/kWh", 1.35, 7200, true},
+		{"starting before the cache", "start=2026-01-11T22:30:00Z&end=2026-01-11T23:30:00Z", 0, 1800, false},
+		{"ending after the cache", "start=2026-01-14T22:00:00Z&end=2026-01-15T00:00:00Z", 223, 3600, false},
+		{"uncached", "start=2026-01-20&end=2026-01-21", none, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := get(t, s, "/price/average?"+tt.query)
+			wantStatus(t, res, http.StatusOK)
+			got := decode[average](t, res)
+			if math.IsNaN(tt.avg) && got.Average != nil || !math.IsNaN(tt.avg) && (got.Average == nil || math.Abs(*got.Average-tt.avg) > 1e-9) {
+				t.Errorf("average %v, want %v", fmtAverage(got.Average), tt.avg)
+			}
+			if got.CoveredSeconds != tt.covers || got.Complete != tt.full {
+				t.Errorf("covered %vs, complete %t, want %vs and %t", got.CoveredSeconds, got.Complete, tt.covers, tt.full)
+			}
+		})
+	}
+
+	wantError(t, get(t, s, "/price/average?start=2026-01-12"), http.StatusBadRequest, codeInvalidRange, "start and end are required")
+}
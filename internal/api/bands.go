@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// defaultBandsWindow and maxBandsWindow are the default and the longest
+// trailing window of /price/bands.
+const (
+	defaultBandsWindow = 90 * 24 * time.Hour
+	maxBandsWindow     = 366 * 24 * time.Hour
+)
+
+// bandQuantiles are the quantiles reported for every hour of the day.
+var bandQuantiles = []float64{0.1, 0.25, 0.5, 0.75, 0.9}
+
+type priceBand struct {
+	Hour  int      `json:"hour"`
+	Count int      `json:"count"`
+	P10   *float64 `json:"p10"`
+	P25   *float64 `json:"p25"`
+	P50   *float64 `json:"p50"`
+	P75   *float64 `json:"p75"`
+	P90   *float64 `json:"p90"`
+}
+
+// trailingDays turns the window query parameter of the bands into start and
+// end parameters covering as many whole Europe/Berlin days before today,
+// before next sees the request. The range changes only at midnight, so the
+// response can be memoized like that of any absolute range.
+func (s *server) trailingDays(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		window := defaultBandsWindow
+		if v := q.Get("window"); v != "" {
+			d, err := parseDuration(v)
+			if err != nil || d < 24*time.Hour || d > maxBandsWindow || d%(24*time.Hour) != 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf(
+					"window: expected whole days from 1d to %s, got %q", formatDuration(maxBandsWindow), v,
+				))
+				return
+			}
+			window = d
+		}
+		y, m, d := s.clock.Now().In(s.loc).Date()
+		end := time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+		start := end.AddDate(0, 0, -int(window/(24*time.Hour)))
+		q.Set("window", formatDuration(window))
+		q.Set("start", start.Format(time.RFC3339))
+		q.Set("end", end.Format(time.RFC3339))
+
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = q.Encode()
+		w.Header().Set("X-Range-Start", q.Get("start"))
+		w.Header().Set("X-Range-End", q.Get("end"))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleBands reports the quantiles of the prices of each local hour of
+// the day over the trailing window set by trailingDays, to draw today's
+// prices against. Quarter-hourly prices count for the hour they are in.
+func (s *server) handleBands(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	buckets := timeOfDayBuckets(s.store.points(start, end), time.Hour, s.loc)
+	bands := make([]priceBand, len(buckets))
+	for i, prices := range buckets {
+		b := priceBand{Hour: i, Count: len(prices)}
+		if len(prices) > 0 {
+			slices.Sort(prices)
+			values := make([]*float64, len(bandQuantiles))
+			for j, p := range bandQuantiles {
+				v := quantile(prices, p) / div
+				values[j] = &v
+			}
+			b.P10, b.P25, b.P50, b.P75, b.P90 = values[0], values[1], values[2], values[3], values[4]
+		}
+		bands[i] = b
+	}
+
+	writeJSON(w, struct {
+		Unit   string      `json:"unit"`
+		Window string      `json:"window"`
+		Start  time.Time   `json:"start"`
+		End    time.Time   `json:"end"`
+		Bands  []priceBand `json:"bands"`
+	}{unit, q.Get("window"), start.UTC(), end.UTC(), bands})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// peakBlock defines the peak hours of a day in local time, [Start, End),
+// and whether weekends have them. The rest of the day is off-peak. The
+// default is the EPEX convention: 08:00 to 20:00 on weekdays.
+type peakBlock struct {
+	Start    int  `json:"start_hour"`
+	End      int  `json:"end_hour"`
+	Weekends bool `json:"weekends"`
+}
+
+var defaultPeakBlock = peakBlock{Start: 8, End: 20}
+
+// isPeak reports whether the slot starting at t is in the peak block. Hours
+// are of the local wall clock, so on DST transition days the block keeps its
+// local hours but not its length.
+func (b peakBlock) isPeak(t time.Time) bool {
+	if !b.Weekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return false
+	}
+	return t.Hour() >= b.Start && t.Hour() < b.End
+}
+
+type dayBlocks struct {
+	Date    string   `json:"date"`
+	Base    *float64 `json:"base"`
+	Peak    *float64 `json:"peak"`
+	OffPeak *float64 `json:"off_peak"`
+}
+
+// blockSum accumulates a time-weighted average.
+type blockSum struct {
+	sum     float64
+	seconds float64
+}
+
+func (b *blockSum) add(p pricePoint) {
+	b.sum += p.Price * p.Duration.Seconds()
+	b.seconds += p.Duration.Seconds()
+}
+
+// average is nil without any slots.
+func (b blockSum) average(div float64) *float64 {
+	if b.seconds == 0 {
+		return nil
+	}
+	avg := b.sum / b.seconds / div
+	return &avg
+}
+
+// handleBlocks reports the base, peak and off-peak average prices of each
+// Europe/Berlin day, weighting slots by their duration.
+func (s *server) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	block := defaultPeakBlock
+	if block.Start, err = parseInt(q, "peak_start", block.Start, 0, 23); err != nil {
+		badRequest(w, err)
+		return
+	}
+	if block.End, err = parseInt(q, "peak_end", block.End, 1, 24); err != nil {
+		badRequest(w, err)
+		return
+	}
+	if block.Start >= block.End {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("peak_end: expected an hour after peak_start %d, got %d", block.Start, block.End))
+		return
+	}
+	if block.Weekends, err = parseBool(q, "weekend_peak"); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	days := groupByDay(s.store.points(start, end), s.loc)
+	blocks := make([]dayBlocks, 0, len(days))
+	for _, d := range days {
+		var base, peak, offPeak blockSum
+		for _, p := range d.Points {
+			base.add(p)
+			if block.isPeak(p.Start.In(s.loc)) {
+				peak.add(p)
+			} else {
+				offPeak.add(p)
+			}
+		}
+		blocks = append(blocks, dayBlocks{d.Date, base.average(div), peak.average(div), offPeak.average(div)})
+	}
+
+	writeJSON(w, struct {
+		Unit string      `json:"unit"`
+		Peak peakBlock   `json:"peak_hours"`
+		Days []dayBlocks `json:"days"`
+	}{unit, block, blocks})
+}
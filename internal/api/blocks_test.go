@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestBlocks checks the block averages of days computed by hand: a
+// weekday, a Saturday with and without weekend peaks, the day switching to
+// summer time, and a day of hourly slots after one of quarter-hourly ones,
+// whose first slot only lasts until the closer neighbour.
+func TestBlocks(t *testing.T) {
+	// Prices are the local hour of hourly slots, and 10 for the quarter-hourly
+	// slots of the Monday.
+	prices := make(map[time.Time]float64)
+	hourly := func(y int, m time.Month, d int) {
+		for t := time.Date(y, m, d, 0, 0, 0, 0, testLoc); t.Day() == d; t = t.Add(time.Hour) {
+			prices[t.UTC()] = float64(t.Hour())
+		}
+	}
+	hourly(2026, time.January, 17)
+	for t := time.Date(2026, time.January, 19, 0, 0, 0, 0, testLoc); t.Day() == 19; t = t.Add(15 * time.Minute) {
+		prices[t.UTC()] = 10
+	}
+	hourly(2026, time.January, 20)
+	hourly(2026, time.March, 29)
+	s := newTestServer(t, "-no-ondemand")
+	s.store.merge(prices, originRefresh, upstreamProvider)
+
+	type blocks struct {
+		Unit string `json:"unit"`
+		Days []struct {
+			Date    string   `json:"date"`
+			Base    *float64 `json:"base"`
+			Peak    *float64 `json:"peak"`
+			OffPeak *float64 `json:"off_peak"`
+		} `json:"days"`
+	}
+	// none is an average without any slots.
+	none := math.NaN()
+	tests := []struct {
+		name   string
+		target string
+		// days are the date, base, peak and off-peak average of each day.
+		days [][4]any
+	}{
+		{
+			// 100 to 123: 08:00 to 20:00 averages 108 to 119, the rest 100 to
+			// 107 and 120 to 123.
+			"weekday",
+			"/price/blocks?start=2026-01-13&end=2026-01-14",
+			[][4]any{{"2026-01-13", 111.5, 113.5, 1314.0 / 12}},
+		},
+		{
+			"weekday in ct/kWh",
+			"/price/blocks?start=2026-01-13&end=2026-01-14&unit=ct/kWh",
+			[][4]any{{"2026-01-13", 11.15, 11.35, 131.4 / 12}},
+		},
+		{
+			// The Sunday in between has no slots, and the Monday is flat.
+			// The Tuesday's first slot lasts 15 minutes, after the Monday's
+			// last: base (1 + ... + 23) / 23.25 hours, off-peak (1 + ... + 7
+			// + 20 + ... + 23) / 11.25 hours.
+			"weekend and resolution change",
+			"/price/blocks?start=2026-01-17&end=2026-01-21",
+			[][4]any{
+				{"2026-01-17", 11.5, none, 11.5},
+				{"2026-01-19", 10.0, 10.0, 10.0},
+				{"2026-01-20", 276 / 23.25, 13.5, 114 / 11.25},
+			},
+		},
+		{
+			// 0 to 7 and 20 to 23 are 114 over 12 hours.
+			"weekend peak",
+			"/price/blocks?start=2026-01-17&end=2026-01-18&weekend_peak=true",
+			[][4]any{{"2026-01-17", 11.5, 13.5, 9.5}},
+		},
+		{
+			// 02:00 is skipped: 274 over 23 hours, and 112 over the 11 hours
+			// off-peak.
+			"summer time",
+			"/price/blocks?start=2026-03-29&end=2026-03-30&weekend_peak=true",
+			[][4]any{{"2026-03-29", 274.0 / 23, 13.5, 112.0 / 11}},
+		},
+		{
+			// Only 10:00 to 12:00 is peak: 110 and 111.
+			"custom peak hours",
+			"/price/blocks?start=2026-01-13&end=2026-01-14&peak_start=10&peak_end=12",
+			[][4]any{{"2026-01-13", 111.5, 110.5, (2676 - 221) / 22.0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := get(t, s, tt.target)
+			wantStatus(t, res, http.StatusOK)
+			got := decode[blocks](t, res)
+			if len(got.Days) != len(tt.days) {
+				t.Fatalf("%d days, want %d", len(got.Days), len(tt.days))
+			}
+			for i, want := range tt.days {
+				d := got.Days[i]
+				if d.Date != want[0] {
+					t.Errorf("day %d is %s, want %s", i, d.Date, want[0])
+				}
+				for j, avg := range []*float64{d.Base, d.Peak, d.OffPeak} {
+					w := want[j+1].(float64)
+					if math.IsNaN(w) && avg != nil || !math.IsNaN(w) && (avg == nil || math.Abs(*avg-w) > 1e-9) {
+						t.Errorf("%s %s average %v, want %v", d.Date, []string{"base", "peak", "off-peak"}[j], fmtAverage(avg), w)
+					}
+				}
+			}
+		})
+	}
+
+	wantError(t, get(t, s, "/price/blocks?start=2026-01-13&end=2026-01-14&peak_start=12&peak_end=12"), http.StatusBadRequest, codeInvalidParameter, "peak_end")
+}
+
+func fmtAverage(avg *float64) string {
+	if avg == nil {
+		return "null"
+	}
+	return fmt.Sprint(*avg)
+}
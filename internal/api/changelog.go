@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// changelogGenerations is how many generations the changelog covers.
+const changelogGenerations = 100
+
+// generationChange lists the starts of the slots a merge added or updated.
+type generationChange struct {
+	generation uint64
+	starts     []time.Time
+}
+
+// logChange appends the slots changed by the current generation to the
+// changelog, dropping the oldest entry beyond changelogGenerations. Changes
+// that remove slots can't be listed as slots, so they clear the changelog
+// instead. The caller must hold the write lock.
+func (s *store) logChange(starts []time.Time) {
+	kept := s.changelog
+	if len(kept) >= changelogGenerations {
+		kept = kept[len(kept)-changelogGenerations+1:]
+	}
+	s.changelog = append(slices.Clip(kept), generationChange{s.generation, starts})
+}
+
+// changedSince returns the sorted starts of the slots added or updated after
+// generation, and false if the changelog doesn't reach back that far or the
+// generation is unknown.
+func (s *store) changedSince(generation uint64) ([]time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch {
+	case generation == s.generation:
+		return nil, true
+	case generation > s.generation || len(s.changelog) == 0 || s.changelog[0].generation > generation+1:
+		return nil, false
+	}
+	var starts []time.Time
+	for _, c := range s.changelog {
+		if c.generation > generation {
+			starts = append(starts, c.starts...)
+		}
+	}
+	slices.SortFunc(starts, time.Time.Compare)
+	return slices.CompactFunc(starts, time.Time.Equal), true
+}
+
+// parseSinceGeneration reads the optional since_generation query parameter,
+// returning the starts of the slots changed since then, or all if it is
+// absent. If the changelog doesn't cover the generation, the response has
+// been written with 409 and the current generation, and ok is false.
+func (s *server) parseSinceGeneration(w http.ResponseWriter, q url.Values) (changed []time.Time, all, ok bool) {
+	v := q.Get("since_generation")
+	if v == "" {
+		return nil, true, true
+	}
+	generation, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("since_generation: expected a generation number, got %q", v))
+		return nil, false, false
+	}
+	if q.Has("generation") {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "since_generation: cannot be combined with generation")
+		return nil, false, false
+	}
+	changed, ok = s.store.changedSince(generation)
+	if !ok {
+		current := s.store.meta().Generation
+		w.Header().Set(generationHeader, strconv.FormatUint(current, 10))
+		writeError(w, http.StatusConflict, codeGenerationUnavailable, fmt.Sprintf(
+			"the changes since generation %d are no longer kept, the current generation is %d, fetch the listing without since_generation", generation, current,
+		))
+		return nil, false, false
+	}
+	return changed, false, true
+}
+
+// scanChanged yields the cached slots starting in [start, end) like scan,
+// but only those starting at the sorted times changed.
+func (s *store) scanChanged(start, end time.Time, changed []time.Time) iter.Seq[cachedSlot] {
+	if len(changed) == 0 {
+		return func(func(cachedSlot) bool) {}
+	}
+	if first := changed[0]; start.IsZero() || start.Before(first) {
+		start = first
+	}
+	if last := changed[len(changed)-1].Add(time.Nanosecond); end.IsZero() || end.After(last) {
+		end = last
+	}
+	return func(yield func(cachedSlot) bool) {
+		for c := range s.scan(start, end) {
+			if _, ok := slices.BinarySearchFunc(changed, c.Start, time.Time.Compare); ok && !yield(c) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSinceGeneration lists the slots changed since a generation after
+// merges, beyond the generations the changelog covers, and after removing
+// slots, when the client has to fetch the whole listing again.
+func TestSinceGeneration(t *testing.T) {
+	s := newTestServer(t, "-no-ondemand")
+	first := s.store.meta().Generation
+	since := func(generation uint64) *http.Response {
+		t.Helper()
+		return get(t, s, fmt.Sprintf("/price?since_generation=%d", generation))
+	}
+	changed := func(generation uint64, want ...time.Time) {
+		t.Helper()
+		res := since(generation)
+		wantStatus(t, res, http.StatusOK)
+		current := strconv.FormatUint(s.store.meta().Generation, 10)
+		if got := res.Header.Get(generationHeader); got != current {
+			t.Errorf("since generation %d: %s %s, want %s", generation, generationHeader, got, current)
+		}
+		slots := decode[[]PricePoint](t, res)
+		if len(slots) != len(want) {
+			t.Fatalf("since generation %d: %d slots, want %d: %+v", generation, len(slots), len(want), slots)
+		}
+		for i, slot := range slots {
+			if !slot.Start.Equal(want[i]) {
+				t.Errorf("since generation %d: slot %d starts at %s, want %s", generation, i, slot.Start, want[i].UTC())
+			}
+		}
+	}
+	unavailable := func(generation uint64) {
+		t.Helper()
+		res := since(generation)
+		current := s.store.meta().Generation
+		if got := res.Header.Get(generationHeader); got != strconv.FormatUint(current, 10) {
+			t.Errorf("since generation %d: %s %s, want %d", generation, generationHeader, got, current)
+		}
+		wantError(t, res, http.StatusConflict, codeGenerationUnavailable, fmt.Sprintf("the changes since generation %d are no longer kept, the current generation is %d", generation, current))
+	}
+
+	// Nothing has changed since the current generation.
+	changed(first)
+
+	a, b := testFirstDay.Add(10*time.Hour), testFirstDay.Add(20*time.Hour)
+	s.store.merge(map[time.Time]float64{b.UTC(): -1}, originRefresh, upstreamProvider)
+	s.store.merge(map[time.Time]float64{a.UTC(): -1}, originRefresh, upstreamProvider)
+	// Merging the same prices again doesn't make a generation.
+	s.store.merge(map[time.Time]float64{a.UTC(): -1}, originRefresh, upstreamProvider)
+	if g := s.store.meta().Generation; g != first+2 {
+		t.Fatalf("generation %d after two changes, want %d", g, first+2)
+	}
+	changed(first, a, b)
+	changed(first+1, a)
+	changed(first + 2)
+	unavailable(first + 3)
+
+	// Changing a slot every generation keeps it listed once, until the
+	// oldest generations drop out of the changelog.
+	for i := range changelogGenerations {
+		s.store.merge(map[time.Time]float64{a.UTC(): float64(i)}, originRefresh, upstreamProvider)
+	}
+	current := s.store.meta().Generation
+	changed(current-changelogGenerations, a)
+	unavailable(current - changelogGenerations - 1)
+	unavailable(first)
+
+	// Removing slots clears the changelog.
+	if n := s.store.remove(b, b.Add(time.Hour)); n != 1 {
+		t.Fatalf("removed %d slots, want 1", n)
+	}
+	unavailable(current)
+	changed(current + 1)
+	s.store.merge(map[time.Time]float64{b.UTC(): 1}, originRefresh, upstreamProvider)
+	changed(current+1, b)
+
+	wantError(t, get(t, s, "/price?since_generation=latest"), http.StatusBadRequest, codeInvalidParameter, `expected a generation number, got "latest"`)
+	wantError(t, get(t, s, fmt.Sprintf("/price?since_generation=%d&generation=%d", first, current+2)), http.StatusBadRequest, codeInvalidParameter, "cannot be combined with generation")
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type chartOptions struct {
+	Width  int
+	Height int
+	// Now is marked with a vertical line if it lies within the chart.
+	Now time.Time
+	Loc *time.Location
+}
+
+const chartMargin = 40
+
+// renderChart writes points as a standalone SVG step chart.
+func renderChart(w io.Writer, points []pricePoint, opts chartOptions) {
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`+"\n",
+		opts.Width, opts.Height, opts.Width, opts.Height)
+	defer fmt.Fprint(w, "</svg>\n")
+
+	if len(points) == 0 {
+		fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">no data</text>`+"\n", opts.Width/2, opts.Height/2)
+		return
+	}
+
+	start := points[0].Start
+	last := points[len(points)-1]
+	end := last.Start.Add(last.Duration)
+
+	lo, hi := 0.0, 0.0
+	for _, p := range points {
+		lo, hi = min(lo, p.Price), max(hi, p.Price)
+	}
+	if hi == lo {
+		hi = lo + 1
+	}
+
+	plotW := float64(opts.Width - 2*chartMargin)
+	plotH := float64(opts.Height - 2*chartMargin)
+	x := func(t time.Time) float64 {
+		return chartMargin + plotW*float64(t.Sub(start))/float64(end.Sub(start))
+	}
+	y := func(p float64) float64 {
+		return chartMargin + plotH*(hi-p)/(hi-lo)
+	}
+
+	// Axes and the zero line.
+	fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#999"/>`+"\n", x(start), y(0), x(end), y(0))
+	fmt.Fprintf(w, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n", chartMargin-4, y(hi)+4, formatFloat(math.Round(hi)))
+	fmt.Fprintf(w, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n", chartMargin-4, y(lo)+4, formatFloat(math.Round(lo)))
+
+	// Day boundaries in the market timezone.
+	y0, m0, d0 := start.In(opts.Loc).Date()
+	for day := time.Date(y0, m0, d0, 0, 0, 0, 0, opts.Loc); day.Before(end); day = day.AddDate(0, 0, 1) {
+		if day.Before(start) {
+			continue
+		}
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" stroke="#ddd"/>`+"\n", x(day), chartMargin, x(day), y(lo))
+		fmt.Fprintf(w, `<text x="%.1f" y="%d">%s</text>`+"\n", x(day)+2, opts.Height-chartMargin+14, day.Format("Mon 2 Jan"))
+	}
+
+	// Shade the area between negative prices and the zero line.
+	for _, p := range points {
+		if p.Price < 0 {
+			fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#d62728" fill-opacity="0.2"/>`+"\n",
+				x(p.Start), y(0), x(p.Start.Add(p.Duration))-x(p.Start), y(p.Price)-y(0))
+		}
+	}
+
+	// The price curve as a step function.
+	fmt.Fprint(w, `<path fill="none" stroke="#1f77b4" stroke-width="1.5" d="`)
+	for i, p := range points {
+		cmd := "L"
+		if i == 0 || !points[i-1].Start.Add(points[i-1].Duration).Equal(p.Start) {
+			cmd = "M"
+		}
+		fmt.Fprintf(w, "%s%.1f %.1f H%.1f ", cmd, x(p.Start), y(p.Price), x(p.Start.Add(p.Duration)))
+	}
+	fmt.Fprint(w, `"/>`+"\n")
+
+	if !opts.Now.Before(start) && opts.Now.Before(end) {
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" stroke="#d62728" stroke-dasharray="4 2"/>`+"\n",
+			x(opts.Now), chartMargin, x(opts.Now), y(lo))
+		i := slices.IndexFunc(points, func(p pricePoint) bool {
+			return !opts.Now.Before(p.Start) && opts.Now.Before(p.Start.Add(p.Duration))
+		})
+		if i >= 0 {
+			p := points[i]
+			fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="3" fill="#d62728"/>`+"\n", x(opts.Now), y(p.Price))
+		}
+	}
+}
+
+// handleChart renders the prices of [start, end) as an SVG image, by default
+// those of today and tomorrow.
+func (s *server) handleChart(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	now := s.clock.Now()
+	if start.IsZero() && end.IsZero() {
+		y, m, d := now.In(s.loc).Date()
+		start = time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+		end = start.AddDate(0, 0, 2)
+	}
+	if !s.checkRange(w, start, end) {
+		return
+	}
+
+	width, err := parseInt(q, "width", 800, 100, 4000)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	height, err := parseInt(q, "height", 200, 50, 2000)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	renderChart(w, s.store.points(start, end), chartOptions{
+		Width:  width,
+		Height: height,
+		Now:    now,
+		Loc:    s.loc,
+	})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChartGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		// Today and tomorrow, with testNow marked.
+		{"chart_default.svg", "/price/chart.svg"},
+		{"chart_negative.svg", "/price/chart.svg?start=2026-01-14T08:00:00Z&end=2026-01-14T16:00:00Z&width=400&height=100"},
+		{"chart_gap.svg", "/price/chart.svg?start=2026-01-12&end=2026-01-13&width=400&height=100"},
+		{"chart_empty.svg", "/price/chart.svg?start=2026-01-20&end=2026-01-21"},
+	}
+	s := newTestServer(t)
+	s.store.merge(map[time.Time]float64{
+		time.Date(2026, time.January, 14, 11, 0, 0, 0, time.UTC): -40,
+		time.Date(2026, time.January, 14, 12, 0, 0, 0, time.UTC): -12.5,
+	}, originRefresh, upstreamProvider)
+	s.store.remove(time.Date(2026, time.January, 12, 5, 0, 0, 0, time.UTC), time.Date(2026, time.January, 12, 8, 0, 0, 0, time.UTC))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := get(t, s, tt.target)
+			wantStatus(t, res, http.StatusOK)
+			if ct := res.Header.Get("Content-Type"); ct != "image/svg+xml" {
+				t.Errorf("Content-Type %q", ct)
+			}
+			body := readBody(t, res)
+			wantWellFormed(t, body)
+			golden(t, tt.name, body)
+		})
+	}
+}
+
+func TestChartBounds(t *testing.T) {
+	s := newTestServer(t)
+	for _, query := range []string{"width=99", "width=4001", "width=wide", "height=49", "height=2001"} {
+		t.Run(query, func(t *testing.T) {
+			wantError(t, get(t, s, "/price/chart.svg?"+query), http.StatusBadRequest, codeInvalidParameter, "expected an integer between")
+		})
+	}
+	wantError(t, get(t, s, "/price/chart.svg?start=2020-01-01&end=2026-01-01"), http.StatusBadRequest, codeInvalidRange, "range exceeds the maximum")
+}
+
+// wantWellFormed fails t unless doc is a well-formed XML document with an
+// svg root element.
+func wantWellFormed(t *testing.T, doc string) {
+	t.Helper()
+	d := xml.NewDecoder(strings.NewReader(doc))
+	root := ""
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("malformed SVG: %v", err)
+		}
+		if el, ok := tok.(xml.StartElement); ok && root == "" {
+			root = el.Name.Space + " " + el.Name.Local
+		}
+	}
+	if root != "http://www.w3.org/2000/svg svg" {
+		t.Errorf("root element %q, want svg in the SVG namespace", root)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkStep is a single check of runCheck. It returns a short description
+// of what it verified, or an error.
+type checkStep struct {
+	name string
+	fn   func(ctx context.Context) (string, error)
+}
+
+// runCheck verifies that the service could start with args, without serving
+// or modifying the cache file, and writes a report to out. It uses the same
+// configuration parsing and upstream client as run and returns the exit code.
+func runCheck(ctx context.Context, args []string, out io.Writer) int {
+	cfg, err := parseConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(out, "FAIL config: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(out, "ok   config")
+
+	var loc *time.Location
+	steps := []checkStep{
+		{"timezone", func(context.Context) (string, error) {
+			if loc, err = loadMarketLocation(); err != nil {
+				return "", err
+			}
+			return marketTimezone, nil
+		}},
+		{"listen", func(context.Context) (string, error) {
+			for _, a := range cfg.listeners {
+				if _, err := net.ResolveTCPAddr("tcp", a.addr); err != nil {
+					return "", fmt.Errorf("%s: %w", a.addr, err)
+				}
+			}
+			return cfg.listeners.String(), nil
+		}},
+		{"cache file", func(context.Context) (string, error) {
+			return checkCacheFile(cfg.cacheFile, cfg.forceImport, cfg.readOnly)
+		}},
+		{"upstream", func(ctx context.Context) (string, error) {
+			if loc == nil {
+				return "", errors.New("skipped without timezone")
+			}
+			return checkFetch(ctx, cfg, func(ctx context.Context, start, end time.Time) (map[time.Time]float64, error) {
+				return newUpstream(cfg, &registry{}).fetchOnce(ctx, start, end, nil)
+			}, loc)
+		}},
+	}
+	if cfg.syncFrom != "" {
+		steps = append(steps, checkStep{"sync", func(ctx context.Context) (string, error) {
+			if loc == nil {
+				return "", errors.New("skipped without timezone")
+			}
+			return checkFetch(ctx, cfg, newPrimary(cfg).fetch, loc)
+		}})
+	}
+
+	code := 0
+	for _, step := range steps {
+		detail, err := step.fn(ctx)
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", step.name, err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(out, "ok   %s: %s\n", step.name, detail)
+	}
+	return code
+}
+
+// checkFetch fetches the first hour of the current day with fetch.
+func checkFetch(
+	ctx context.Context,
+	cfg config,
+	fetch func(ctx context.Context, start, end time.Time) (map[time.Time]float64, error),
+	loc *time.Location,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.onDemandTimeout)
+	defer cancel()
+
+	y, m, d := time.Now().In(loc).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	prices, err := fetch(ctx, start, start.Add(time.Hour))
+	if err != nil {
+		return "", err
+	}
+	if len(prices) == 0 {
+		return "", fmt.Errorf("no prices for %s", start.Format(time.DateOnly))
+	}
+	return fmt.Sprintf("%d slots for the first hour of %s", len(prices), start.Format(time.DateOnly)), nil
+}
+
+// checkCacheFile verifies that an existing cache file can be loaded and that
+// its directory allows saving a new one, the way store.save does, unless the
+// cache is only read.
+func checkCacheFile(path string, force, readOnly bool) (string, error) {
+	if path == "" {
+		return "not configured", nil
+	}
+
+	detail := "no saved cache yet"
+	saved, err := loadCache(path, force)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return "", err
+	default:
+		detail = fmt.Sprintf("%d slots saved %s", len(saved.Timestamps), saved.SavedAt.Format(time.RFC3339))
+	}
+	if readOnly {
+		return fmt.Sprintf("%s, %s, read-only", path, detail), nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("directory not writable: %w", err)
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return fmt.Sprintf("%s, %s", path, detail), nil
+}
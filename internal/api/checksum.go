@@ -0,0 +1,52 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// checksumScale is the number of price units per EUR/MWh hashed by
+// priceChecksum. The upstream publishes prices with two decimals.
+const checksumScale = 100
+
+// priceChecksum returns the hex-encoded SHA-256 of points in their canonical
+// form: one line "<unix seconds>:<price in hundredths of EUR/MWh>\n" per
+// point in the given order. Prices are rounded to integers before
+// formatting, so the result doesn't depend on how floats are printed and
+// equal caches hash equally on every platform.
+func priceChecksum(points []pricePoint) string {
+	h := sha256.New()
+	var line []byte
+	for _, p := range points {
+		line = strconv.AppendInt(line[:0], p.Start.Unix(), 10)
+		line = append(line, ':')
+		line = strconv.AppendInt(line, int64(math.Round(p.Price*checksumScale)), 10)
+		line = append(line, '\n')
+		h.Write(line)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleChecksum reports a checksum of the cached prices in a range, so that
+// replicas can be compared without transferring the prices themselves.
+func (s *server) handleChecksum(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseRange(r.URL.Query(), s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	points := s.store.points(start, end)
+	writeJSON(w, struct {
+		Start      *time.Time `json:"start"`
+		End        *time.Time `json:"end"`
+		Algorithm  string     `json:"algorithm"`
+		Checksum   string     `json:"checksum"`
+		Count      int        `json:"count"`
+		Generation uint64     `json:"generation"`
+	}{optionalTime(start), optionalTime(end), "sha256", priceChecksum(points), len(points), s.store.meta().Generation})
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPriceChecksumCanonical(t *testing.T) {
+	t0 := time.Unix(1768258800, 0)
+	points := func(prices ...float64) []pricePoint {
+		ps := make([]pricePoint, len(prices))
+		for i, p := range prices {
+			ps[i] = pricePoint{Start: t0.Add(time.Duration(i) * time.Hour), Duration: time.Hour, Price: p}
+		}
+		return ps
+	}
+
+	// The canonical form is fixed: changing it breaks comparing replicas of
+	// different versions.
+	canonical := "1768258800:8320\n1768262400:-325\n1768266000:0\n"
+	sum := sha256.Sum256([]byte(canonical))
+	want := hex.EncodeToString(sum[:])
+	if want != "84a143f8d7037fd2df8179e5ad5d80bba9ab2ad7afbb7d4c8c2a241a331203b1" {
+		t.Fatalf("SHA-256 of the canonical form %s", want)
+	}
+	if got := priceChecksum(points(83.2, -3.25, 0)); got != want {
+		t.Errorf("checksum %s, want %s", got, want)
+	}
+
+	// Prices that print differently but are equal to the cent hash equally.
+	equal := [][]float64{
+		{83.2, -3.25, 0},
+		{83.20000000000001, -3.2499999999, 0},
+		{41.6 * 2, -3.25, math.Copysign(0, -1)},
+		{83.2001, -3.2501, 0.004},
+	}
+	for _, prices := range equal {
+		if got := priceChecksum(points(prices...)); got != want {
+			t.Errorf("checksum of %v %s, want %s", prices, got, want)
+		}
+	}
+
+	different := [][]float64{
+		{83.21, -3.25, 0},
+		{83.2, 3.25, 0},
+		{-3.25, 83.2, 0},
+		{83.2, -3.25},
+		{83.2, -3.25, 0, 0},
+		nil,
+	}
+	for _, prices := range different {
+		if got := priceChecksum(points(prices...)); got == want {
+			t.Errorf("checksum of %v equals that of 83.2, -3.25, 0", prices)
+		}
+	}
+}
+
+func TestChecksumEndpoint(t *testing.T) {
+	type checksum struct {
+		Checksum string `json:"checksum"`
+		Count    int    `json:"count"`
+	}
+	sum := func(s *server, target string) checksum {
+		t.Helper()
+		res := get(t, s, target)
+		wantStatus(t, res, http.StatusOK)
+		return decode[checksum](t, res)
+	}
+
+	// A replica that got the same prices by other merges agrees.
+	a := newTestServer(t)
+	b := newTestServer(t)
+	b.store.remove(testFirstDay, testFirstDay.AddDate(0, 0, testDays))
+	b.store.merge(map[time.Time]float64{testNow.Truncate(time.Hour): -1}, originRefresh, upstreamProvider)
+	b.store.merge(testPrices(), originRefresh, upstreamProvider)
+
+	const day = "/price/checksum?start=2026-01-13&end=2026-01-14"
+	if ca, cb := sum(a, day), sum(b, day); ca != cb || ca.Count != 24 {
+		t.Errorf("checksums %+v and %+v, want equal ones of 24 slots", ca, cb)
+	}
+
+	// One that missed an update disagrees, but only for ranges holding it.
+	b.store.merge(map[time.Time]float64{testNow.Truncate(time.Hour): 1}, originRefresh, upstreamProvider)
+	if ca, cb := sum(a, day), sum(b, day); ca == cb {
+		t.Errorf("equal checksums %+v for different prices", ca)
+	}
+	const before = "/price/checksum?start=2026-01-13&end=2026-01-13T12:00:00Z"
+	if ca, cb := sum(a, before), sum(b, before); ca != cb || ca.Count != 13 {
+		t.Errorf("checksums %+v and %+v before the difference, want equal ones of 13 slots", ca, cb)
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import "time"
+
+// clock is the source of the current time for everything that depends on
+// "now", so that it can be controlled independently of the wall clock.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the part of time.Ticker used by the service.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the clock backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (systemClock) NewTicker(d time.Duration) ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to. Its timers and tickers
+// fire while Advance passes their time, in the order of their times.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	// period is the interval of tickers, zero for timers.
+	period time.Duration
+	c      chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.start(d, 0).c
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	if d <= 0 {
+		panic("non-positive interval for fakeClock.NewTicker")
+	}
+	return fakeTicker{c, c.start(d, d)}
+}
+
+func (c *fakeClock) start(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Like those of the time package, the channels hold a single value and
+	// tickers drop ticks for slow receivers.
+	t := &fakeTimer{at: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) stop(t *fakeTimer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timers = slices.DeleteFunc(c.timers, func(u *fakeTimer) bool { return u == t })
+}
+
+// Advance moves the clock forward by d, firing the timers due on the way.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		i := -1
+		for j, t := range c.timers {
+			if !t.at.After(end) && (i < 0 || t.at.Before(c.timers[i].at)) {
+				i = j
+			}
+		}
+		if i < 0 {
+			break
+		}
+		t := c.timers[i]
+		c.now = t.at
+		select {
+		case t.c <- t.at:
+		default:
+		}
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+		} else {
+			c.timers = slices.Delete(c.timers, i, i+1)
+		}
+	}
+	c.now = end
+}
+
+// Set moves the clock to t like Advance. Moving it back fires no timers.
+func (c *fakeClock) Set(t time.Time) {
+	c.Advance(t.Sub(c.Now()))
+}
+
+// WaitTimers waits until n timers and tickers are pending, such as those of
+// a goroutine that is expected to schedule its next run, and fails t if
+// that doesn't happen within a few seconds.
+func (c *fakeClock) WaitTimers(t testing.TB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := len(c.timers)
+		c.mu.Unlock()
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type fakeTicker struct {
+	c *fakeClock
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.c }
+func (t fakeTicker) Stop()               { t.c.stop(t.t) }
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock(testNow)
+	after := c.After(time.Minute)
+	tick := c.NewTicker(20 * time.Second)
+	defer tick.Stop()
+
+	c.Advance(59 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("timer fired early")
+	default:
+	}
+	if got := <-tick.C(); !got.Equal(testNow.Add(20 * time.Second)) {
+		t.Errorf("first tick at %s", got)
+	}
+	// The tick at 40s was dropped, as the channel was full.
+	select {
+	case got := <-tick.C():
+		t.Fatalf("dropped tick delivered at %s", got)
+	default:
+	}
+
+	c.Advance(time.Second)
+	if got := <-after; !got.Equal(testNow.Add(time.Minute)) {
+		t.Errorf("timer fired at %s", got)
+	}
+	if got := <-tick.C(); !got.Equal(testNow.Add(time.Minute)) {
+		t.Errorf("tick at %s", got)
+	}
+	if got := c.Now(); !got.Equal(testNow.Add(time.Minute)) {
+		t.Errorf("now %s", got)
+	}
+	c.WaitTimers(t, 1)
+	tick.Stop()
+	c.WaitTimers(t, 0)
+}
+
+// TestClockMidnight moves the clock across midnight and a DST transition,
+// where the current and next day's prices change without any refresh.
+func TestClockMidnight(t *testing.T) {
+	s := newTestServer(t)
+	c := newFakeClock(time.Date(2026, time.January, 13, 23, 59, 59, 0, testLoc))
+	s.setClock(c)
+
+	current := func() float64 {
+		t.Helper()
+		res := get(t, s, "/price/current")
+		wantStatus(t, res, http.StatusOK)
+		return decode[struct {
+			Price float64 `json:"price"`
+		}](t, res).Price
+	}
+	if p := current(); p != 123 {
+		t.Errorf("price before midnight %v, want 123", p)
+	}
+	if !s.tomorrowAvailable() {
+		t.Error("tomorrow unavailable before midnight")
+	}
+
+	c.Advance(time.Second)
+	if p := current(); p != 200 {
+		t.Errorf("price after midnight %v, want 200", p)
+	}
+	if s.tomorrowAvailable() {
+		t.Error("tomorrow available after midnight, where it is 2026-01-15")
+	}
+
+	// The spring-forward day has 23 hours, and its refresh window still
+	// starts and ends at midnights.
+	c.Set(time.Date(2026, time.March, 29, 12, 0, 0, 0, testLoc))
+	start, end := refreshWindow(c.Now(), testLoc)
+	if want := time.Date(2026, time.March, 29, 0, 0, 0, 0, testLoc); !start.Equal(want) {
+		t.Errorf("refresh window starts %s, want %s", start, want)
+	}
+	if want := time.Date(2026, time.April, 1, 0, 0, 0, 0, testLoc); !end.Equal(want) {
+		t.Errorf("refresh window ends %s, want %s", end, want)
+	}
+}
+
+// TestClockStore checks that the refresh and modification times of the
+// cache follow the server's clock.
+func TestClockStore(t *testing.T) {
+	s := newTestServer(t)
+	c := newFakeClock(testNow.Add(time.Hour))
+	s.setClock(c)
+
+	s.store.merge(map[time.Time]float64{testFirstDay.AddDate(0, 0, testDays): 1}, originRefresh, upstreamProvider)
+	if got := s.store.meta().LastRefresh; !got.Equal(c.Now()) {
+		t.Errorf("last refresh %s after merging, want %s", got, c.Now())
+	}
+	res := get(t, s, "/price?start=2026-01-13&end=2026-01-14")
+	wantStatus(t, res, http.StatusOK)
+	if got, want := res.Header.Get("Last-Modified"), c.Now().UTC().Format(http.TimeFormat); got != want {
+		t.Errorf("Last-Modified %s, want %s", got, want)
+	}
+
+	c.Advance(time.Minute)
+	s.store.touch()
+	if got := s.store.meta().LastRefresh; !got.Equal(c.Now()) {
+		t.Errorf("last refresh %s after a refresh without changes, want %s", got, c.Now())
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type rangeStats struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Slots         int       `json:"slots"`
+	Mean          *float64  `json:"mean"`
+	Median        *float64  `json:"median"`
+	Min           *float64  `json:"min"`
+	Max           *float64  `json:"max"`
+	HoursNegative float64   `json:"hours_negative"`
+}
+
+func computeRangeStats(points []pricePoint, start, end time.Time, div float64) rangeStats {
+	st := rangeStats{Start: start.UTC(), End: end.UTC(), Slots: len(points)}
+	if len(points) == 0 {
+		return st
+	}
+
+	prices := make([]float64, len(points))
+	for i, p := range points {
+		prices[i] = p.Price / div
+		if p.Price < 0 {
+			st.HoursNegative += p.Duration.Hours()
+		}
+	}
+	m := mean(prices)
+	med := median(prices)
+	lo, hi := slices.Min(prices), slices.Max(prices)
+	st.Mean, st.Median, st.Min, st.Max = &m, &med, &lo, &hi
+	return st
+}
+
+type statDelta struct {
+	Absolute float64  `json:"absolute"`
+	Percent  *float64 `json:"percent"`
+}
+
+func delta(a, b *float64) *statDelta {
+	if a == nil || b == nil {
+		return nil
+	}
+	d := statDelta{Absolute: *b - *a}
+	if *a != 0 {
+		pct := 100 * d.Absolute / math.Abs(*a)
+		d.Percent = &pct
+	}
+	return &d
+}
+
+type rangeDelta struct {
+	Mean          *statDelta `json:"mean"`
+	Median        *statDelta `json:"median"`
+	Min           *statDelta `json:"min"`
+	Max           *statDelta `json:"max"`
+	HoursNegative *statDelta `json:"hours_negative"`
+}
+
+// handleCompare reports statistics for two ranges side by side together
+// with the change from range a to range b. Questionable input such as
+// overlapping ranges or ranges not covered by the cache yields warnings
+// rather than errors.
+func (s *server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	var ranges [2][2]time.Time
+	for i, name := range []string{"a", "b"} {
+		start, end, err := parseNamedRange(q, s.loc, name+"_start", name+"_end")
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		if start.IsZero() || end.IsZero() {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("%s_start and %s_end are required", name, name))
+			return
+		}
+		ranges[i] = [2]time.Time{start, end}
+	}
+
+	meta := s.store.meta()
+	var warnings []string
+	var stats [2]rangeStats
+	for i, name := range []string{"a", "b"} {
+		start, end := ranges[i][0], ranges[i][1]
+		stats[i] = computeRangeStats(s.store.points(start, end), start, end, div)
+
+		switch {
+		case stats[i].Slots == 0:
+			warnings = append(warnings, fmt.Sprintf("range %s contains no cached prices", name))
+		case start.Before(meta.Earliest) || end.After(meta.Through):
+			warnings = append(warnings, fmt.Sprintf("range %s extends beyond the cached data", name))
+		}
+	}
+
+	a, b := ranges[0], ranges[1]
+	if a[0].Before(b[1]) && b[0].Before(a[1]) {
+		warnings = append(warnings, "ranges overlap")
+	}
+	lengthMismatch := a[1].Sub(a[0]) != b[1].Sub(b[0])
+	if lengthMismatch {
+		warnings = append(warnings, "ranges differ in length")
+	}
+
+	writeJSON(w, struct {
+		Unit           string     `json:"unit"`
+		A              rangeStats `json:"a"`
+		B              rangeStats `json:"b"`
+		LengthMismatch bool       `json:"length_mismatch"`
+		Delta          rangeDelta `json:"delta"`
+		Warnings       []string   `json:"warnings"`
+	}{
+		Unit:           unit,
+		A:              stats[0],
+		B:              stats[1],
+		LengthMismatch: lengthMismatch,
+		Delta: rangeDelta{
+			Mean:          delta(stats[0].Mean, stats[1].Mean),
+			Median:        delta(stats[0].Median, stats[1].Median),
+			Min:           delta(stats[0].Min, stats[1].Min),
+			Max:           delta(stats[0].Max, stats[1].Max),
+			HoursNegative: delta(&stats[0].HoursNegative, &stats[1].HoursNegative),
+		},
+		Warnings: warnings,
+	})
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/t-arik/energy-market-prices/internal/brotli"
+)
+
+// compressor compresses responses with Brotli or gzip, at a fixed level
+// for each, to clients accepting them, with Brotli unless they prefer gzip.
+// A level of 0 leaves the coding out. Responses shorter than minSize
+// aren't worth it and are sent as they are, as are those already encoded
+// by their handler, such as the precomputed full listing, and event
+// streams, which are flushed event by event.
+type compressor struct {
+	minSize    int
+	gzipPool   sync.Pool
+	brotliPool sync.Pool
+	// codings are those offered, by preference.
+	codings []string
+}
+
+// encoder is a gzip.Writer or brotli.Writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func newCompressor(gzipLevel, brotliLevel, minSize int) *compressor {
+	c := &compressor{minSize: minSize}
+	// The levels are checked by the configuration.
+	if brotliLevel > 0 {
+		c.codings = append(c.codings, "br")
+		c.brotliPool.New = func() any {
+			zw, _ := brotli.NewWriterLevel(io.Discard, brotliLevel)
+			return zw
+		}
+	}
+	if gzipLevel > 0 {
+		c.codings = append(c.codings, "gzip")
+		c.gzipPool.New = func() any {
+			zw, _ := gzip.NewWriterLevel(io.Discard, gzipLevel)
+			return zw
+		}
+	}
+	return c
+}
+
+func (c *compressor) pool(coding string) *sync.Pool {
+	if coding == "br" {
+		return &c.brotliPool
+	}
+	return &c.gzipPool
+}
+
+func (c *compressor) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addVary(w.Header(), "Accept-Encoding")
+		coding := acceptedCoding(r.Header.Get("Accept-Encoding"), c.codings...)
+		if coding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, c: c, coding: coding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// addVary adds name to the Vary header unless it is listed already.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// compressWriter holds back the start of a response until it is known
+// whether it is long enough to compress.
+type compressWriter struct {
+	http.ResponseWriter
+	c      *compressor
+	coding string
+	status int
+	buf    []byte
+	// decided is set once the response is being written, through zw if it
+	// is compressed.
+	decided bool
+	zw      encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.pass()
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		h := w.Header()
+		if h.Get("Content-Encoding") != "" || strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+			w.pass()
+		} else if w.buf = append(w.buf, p...); len(w.buf) < w.c.minSize {
+			return len(p), nil
+		} else {
+			return len(p), w.compress()
+		}
+	}
+	if w.zw != nil {
+		return w.zw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends a response that isn't compressed yet as it is, as its
+// handler wants it out rather than small.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.pass()
+	}
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// pass writes the response without compressing it.
+func (w *compressWriter) pass() {
+	w.decided = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// compress starts writing the response through a writer of the pool of
+// its coding.
+func (w *compressWriter) compress() error {
+	w.decided = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.coding)
+	w.ResponseWriter.WriteHeader(w.status)
+	w.zw = w.c.pool(w.coding).Get().(encoder)
+	w.zw.Reset(w.ResponseWriter)
+	_, err := w.zw.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// close ends the response, passing on what is held back.
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.pass()
+	}
+	if w.zw != nil {
+		w.zw.Close()
+		w.zw.Reset(io.Discard)
+		w.c.pool(w.coding).Put(w.zw)
+		w.zw = nil
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/brotli"
+)
+
+// TestCompress checks which responses are compressed with which coding and
+// that they decompress to the plain response.
+func TestCompress(t *testing.T) {
+	const listing = "/price?start=2026-01-12&end=2026-01-15"
+	s := newTestServer(t)
+	plain := readBody(t, get(t, s, listing))
+	if len(plain) < s.cfg.compressMinSize {
+		t.Fatalf("listing of %d bytes is too short to be compressed", len(plain))
+	}
+	gzipOnly := newTestServer(t, "-brotli-level", "0")
+
+	tests := []struct {
+		name           string
+		s              *server
+		method, target string
+		acceptEncoding string
+		coding         string
+	}{
+		{"both accepted", s, http.MethodGet, listing, "gzip, deflate, br", "br"},
+		{"gzip preferred", s, http.MethodGet, listing, "br;q=0.5, gzip", "gzip"},
+		{"gzip accepted", s, http.MethodGet, listing, "gzip", "gzip"},
+		{"any coding", s, http.MethodGet, listing, "*", "br"},
+		{"not accepted", s, http.MethodGet, listing, "", ""},
+		{"gzip refused", s, http.MethodGet, listing, "gzip;q=0, *", "br"},
+		{"both refused", s, http.MethodGet, listing, "br;q=0, gzip;q=0, *", ""},
+		{"brotli not offered", gzipOnly, http.MethodGet, listing, "gzip, br", "gzip"},
+		{"short", s, http.MethodGet, "/price?at=2026-01-13T10:00:00Z", "gzip, br", ""},
+		{"head", s, http.MethodHead, listing, "gzip, br", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serve(t, tt.s, tt.method, tt.target, "", "Accept-Encoding", tt.acceptEncoding)
+			wantStatus(t, res, http.StatusOK)
+			if tt.method != http.MethodHead && !strings.Contains(strings.Join(res.Header.Values("Vary"), ","), "Accept-Encoding") {
+				t.Errorf("Vary %q doesn't name Accept-Encoding", res.Header.Values("Vary"))
+			}
+			if got := res.Header.Get("Content-Encoding"); got != tt.coding {
+				t.Fatalf("Content-Encoding %q, want %q", got, tt.coding)
+			}
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tt.coding {
+			case "gzip":
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err := io.ReadAll(zr); err != nil || string(body) != plain {
+					t.Errorf("decompressed body differs from the plain one (%v)", err)
+				}
+			case "br":
+				// The encoder is deterministic, and tested on its own.
+				if want := brotliBytes(t, plain, s.cfg.brotliLevel); !bytes.Equal(body, want) {
+					t.Errorf("%d bytes compressed with Brotli, want the %d of compressing the plain response", len(body), len(want))
+				}
+			}
+		})
+	}
+}
+
+// brotliBytes compresses p with Brotli at level.
+func brotliBytes(t testing.TB, p string, level int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw, err := brotli.NewWriterLevel(&b, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(zw, p)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// countingWriter is a discardWriter that counts the bytes written.
+type countingWriter struct {
+	discardWriter
+	n *int
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	*w.n += len(b)
+	return len(b), nil
+}
+
+// BenchmarkCompress serves a year of hourly prices, encoded per request,
+// gzipped and compressed with Brotli at a range of levels, and
+// uncompressed, reporting the size of the response.
+func BenchmarkCompress(b *testing.B) {
+	target := fmt.Sprintf("/price?start=%s&end=%s",
+		testFirstDay.UTC().Format(time.RFC3339), testFirstDay.AddDate(1, 0, 0).UTC().Format(time.RFC3339))
+	type codingLevel struct {
+		coding string
+		level  int
+	}
+	for _, cl := range []codingLevel{
+		{"identity", 0},
+		{"gzip", 1}, {"gzip", 6}, {"gzip", 9},
+		{"br", 1}, {"br", 4}, {"br", 6}, {"br", 9}, {"br", 11},
+	} {
+		b.Run(fmt.Sprintf("%s-level=%d", cl.coding, cl.level), func(b *testing.B) {
+			s := newTestServer(b, "-max-range", "0", "-no-ondemand", "-gzip-level", "0", "-brotli-level", "0")
+			switch cl.coding {
+			case "gzip":
+				s.cfg.gzipLevel = cl.level
+			case "br":
+				s.cfg.brotliLevel = cl.level
+			}
+			s.store = uniformStore(24*366, 1)
+			// Vary the prices, so that they compress like real ones.
+			prices := make(map[time.Time]float64)
+			for i, p := range s.store.points(time.Time{}, time.Time{}) {
+				prices[p.Start] = float64(i*7919%20000)/100 - 20
+			}
+			s.store.merge(prices, originRefresh, upstreamProvider)
+			h := s.routes(routesAll)
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("Accept-Encoding", cl.coding)
+
+			var size int
+			h.ServeHTTP(countingWriter{discardWriter{http.Header{}}, &size}, req)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				h.ServeHTTP(discardWriter{http.Header{}}, req)
+			}
+			b.ReportMetric(float64(size), "bytes/response")
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errNotModified is returned by conditional fetches when the upstream
+// reports the prices unchanged since the last response.
+var errNotModified = errors.New("prices not modified")
+
+// validator holds the cache validators of an upstream response.
+type validator struct {
+	ETag         string
+	LastModified string
+}
+
+func validatorOf(h http.Header) validator {
+	return validator{ETag: h.Get("ETag"), LastModified: h.Get("Last-Modified")}
+}
+
+// apply makes a request with header h conditional on v.
+func (v validator) apply(h http.Header) {
+	if v.ETag != "" {
+		h.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		h.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// fetchIfModified retrieves the prices between start and end like fetch, but
+// conditional on the validators of the last response for the same window. If
+// the upstream reports them unchanged, it returns errNotModified without
+// prices. Conditional fetches aren't shared with concurrent fetches, whose
+// callers need the prices.
+func (u *upstream) fetchIfModified(ctx context.Context, start, end time.Time) (map[time.Time]float64, error) {
+	key := flightKey{zone: biddingZone, start: start.Unix(), end: end.Unix()}
+	u.mu.Lock()
+	cond := u.validators[key]
+	u.mu.Unlock()
+
+	prices, err := u.fetchWithRetries(ctx, start, end, &cond)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.validators == nil {
+		u.validators = make(map[flightKey]validator)
+	}
+	// Windows that have ended won't be requested by a refresh again.
+	now := u.clock.Now().Unix()
+	for k := range u.validators {
+		if k.end < now {
+			delete(u.validators, k)
+		}
+	}
+	if cond == (validator{}) {
+		delete(u.validators, key)
+	} else {
+		u.validators[key] = cond
+	}
+	return prices, nil
+}
+
+// savedValidator is a validator of a window as saved to the cache file.
+type savedValidator struct {
+	Zone         string `json:"zone"`
+	Start        int64  `json:"start"`
+	End          int64  `json:"end"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// savedValidators returns the validators to save with the cache.
+func (u *upstream) savedValidators() []savedValidator {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var saved []savedValidator
+	for k, v := range u.validators {
+		saved = append(saved, savedValidator{Zone: k.zone, Start: k.start, End: k.end, ETag: v.ETag, LastModified: v.LastModified})
+	}
+	return saved
+}
+
+// restoreValidators adds validators loaded with the cache.
+func (u *upstream) restoreValidators(saved []savedValidator) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.validators == nil {
+		u.validators = make(map[flightKey]validator)
+	}
+	for _, v := range saved {
+		if v.Zone != biddingZone {
+			continue
+		}
+		u.validators[flightKey{zone: v.Zone, start: v.Start, end: v.End}] = validator{ETag: v.ETag, LastModified: v.LastModified}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIfModifiedSince(t *testing.T) {
+	const target = "/price?start=2026-01-13&end=2026-01-14"
+	s := newTestServer(t)
+
+	res := get(t, s, target)
+	wantStatus(t, res, http.StatusOK)
+	modified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("Last-Modified %q: %v", res.Header.Get("Last-Modified"), err)
+	}
+
+	tests := []struct {
+		name   string
+		since  string
+		status int
+	}{
+		{"at", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{"after", modified.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"before", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK},
+		{"invalid", "yesterday", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := get(t, s, target, "If-Modified-Since", tt.since)
+			wantStatus(t, res, tt.status)
+			if got := res.Header.Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified %q, want %q", got, modified.Format(http.TimeFormat))
+			}
+		})
+	}
+
+	t.Run("relative", func(t *testing.T) {
+		res := get(t, s, "/price?start=-1h&end=now", "If-Modified-Since", modified.Format(http.TimeFormat))
+		wantStatus(t, res, http.StatusOK)
+		if got := res.Header.Get("Last-Modified"); got != "" {
+			t.Errorf("Last-Modified %q for a relative range", got)
+		}
+	})
+}
+
+// TestIfModifiedSinceSameSecond checks that a change within the second of
+// the previous one, which can't be told apart at the resolution of
+// Last-Modified, still modifies the cache for If-Modified-Since.
+func TestIfModifiedSinceSameSecond(t *testing.T) {
+	const target = "/price?start=2026-01-13&end=2026-01-14"
+	s := newTestServer(t)
+	first := get(t, s, target).Header.Get("Last-Modified")
+
+	// Changes within the same second as the merge of newTestServer, unless
+	// the second happens to end in between.
+	for i := range 3 {
+		s.store.merge(map[time.Time]float64{testNow.Truncate(time.Hour): float64(i)}, originRefresh, upstreamProvider)
+	}
+
+	res := get(t, s, target, "If-Modified-Since", first)
+	wantStatus(t, res, http.StatusOK)
+	last := res.Header.Get("Last-Modified")
+	if last == first {
+		t.Fatalf("Last-Modified still %q after a change", last)
+	}
+	wantStatus(t, get(t, s, target, "If-Modified-Since", last), http.StatusNotModified)
+
+	m, _ := http.ParseTime(last)
+	if m.After(time.Now().Add(3 * time.Second)) {
+		t.Errorf("Last-Modified %q more than a second per change ahead", last)
+	}
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type config struct {
+	// listeners are the addresses to serve on unless a listener is passed
+	// by systemd socket activation.
+	listeners listenAddrs
+
+	// basePath prefixes all routes. It is either empty or starts with a
+	// slash and has no trailing slash.
+	basePath string
+
+	trustedProxies trustedProxies
+
+	// fx are the currencies prices can be converted to besides euros.
+	fx fxRates
+
+	// strictParams rejects unknown query parameters unless a request opts
+	// out with strict=false.
+	strictParams bool
+
+	// maxRange limits the ranges a listing request can ask for.
+	maxRange time.Duration
+
+	// maxBody limits request bodies unless a route overrides it, and maxURI
+	// limits the request target including the query.
+	maxBody int64
+	maxURI  int
+
+	// memoryLimit is the soft memory limit of the runtime, if set. Expensive
+	// requests are shed while the heap is close to it, or while their
+	// responses would take more than shedInflightBytes, a quarter of the
+	// memory limit unless set.
+	memoryLimit       int64
+	shedInflightBytes int64
+
+	// gzipLevel and brotliLevel are the levels responses are compressed at
+	// with gzip and Brotli, 0 to not offer either, and compressMinSize the
+	// size in bytes from which they are.
+	gzipLevel       int
+	brotliLevel     int
+	compressMinSize int
+
+	// onDemand fetches listing ranges before the cached data from the
+	// upstream, as long as the missing part is at most onDemandMax long.
+	onDemand        bool
+	onDemandMax     time.Duration
+	onDemandTimeout time.Duration
+	// onDemandMerge keeps prices fetched on demand in the cache.
+	onDemandMerge bool
+	// settleAfter is the age after which past days that were fetched
+	// completely aren't fetched on demand again.
+	settleAfter time.Duration
+	// datasetEpoch is when the upstream's prices of the bidding zone begin,
+	// see clampToEpoch.
+	datasetEpoch time.Time
+
+	// upstreamURL is the base URL of the energy-charts API, replaceable by a
+	// server of the same shape such as a local mock.
+	upstreamURL string
+	// upstreamMaxBody limits the size of upstream responses in bytes.
+	upstreamMaxBody int64
+
+	// refreshInterval is the time between regular background refreshes.
+	refreshInterval time.Duration
+	// refreshTimeout bounds each background refresh, which may take longer
+	// than upstream calls on behalf of a request, bounded by onDemandTimeout.
+	refreshTimeout time.Duration
+	// refreshHistory is the number of refresh attempts kept for the admin
+	// endpoint.
+	refreshHistory int
+	// refreshDryRun logs the fetches of the refresher instead of making
+	// them.
+	refreshDryRun bool
+
+	// markup is added to spot prices for gross costs, in ct/kWh.
+	markup float64
+
+	// syncFrom is the base URL of an instance whose prices are fetched
+	// instead of the upstream's, if set. The upstream remains the fallback.
+	syncFrom string
+
+	// staleHorizon is how far ahead of now the cached prices have to
+	// reach for the service not to log warnings about stale data.
+	staleHorizon time.Duration
+
+	// clockSkewThreshold is how far the host clock may be off the
+	// upstream's before a warning is logged and /healthz reports degraded.
+	// correctClock makes "now" follow the upstream's clock beyond it.
+	clockSkewThreshold time.Duration
+	correctClock       bool
+
+	// cacheFile is where the cache is kept across restarts, if set.
+	cacheFile string
+	// persistCounters keeps the totals of the counters across restarts in
+	// a file next to cacheFile.
+	persistCounters bool
+	// forceImport loads a cache file even if it has prices of another zone,
+	// provider or unit.
+	forceImport bool
+	// readOnly keeps the process from writing the cache and counter files,
+	// which are only loaded, and disables the admin endpoints.
+	readOnly bool
+
+	// shutdownTimeout bounds draining requests and saving the cache. If
+	// draining takes it all, the cache is saved within flushTimeout more.
+	shutdownTimeout time.Duration
+
+	// adminToken enables the admin endpoints for requests bearing it.
+	adminToken string
+	// adminMaxDelete limits the slots removed by a single admin request.
+	adminMaxDelete int
+
+	// printConfig prints settings and exits instead of serving.
+	printConfig bool
+	// settings are the resolved flags together with where their values
+	// came from.
+	settings map[string]setting
+
+	// The fields below aren't set by flags but by tests that run the
+	// service in-process.
+
+	// clock replaces the system clock if set.
+	clock clock
+	// ready is called with the listeners once they accept requests.
+	ready func(lns []net.Listener)
+}
+
+// setting is the effective value of a flag.
+type setting struct {
+	Value string `json:"value"`
+	// Source is "flag" for flags given on the command line and "default"
+	// otherwise.
+	Source string `json:"source"`
+}
+
+func parseConfig(args []string) (config, error) {
+	cfg := config{
+		maxRange:        366 * 24 * time.Hour,
+		maxBody:         64 << 10,
+		maxURI:          8 << 10,
+		gzipLevel:       6,
+		brotliLevel:     4,
+		compressMinSize: 1 << 10,
+		onDemandMax:     31 * 24 * time.Hour,
+		settleAfter:     7 * 24 * time.Hour,
+		onDemandTimeout: 10 * time.Second,
+		refreshInterval: 6 * time.Hour,
+		refreshTimeout:  5 * time.Minute,
+		refreshHistory:  50,
+		upstreamURL:     "https://api.energy-charts.info",
+		datasetEpoch:    historyStart,
+		upstreamMaxBody: 50 << 20,
+		shutdownTimeout: 10 * time.Second,
+		staleHorizon:    6 * time.Hour,
+		adminMaxDelete:  1000,
+
+		clockSkewThreshold: time.Minute,
+	}
+	var adminTokenFile string
+	var noOnDemand bool
+	fs := flag.NewFlagSet("energy-market-prices", flag.ContinueOnError)
+	fs.Var(&cfg.listeners, "listen", "`address` to serve on when not socket activated, optionally prefixed with all=, public= or internal= to select routes; may be repeated (default :2002)")
+	fs.StringVar(&cfg.basePath, "base-path", "", "`prefix` of all routes, e.g. /energy")
+	fs.Var(&cfg.trustedProxies, "trusted-proxies", "comma-separated `networks` of reverse proxies whose forwarding headers to trust")
+	fs.Var(&cfg.fx, "fx", "conversion `rate` from euros as CODE=RATE[@YYYY-MM-DD], may be repeated")
+	fs.BoolVar(&cfg.strictParams, "strict-params", false, "reject unknown query parameters by default")
+	fs.Var((*durationFlag)(&cfg.maxRange), "max-range", "maximum `duration` of an explicit listing range, 0 for no limit")
+	fs.Int64Var(&cfg.maxBody, "max-body", cfg.maxBody, "maximum request body size in `bytes` for routes without a limit of their own")
+	fs.IntVar(&cfg.maxURI, "max-uri", cfg.maxURI, "maximum length of request targets in `bytes`")
+	fs.Int64Var(&cfg.memoryLimit, "memory-limit", 0, "soft memory limit of the runtime in `bytes`, shedding expensive requests close to it; 0 leaves GOMEMLIMIT in effect")
+	fs.Int64Var(&cfg.shedInflightBytes, "shed-inflight-bytes", 0, "shed expensive requests while the responses in flight would take more than this many `bytes`; defaults to a quarter of -memory-limit")
+	fs.IntVar(&cfg.gzipLevel, "gzip-level", cfg.gzipLevel, "`level` from 1 (fastest) to 9 (smallest) responses are gzipped at for clients accepting it, 0 to not compress responses")
+	fs.IntVar(&cfg.brotliLevel, "brotli-level", cfg.brotliLevel, "`level` from 1 (fastest) to 11 (smallest) responses are compressed at with Brotli for clients preferring it to gzip, 0 to not offer Brotli")
+	fs.IntVar(&cfg.compressMinSize, "compress-min-size", cfg.compressMinSize, "minimum size in `bytes` of responses to compress")
+	fs.BoolVar(&noOnDemand, "no-ondemand", false, "never fetch uncached ranges from the upstream while serving a request")
+	fs.Var((*durationFlag)(&cfg.onDemandMax), "ondemand-max", "maximum `duration` of uncached data to fetch for a request")
+	fs.Var((*durationFlag)(&cfg.settleAfter), "settle-after", "age as a `duration` after which past days fetched completely are never fetched on demand again, as the upstream no longer corrects them")
+	fs.Var((*durationFlag)(&cfg.onDemandTimeout), "ondemand-timeout", "maximum `duration` to wait for the upstream while serving a request, answered with 504 when exceeded")
+	fs.BoolVar(&cfg.onDemandMerge, "ondemand-merge", true, "keep prices fetched for a request in the cache")
+	fs.Var((*timeFlag)(&cfg.datasetEpoch), "dataset-epoch", "RFC 3339 `time` or UTC date from which the upstream has prices, earlier starts of ranges are clamped to it")
+	fs.StringVar(&cfg.upstreamURL, "upstream-url", cfg.upstreamURL, "base `URL` of the energy-charts API, e.g. of a recorded or mock upstream for testing")
+	fs.Int64Var(&cfg.upstreamMaxBody, "upstream-max-body", cfg.upstreamMaxBody, "maximum size of upstream responses in `bytes`")
+	fs.Var((*durationFlag)(&cfg.refreshInterval), "refresh-interval", "`duration` between background refreshes")
+	fs.Var((*durationFlag)(&cfg.refreshTimeout), "refresh-timeout", "maximum `duration` of a background refresh including retries")
+	fs.BoolVar(&cfg.refreshDryRun, "refresh-dry-run", false, "log the window, expected slots and source of every refresh, including the initial fetch, instead of fetching and merging them; on-demand fetches are unaffected")
+	fs.IntVar(&cfg.refreshHistory, "refresh-history", cfg.refreshHistory, "`number` of refresh attempts kept for /admin/refreshes")
+	fs.Float64Var(&cfg.markup, "markup", 0, "`ct/kWh` added to spot prices for gross costs, e.g. grid fees and taxes")
+	fs.StringVar(&cfg.syncFrom, "sync-from", "", "base `URL` of another instance to fetch prices from instead of the upstream, e.g. http://primary:2002")
+	fs.Var((*durationFlag)(&cfg.staleHorizon), "stale-alert-horizon", "log a warning while the cached prices end less than this `duration` from now, 0 to warn only once they have run out")
+	fs.Var((*durationFlag)(&cfg.clockSkewThreshold), "clock-skew-threshold", "warn when the host clock is more than this `duration` off the upstream's Date header")
+	fs.BoolVar(&cfg.correctClock, "correct-clock", false, "use the upstream's clock for the current time while the host clock is off by more than -clock-skew-threshold")
+	fs.StringVar(&cfg.cacheFile, "cache-file", "", "`file` to save the cache to on shutdown and load it from on startup")
+	fs.BoolVar(&cfg.persistCounters, "persist-counters", false, "keep the totals of all counters across restarts next to the cache file, exposed as _lifetime metrics")
+	fs.BoolVar(&cfg.forceImport, "force-import", false, "load the cache file even if its zone, provider or unit don't match")
+	fs.BoolVar(&cfg.readOnly, "read-only", false, "never write the cache or counter files, only load them, and answer the admin endpoints with 403")
+	fs.Var((*durationFlag)(&cfg.shutdownTimeout), "shutdown-timeout", "maximum `duration` of a graceful shutdown")
+	fs.StringVar(&adminTokenFile, "admin-token-file", "", "`file` containing the bearer token enabling the admin endpoints")
+	fs.IntVar(&cfg.adminMaxDelete, "admin-max-delete", cfg.adminMaxDelete, "maximum `number` of slots removed by one admin request")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "print the effective configuration as JSON and exit")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	cfg.onDemand = !noOnDemand
+
+	// Unlike parse errors, these aren't reported by the flag set itself.
+	if err := cfg.finish(adminTokenFile); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return cfg, err
+	}
+
+	// The flags point at the fields of cfg, so their values are those
+	// normalized by finish.
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	cfg.settings = map[string]setting{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-config" {
+			return
+		}
+		s := setting{Value: f.Value.String(), Source: "default"}
+		if given[f.Name] {
+			s.Source = "flag"
+		}
+		cfg.settings[f.Name] = s
+	})
+	return cfg, nil
+}
+
+// writeSettings writes the effective configuration as JSON to w. Secrets
+// are redacted.
+func (cfg config) writeSettings(w io.Writer) error {
+	settings := maps.Clone(cfg.settings)
+	if cfg.adminToken != "" {
+		settings["admin-token"] = setting{Value: "<redacted>", Source: "admin-token-file"}
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// finish normalizes the parsed flags and reads the files they refer to.
+func (cfg *config) finish(adminTokenFile string) error {
+	if len(cfg.listeners) == 0 {
+		cfg.listeners = listenAddrs{{routes: routesAll, addr: net.JoinHostPort("", "2002")}}
+	}
+	cfg.listeners = cfg.listeners.resolve()
+
+	var err error
+	if cfg.basePath, err = normalizeBasePath(cfg.basePath); err != nil {
+		return err
+	}
+	if cfg.persistCounters && cfg.cacheFile == "" {
+		return errors.New("-persist-counters requires -cache-file")
+	}
+	if cfg.gzipLevel < 0 || cfg.gzipLevel > 9 {
+		return fmt.Errorf("invalid gzip level %d: must be between 0 and 9", cfg.gzipLevel)
+	}
+	if cfg.brotliLevel < 0 || cfg.brotliLevel > 11 {
+		return fmt.Errorf("invalid brotli level %d: must be between 0 and 11", cfg.brotliLevel)
+	}
+	if cfg.memoryLimit < 0 || cfg.shedInflightBytes < 0 {
+		return errors.New("-memory-limit and -shed-inflight-bytes must not be negative")
+	}
+	if cfg.shedInflightBytes == 0 {
+		cfg.shedInflightBytes = cfg.memoryLimit / 4
+	}
+	if cfg.refreshHistory < 1 {
+		return fmt.Errorf("invalid refresh history size %d: must be at least 1", cfg.refreshHistory)
+	}
+	if u, err := url.Parse(cfg.upstreamURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid upstream URL %q: expected e.g. https://api.energy-charts.info", cfg.upstreamURL)
+	}
+	cfg.upstreamURL = strings.TrimSuffix(cfg.upstreamURL, "/")
+	if cfg.refreshInterval < time.Second {
+		return fmt.Errorf("invalid refresh interval %s: must be at least 1s", cfg.refreshInterval)
+	}
+	if cfg.syncFrom != "" {
+		u, err := url.Parse(cfg.syncFrom)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid sync URL %q: expected e.g. http://primary:2002", cfg.syncFrom)
+		}
+		cfg.syncFrom = strings.TrimSuffix(cfg.syncFrom, "/")
+	}
+	if adminTokenFile != "" {
+		token, err := os.ReadFile(adminTokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading admin token: %w", err)
+		}
+		if cfg.adminToken = strings.TrimSpace(string(token)); cfg.adminToken == "" {
+			return fmt.Errorf("admin token file %s is empty", adminTokenFile)
+		}
+	}
+	return nil
+}
+
+// normalizeBasePath turns "energy/", "/energy" and "/energy//" into
+// "/energy", and "/" into the empty base path.
+func normalizeBasePath(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "{}?#% ") {
+		return "", fmt.Errorf("invalid base path %q", p)
+	}
+	return "/" + p, nil
+}
+
+// durationFlag is a flag.Value accepting the durations of parseDuration.
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string {
+	return formatDuration(time.Duration(*d))
+}
+
+func (d *durationFlag) Set(v string) error {
+	parsed, err := parseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(parsed)
+	return nil
+}
+
+// timeFlag is a flag.Value accepting the times of parseTime, with dates in
+// UTC.
+type timeFlag time.Time
+
+func (t *timeFlag) String() string {
+	return time.Time(*t).UTC().Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(v string) error {
+	parsed, err := parseTime(v, time.UTC)
+	if err != nil {
+		return err
+	}
+	*t = timeFlag(parsed)
+	return nil
+}
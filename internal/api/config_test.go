@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestPrintConfig prints the configuration of flags given on the command
+// line, repeated, left at their defaults and derived from others, and
+// checks the values against those parseConfig gives serving.
+func TestPrintConfig(t *testing.T) {
+	args := append(adminTokenArgs(t),
+		"-print-config",
+		"-refresh-interval", "1h",
+		// The last of repeated flags wins.
+		"-gzip-level", "1", "-gzip-level", "9",
+		"-base-path", "energy/",
+		"-upstream-url", "http://upstream.test/",
+		"-memory-limit", "1024",
+	)
+	var out bytes.Buffer
+	if code := Main(context.Background(), args, &out); code != 0 {
+		t.Fatalf("exit status %d, want 0", code)
+	}
+	var settings map[string]setting
+	if err := json.Unmarshal(out.Bytes(), &settings); err != nil {
+		t.Fatalf("%v in %s", err, out.Bytes())
+	}
+
+	for name, want := range map[string]setting{
+		"refresh-interval": {"1h0m0s", "flag"},
+		"gzip-level":       {"9", "flag"},
+		"base-path":        {"/energy", "flag"},
+		"upstream-url":     {"http://upstream.test", "flag"},
+		"memory-limit":     {"1024", "flag"},
+		// Settings derived from others are still defaults.
+		"shed-inflight-bytes": {"256", "default"},
+		"listen":              {"all=:2002", "default"},
+		"brotli-level":        {"4", "default"},
+		"refresh-timeout":     {"5m0s", "default"},
+		"no-ondemand":         {"false", "default"},
+		"admin-token":         {"<redacted>", "admin-token-file"},
+	} {
+		if got := settings[name]; got != want {
+			t.Errorf("%s is %+v, want %+v", name, got, want)
+		}
+	}
+	if _, ok := settings["print-config"]; ok {
+		t.Error("print-config is listed")
+	}
+	if strings.Contains(out.String(), testAdminToken) {
+		t.Errorf("the admin token is printed:\n%s", out.Bytes())
+	}
+
+	// Serving runs with what is printed.
+	cfg, err := parseConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.refreshInterval.String() != "1h0m0s" || cfg.gzipLevel != 9 || cfg.basePath != "/energy" || cfg.upstreamURL != "http://upstream.test" || cfg.shedInflightBytes != 256 {
+		t.Errorf("serving with refresh interval %s, gzip level %d, base path %q, upstream %q and shedding at %d bytes",
+			cfg.refreshInterval, cfg.gzipLevel, cfg.basePath, cfg.upstreamURL, cfg.shedInflightBytes)
+	}
+
+	// Invalid configurations aren't printed.
+	out.Reset()
+	if code := Main(context.Background(), []string{"-print-config", "-gzip-level", "10"}, &out); code != 2 || out.Len() > 0 {
+		t.Errorf("with an invalid gzip level: exit status %d and printed %q, want 2 and nothing", code, out.Bytes())
+	}
+}
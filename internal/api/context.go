@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// handleContext relates the current price to the prices of the trailing window.
+func (s *server) handleContext(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	window := 30 * 24 * time.Hour
+	if v := q.Get("window"); v != "" {
+		if window, err = parseDuration(v); err != nil || window <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, "window: expected a positive duration such as 30d")
+			return
+		}
+	}
+
+	cur, ok := s.store.at(s.clock.Now())
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "no price for the current slot")
+		return
+	}
+
+	from := cur.Start.Add(-window)
+	var trailing []float64
+	below, equal := 0, 0
+	for _, p := range s.store.points(from, cur.Start) {
+		trailing = append(trailing, p.Price)
+		switch {
+		case p.Price < cur.Price:
+			below++
+		case p.Price == cur.Price:
+			equal++
+		}
+	}
+	if len(trailing) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "no prices in the trailing window")
+		return
+	}
+
+	writeJSON(w, struct {
+		Time           int64   `json:"time"`
+		Price          float64 `json:"price"`
+		Unit           string  `json:"unit"`
+		Window         string  `json:"window"`
+		Samples        int     `json:"samples"`
+		Percentile     float64 `json:"percentile"`
+		TrailingMean   float64 `json:"trailing_mean"`
+		TrailingMedian float64 `json:"trailing_median"`
+	}{
+		Time:           cur.Start.Unix(),
+		Price:          cur.Price / div,
+		Unit:           unit,
+		Window:         window.String(),
+		Samples:        len(trailing),
+		Percentile:     100 * (float64(below) + float64(equal)/2) / float64(len(trailing)),
+		TrailingMean:   mean(trailing) / div,
+		TrailingMedian: median(trailing) / div,
+	})
+}
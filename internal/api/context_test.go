@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	// The slot of testNow costs 115, more than every earlier one.
+	tests := []struct {
+		query   string
+		samples int
+		mean    float64
+		median  float64
+	}{
+		{"window=1h", 1, 114, 114},
+		{"window=2h", 2, 113.5, 113.5},
+		{"", 39, 1881.0 / 39, 19},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res := get(t, s, "/price/context?"+tt.query)
+			wantStatus(t, res, http.StatusOK)
+			got := decode[struct {
+				Price          float64 `json:"price"`
+				Samples        int     `json:"samples"`
+				Percentile     float64 `json:"percentile"`
+				TrailingMean   float64 `json:"trailing_mean"`
+				TrailingMedian float64 `json:"trailing_median"`
+			}](t, res)
+			if got.Price != 115 || got.Samples != tt.samples || got.Percentile != 100 || got.TrailingMean != tt.mean || got.TrailingMedian != tt.median {
+				t.Errorf("got %+v, want price 115, %d samples at percentile 100 with mean %v and median %v", got, tt.samples, tt.mean, tt.median)
+			}
+		})
+	}
+}
+
+// BenchmarkContext measures the context of the current price in a 90-day
+// window of quarter-hourly prices.
+func BenchmarkContext(b *testing.B) {
+	s := newTestServer(b)
+	prices := make(map[time.Time]float64)
+	for t := testFirstDay.AddDate(0, 0, -90); t.Before(testFirstDay); t = t.Add(15 * time.Minute) {
+		prices[t] = float64(t.Unix()%997) / 10
+	}
+	s.store.merge(prices, originRefresh, upstreamProvider)
+
+	b.ReportAllocs()
+	for range b.N {
+		if res := get(b, s, "/price/context?window=90d"); res.StatusCode != http.StatusOK {
+			b.Fatalf("status %d", res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// maxCostBytes limits the size of cost request bodies, overriding the
+	// configured default.
+	maxCostBytes = 1 << 20
+	// maxCostEntries limits the number of consumption entries per request.
+	maxCostEntries = 10000
+)
+
+// consumption is energy used in the interval [Start, End).
+type consumption struct {
+	Start, End time.Time
+	KWh        float64
+}
+
+type costEntry struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	KWh   float64   `json:"kwh"`
+	// Average is the time-weighted spot price in the currency per kWh. It
+	// and the costs are null unless the whole interval is cached.
+	Average   *float64 `json:"average_price"`
+	Cost      *float64 `json:"cost"`
+	GrossCost *float64 `json:"gross_cost,omitempty"`
+}
+
+// handleCost computes the spot cost of each consumption entry of the body
+// using time-weighted prices. The body is a JSON array of objects with start,
+// end and kwh, or CSV with a start,end,kwh header. Entries not completely
+// covered by the cache are listed without a cost and left out of the total.
+// With a configured markup, gross costs add it to every kWh.
+func (s *server) handleCost(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	currency := q.Get("currency")
+	if currency == "" {
+		currency = baseCurrency
+	}
+	// The cost per kWh is the price in EUR/kWh converted to the currency.
+	priceQuery := maps.Clone(q)
+	priceQuery.Set("unit", "EUR/kWh")
+	_, div, err := s.parseUnit(w, priceQuery)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	var entries []consumption
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "text/csv" {
+		entries, err = parseConsumptionCSV(r.Body)
+	} else {
+		entries, err = parseConsumptionJSON(r.Body)
+	}
+	if err != nil {
+		if errors.Is(err, errTooManyEntries) {
+			writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, err.Error())
+		} else if !bodyTooLarge(w, err) {
+			badRequest(w, err)
+		}
+		return
+	}
+
+	res := struct {
+		Currency   string      `json:"currency"`
+		Entries    []costEntry `json:"entries"`
+		Total      float64     `json:"total"`
+		GrossTotal *float64    `json:"gross_total,omitempty"`
+		Uncosted   int         `json:"uncosted"`
+	}{Currency: currency, Entries: make([]costEntry, len(entries))}
+	var grossTotal float64
+
+	var points []pricePoint
+	if len(entries) > 0 {
+		first := slices.MinFunc(entries, func(a, b consumption) int { return a.Start.Compare(b.Start) })
+		last := slices.MaxFunc(entries, func(a, b consumption) int { return a.End.Compare(b.End) })
+		points = s.store.points(first.Start.Add(-maxSlotDuration), last.End)
+	}
+	for i, e := range entries {
+		ce := costEntry{Start: e.Start.UTC(), End: e.End.UTC(), KWh: e.KWh}
+		avg, covered := timeWeightedAverage(pointsBetween(points, e.Start.Add(-maxSlotDuration), e.End), e.Start, e.End)
+		if covered == e.End.Sub(e.Start) {
+			price := avg / div
+			cost := price * e.KWh
+			ce.Average, ce.Cost = &price, &cost
+			res.Total += cost
+			if s.cfg.markup != 0 {
+				// The markup is in ct/kWh, i.e. tens of EUR/MWh.
+				gross := (avg + 10*s.cfg.markup) / div * e.KWh
+				ce.GrossCost = &gross
+				grossTotal += gross
+			}
+		} else {
+			res.Uncosted++
+		}
+		res.Entries[i] = ce
+	}
+	if s.cfg.markup != 0 {
+		res.GrossTotal = &grossTotal
+	}
+	writeJSON(w, res)
+}
+
+// pointsBetween returns the points of the sorted points starting in
+// [start, end).
+func pointsBetween(points []pricePoint, start, end time.Time) []pricePoint {
+	cmp := func(p pricePoint, t time.Time) int { return p.Start.Compare(t) }
+	lo, _ := slices.BinarySearchFunc(points, start, cmp)
+	hi, _ := slices.BinarySearchFunc(points, end, cmp)
+	return points[lo:max(lo, hi)]
+}
+
+var errTooManyEntries = fmt.Errorf("at most %d entries per request", maxCostEntries)
+
+func parseConsumptionJSON(body io.Reader) ([]consumption, error) {
+	var raw []struct {
+		Start json.RawMessage `json:"start"`
+		End   json.RawMessage `json:"end"`
+		KWh   *float64        `json:"kwh"`
+	}
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of objects with start, end and kwh: %w", err)
+	}
+	if len(raw) > maxCostEntries {
+		return nil, errTooManyEntries
+	}
+
+	entries := make([]consumption, len(raw))
+	for i, v := range raw {
+		if v.Start == nil || v.End == nil || v.KWh == nil {
+			return nil, fmt.Errorf("entry %d: start, end and kwh are required", i)
+		}
+		start, err := parseInstantJSON(v.Start)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: start: %w", i, err)
+		}
+		end, err := parseInstantJSON(v.End)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: end: %w", i, err)
+		}
+		if entries[i], err = newConsumption(start, end, *v.KWh); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	return entries, nil
+}
+
+// parseConsumptionCSV reads consumption in CSV with the header start,end,kwh.
+// Documents separated by semicolons are read with decimal commas, like the
+// listings exported with locale=de.
+func parseConsumptionCSV(body io.Reader) ([]consumption, error) {
+	locale, body := detectCSVLocale(body)
+	cr := csv.NewReader(body)
+	cr.Comma = locale.comma
+	cr.FieldsPerRecord = 3
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("empty body")
+		}
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if !slices.Equal(header, []string{"start", "end", "kwh"}) {
+		return nil, errors.New("expected CSV with the header start,end,kwh")
+	}
+
+	var entries []consumption
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(entries) == maxCostEntries {
+			return nil, errTooManyEntries
+		}
+
+		start, err := parseInstant(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: start: %w", line, err)
+		}
+		end, err := parseInstant(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: end: %w", line, err)
+		}
+		kwh, err := locale.parseFloat(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: kwh: invalid number %q", line, record[2])
+		}
+		c, err := newConsumption(start, end, kwh)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, c)
+	}
+}
+
+func newConsumption(start, end time.Time, kwh float64) (consumption, error) {
+	if !start.Before(end) {
+		return consumption{}, errors.New("start must be before end")
+	}
+	if math.IsNaN(kwh) || math.IsInf(kwh, 0) {
+		return consumption{}, fmt.Errorf("kwh: invalid number %v", kwh)
+	}
+	return consumption{start, end, kwh}, nil
+}
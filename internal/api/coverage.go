@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coverageGap is a hole in the cached prices as listed by handleCoverage.
+type coverageGap struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	MissingSlots int       `json:"missing_slots"`
+}
+
+// maxMissingRanges limits the ranges listed in X-Range-Missing.
+const maxMissingRanges = 32
+
+// markCoverage states in headers whether the cache covers the range of the
+// start and end parameters of q, so that clients can tell a range without
+// published prices from a wrong one: X-Range-Complete is true or false and
+// X-Range-Missing lists the uncovered parts as RFC 3339 intervals, at most
+// maxMissingRanges of the X-Range-Missing-Count. Invalid parameters are left
+// to the handler.
+func (s *server) markCoverage(h http.Header, st *store, q url.Values) {
+	if !q.Has("start") && !q.Has("end") {
+		return
+	}
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		return
+	}
+	missing := st.missing(start, end)
+	h.Set("X-Range-Complete", strconv.FormatBool(len(missing) == 0))
+	if len(missing) == 0 {
+		return
+	}
+	listed := make([]string, 0, min(len(missing), maxMissingRanges))
+	for _, m := range missing[:cap(listed)] {
+		listed = append(listed, m.Start.UTC().Format(time.RFC3339)+"/"+m.End.UTC().Format(time.RFC3339))
+	}
+	h.Set("X-Range-Missing", strings.Join(listed, ", "))
+	h.Set("X-Range-Missing-Count", strconv.Itoa(len(missing)))
+}
+
+// coverageWriter calls mark with the headers of a successful response
+// before they are written.
+type coverageWriter struct {
+	http.ResponseWriter
+	mark   func(h http.Header)
+	marked bool
+}
+
+func (w *coverageWriter) WriteHeader(code int) {
+	if !w.marked && code < 300 {
+		w.mark(w.Header())
+	}
+	w.marked = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *coverageWriter) Write(p []byte) (int, error) {
+	if !w.marked {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *coverageWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// handleCoverage describes which ranges can be queried: the bounds of the
+// cached prices, their current resolution and the holes in them. The holes
+// are kept by the store as it changes, so this doesn't scan the cache.
+func (s *server) handleCoverage(w http.ResponseWriter, _ *http.Request) {
+	m := s.store.meta()
+	gaps, res := s.store.coverage()
+
+	listed := make([]coverageGap, len(gaps))
+	for i, g := range gaps {
+		listed[i] = coverageGap{g.Start.UTC(), g.End.UTC(), g.Missing}
+	}
+	writeJSON(w, struct {
+		Zone              string        `json:"zone"`
+		Earliest          time.Time     `json:"earliest"`
+		Latest            time.Time     `json:"latest"`
+		Through           time.Time     `json:"through"`
+		Resolution        int           `json:"resolution_minutes"`
+		Gaps              []coverageGap `json:"gaps"`
+		HistoryStart      time.Time     `json:"history_start"`
+		DatasetEpoch      time.Time     `json:"dataset_epoch"`
+		TomorrowAvailable bool          `json:"tomorrow_available"`
+	}{
+		Zone:              biddingZone,
+		Earliest:          m.Earliest.UTC(),
+		Latest:            m.Latest.UTC(),
+		Through:           m.Through.UTC(),
+		Resolution:        int(res.Minutes()),
+		Gaps:              listed,
+		HistoryStart:      historyStart,
+		DatasetEpoch:      s.datasetEpoch(s.store).UTC(),
+		TomorrowAvailable: tomorrowAvailable(m.Latest, s.clock.Now(), s.loc),
+	})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// csvLocale is the separator and number format of CSV documents.
+type csvLocale struct {
+	name    string
+	comma   rune
+	decimal string
+}
+
+// csvLocales are the accepted values of the locale parameter. Spreadsheets
+// set up for German expect semicolons between fields, as the comma is the
+// decimal separator.
+var csvLocales = map[string]csvLocale{
+	"en": {name: "en", comma: ',', decimal: "."},
+	"de": {name: "de", comma: ';', decimal: ","},
+}
+
+// parseCSVLocale reads the optional locale query parameter.
+func parseCSVLocale(q url.Values) (csvLocale, error) {
+	v := q.Get("locale")
+	if v == "" {
+		return csvLocales["en"], nil
+	}
+	l, ok := csvLocales[v]
+	if !ok {
+		return csvLocale{}, fmt.Errorf("locale: unknown locale %q, expected one of %s", v, strings.Join(slices.Sorted(maps.Keys(csvLocales)), ", "))
+	}
+	return l, nil
+}
+
+func (l csvLocale) formatFloat(f float64) string {
+	return strings.Replace(formatFloat(f), ".", l.decimal, 1)
+}
+
+func (l csvLocale) parseFloat(v string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(v, l.decimal, ".", 1), 64)
+}
+
+// detectCSVLocale returns the locale of the CSV document read from r by the
+// separator of its header line, together with a reader of the whole document.
+func detectCSVLocale(r io.Reader) (csvLocale, io.Reader) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		head = head[:i]
+	}
+	if bytes.IndexByte(head, ';') >= 0 {
+		return csvLocales["de"], br
+	}
+	return csvLocales["en"], br
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nextCursorTrailer names the cursor to resume a streamed listing after its
+// last slot.
+const nextCursorTrailer = "X-Next-Cursor"
+
+// listingCursor resumes a listing after the slot starting at after, as
+// listed from the cache of generation. It is written as the generation and
+// the Unix seconds of the slot separated by a colon, so that a client whose
+// transfer broke off can build it from X-Cache-Generation and the last row it
+// received completely. Listings are sorted by time, so the resumed listing
+// continues the interrupted one row by row, as long as it is answered from
+// the same generation.
+type listingCursor struct {
+	generation uint64
+	after      time.Time
+}
+
+func (c listingCursor) String() string {
+	return strconv.FormatUint(c.generation, 10) + ":" + strconv.FormatInt(c.after.Unix(), 10)
+}
+
+// parseCursor reads the optional cursor query parameter.
+func parseCursor(q url.Values) (_ listingCursor, ok bool, _ error) {
+	v := q.Get("cursor")
+	if v == "" {
+		return listingCursor{}, false, nil
+	}
+	g, t, _ := strings.Cut(v, ":")
+	generation, err := strconv.ParseUint(g, 10, 64)
+	if err != nil {
+		return listingCursor{}, false, fmt.Errorf("cursor: expected a generation and Unix seconds separated by a colon, got %q", v)
+	}
+	after, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return listingCursor{}, false, fmt.Errorf("cursor: expected a generation and Unix seconds separated by a colon, got %q", v)
+	}
+	return listingCursor{generation, time.Unix(after, 0)}, true, nil
+}
+
+// resume narrows [start, end) to the slots after the cursor in q, if any.
+// A cursor of another generation than the one answering the request is
+// refused with 409, as the listing may have changed before it; it can be
+// resumed by pinning the request to the cursor's generation as long as that
+// is kept. If the response has been written, ok is false.
+func (s *server) resume(w http.ResponseWriter, q url.Values, start time.Time) (_ time.Time, ok bool) {
+	cursor, ok, err := parseCursor(q)
+	if err != nil {
+		badRequest(w, err)
+		return start, false
+	}
+	if !ok {
+		return start, true
+	}
+	if q.Has("since_generation") {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "cursor: cannot be combined with since_generation")
+		return start, false
+	}
+	if current := s.store.meta().Generation; cursor.generation != current {
+		w.Header().Set(generationHeader, strconv.FormatUint(current, 10))
+		writeError(w, http.StatusConflict, codeGenerationUnavailable, fmt.Sprintf(
+			"the cursor is of generation %d, the listing is of generation %d, resume with generation=%d while it is kept or start over",
+			cursor.generation, current, cursor.generation,
+		))
+		return start, false
+	}
+	if after := cursor.after.Add(time.Nanosecond); start.IsZero() || start.Before(after) {
+		start = after
+	}
+	return start, true
+}
+
+// maxCursorEvery bounds the cursor_every parameter of listings.
+const maxCursorEvery = 1000000
+
+// cursorRecord is the in-band cursor of NDJSON listings requested with
+// cursor_every. Sent after every that many slots, it resumes the listing
+// after the slot before it, so that a client whose transfer broke off learns
+// where to resume without the trailer, which never arrives then.
+type cursorRecord struct {
+	Cursor string `json:"cursor"`
+}
+
+// trailCursor declares the cursor trailer of a streamed listing and yields
+// slots, setting the trailer to the cursor after the last of them once all
+// were yielded. It must be called before the response is written.
+func (s *server) trailCursor(w http.ResponseWriter, slots iter.Seq[cachedSlot]) iter.Seq[cachedSlot] {
+	w.Header().Set("Trailer", nextCursorTrailer)
+	generation := s.store.meta().Generation
+	return func(yield func(cachedSlot) bool) {
+		var last time.Time
+		for c := range slots {
+			if !yield(c) {
+				return
+			}
+			last = c.Start
+		}
+		if !last.IsZero() {
+			w.Header().Set(nextCursorTrailer, listingCursor{generation, last}.String())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCursorResume breaks off a transfer of an NDJSON listing with in-band
+// cursors and resumes it from the last cursor received, and checks that the
+// slots before that cursor and the resumed listing concatenate to the whole
+// listing byte by byte.
+func TestCursorResume(t *testing.T) {
+	const listing = "/price?format=ndjson"
+	s := newTestServer(t)
+	want := readBody(t, get(t, s, listing))
+	if n := strings.Count(want, "\n"); n != 24*testDays {
+		t.Fatalf("%d slots listed, want %d", n, 24*testDays)
+	}
+
+	srv := httptest.NewServer(s.routes(routesAll))
+	defer srv.Close()
+	res, err := srv.Client().Get(srv.URL + listing + "&cursor_every=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The transfer breaks off after 25 slots, 5 of them after the last
+	// cursor.
+	var received strings.Builder
+	var cursor string
+	br := bufio.NewReader(res.Body)
+	for slots := 0; slots < 25; {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record cursorRecord
+		if json.Unmarshal([]byte(line), &record); record.Cursor != "" {
+			cursor = record.Cursor
+			continue
+		}
+		received.WriteString(line)
+		slots++
+	}
+	res.Body.Close()
+	if want := "1:" + strconv.FormatInt(testFirstDay.Add(19*time.Hour).Unix(), 10); cursor != want {
+		t.Fatalf("last cursor %q, want %q", cursor, want)
+	}
+	before := strings.Join(strings.SplitAfter(received.String(), "\n")[:20], "")
+
+	resumed := readBody(t, get(t, s, listing+"&cursor="+cursor))
+	if got := before + resumed; got != want {
+		t.Errorf("resumed listing doesn't continue the interrupted one:\n%s\nwant\n%s", got, want)
+	}
+
+	// Cursors are sent every 10 slots and end with the last full batch.
+	full := readBody(t, get(t, s, listing+"&cursor_every=10"))
+	if n := strings.Count(full, `{"cursor":`); n != 24*testDays/10 {
+		t.Errorf("%d cursors in a listing of %d slots, want %d", n, 24*testDays, 24*testDays/10)
+	}
+
+	// A cursor of an earlier generation is refused.
+	s.store.merge(map[time.Time]float64{testFirstDay.AddDate(0, 0, testDays): 1}, originRefresh, upstreamProvider)
+	wantError(t, get(t, s, listing+"&cursor="+cursor), http.StatusConflict, codeGenerationUnavailable, "the cursor is of generation 1")
+	wantError(t, get(t, s, "/price?format=csv&cursor_every=10"), http.StatusBadRequest, codeInvalidParameter, "only supported for NDJSON")
+}
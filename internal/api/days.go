@@ -0,0 +1,24 @@
+package api
+
+import (
+	"time"
+)
+
+// day is the set of slots starting on one calendar day in the market timezone.
+type day struct {
+	Date   string
+	Points []pricePoint
+}
+
+// groupByDay splits sorted points into consecutive local calendar days of loc.
+func groupByDay(points []pricePoint, loc *time.Location) []day {
+	var days []day
+	for _, p := range points {
+		date := p.Start.In(loc).Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, day{Date: date})
+		}
+		days[len(days)-1].Points = append(days[len(days)-1].Points, p)
+	}
+	return days
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// slotDiff is a difference between two generations of the cache.
+type slotDiff struct {
+	Time time.Time `json:"time"`
+	// Change is added, removed or updated.
+	Change   string   `json:"change"`
+	OldPrice *float64 `json:"old_price"`
+	NewPrice *float64 `json:"new_price"`
+	// Origin and MergedAt are of the merge that introduced the new price.
+	Origin   string     `json:"origin,omitempty"`
+	MergedAt *time.Time `json:"merged_at,omitempty"`
+}
+
+// cacheDiff lists the slots that differ between the previous and the
+// current generation of the cache.
+type cacheDiff struct {
+	From    uint64     `json:"from_generation"`
+	To      uint64     `json:"to_generation"`
+	Changed time.Time  `json:"changed_at"`
+	Slots   []slotDiff `json:"slots"`
+}
+
+// diff compares the slots of the previous generation with the current ones.
+// It is false while the only change was filling the empty cache, which
+// isn't worth listing.
+func (s *store) diff() (cacheDiff, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.previous == nil || s.previous.generation == 0 {
+		return cacheDiff{}, false
+	}
+	d := cacheDiff{From: s.previous.generation, To: s.generation, Changed: s.modified.UTC(), Slots: []slotDiff{}}
+	old, cur := s.previous.slots, s.slots
+	i, j := 0, 0
+	for i < len(old) || j < len(cur) {
+		switch {
+		case j == len(cur) || i < len(old) && old[i].Start.Before(cur[j].Start):
+			d.Slots = append(d.Slots, slotDiff{Time: old[i].Start.UTC(), Change: "removed", OldPrice: &old[i].Price})
+			i++
+		case i == len(old) || cur[j].Start.Before(old[i].Start):
+			d.Slots = append(d.Slots, changeTo(cur[j], "added", nil))
+			j++
+		default:
+			if old[i].Price != cur[j].Price {
+				d.Slots = append(d.Slots, changeTo(cur[j], "updated", &old[i].Price))
+			}
+			i++
+			j++
+		}
+	}
+	return d, true
+}
+
+func changeTo(slot storedSlot, change string, oldPrice *float64) slotDiff {
+	merged := slot.MergedAt.UTC()
+	return slotDiff{
+		Time:     slot.Start.UTC(),
+		Change:   change,
+		OldPrice: oldPrice,
+		NewPrice: &slot.Price,
+		Origin:   slot.Origin,
+		MergedAt: &merged,
+	}
+}
+
+// handleCacheDiff lists the slots changed by the last change of the cache.
+// Only that generation is kept, and not across restarts.
+func (s *server) handleCacheDiff(w http.ResponseWriter, _ *http.Request) {
+	d, ok := s.store.diff()
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "the cache hasn't changed since it was filled at startup")
+		return
+	}
+	writeJSON(w, d)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestCacheDiff lists the slots of the last change of the cache after
+// filling it, merging revised and new prices, and removing slots.
+func TestCacheDiff(t *testing.T) {
+	s := newTestServer(t, adminTokenArgs(t)...)
+	auth := []string{"Authorization", "Bearer " + testAdminToken}
+	diff := func() cacheDiff {
+		t.Helper()
+		res := get(t, s, "/admin/diff", auth...)
+		wantStatus(t, res, http.StatusOK)
+		return decode[cacheDiff](t, res)
+	}
+	price := func(p float64) *float64 { return &p }
+
+	// Filling the empty cache isn't listed.
+	wantError(t, get(t, s, "/admin/diff", auth...), http.StatusNotFound, codeNotFound, "hasn't changed since it was filled")
+
+	revised := testFirstDay.Add(10 * time.Hour)
+	added := testFirstDay.AddDate(0, 0, testDays)
+	s.store.merge(map[time.Time]float64{
+		revised.UTC():                    -1.5,
+		testFirstDay.Add(11 * time.Hour): testPrice(testFirstDay.Add(11 * time.Hour)),
+		added.UTC():                      42,
+	}, originRefresh, upstreamProvider)
+	want := []slotDiff{
+		{Time: revised.UTC(), Change: "updated", OldPrice: price(testPrice(revised)), NewPrice: price(-1.5), Origin: originRefresh},
+		{Time: added.UTC(), Change: "added", NewPrice: price(42), Origin: originRefresh},
+	}
+	d := diff()
+	// The change is dated by the Last-Modified of the cache, which moves on
+	// by a second from that of filling it in the same second.
+	if changed := testNow.Add(time.Second); d.From != 1 || d.To != 2 || !d.Changed.Equal(changed) {
+		t.Errorf("diff from generation %d to %d at %s, want 1 to 2 at %s", d.From, d.To, d.Changed, changed)
+	}
+	wantDiff(t, d.Slots, want)
+
+	// Removing slots is listed as the next change, which replaces it.
+	res := serve(t, s, http.MethodDelete, "/admin/cache?start="+revised.UTC().Format(time.RFC3339)+"&end="+revised.Add(2*time.Hour).UTC().Format(time.RFC3339), "", auth...)
+	wantStatus(t, res, http.StatusOK)
+	d = diff()
+	if d.From != 2 || d.To != 3 {
+		t.Errorf("diff from generation %d to %d, want 2 to 3", d.From, d.To)
+	}
+	wantDiff(t, d.Slots, []slotDiff{
+		{Time: revised.UTC(), Change: "removed", OldPrice: price(-1.5)},
+		{Time: revised.Add(time.Hour).UTC(), Change: "removed", OldPrice: price(testPrice(revised.Add(time.Hour)))},
+	})
+}
+
+// wantDiff fails t unless got lists the slots of want, with the merge time
+// of the new prices at testNow.
+func wantDiff(t *testing.T, got, want []slotDiff) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d slots changed, want %d: %+v", len(got), len(want), got)
+	}
+	equal := func(a, b *float64) bool { return a == nil && b == nil || a != nil && b != nil && *a == *b }
+	for i, g := range got {
+		w := want[i]
+		merged := g.MergedAt == nil
+		if w.NewPrice != nil {
+			merged = g.MergedAt != nil && g.MergedAt.Equal(testNow)
+		}
+		if !g.Time.Equal(w.Time) || g.Change != w.Change || !equal(g.OldPrice, w.OldPrice) || !equal(g.NewPrice, w.NewPrice) || g.Origin != w.Origin || !merged {
+			t.Errorf("slot %d: %s %s from %v to %v by %q at %v, want %s %s from %v to %v by %q", i,
+				g.Time, g.Change, fmtPrice(g.OldPrice), fmtPrice(g.NewPrice), g.Origin, g.MergedAt,
+				w.Time, w.Change, fmtPrice(w.OldPrice), fmtPrice(w.NewPrice), w.Origin)
+		}
+	}
+}
+
+func fmtPrice(p *float64) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/brotli"
+)
+
+// fullDump holds the precomputed response of the default full listing, GET
+// /price without parameters as a version 1 JSON array, plain and compressed
+// with each of dumpCodings. It is rebuilt in the background whenever the cache generation
+// advances and only served while it is of the current generation, so a
+// listing that can't be built is encoded on the fly instead.
+type fullDump struct {
+	mu         sync.Mutex
+	generation uint64
+	// body is nil until the first build succeeds, and compressed holds it
+	// compressed by coding.
+	body       []byte
+	compressed map[string][]byte
+	header     http.Header
+	// failed is the generation the last build failed for, not retried.
+	failed   uint64
+	building bool
+
+	builds *counterVec
+}
+
+func newFullDump(reg *registry) *fullDump {
+	d := &fullDump{
+		builds: &counterVec{
+			name:   "energy_prices_full_dump_builds_total",
+			help:   "Builds of the precomputed full listing by result.",
+			labels: []string{"result"},
+		},
+	}
+	reg.register(d.builds)
+	return d
+}
+
+// rebuild starts building the dump for the current generation of st unless
+// it is up to date or a build is already in progress, which rebuilds again
+// when it finishes if the generation has advanced meanwhile.
+func (d *fullDump) rebuild(st *store) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.building || d.current(st.meta().Generation) {
+		return
+	}
+	d.building = true
+	go d.build(st)
+}
+
+// current reports whether the dump needs no build for generation, because
+// it is of it or building it failed. d.mu must be held.
+func (d *fullDump) current(generation uint64) bool {
+	return d.body != nil && d.generation == generation || generation != 0 && d.failed == generation
+}
+
+func (d *fullDump) build(st *store) {
+	for {
+		generation := st.meta().Generation
+		body, header, err := renderFullDump(st, generation)
+		var compressed map[string][]byte
+		if err == nil {
+			compressed, err = compressDump(body)
+		}
+
+		d.mu.Lock()
+		if err != nil {
+			log.Printf("error building the full listing of generation %d, encoding it per request: %v", generation, err)
+			d.builds.inc("failure")
+			d.failed = generation
+		} else {
+			d.builds.inc("success")
+			d.generation, d.body, d.compressed, d.header = generation, body, compressed, header
+		}
+		if d.current(st.meta().Generation) {
+			d.building = false
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+	}
+}
+
+// renderFullDump renders the full listing of a snapshot of st of generation
+// like handlePrices does.
+func renderFullDump(st *store, generation uint64) ([]byte, http.Header, error) {
+	snapshot, ok := st.snapshot(generation)
+	if !ok {
+		return nil, nil, fmt.Errorf("generation %d is gone", generation)
+	}
+	rendered := []any{}
+	for c := range snapshot.scan(time.Time{}, time.Time{}) {
+		rendered = append(rendered, fieldSlot{defaultSlotFields, c})
+	}
+	buf := &bufferedResponse{header: http.Header{}}
+	listingSerializers[1](buf, listing{Unit: defaultUnit, Slots: rendered})
+	if buf.status != http.StatusOK {
+		return nil, nil, errors.New(strings.TrimSpace(buf.body.String()))
+	}
+	return buf.body.Bytes(), buf.header, nil
+}
+
+// dumpCodings are the content codings the dump is served with, by
+// preference.
+var dumpCodings = []string{"br", "gzip"}
+
+// compressDump compresses the dump with each of dumpCodings, at the best
+// compression, as it is built once and served many times.
+func compressDump(p []byte) (map[string][]byte, error) {
+	compressed := make(map[string][]byte)
+	for _, coding := range dumpCodings {
+		var b bytes.Buffer
+		var zw io.WriteCloser
+		if coding == "br" {
+			zw, _ = brotli.NewWriterLevel(&b, brotli.BestCompression)
+		} else {
+			zw, _ = gzip.NewWriterLevel(&b, gzip.BestCompression)
+		}
+		if _, err := zw.Write(p); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		compressed[coding] = b.Bytes()
+	}
+	return compressed, nil
+}
+
+// serve answers r with the dump if it is of the current generation of st,
+// compressed if the client accepts it, and reports whether it did. Otherwise it
+// starts a rebuild and the caller has to answer.
+func (d *fullDump) serve(w http.ResponseWriter, r *http.Request, st *store) bool {
+	generation := st.meta().Generation
+	d.mu.Lock()
+	ok := d.body != nil && d.generation == generation
+	body, compressed, header := d.body, d.compressed, d.header
+	d.mu.Unlock()
+	if !ok {
+		d.rebuild(st)
+		return false
+	}
+
+	h := w.Header()
+	addVary(h, "Accept-Encoding")
+	for k, v := range header {
+		h[k] = v
+	}
+	etag := `"full-` + strconv.FormatUint(generation, 10)
+	if coding := acceptedCoding(r.Header.Get("Accept-Encoding"), dumpCodings...); coding != "" {
+		body, etag = compressed[coding], etag+`-`+coding
+		h.Set("Content-Encoding", coding)
+	}
+	etag += `"`
+	h.Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+	return true
+}
+
+// acceptedCoding returns the content coding of those offered that an
+// Accept-Encoding header weights highest, the first offered of those
+// weighted the same, or "" if it allows none of them.
+func acceptedCoding(acceptEncoding string, offered ...string) string {
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		if q := codingWeight(acceptEncoding, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// codingWeight returns the weight an Accept-Encoding header gives a
+// content coding, by an entry of its own or else by one for any coding,
+// and 0 if it has neither.
+func codingWeight(acceptEncoding, coding string) float64 {
+	codingQ, anyQ := -1.0, -1.0
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		c, params, _ := strings.Cut(entry, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(c)) {
+		case coding:
+			codingQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if codingQ >= 0 {
+		return codingQ
+	}
+	return max(anyQ, 0)
+}
+
+// etagMatches reports whether an If-None-Match header matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		if v = strings.TrimSpace(v); v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
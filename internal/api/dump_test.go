@@ -0,0 +1,184 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/brotli"
+)
+
+// waitForDump waits until s's dump has no build in progress.
+func waitForDump(t *testing.T, s *server) {
+	t.Helper()
+	waitFor(t, "the full listing build", func() bool {
+		s.dump.mu.Lock()
+		defer s.dump.mu.Unlock()
+		return !s.dump.building
+	})
+}
+
+// TestFullDump checks that the default full listing is served prebuilt,
+// plain, gzipped and compressed with Brotli, with the same slots as any
+// other listing.
+func TestFullDump(t *testing.T) {
+	s := newTestServer(t)
+	s.dump.rebuild(s.store)
+	waitForDump(t, s)
+	generation := s.store.meta().Generation
+
+	res := get(t, s, "/price")
+	wantStatus(t, res, http.StatusOK)
+	if got, want := res.Header.Get("ETag"), `"full-`+strconv.FormatUint(generation, 10)+`"`; got != want {
+		t.Errorf("ETag %s, want %s", got, want)
+	}
+	plain := decode[[]slotJSON](t, res)
+	if len(plain) != 24*testDays {
+		t.Errorf("%d slots, want %d", len(plain), 24*testDays)
+	}
+
+	res = get(t, s, "/price", "Accept-Encoding", "gzip")
+	wantStatus(t, res, http.StatusOK)
+	if got := res.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", got)
+	}
+	etag := res.Header.Get("ETag")
+	if want := `"full-` + strconv.FormatUint(generation, 10) + `-gzip"`; etag != want {
+		t.Errorf("ETag %s, want %s", etag, want)
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzipped []slotJSON
+	if err := json.NewDecoder(zr).Decode(&gzipped); err != nil {
+		t.Fatal(err)
+	}
+	if len(gzipped) != len(plain) || gzipped[0] != plain[0] {
+		t.Errorf("gzipped listing differs from the plain one")
+	}
+
+	res = get(t, s, "/price", "Accept-Encoding", "gzip, br")
+	wantStatus(t, res, http.StatusOK)
+	if got := res.Header.Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding %q, want br", got)
+	}
+	if got, want := res.Header.Get("ETag"), `"full-`+strconv.FormatUint(generation, 10)+`-br"`; got != want {
+		t.Errorf("ETag %s, want %s", got, want)
+	}
+	if got, want := readBody(t, res), brotliBytes(t, string(s.dump.body), brotli.BestCompression); got != string(want) {
+		t.Errorf("%d bytes compressed with Brotli, want the %d of compressing the plain listing", len(got), len(want))
+	}
+
+	res = get(t, s, "/price", "Accept-Encoding", "gzip", "If-None-Match", etag)
+	wantStatus(t, res, http.StatusNotModified)
+	if got := res.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding %q on a 304", got)
+	}
+}
+
+// slotJSON is a slot of a version 1 listing.
+type slotJSON struct {
+	Time  int64   `json:"time"`
+	Price float64 `json:"price"`
+}
+
+// TestFullDumpFallback checks that a listing whose build fails is encoded
+// per request instead of serving the dump of an older generation, and that
+// the next generation is prebuilt again.
+func TestFullDumpFallback(t *testing.T) {
+	s := newTestServer(t)
+	s.dump.rebuild(s.store)
+	waitForDump(t, s)
+	wantStatus(t, get(t, s, "/price"), http.StatusOK)
+
+	// Infinite prices can't be encoded as JSON, failing the build.
+	bad := testFirstDay.AddDate(0, 0, testDays).UTC()
+	s.store.merge(map[time.Time]float64{bad: math.Inf(1)}, originRefresh, upstreamProvider)
+	s.dump.rebuild(s.store)
+	waitForDump(t, s)
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_full_dump_builds_total{result="success"}`: 1,
+		`energy_prices_full_dump_builds_total{result="failure"}`: 1,
+	})
+	res := get(t, s, "/price")
+	wantError(t, res, http.StatusInternalServerError, codeInternal, "unsupported value")
+	if got := res.Header.Get("ETag"); strings.HasPrefix(got, `"full-`) {
+		t.Errorf("ETag %s of a stale dump", got)
+	}
+	// Failed builds aren't retried for the same generation.
+	get(t, s, "/price")
+	waitForDump(t, s)
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_full_dump_builds_total{result="failure"}`: 1,
+	})
+
+	s.store.remove(bad, bad.Add(time.Hour))
+	generation := s.store.meta().Generation
+	res = get(t, s, "/price")
+	wantStatus(t, res, http.StatusOK)
+	if got := res.Header.Get("ETag"); strings.HasPrefix(got, `"full-`) {
+		t.Errorf("ETag %s before the dump of generation %d is built", got, generation)
+	}
+	if slots := decode[[]slotJSON](t, res); len(slots) != 24*testDays {
+		t.Errorf("%d slots, want %d", len(slots), 24*testDays)
+	}
+	waitForDump(t, s)
+	res = get(t, s, "/price")
+	wantStatus(t, res, http.StatusOK)
+	if got, want := res.Header.Get("ETag"), `"full-`+strconv.FormatUint(generation, 10)+`"`; got != want {
+		t.Errorf("ETag %s, want %s", got, want)
+	}
+}
+
+// BenchmarkFullDump serves the default full listing of two years of hourly
+// prices prebuilt and, as when its build failed, encoded per request,
+// plain, gzipped and compressed with Brotli.
+func BenchmarkFullDump(b *testing.B) {
+	for _, prebuilt := range []bool{true, false} {
+		for _, encoding := range []string{"identity", "gzip", "br"} {
+			name := "encoded"
+			if prebuilt {
+				name = "prebuilt"
+			}
+			b.Run(name+"/"+encoding, func(b *testing.B) {
+				s := newTestServer(b, "-no-ondemand")
+				s.store = uniformStore(24*366*2, 1)
+				if prebuilt {
+					body, header, err := renderFullDump(s.store, s.store.meta().Generation)
+					if err != nil {
+						b.Fatal(err)
+					}
+					compressed, err := compressDump(body)
+					if err != nil {
+						b.Fatal(err)
+					}
+					s.dump.generation, s.dump.body, s.dump.compressed, s.dump.header = s.store.meta().Generation, body, compressed, header
+				} else {
+					s.dump.failed = s.store.meta().Generation
+				}
+				h := s.routes(routesAll)
+				req := httptest.NewRequest(http.MethodGet, "/price", nil)
+				req.Header.Set("Accept-Encoding", encoding)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				wantStatus(b, rec.Result(), http.StatusOK)
+				if got := strings.HasPrefix(rec.Header().Get("ETag"), `"full-`); got != prebuilt {
+					b.Fatalf("served prebuilt: %t, want %t", got, prebuilt)
+				}
+				b.ReportAllocs()
+				b.SetBytes(int64(rec.Body.Len()))
+				b.ResetTimer()
+				for range b.N {
+					h.ServeHTTP(discardWriter{http.Header{}}, req)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// datasetEpoch returns when the prices of the bidding zone begin: the
+// configured epoch, or the oldest slot of st if that is older, e.g. of a
+// fixture.
+func (s *server) datasetEpoch(st *store) time.Time {
+	epoch := s.cfg.datasetEpoch
+	if earliest := st.meta().Earliest; !earliest.IsZero() && earliest.Before(epoch) {
+		epoch = earliest
+	}
+	return epoch
+}
+
+// clampToEpoch moves the start parameter of q to the dataset epoch if it is
+// before, as there are no prices to list, aggregate or fetch on demand
+// there, and reports whether it did. The clamped start is stated in
+// X-Range-Start and explained by a Warning header. A range ending at or
+// before the epoch is answered with 404, rather than with an empty
+// response, and ok is false. Invalid parameters are left to the handler.
+func (s *server) clampToEpoch(w http.ResponseWriter, st *store, q url.Values) (clamped, ok bool) {
+	start, end, err := parseRange(q, s.loc)
+	if err != nil || start.IsZero() {
+		return false, true
+	}
+	epoch := s.datasetEpoch(st)
+	if !start.Before(epoch) {
+		return false, true
+	}
+	if !end.IsZero() && !end.After(epoch) {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf(
+			"the range ends before %s, when the %s day-ahead prices begin", epoch.UTC().Format(time.RFC3339), biddingZone,
+		))
+		return false, false
+	}
+	original := q.Get("start")
+	q.Set("start", epoch.UTC().Format(time.RFC3339))
+	w.Header().Set("X-Range-Start", q.Get("start"))
+	w.Header().Add("Warning", fmt.Sprintf(`299 - "start %s is before the dataset epoch and was clamped to %s"`, original, q.Get("start")))
+	return true, true
+}
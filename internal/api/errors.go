@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// errorCode identifies the kind of an error in structured error responses.
+// The codes are enumerated in openapi.json.
+type errorCode string
+
+const (
+	codeInvalidParameter errorCode = "invalid_parameter"
+	codeInvalidRange     errorCode = "invalid_range"
+	codeUnauthorized     errorCode = "unauthorized"
+	codeForbidden        errorCode = "forbidden"
+	codeNotFound         errorCode = "not_found"
+	codeMethodNotAllowed errorCode = "method_not_allowed"
+	codeNotAcceptable    errorCode = "not_acceptable"
+	codePayloadTooLarge  errorCode = "payload_too_large"
+	codeURITooLong       errorCode = "uri_too_long"
+	codeRateLimited      errorCode = "rate_limited"
+	codeInternal         errorCode = "internal"
+	codeUnavailable      errorCode = "unavailable"
+
+	codeGenerationUnavailable errorCode = "generation_unavailable"
+)
+
+// errInvalidRange marks parameter errors about the relation between the
+// bounds of a range rather than about a single malformed value.
+var errInvalidRange = errors.New("invalid range")
+
+type errorBody struct {
+	Error struct {
+		Code      errorCode `json:"code"`
+		Message   string    `json:"message"`
+		RequestID string    `json:"request_id,omitempty"`
+	} `json:"error"`
+}
+
+// writeError writes a structured error response. All error responses of the
+// service go through here.
+func writeError(w http.ResponseWriter, status int, code errorCode, message string) {
+	var body errorBody
+	body.Error.Code = code
+	body.Error.Message = message
+	body.Error.RequestID = w.Header().Get(requestIDHeader)
+
+	bytes, _ := json.Marshal(body)
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// bodyTooLarge writes a 413 response and returns true if reading the request
+// body failed because it exceeds the limit of its route.
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxErr.Limit))
+	return true
+}
+
+// badRequest writes a 400 response for a parameter parsing error.
+func badRequest(w http.ResponseWriter, err error) {
+	code := codeInvalidParameter
+	if errors.Is(err, errInvalidRange) {
+		code = codeInvalidRange
+	}
+	writeError(w, http.StatusBadRequest, code, err.Error())
+}
+
+// errorWriter replaces the plain-text 404 and 405 responses of
+// http.ServeMux with structured errors.
+type errorWriter struct {
+	http.ResponseWriter
+	replaced bool
+}
+
+func (ew *errorWriter) WriteHeader(status int) {
+	switch status {
+	case http.StatusNotFound:
+		ew.replaced = true
+		writeError(ew.ResponseWriter, status, codeNotFound, "no such endpoint")
+	case http.StatusMethodNotAllowed:
+		ew.replaced = true
+		writeError(ew.ResponseWriter, status, codeMethodNotAllowed, "method not allowed")
+	default:
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *errorWriter) Write(b []byte) (int, error) {
+	if ew.replaced {
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// structuredMuxErrors routes requests no pattern matches through an errorWriter.
+func structuredMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			w = &errorWriter{ResponseWriter: w}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
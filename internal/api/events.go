@@ -0,0 +1,103 @@
+package api
+
+import (
+	"sync"
+)
+
+// subscriberQueue is how many events a subscriber can fall behind by before
+// it misses events and is told to resync.
+const subscriberQueue = 16
+
+type event struct {
+	Name string
+	Data any
+	// Generation is the cache generation announced by the event, if any.
+	Generation uint64
+}
+
+// resyncEvent tells a subscriber that it missed events because it didn't
+// keep up, so it should refetch what it needs rather than rely on them. It
+// names the generation of the last event the subscriber was sent, from
+// which the changes can be listed with since_generation.
+func resyncEvent(generation uint64) event {
+	return event{Name: resyncEventName, Data: struct {
+		SinceGeneration uint64 `json:"since_generation"`
+	}{generation}}
+}
+
+const resyncEventName = "resync"
+
+// subscriber is the bounded send queue of a subscriber. The last place in
+// the queue is kept for the resync event, and while it is lagging, that is
+// it has been sent the resync event and not yet drained its queue, it
+// misses the events published.
+type subscriber struct {
+	ch      chan event
+	lagging bool
+	// generation is the last cache generation the subscriber was sent, or
+	// the one it subscribed at.
+	generation uint64
+}
+
+// broker fans events out to all current subscribers. Publishing never
+// blocks and memory per subscriber is bounded: a subscriber that is not
+// ready to receive misses the event and is sent the resync event instead.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan event]*subscriber
+
+	resyncs *counterVec
+}
+
+func newBroker(reg *registry) *broker {
+	b := &broker{
+		subs: make(map[chan event]*subscriber),
+		resyncs: &counterVec{
+			name: "energy_prices_event_resyncs_total",
+			help: "Times a subscriber of the event stream fell behind, missed events and was told to resync.",
+		},
+	}
+	reg.register(b.resyncs)
+	return b
+}
+
+// subscribe returns the events published from now on, for a subscriber that
+// knows the cache as of generation, and a function to unsubscribe.
+func (b *broker) subscribe(generation uint64) (<-chan event, func()) {
+	ch := make(chan event, subscriberQueue)
+
+	b.mu.Lock()
+	b.subs[ch] = &subscriber{ch: ch, generation: generation}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *broker) publish(e event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subs {
+		if sub.lagging {
+			if len(sub.ch) > 0 {
+				continue
+			}
+			sub.lagging = false
+		}
+		if len(sub.ch) == cap(sub.ch)-1 {
+			// Only the publisher sends, so the last place is still free.
+			sub.ch <- resyncEvent(sub.generation)
+			sub.lagging = true
+			b.resyncs.inc()
+			continue
+		}
+		sub.ch <- e
+		if e.Generation > 0 {
+			sub.generation = e.Generation
+		}
+	}
+}
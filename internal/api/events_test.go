@@ -0,0 +1,123 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBrokerLoad broadcasts to a few hundred subscribers, one of which never
+// receives, and checks that every event reaches the others promptly and in
+// order, and that the stalled one is told to resync once rather than holding
+// up the broadcast or queueing without bound.
+func TestBrokerLoad(t *testing.T) {
+	const (
+		subscribers = 300
+		events      = 100
+		// maxLatency is generous, as receiving takes a few microseconds.
+		maxLatency = time.Second
+	)
+	reg := &registry{}
+	b := newBroker(reg)
+
+	stalled, unsubscribe := b.subscribe(0)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	defer close(done)
+	received := make(chan struct{}, subscribers)
+	errs := make(chan string, subscribers)
+	for range subscribers - 1 {
+		ch, unsubscribe := b.subscribe(0)
+		defer unsubscribe()
+		go func() {
+			var want uint64 = 1
+			for {
+				var e event
+				select {
+				case e = <-ch:
+				case <-done:
+					return
+				}
+				switch {
+				case e.Name == resyncEventName:
+					errs <- "resync"
+				case e.Generation != want:
+					errs <- "out of order"
+				}
+				want++
+				received <- struct{}{}
+			}
+		}()
+	}
+
+	var slowest time.Duration
+	for g := range uint64(events) {
+		start := time.Now()
+		b.publish(event{Name: "cache_updated", Generation: g + 1})
+		if d := time.Since(start); d > maxLatency {
+			t.Fatalf("publishing event %d took %s", g+1, d)
+		}
+		timeout := time.After(maxLatency)
+		for range subscribers - 1 {
+			select {
+			case <-received:
+			case err := <-errs:
+				t.Fatalf("event %d: %s", g+1, err)
+			case <-timeout:
+				t.Fatalf("event %d not received by all subscribers within %s", g+1, maxLatency)
+			}
+		}
+		slowest = max(slowest, time.Since(start))
+	}
+	t.Logf("slowest broadcast to %d subscribers: %s", subscribers-1, slowest)
+
+	if len(stalled) != subscriberQueue {
+		t.Fatalf("stalled subscriber queued %d events, want %d", len(stalled), subscriberQueue)
+	}
+	for i := range subscriberQueue - 1 {
+		if e := <-stalled; e.Generation != uint64(i+1) {
+			t.Errorf("stalled subscriber's event %d announces generation %d", i, e.Generation)
+		}
+	}
+	if e := <-stalled; e.Name != resyncEventName {
+		t.Errorf("stalled subscriber's last event is %s, want %s", e.Name, resyncEventName)
+	}
+	wantMetrics(t, reg, map[string]float64{"energy_prices_event_resyncs_total": 1})
+}
+
+// TestBrokerResync checks that a lagging subscriber misses events until it
+// has drained its queue, and then receives them again.
+func TestBrokerResync(t *testing.T) {
+	b := newBroker(&registry{})
+	ch, unsubscribe := b.subscribe(3)
+	defer unsubscribe()
+
+	generation := uint64(3)
+	for range subscriberQueue + 5 {
+		generation++
+		b.publish(event{Name: "cache_updated", Generation: generation})
+	}
+	for range subscriberQueue - 1 {
+		<-ch
+	}
+	e := <-ch
+	if e.Name != resyncEventName {
+		t.Fatalf("event %s, want %s", e.Name, resyncEventName)
+	}
+	// The resync names the last generation sent before falling behind.
+	if got := e.Data.(struct {
+		SinceGeneration uint64 `json:"since_generation"`
+	}).SinceGeneration; got != 3+subscriberQueue-1 {
+		t.Errorf("resync since generation %d, want %d", got, 3+subscriberQueue-1)
+	}
+
+	b.publish(event{Name: "cache_updated", Generation: generation + 1})
+	select {
+	case e := <-ch:
+		if e.Generation != generation+1 {
+			t.Errorf("event of generation %d after resyncing, want %d", e.Generation, generation+1)
+		}
+	default:
+		t.Error("no event after draining the queue")
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// maxExtremesWindow limits the window of handleExtremes.
+const maxExtremesWindow = 31 * 24 * time.Hour
+
+// slidingExtremes reports for each of the sorted points whether its price is
+// the minimum or the maximum among the points starting within half the
+// window before or after it. Tied prices are all marked.
+//
+// Both window edges only move forward, so a monotonic deque of candidates
+// per extreme finds all of them in O(n): the front of each deque is the
+// extreme of the current window.
+func slidingExtremes(points []pricePoint, window time.Duration) (isMin, isMax []bool) {
+	isMin, isMax = make([]bool, len(points)), make([]bool, len(points))
+	half := window / 2
+
+	// The deques hold indexes of points with increasing (mins) and
+	// decreasing (maxs) prices.
+	var mins, maxs []int
+	next := 0
+	for i, p := range points {
+		for ; next < len(points) && !points[next].Start.After(p.Start.Add(half)); next++ {
+			for len(mins) > 0 && points[mins[len(mins)-1]].Price > points[next].Price {
+				mins = mins[:len(mins)-1]
+			}
+			mins = append(mins, next)
+			for len(maxs) > 0 && points[maxs[len(maxs)-1]].Price < points[next].Price {
+				maxs = maxs[:len(maxs)-1]
+			}
+			maxs = append(maxs, next)
+		}
+		from := p.Start.Add(-half)
+		for points[mins[0]].Start.Before(from) {
+			mins = mins[1:]
+		}
+		for points[maxs[0]].Start.Before(from) {
+			maxs = maxs[1:]
+		}
+		// Equal prices are kept in the deques, so ties with the front are
+		// extremes as well.
+		isMin[i] = p.Price == points[mins[0]].Price
+		isMax[i] = p.Price == points[maxs[0]].Price
+	}
+	return isMin, isMax
+}
+
+// handleExtremes annotates each slot from the current one to the end of the
+// cache with whether it is the cheapest or the most expensive slot of the
+// window around it.
+func (s *server) handleExtremes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	window := 7 * 24 * time.Hour
+	if v := q.Get("window"); v != "" {
+		if window, err = parseDuration(v); err != nil || window <= 0 || window > maxExtremesWindow {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, "window: expected a positive duration of at most 31d such as 7d")
+			return
+		}
+	}
+
+	now := s.clock.Now()
+	from := now.Truncate(maxSlotDuration)
+	if cur, ok := s.store.at(now); ok {
+		from = cur.Start
+	}
+	// Earlier slots are only needed as the window of the upcoming ones.
+	points := s.store.points(from.Add(-window/2), time.Time{})
+	isMin, isMax := slidingExtremes(points, window)
+
+	type extremeSlot struct {
+		Time  int64   `json:"time"`
+		Price float64 `json:"price"`
+		IsMin bool    `json:"is_min"`
+		IsMax bool    `json:"is_max"`
+	}
+	slots := []extremeSlot{}
+	for i, p := range points {
+		if p.Start.Before(from) {
+			continue
+		}
+		slots = append(slots, extremeSlot{p.Start.Unix(), p.Price / div, isMin[i], isMax[i]})
+	}
+
+	writeJSON(w, struct {
+		Unit   string        `json:"unit"`
+		Window string        `json:"window"`
+		Slots  []extremeSlot `json:"slots"`
+	}{unit, formatDuration(window), slots})
+}
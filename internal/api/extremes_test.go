@@ -0,0 +1,139 @@
+package api
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// hourlyPoints returns hourly points from testNow with prices.
+func hourlyPoints(prices ...float64) []pricePoint {
+	points := make([]pricePoint, len(prices))
+	for i, p := range prices {
+		points[i] = pricePoint{Start: testNow.Add(time.Duration(i) * time.Hour), Duration: time.Hour, Price: p}
+	}
+	return points
+}
+
+// naiveExtremes is slidingExtremes by comparing every pair of points.
+func naiveExtremes(points []pricePoint, window time.Duration) (isMin, isMax []bool) {
+	isMin, isMax = make([]bool, len(points)), make([]bool, len(points))
+	for i, p := range points {
+		isMin[i], isMax[i] = true, true
+		for _, q := range points {
+			if d := q.Start.Sub(p.Start); d < -window/2 || d > window/2 {
+				continue
+			}
+			isMin[i] = isMin[i] && p.Price <= q.Price
+			isMax[i] = isMax[i] && p.Price >= q.Price
+		}
+	}
+	return isMin, isMax
+}
+
+func TestSlidingExtremes(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		prices []float64
+		// marks are "-" for minima, "+" for maxima, "=" for both and "." for
+		// neither.
+		marks string
+	}{
+		{"single", 2 * time.Hour, []float64{5}, "="},
+		{"flat", 2 * time.Hour, []float64{5, 5, 5}, "==="},
+		{"rising", 2 * time.Hour, []float64{1, 2, 3, 4}, "-..+"},
+		{"valley", 2 * time.Hour, []float64{3, 1, 3}, "+-+"},
+		{"tied minima", 4 * time.Hour, []float64{4, 1, 3, 1, 4}, "+-.-+"},
+		{"tied maxima", 4 * time.Hour, []float64{1, 4, 2, 4, 1}, "-+.+-"},
+		// Points exactly half the window away are inside it.
+		{"on the edge", 4 * time.Hour, []float64{0, 5, 5, 5, 9}, "-+.-+"},
+		// In a window two minutes shorter, they aren't.
+		{"beyond the edge", 4*time.Hour - 2*time.Minute, []float64{0, 5, 5, 5, 9}, "-+=-+"},
+		{"window shorter than the slots", time.Hour, []float64{3, 1, 2}, "==="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isMin, isMax := slidingExtremes(hourlyPoints(tt.prices...), tt.window)
+			if got := extremeMarks(isMin, isMax); got != tt.marks {
+				t.Errorf("marked %s, want %s", got, tt.marks)
+			}
+		})
+	}
+
+	// Random quarter-hourly prices with many ties, compared with the naive
+	// scan.
+	rng := rand.New(rand.NewPCG(149, 0))
+	for range 50 {
+		points := make([]pricePoint, 1+rng.IntN(300))
+		at := testNow
+		for i := range points {
+			points[i] = pricePoint{Start: at, Duration: 15 * time.Minute, Price: float64(rng.IntN(8))}
+			// Leave gaps now and then.
+			at = at.Add(time.Duration(1+rng.IntN(2)*rng.IntN(8)) * 15 * time.Minute)
+		}
+		window := time.Duration(1+rng.IntN(24*4)) * 15 * time.Minute
+		isMin, isMax := slidingExtremes(points, window)
+		wantMin, wantMax := naiveExtremes(points, window)
+		if !slices.Equal(isMin, wantMin) || !slices.Equal(isMax, wantMax) {
+			t.Fatalf("window %s: marked %s, want %s", window, extremeMarks(isMin, isMax), extremeMarks(wantMin, wantMax))
+		}
+	}
+}
+
+func extremeMarks(isMin, isMax []bool) string {
+	marks := make([]byte, len(isMin))
+	for i := range marks {
+		marks[i] = ".+-="[btoi(isMin[i])<<1|btoi(isMax[i])]
+	}
+	return string(marks)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// TestExtremes annotates the slots from the current one to the end of the
+// cache, whose hourly prices rise through each day.
+func TestExtremes(t *testing.T) {
+	s := newTestServer(t, "-no-ondemand")
+	type extremes struct {
+		Unit   string `json:"unit"`
+		Window string `json:"window"`
+		Slots  []struct {
+			Time  int64   `json:"time"`
+			Price float64 `json:"price"`
+			IsMin bool    `json:"is_min"`
+			IsMax bool    `json:"is_max"`
+		} `json:"slots"`
+	}
+	res := get(t, s, "/price/extremes?window=2h&unit=ct/kWh")
+	wantStatus(t, res, http.StatusOK)
+	got := decode[extremes](t, res)
+	// From 15:00 on the second day to the end of the third.
+	current := testNow.Truncate(time.Hour)
+	if n := len(got.Slots); n != 33 || got.Slots[0].Time != current.Unix() {
+		t.Fatalf("%d slots from %d, want 33 from %d", n, got.Slots[0].Time, current.Unix())
+	}
+	if got.Unit != "ct/kWh" || got.Window != "2h0m0s" || got.Slots[0].Price != 11.5 {
+		t.Errorf("unit %s, window %s, first price %v", got.Unit, got.Window, got.Slots[0].Price)
+	}
+	for i, slot := range got.Slots {
+		// The slot before the current one is in the window of the current
+		// one, and midnight jumps up by a day's 100, so only the very last
+		// slot is an extreme.
+		last := i == len(got.Slots)-1
+		if slot.IsMin || slot.IsMax != last {
+			t.Errorf("slot at %s: min %t, max %t", time.Unix(slot.Time, 0).UTC(), slot.IsMin, slot.IsMax)
+		}
+	}
+
+	for _, window := range []string{"0", "32d", "week"} {
+		wantError(t, get(t, s, "/price/extremes?window="+window), http.StatusBadRequest, codeInvalidParameter, "window: expected a positive duration")
+	}
+}
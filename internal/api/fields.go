@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// slotField is a field of the slot objects of JSON listings.
+type slotField struct {
+	name string
+	// meta is set for fields needing the slot metadata of store.describe.
+	meta  bool
+	value func(c cachedSlot) any
+}
+
+// slotFields are the fields listed slots can have, in the order they are
+// rendered. It is the single definition of the slot shape of listings.
+var slotFields = []slotField{
+	{name: "time", value: func(c cachedSlot) any { return c.Start.Unix() }},
+	{name: "price", value: func(c cachedSlot) any { return c.Price }},
+	{name: "duration_minutes", value: func(c cachedSlot) any { return int(c.Duration.Minutes()) }},
+	{name: "revised", meta: true, value: func(c cachedSlot) any { return c.Revised }},
+	{name: "previous_price", meta: true, value: func(c cachedSlot) any {
+		if !c.Revised {
+			return nil
+		}
+		return c.PreviousPrice
+	}},
+	{name: "source", meta: true, value: func(c cachedSlot) any { return c.Source }},
+}
+
+// adminSlotFields add where and when slots were last changed, and the prices
+// of other sources they override, to slotFields.
+var adminSlotFields = append(slices.Clip(slotFields),
+	slotField{name: "origin", meta: true, value: func(c cachedSlot) any { return c.Origin }},
+	slotField{name: "merged_at", meta: true, value: func(c cachedSlot) any { return c.MergedAt.UTC().Format(time.RFC3339Nano) }},
+	slotField{name: "shadowed_source", meta: true, value: func(c cachedSlot) any {
+		if c.ShadowedSource == "" {
+			return nil
+		}
+		return c.ShadowedSource
+	}},
+	slotField{name: "shadowed_price", meta: true, value: func(c cachedSlot) any {
+		if c.ShadowedSource == "" {
+			return nil
+		}
+		return c.ShadowedPrice
+	}},
+)
+
+// defaultSlotFields are the fields of listings without detail.
+var defaultSlotFields = slotFields[:2]
+
+// parseFields reads the optional comma-separated fields query parameter,
+// returning def if it is absent. The selected fields keep the order of
+// slotFields.
+func parseFields(q url.Values, def []slotField) ([]slotField, error) {
+	v := q.Get("fields")
+	if v == "" {
+		return def, nil
+	}
+	names := strings.Split(v, ",")
+	for _, name := range names {
+		if !slices.ContainsFunc(slotFields, func(f slotField) bool { return f.name == name }) {
+			valid := make([]string, len(slotFields))
+			for i, f := range slotFields {
+				valid[i] = f.name
+			}
+			return nil, fmt.Errorf("fields: unknown field %q, expected a comma-separated list of %s", name, strings.Join(valid, ", "))
+		}
+	}
+	var fields []slotField
+	for _, f := range slotFields {
+		if slices.Contains(names, f.name) {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// needsMeta reports whether any of fields needs the slot metadata.
+func needsMeta(fields []slotField) bool {
+	return slices.ContainsFunc(fields, func(f slotField) bool { return f.meta })
+}
+
+// fieldSlot renders the given fields of a slot as a JSON object.
+type fieldSlot struct {
+	fields []slotField
+	slot   cachedSlot
+}
+
+func (f fieldSlot) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, field := range f.fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v, err := json.Marshal(field.value(f.slot))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%q:%s", field.name, v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// PricePoint is a slot in the wire shape of listings, as answered by the
+// routes returning bare slots and read from the listings of a primary. It is
+// rendered with the default listing fields, time and price, adding
+// duration_minutes if Duration is set and unit if Unit is, so that its shape
+// can't drift from that of listings. Price is in the unit of the response.
+type PricePoint struct {
+	Start    time.Time
+	Duration time.Duration
+	Price    float64
+	Unit     string
+}
+
+// priced returns p as a bare slot with its price divided by div. The
+// duration isn't part of the shape of bare slots.
+func priced(p pricePoint, div float64) PricePoint {
+	return PricePoint{Start: p.Start, Price: p.Price / div}
+}
+
+func (p PricePoint) MarshalJSON() ([]byte, error) {
+	fields := defaultSlotFields
+	if p.Duration != 0 {
+		// The default fields followed by duration_minutes.
+		fields = slotFields[:3]
+	}
+	b, err := fieldSlot{fields, cachedSlot{pricePoint: pricePoint{Start: p.Start, Duration: p.Duration, Price: p.Price}}}.MarshalJSON()
+	if err != nil || p.Unit == "" {
+		return b, err
+	}
+	unit, err := json.Marshal(p.Unit)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b[:len(b)-1], `,"unit":`...)
+	return append(append(b, unit...), '}'), nil
+}
+
+func (p *PricePoint) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Time            int64   `json:"time"`
+		Price           float64 `json:"price"`
+		DurationMinutes int64   `json:"duration_minutes"`
+		Unit            string  `json:"unit"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*p = PricePoint{Start: time.Unix(v.Time, 0), Duration: time.Duration(v.DurationMinutes) * time.Minute, Price: v.Price, Unit: v.Unit}
+	return nil
+}
+
+// fieldSlots renders points with the given fields, adding the metadata of
+// the cached slots if the fields need it.
+func (s *server) fieldSlots(points []pricePoint, fields []slotField) []any {
+	var slots []cachedSlot
+	if needsMeta(fields) {
+		slots = s.store.describe(points)
+	} else {
+		slots = make([]cachedSlot, len(points))
+		for i, p := range points {
+			slots[i] = cachedSlot{pricePoint: p}
+		}
+	}
+	rendered := make([]any, len(slots))
+	for i, c := range slots {
+		rendered[i] = fieldSlot{fields, c}
+	}
+	return rendered
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPricePointJSON encodes and decodes each shape of PricePoint.
+func TestPricePointJSON(t *testing.T) {
+	start := time.Unix(time.Date(2026, time.January, 13, 14, 0, 0, 0, time.UTC).Unix(), 0)
+	tests := []struct {
+		name  string
+		point PricePoint
+		json  string
+	}{
+		{"bare", PricePoint{Start: start, Price: 101.5}, `{"time":1768312800,"price":101.5}`},
+		{"duration", PricePoint{Start: start, Duration: 15 * time.Minute, Price: -3.25}, `{"time":1768312800,"price":-3.25,"duration_minutes":15}`},
+		{"unit", PricePoint{Start: start, Price: 10.15, Unit: "ct/kWh"}, `{"time":1768312800,"price":10.15,"unit":"ct/kWh"}`},
+		{"duration and unit", PricePoint{Start: start, Duration: time.Hour, Price: 0, Unit: "EUR/MWh"}, `{"time":1768312800,"price":0,"duration_minutes":60,"unit":"EUR/MWh"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.point)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.json {
+				t.Errorf("encoded %s, want %s", b, tt.json)
+			}
+			var got PricePoint
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.point {
+				t.Errorf("decoded %+v, want %+v", got, tt.point)
+			}
+		})
+	}
+}
+
+// TestPricePointRoutes decodes the bare slots of the routes answering them
+// and the slots of listings as PricePoints, which encode to what was served.
+func TestPricePointRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.setClock(newFakeClock(testNow))
+	type day struct {
+		Date  string       `json:"date"`
+		Unit  string       `json:"unit"`
+		Slots []PricePoint `json:"slots"`
+	}
+	type envelope struct {
+		Version int             `json:"version"`
+		Unit    string          `json:"unit"`
+		Start   *time.Time      `json:"start"`
+		End     *time.Time      `json:"end"`
+		Count   int             `json:"count"`
+		Slots   []PricePoint    `json:"slots"`
+		License json.RawMessage `json:"license"`
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		// v is what the response is decoded into.
+		v any
+	}{
+		{"at", http.MethodGet, "/price?at=2026-01-13T14:30:00Z", "", new(PricePoint)},
+		{"at in another unit", http.MethodGet, "/price?at=2026-01-13T14:30:00Z&unit=ct/kWh", "", new(PricePoint)},
+		{"lookup", http.MethodPost, "/price/lookup", `["2026-01-13T10:00:00Z","2026-01-14T22:59:59Z"]`, new([]PricePoint)},
+		{"listing", http.MethodGet, "/price?start=2026-01-13&end=2026-01-14", "", new([]PricePoint)},
+		{"listing with durations", http.MethodGet, "/price?start=2026-01-13&end=2026-01-14&fields=time,price,duration_minutes", "", new([]PricePoint)},
+		{"listing version 2", http.MethodGet, "/price?start=2026-01-13&end=2026-01-14&v=2", "", new(envelope)},
+		{"tomorrow", http.MethodGet, "/price/tomorrow", "", new(day)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serve(t, s, tt.method, tt.target, tt.body)
+			wantStatus(t, res, http.StatusOK)
+			body := strings.TrimSpace(readBody(t, res))
+			if err := json.Unmarshal([]byte(body), tt.v); err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != body {
+				t.Errorf("decoded slots encoded to %s, want %s", b, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baseCurrency is the currency the market clears in and prices are stored in.
+const baseCurrency = "EUR"
+
+type fxRate struct {
+	// Rate is the amount of the currency worth one euro.
+	Rate float64
+	// AsOf is the date the rate is valid for, or zero if unknown.
+	AsOf time.Time
+}
+
+// fxRates maps currency codes to their conversion rates from euros. It
+// implements flag.Value for repeated CODE=RATE[@YYYY-MM-DD] flags.
+type fxRates map[string]fxRate
+
+func (f *fxRates) String() string {
+	var s []string
+	for _, code := range slices.Sorted(maps.Keys(*f)) {
+		s = append(s, code+"="+formatFloat((*f)[code].Rate))
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *fxRates) Set(v string) error {
+	code, rate, ok := strings.Cut(v, "=")
+	if !ok || len(code) != 3 || strings.ToUpper(code) != code || code == baseCurrency {
+		return fmt.Errorf("invalid conversion %q: expected e.g. SEK=11.35", v)
+	}
+	rate, asOf, hasDate := strings.Cut(rate, "@")
+
+	var r fxRate
+	var err error
+	if r.Rate, err = strconv.ParseFloat(rate, 64); err != nil || !(r.Rate > 0) {
+		return fmt.Errorf("invalid conversion rate %q", rate)
+	}
+	if hasDate {
+		if r.AsOf, err = time.Parse(time.DateOnly, asOf); err != nil {
+			return fmt.Errorf("invalid conversion rate date %q: expected YYYY-MM-DD", asOf)
+		}
+	}
+
+	if *f == nil {
+		*f = make(fxRates)
+	}
+	(*f)[code] = r
+	return nil
+}
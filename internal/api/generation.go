@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// generationHeader names the cache generation a price response was computed
+// from, for use with the generation parameter.
+const generationHeader = "X-Cache-Generation"
+
+// pinnedKey is the context key of the snapshot a request is pinned to.
+type pinnedKey struct{}
+
+// pinGeneration reads the optional generation query parameter. A request
+// with it is answered from a snapshot of the cache of that generation, so
+// that a client can make several requests against the same data even while
+// refreshes merge new prices. Only the current and the previous generation
+// are kept; for others the request is refused with 409 and the current
+// generation, so that the client can start over. If the response has been
+// written, ok is false.
+func (s *server) pinGeneration(w http.ResponseWriter, r *http.Request) (_ *http.Request, ok bool) {
+	v := r.URL.Query().Get("generation")
+	if v == "" {
+		return r, true
+	}
+	generation, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("generation: expected a generation number, got %q", v))
+		return r, false
+	}
+	snapshot, ok := s.store.snapshot(generation)
+	if !ok {
+		current := s.store.meta().Generation
+		w.Header().Set(generationHeader, strconv.FormatUint(current, 10))
+		writeError(w, http.StatusConflict, codeGenerationUnavailable, fmt.Sprintf(
+			"generation %d is no longer available, the current generation is %d", generation, current,
+		))
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), pinnedKey{}, snapshot)), true
+}
+
+// pinned returns the server to answer r with: s itself, or a copy of it
+// reading the snapshot r is pinned to.
+func (s *server) pinned(r *http.Request) *server {
+	snapshot, ok := r.Context().Value(pinnedKey{}).(*store)
+	if !ok {
+		return s
+	}
+	p := *s
+	p.store = snapshot
+	return &p
+}
+
+// isPinned reports whether r is pinned to a snapshot, which must not be
+// merged into.
+func isPinned(r *http.Request) bool {
+	_, ok := r.Context().Value(pinnedKey{}).(*store)
+	return ok
+}
+
+// pinnedHandler is a handler method, called on the server returned by
+// pinned.
+type pinnedHandler func(s *server, w http.ResponseWriter, r *http.Request)
+
+func (s *server) bind(h pinnedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(s.pinned(r), w, r)
+	}
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/mockupstream"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testLoc is the market's location, Europe/Berlin.
+var testLoc *time.Location
+
+// testNow is the time tests run at unless they move their clock: 15:30 in
+// Europe/Berlin on Tuesday 2026-01-13, after the next day's prices are
+// published.
+var testNow = time.Date(2026, time.January, 13, 14, 30, 0, 0, time.UTC)
+
+// testFirstDay is the start of the first Europe/Berlin day of testPrices,
+// the day before testNow's.
+var testFirstDay time.Time
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	var err error
+	if testLoc, err = loadMarketLocation(); err != nil {
+		log.SetOutput(os.Stderr)
+		log.Fatal(err)
+	}
+	testFirstDay = time.Date(2026, time.January, 12, 0, 0, 0, 0, testLoc)
+	os.Exit(m.Run())
+}
+
+// testDays is the number of days of testPrices: the one before testNow's,
+// its own and the next.
+const testDays = 3
+
+// testPrice is the price of the hourly slot starting at t in testPrices: 100
+// for each day since testFirstDay plus the Europe/Berlin hour, so that every
+// day is cheapest at midnight and each price tells its slot.
+func testPrice(t time.Time) float64 {
+	local := t.In(testLoc)
+	days := int(local.Sub(testFirstDay).Hours() / 24)
+	return float64(100*days + local.Hour())
+}
+
+// testPrices returns the hourly prices of testDays Europe/Berlin days from
+// testFirstDay as priced by testPrice.
+func testPrices() map[time.Time]float64 {
+	prices := make(map[time.Time]float64)
+	for t := testFirstDay; t.Before(testFirstDay.AddDate(0, 0, testDays)); t = t.Add(time.Hour) {
+		prices[t.UTC()] = testPrice(t)
+	}
+	return prices
+}
+
+// newTestServer returns a server configured by args whose store holds
+// testPrices, at testNow on a fakeClock. Its upstream is a mock serving
+// testPrices, so that on-demand fetches never reach the network.
+func newTestServer(t testing.TB, args ...string) *server {
+	t.Helper()
+	up := httptest.NewServer(newTestUpstream(t))
+	t.Cleanup(up.Close)
+
+	cfg, err := parseConfig(append([]string{"-upstream-url", up.URL}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &registry{}
+	s := newServer(cfg, newStore(), newUpstream(cfg, reg), testLoc, reg)
+	s.setClock(newFakeClock(testNow))
+	s.store.merge(testPrices(), originRefresh, upstreamProvider)
+	return s
+}
+
+// exampleFixture is the fixture the examples of the OpenAPI document are
+// the answers for, as described by its info.x-example-fixture.
+type exampleFixture struct {
+	Now time.Time `json:"now"`
+	// Prices are the hourly prices of dates from their Europe/Berlin
+	// midnight.
+	Prices map[string][]float64 `json:"prices"`
+}
+
+// newFixtureServer returns a server configured by args whose store holds
+// the prices of the example fixture of the OpenAPI document, at its time.
+// It doesn't fetch prices on demand, as the fixture is all there is.
+func newFixtureServer(t testing.TB, args ...string) *server {
+	t.Helper()
+	var doc struct {
+		Info struct {
+			Fixture exampleFixture `json:"x-example-fixture"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	fixture := doc.Info.Fixture
+	prices := make(map[time.Time]float64)
+	for date, day := range fixture.Prices {
+		midnight, err := time.ParseInLocation(time.DateOnly, date, testLoc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for h, p := range day {
+			prices[midnight.Add(time.Duration(h)*time.Hour).UTC()] = p
+		}
+	}
+
+	s := newTestServer(t, append([]string{"-no-ondemand"}, args...)...)
+	s.store = newStore()
+	s.setClock(newFakeClock(fixture.Now))
+	s.store.merge(prices, originRefresh, upstreamProvider)
+	return s
+}
+
+// newTestUpstream returns a mock upstream serving testPrices at testNow on a fakeClock.
+func newTestUpstream(t testing.TB) *mockupstream.Mock {
+	t.Helper()
+	mock, err := mockupstream.New(mockupstream.Config{
+		Fixture: fixturePrices(testPrices()),
+		Now:     func() time.Time { return testNow },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mock
+}
+
+// testAdminToken is the admin token of servers configured with
+// adminTokenArgs.
+const testAdminToken = "test-admin-token"
+
+// adminTokenArgs returns the arguments enabling the admin endpoints with
+// testAdminToken.
+func adminTokenArgs(t testing.TB) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "admin-token")
+	if err := os.WriteFile(path, []byte(testAdminToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"-admin-token-file", path}
+}
+
+// fixturePrices returns prices in the shape of a mock upstream fixture.
+func fixturePrices(prices map[time.Time]float64) *mockupstream.Prices {
+	var f mockupstream.Prices
+	for t, p := range prices {
+		f.Timestamps = append(f.Timestamps, t.Unix())
+		f.Prices = append(f.Prices, p)
+	}
+	return &f
+}
+
+// serve sends a request with method, target and body to all routes of s and
+// returns the response. header holds pairs of header names and values.
+func serve(t testing.TB, s *server, method, target, body string, header ...string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.routes(routesAll).ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+// get is serve for GET requests without a body.
+func get(t testing.TB, s *server, target string, header ...string) *http.Response {
+	t.Helper()
+	return serve(t, s, http.MethodGet, target, "", header...)
+}
+
+// readBody returns the body of res.
+func readBody(t testing.TB, res *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// decode unmarshals the JSON body of res.
+func decode[T any](t testing.TB, res *http.Response) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		t.Fatalf("error decoding response body: %v", err)
+	}
+	return v
+}
+
+// golden fails t unless got equals the golden file testdata/name, which is
+// rewritten instead with -update.
+func golden(t testing.TB, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, rewrite the golden files with -update", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s, rewrite it with -update if intended:\n%s", path, got)
+	}
+}
+
+// metricValue returns the value of the series, such as
+// name{label="value"}, as written by reg, and whether it was.
+func metricValue(reg *registry, series string) (float64, bool) {
+	var b strings.Builder
+	reg.write(&b)
+	for _, line := range strings.Split(b.String(), "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			return f, err == nil
+		}
+	}
+	return 0, false
+}
+
+// wantMetrics fails t unless the series written by reg have the values of
+// want.
+func wantMetrics(t testing.TB, reg *registry, want map[string]float64) {
+	t.Helper()
+	for _, series := range slices.Sorted(maps.Keys(want)) {
+		if v, ok := metricValue(reg, series); !ok || v != want[series] {
+			t.Errorf("%s = %v (written: %t), want %v", series, v, ok, want[series])
+		}
+	}
+}
+
+// wantStatus fails t unless res has status code.
+func wantStatus(t testing.TB, res *http.Response, code int) {
+	t.Helper()
+	if res.StatusCode != code {
+		t.Fatalf("status %d, want %d: %s", res.StatusCode, code, readBody(t, res))
+	}
+}
+
+// wantError fails t unless res is an error response with status and code
+// whose message contains msg.
+func wantError(t testing.TB, res *http.Response, status int, code errorCode, msg string) {
+	t.Helper()
+	wantStatus(t, res, status)
+	body := decode[errorBody](t, res)
+	if body.Error.Code != code || !strings.Contains(body.Error.Message, msg) {
+		t.Fatalf("error %s %q, want %s containing %q", body.Error.Code, body.Error.Message, code, msg)
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// maxHistogramBuckets bounds the allocation a single histogram request can cause.
+const maxHistogramBuckets = 10000
+
+type histogramBucket struct {
+	From  *float64 `json:"from"`
+	To    *float64 `json:"to"`
+	Count int      `json:"count"`
+}
+
+// handleHistogram counts slots per price bucket. Buckets of the given width
+// span [min, max); prices below min and at or above max are counted in an
+// underflow and an overflow bucket, which have an open lower and upper bound
+// respectively.
+func (s *server) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	width, err := parseFloat(q, "bucket", 0)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if !(width > 0) {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "bucket: expected a positive width")
+		return
+	}
+	lo, err := parseFloat(q, "min", -100/div)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	hi, err := parseFloat(q, "max", 300/div)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if !(lo < hi) {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "min must be below max")
+		return
+	}
+	n := math.Ceil((hi - lo) / width)
+	if n > maxHistogramBuckets {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("bucket: width too small, at most %d buckets are supported", maxHistogramBuckets))
+		return
+	}
+
+	counts := make([]int, int(n)+2)
+	for c := range s.store.scan(start, end) {
+		switch v := c.Price / div; {
+		case v < lo:
+			counts[0]++
+		case v >= hi:
+			counts[len(counts)-1]++
+		default:
+			counts[1+min(int((v-lo)/width), int(n)-1)]++
+		}
+	}
+
+	buckets := make([]histogramBucket, len(counts))
+	for i, c := range counts {
+		b := histogramBucket{Count: c}
+		if i > 0 {
+			from := lo + float64(i-1)*width
+			b.From = &from
+		}
+		if i < len(counts)-1 {
+			to := min(lo+float64(i)*width, hi)
+			b.To = &to
+		}
+		buckets[i] = b
+	}
+
+	writeJSON(w, struct {
+		Unit    string            `json:"unit"`
+		Buckets []histogramBucket `json:"buckets"`
+	}{unit, buckets})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// refreshRecord describes a single refresh attempt.
+type refreshRecord struct {
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Start and End are the requested window.
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Source  string    `json:"source,omitempty"`
+	Added   int       `json:"added"`
+	Updated int       `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// refreshHistory keeps the last refresh attempts in a ring buffer, together
+// with the last success and failure however long ago they were.
+type refreshHistory struct {
+	mu      sync.Mutex
+	records []refreshRecord
+	// next is the index the next record is written to.
+	next int
+	full bool
+
+	lastSuccess *refreshRecord
+	lastFailure *refreshRecord
+}
+
+func newRefreshHistory(size int) *refreshHistory {
+	return &refreshHistory{records: make([]refreshRecord, size)}
+}
+
+func (h *refreshHistory) add(rec refreshRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records[h.next] = rec
+	h.next = (h.next + 1) % len(h.records)
+	h.full = h.full || h.next == 0
+	if rec.Error == "" {
+		h.lastSuccess = &rec
+	} else {
+		h.lastFailure = &rec
+	}
+}
+
+// list returns the kept records, newest first.
+func (h *refreshHistory) list() []refreshRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.next
+	if h.full {
+		n = len(h.records)
+	}
+	list := make([]refreshRecord, n)
+	for i := range list {
+		list[i] = h.records[(h.next-1-i+len(h.records))%len(h.records)]
+	}
+	return list
+}
+
+// last returns copies of the last successful and the last failed refresh,
+// nil if there was none.
+func (h *refreshHistory) last() (success, failure *refreshRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.lastSuccess != nil {
+		rec := *h.lastSuccess
+		success = &rec
+	}
+	if h.lastFailure != nil {
+		rec := *h.lastFailure
+		failure = &rec
+	}
+	return success, failure
+}
+
+// handleRefreshHistory lists the last refresh attempts, newest first.
+func (s *server) handleRefreshHistory(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.refreshHistory.list())
+}
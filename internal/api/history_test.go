@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefreshHistoryWraparound(t *testing.T) {
+	h := newRefreshHistory(3)
+	if list := h.list(); len(list) != 0 {
+		t.Fatalf("empty history lists %v", list)
+	}
+
+	// Record i adds i slots and fails if it's a multiple of 4.
+	record := func(i int) refreshRecord {
+		rec := refreshRecord{StartedAt: testNow.Add(time.Duration(i) * time.Minute), Added: i}
+		if i%4 == 0 {
+			rec.Error = fmt.Sprint("failure ", i)
+		}
+		return rec
+	}
+	for i := 1; i <= 10; i++ {
+		h.add(record(i))
+
+		var want []int
+		for j := i; j > max(0, i-3); j-- {
+			want = append(want, j)
+		}
+		var got []int
+		for _, rec := range h.list() {
+			got = append(got, rec.Added)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("after %d records, listed %v, want %v", i, got, want)
+		}
+	}
+
+	// The last success and failure are kept after the buffer moved on,
+	// as copies.
+	h = newRefreshHistory(2)
+	h.add(record(4))
+	h.add(record(5))
+	h.add(record(6))
+	h.add(record(7))
+	success, failure := h.last()
+	if success == nil || success.Added != 7 || failure == nil || failure.Added != 4 {
+		t.Fatalf("last success %+v, failure %+v, want records 7 and 4", success, failure)
+	}
+	failure.Added = 0
+	if _, failure := h.last(); failure.Added != 4 {
+		t.Error("modifying the last failure changed the history")
+	}
+}
+
+func TestRefreshHistoryConcurrent(t *testing.T) {
+	const size, writers, records = 5, 4, 100
+	h := newRefreshHistory(size)
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range records {
+				h.add(refreshRecord{Added: w*records + i})
+				if n := len(h.list()); n < 1 || n > size {
+					t.Errorf("listed %d records", n)
+					return
+				}
+				h.last()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(h.list()); n != size {
+		t.Errorf("listed %d records, want %d", n, size)
+	}
+}
+
+func TestRefreshHistoryEndpoints(t *testing.T) {
+	s := newTestServer(t, append(adminTokenArgs(t), "-refresh-history", "2")...)
+	for i := 1; i <= 3; i++ {
+		s.refreshHistory.add(refreshRecord{StartedAt: testNow.Add(time.Duration(i) * time.Hour), Added: i})
+	}
+	s.refreshHistory.add(refreshRecord{StartedAt: testNow.Add(4 * time.Hour), Error: "upstream down"})
+
+	res := get(t, s, "/admin/refreshes", "Authorization", "Bearer "+testAdminToken)
+	wantStatus(t, res, http.StatusOK)
+	list := decode[[]refreshRecord](t, res)
+	if len(list) != 2 || list[0].Error != "upstream down" || list[1].Added != 3 {
+		t.Errorf("listed %+v, want the failure and record 3", list)
+	}
+	wantError(t, get(t, s, "/admin/refreshes"), http.StatusUnauthorized, codeUnauthorized, "missing or invalid admin token")
+
+	res = get(t, s, "/price/meta")
+	wantStatus(t, res, http.StatusOK)
+	meta := decode[metaResponse](t, res)
+	if meta.LastSuccess == nil || meta.LastSuccess.Added != 3 || meta.LastFailure == nil || meta.LastFailure.Error != "upstream down" {
+		t.Errorf("meta lists last success %+v and failure %+v", meta.LastSuccess, meta.LastFailure)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//go:embed index.html
+var indexHTML string
+
+var indexTemplate = template.Must(template.New("index").Parse(indexHTML))
+
+// handleIndex serves an HTML page with a chart to browsers and the price
+// listing to everyone else.
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.conditional(s.validParams(priceParams, http.HandlerFunc(s.handlePrices))).ServeHTTP(w, r)
+		return
+	}
+
+	now := s.clock.Now()
+	y, m, d := now.In(s.loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+
+	var chart strings.Builder
+	renderChart(&chart, s.store.points(today, today.AddDate(0, 0, 2)), chartOptions{
+		Width:  860,
+		Height: 320,
+		Now:    now,
+		Loc:    s.loc,
+	})
+
+	data := struct {
+		Current  string
+		Chart    template.HTML
+		BasePath string
+	}{
+		Chart:    template.HTML(chart.String()),
+		BasePath: s.cfg.basePath,
+	}
+	if cur, ok := s.store.at(now); ok {
+		data.Current = formatFloat(cur.Price / unitDivisors["ct/kWh"])
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+	}
+}
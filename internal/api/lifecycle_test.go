@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/mockupstream"
+)
+
+// Modes of lifecycleUpstream.
+const (
+	upstreamUp = iota
+	upstreamDown
+	upstreamStalled
+)
+
+// lifecycleUpstream serves the mock upstream, or fails or stalls every
+// request by its mode.
+type lifecycleUpstream struct {
+	mock     http.Handler
+	mode     atomic.Int32
+	requests atomic.Int32
+	// stalled receives the requests held while the mode is upstreamStalled.
+	stalled chan struct{}
+}
+
+func (u *lifecycleUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests.Add(1)
+	switch u.mode.Load() {
+	case upstreamDown:
+		http.Error(w, "outage", http.StatusServiceUnavailable)
+	case upstreamStalled:
+		u.stalled <- struct{}{}
+		<-r.Context().Done()
+	default:
+		u.mock.ServeHTTP(w, r)
+	}
+}
+
+// lifecycleMeta is the part of the /price/meta response followed through
+// the lifecycle.
+type lifecycleMeta struct {
+	Slots             int  `json:"slots"`
+	TomorrowAvailable bool `json:"tomorrow_available"`
+	Refresh           struct {
+		ConsecutiveFailures int       `json:"consecutive_failures"`
+		NextAttempt         time.Time `json:"next_attempt"`
+	} `json:"refresh"`
+}
+
+// lifecycleService is the service run in-process by run.
+type lifecycleService struct {
+	addr   string
+	client *http.Client
+	cancel context.CancelFunc
+	// done is closed when run has returned err.
+	done chan struct{}
+	err  error
+}
+
+// startLifecycleService runs the service with args and the clock clk on
+// the upstream at url, returning once it accepts requests.
+func startLifecycleService(t *testing.T, clk *fakeClock, url string, args ...string) *lifecycleService {
+	t.Helper()
+	cfg, err := parseConfig(append([]string{"-listen", "127.0.0.1:0", "-upstream-url", url}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.clock = clk
+	ready := make(chan string, 1)
+	cfg.ready = func(lns []net.Listener) { ready <- lns[0].Addr().String() }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &lifecycleService{client: &http.Client{Timeout: 5 * time.Second}, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.err = run(ctx, cfg)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-s.done
+	})
+	select {
+	case s.addr = <-ready:
+	case <-s.done:
+		t.Fatalf("service stopped before serving: %v", s.err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("service not serving within 5s")
+	}
+	return s
+}
+
+func (s *lifecycleService) get(t *testing.T, target string) *http.Response {
+	t.Helper()
+	res, err := s.client.Get("http://" + s.addr + target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func (s *lifecycleService) meta(t *testing.T) lifecycleMeta {
+	t.Helper()
+	res := s.get(t, "/price/meta")
+	wantStatus(t, res, http.StatusOK)
+	return decode[lifecycleMeta](t, res)
+}
+
+// waitForRefresh waits until the refresher has scheduled its next attempt
+// at next, and its timer is pending among those of the slot, day and
+// staleness watchers.
+func (s *lifecycleService) waitForRefresh(t *testing.T, clk *fakeClock, next time.Time) lifecycleMeta {
+	t.Helper()
+	var m lifecycleMeta
+	waitFor(t, "the next refresh at "+next.Format(time.RFC3339), func() bool {
+		m = s.meta(t)
+		return m.Refresh.NextAttempt.Equal(next)
+	})
+	clk.WaitTimers(t, 4)
+	return m
+}
+
+// stop shuts the service down and waits for run to return.
+func (s *lifecycleService) stop(t *testing.T) {
+	t.Helper()
+	s.cancel()
+	select {
+	case <-s.done:
+		if !errors.Is(s.err, context.Canceled) {
+			t.Fatalf("service stopped with %v", s.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("service not stopped within 5s")
+	}
+	if res, err := s.client.Get("http://" + s.addr + "/healthz"); err == nil {
+		res.Body.Close()
+		t.Error("service still accepting requests after stopping")
+	}
+}
+
+// TestLifecycle runs the service in-process over the days of the fixture
+// testdata/upstream_fixture.json, served by the mock upstream with a fake
+// clock: a cold start backfills the history, a scheduled refresh adds the
+// next day's prices once published, an upstream outage is backed off and
+// recovered from, and shutting down saves the cache. A restart serves that
+// cache, also while its refresh hangs on a stalled upstream, and shuts down
+// with the refresh in flight.
+//
+// The listeners only open once the initial fetch has completed, so there is
+// nothing to serve before the backfill or catch-up.
+func TestLifecycle(t *testing.T) {
+	fixture, err := mockupstream.LoadFixture(filepath.Join("testdata", "upstream_fixture.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := newFakeClock(time.Date(2026, time.January, 13, 10, 0, 0, 0, testLoc))
+	mock, err := mockupstream.New(mockupstream.Config{Fixture: fixture, PublishAt: 13 * time.Hour, Now: clk.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := &lifecycleUpstream{mock: mock, stalled: make(chan struct{})}
+	upstream := httptest.NewServer(up)
+	defer upstream.Close()
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	args := []string{"-cache-file", cacheFile, "-refresh-interval", "4h", "-shutdown-timeout", "5s"}
+
+	// A cold start backfills the history up to the end of today, as the
+	// next day's prices aren't published before 13:00.
+	s := startLifecycleService(t, clk, upstream.URL, args...)
+	if got := up.requests.Load(); got != 1 {
+		t.Errorf("cold start made %d upstream requests, want 1", got)
+	}
+	m := s.waitForRefresh(t, clk, clk.Now().Add(4*time.Hour))
+	if m.Slots != 2*96 || m.TomorrowAvailable {
+		t.Fatalf("after the backfill: %d slots, tomorrow available %t, want %d and false", m.Slots, m.TomorrowAvailable, 2*96)
+	}
+	wantStatus(t, s.get(t, "/price/current"), http.StatusOK)
+	wantStatus(t, s.get(t, "/price?start=2026-01-12&end=2026-01-14"), http.StatusOK)
+
+	// The scheduled refresh at 14:00 finds the next day's prices.
+	clk.Advance(4 * time.Hour)
+	m = s.waitForRefresh(t, clk, clk.Now().Add(4*time.Hour))
+	if m.Slots != 3*96 || !m.TomorrowAvailable {
+		t.Fatalf("after the refresh: %d slots, tomorrow available %t, want %d and true", m.Slots, m.TomorrowAvailable, 3*96)
+	}
+	wantStatus(t, s.get(t, "/price/tomorrow"), http.StatusOK)
+
+	// During an outage, the refresh at 18:00 fails after two retries 5s and
+	// 10s apart, and is attempted again after the minimum backoff, while the
+	// cache is served throughout.
+	up.mode.Store(upstreamDown)
+	requests := up.requests.Load()
+	clk.Advance(4 * time.Hour)
+	for _, d := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		// The retry's timer replaces the refresher's.
+		clk.WaitTimers(t, 4)
+		clk.Advance(d)
+	}
+	m = s.waitForRefresh(t, clk, clk.Now().Add(minRefreshBackoff))
+	if m.Refresh.ConsecutiveFailures != 1 || m.Slots != 3*96 {
+		t.Fatalf("during the outage: %d consecutive failures, %d slots, want 1 and %d", m.Refresh.ConsecutiveFailures, m.Slots, 3*96)
+	}
+	if got := up.requests.Load() - requests; got != 3 {
+		t.Errorf("failed refresh made %d upstream requests, want 3", got)
+	}
+	wantStatus(t, s.get(t, "/price/current"), http.StatusOK)
+	wantStatus(t, s.get(t, "/price/tomorrow"), http.StatusOK)
+	up.mode.Store(upstreamUp)
+	clk.Advance(minRefreshBackoff)
+	m = s.waitForRefresh(t, clk, clk.Now().Add(4*time.Hour))
+	if m.Refresh.ConsecutiveFailures != 0 {
+		t.Fatalf("after recovering: %d consecutive failures, want 0", m.Refresh.ConsecutiveFailures)
+	}
+
+	// Shutting down saves the cache.
+	s.stop(t)
+	saved, err := loadCache(cacheFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Timestamps) != 3*96 {
+		t.Fatalf("saved %d slots, want %d", len(saved.Timestamps), 3*96)
+	}
+
+	// The next morning, a restart catches up from the saved cache and
+	// serves it, also while its first refresh hangs on the upstream.
+	clk.Set(time.Date(2026, time.January, 14, 9, 0, 0, 0, testLoc))
+	requests = up.requests.Load()
+	s = startLifecycleService(t, clk, upstream.URL, args...)
+	if got := up.requests.Load() - requests; got != 1 {
+		t.Errorf("restart made %d upstream requests, want 1", got)
+	}
+	s.waitForRefresh(t, clk, clk.Now().Add(4*time.Hour))
+	up.mode.Store(upstreamStalled)
+	clk.Advance(4 * time.Hour)
+	select {
+	case <-up.stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no refresh within 5s")
+	}
+	m = s.meta(t)
+	if m.Slots != 3*96 {
+		t.Fatalf("while refreshing: %d slots, want %d", m.Slots, 3*96)
+	}
+	wantStatus(t, s.get(t, "/price/current"), http.StatusOK)
+	wantStatus(t, s.get(t, "/price?start=2026-01-12&end=2026-01-15"), http.StatusOK)
+
+	// Shutting down cancels the stalled refresh and saves the cache again.
+	s.stop(t)
+	if saved, err = loadCache(cacheFile, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Timestamps) != 3*96 {
+		t.Fatalf("saved %d slots on the restart, want %d", len(saved.Timestamps), 3*96)
+	}
+}
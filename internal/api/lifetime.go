@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// countersFileVersion is the version of the counters file format.
+const countersFileVersion = 1
+
+// countersFile holds the lifetime totals of the counters across restarts,
+// by counter name and label key.
+type countersFile struct {
+	Version  int                      `json:"version"`
+	SavedAt  time.Time                `json:"saved_at"`
+	Counters map[string][]savedSeries `json:"counters"`
+}
+
+type savedSeries struct {
+	Labels []string `json:"labels,omitempty"`
+	Value  float64  `json:"value"`
+}
+
+// countersPath is where the counters are persisted next to the cache file.
+func countersPath(cacheFile string) string {
+	return cacheFile + ".counters"
+}
+
+// restoreCounters sets the lifetime totals of the counters of reg from the
+// file at path, starting from zero for counters it doesn't have. A missing
+// file means there was no earlier run. A corrupt file or series are logged
+// and skipped, as losing the totals of earlier runs is no reason not to start.
+func restoreCounters(reg *registry, path string) {
+	counters := reg.counters()
+	for _, c := range counters {
+		c.mu.Lock()
+		c.lifetime = make(map[string]float64)
+		c.mu.Unlock()
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var f countersFile
+	if err == nil {
+		err = json.Unmarshal(data, &f)
+	}
+	if err == nil && f.Version != countersFileVersion {
+		err = fmt.Errorf("unsupported version %d", f.Version)
+	}
+	if err != nil {
+		log.Printf("warning: ignoring counters file %s, lifetime counters start from zero: %v", path, err)
+		return
+	}
+
+	skipped := 0
+	for _, c := range counters {
+		c.mu.Lock()
+		for _, series := range f.Counters[c.name] {
+			if len(series.Labels) != len(c.labels) || series.Value < 0 || math.IsInf(series.Value, 0) || math.IsNaN(series.Value) {
+				skipped++
+				continue
+			}
+			c.lifetime[labelKey(series.Labels)] += series.Value
+		}
+		c.mu.Unlock()
+	}
+	if skipped > 0 {
+		log.Printf("warning: skipped %d invalid series of counters file %s", skipped, path)
+	}
+}
+
+// saveCounters writes the lifetime totals of the counters of reg to path.
+func saveCounters(reg *registry, path string, now time.Time) error {
+	f := countersFile{Version: countersFileVersion, SavedAt: now.UTC(), Counters: map[string][]savedSeries{}}
+	for _, c := range reg.counters() {
+		c.mu.Lock()
+		for k, v := range c.totals() {
+			f.Counters[c.name] = append(f.Counters[c.name], savedSeries{Labels: splitKey(k, len(c.labels)), Value: v})
+		}
+		c.mu.Unlock()
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error saving counters: %w", err)
+	}
+	return nil
+}
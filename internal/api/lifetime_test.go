@@ -0,0 +1,112 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newCountersRegistry returns a registry with a counter with and one
+// without labels, as each run of the service registers them.
+func newCountersRegistry() (*registry, *counterVec, *counterVec) {
+	reg := &registry{}
+	byOutcome := &counterVec{name: "test_requests_total", help: "Requests.", labels: []string{"outcome"}}
+	plain := &counterVec{name: "test_events_total", help: "Events."}
+	reg.register(byOutcome)
+	reg.register(plain)
+	return reg, byOutcome, plain
+}
+
+// TestCountersRoundTrip saves the totals of a run and restores them in the
+// next, whose _lifetime counters add its own counts to them.
+func TestCountersRoundTrip(t *testing.T) {
+	path := countersPath(filepath.Join(t.TempDir(), "cache.json"))
+	reg, byOutcome, plain := newCountersRegistry()
+	// No file means no earlier run.
+	restoreCounters(reg, path)
+	byOutcome.add(3, "success")
+	byOutcome.inc("failure")
+	plain.add(2)
+	if err := saveCounters(reg, path, testNow); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, byOutcome, plain = newCountersRegistry()
+	restoreCounters(reg, path)
+	wantMetrics(t, reg, map[string]float64{
+		`test_requests_total_lifetime{outcome="success"}`: 3,
+		`test_requests_total_lifetime{outcome="failure"}`: 1,
+		`test_events_total_lifetime`:                      2,
+		`test_events_total`:                               0,
+	})
+	byOutcome.inc("success")
+	plain.inc()
+	wantMetrics(t, reg, map[string]float64{
+		`test_requests_total{outcome="success"}`:          1,
+		`test_requests_total_lifetime{outcome="success"}`: 4,
+		`test_requests_total_lifetime{outcome="failure"}`: 1,
+		`test_events_total_lifetime`:                      3,
+	})
+
+	// Saving again writes the totals of both runs.
+	if err := saveCounters(reg, path, testNow); err != nil {
+		t.Fatal(err)
+	}
+	reg, _, _ = newCountersRegistry()
+	restoreCounters(reg, path)
+	wantMetrics(t, reg, map[string]float64{
+		`test_requests_total_lifetime{outcome="success"}`: 4,
+		`test_events_total_lifetime`:                      3,
+	})
+}
+
+// TestRestoreCorruptCounters restores from corrupt counters files, which
+// are ignored with a warning, and from files with invalid series, of which
+// only the valid ones are restored.
+func TestRestoreCorruptCounters(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		log  string
+		// success is the restored lifetime total of test_requests_total
+		// by outcome success.
+		success float64
+	}{
+		{"not json", `{"version":1,"counters":`, "warning: ignoring counters file", 0},
+		{"wrong shape", `{"version":1,"counters":{"test_events_total":{"value":1}}}`, "warning: ignoring counters file", 0},
+		{"unknown version", `{"version":2,"counters":{"test_events_total":[{"value":1}]}}`, "unsupported version 2", 0},
+		{"empty", ``, "warning: ignoring counters file", 0},
+		{
+			"invalid series",
+			`{"version":1,"counters":{"test_requests_total":[{"labels":["success"],"value":5},{"labels":["failure","extra"],"value":1},{"value":1},{"labels":["failure"],"value":-1}],"test_events_total":[{"labels":["x"],"value":1}]}}`,
+			"warning: skipped 4 invalid series of counters file",
+			5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := countersPath(filepath.Join(t.TempDir(), "cache.json"))
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			logged := captureLog(t)
+			reg, byOutcome, _ := newCountersRegistry()
+			restoreCounters(reg, path)
+			if !strings.Contains(logged.String(), tt.log) {
+				t.Errorf("logged %q, want %q", logged, tt.log)
+			}
+
+			// The lifetime counters are exposed even so, starting from what
+			// could be restored.
+			byOutcome.inc("success")
+			wantMetrics(t, reg, map[string]float64{
+				`test_requests_total_lifetime{outcome="success"}`: tt.success + 1,
+				`test_events_total_lifetime`:                      0,
+			})
+			if v, ok := metricValue(reg, `test_requests_total_lifetime{outcome="failure"}`); ok {
+				t.Errorf(`invalid series restored: test_requests_total_lifetime{outcome="failure"} = %v`, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// routeSet selects the routes served on a listener.
+type routeSet string
+
+const (
+	// routesAll serves every route. It is used unless an internal listener
+	// separates the operational routes from the public API.
+	routesAll routeSet = "all"
+	// routesPublic serves the API without the operational routes.
+	routesPublic routeSet = "public"
+	// routesInternal serves the operational routes: metrics and admin.
+	routesInternal routeSet = "internal"
+)
+
+// includes reports whether the set contains the public or the internal
+// routes.
+func (rs routeSet) includes(internal bool) bool {
+	switch rs {
+	case routesPublic:
+		return !internal
+	case routesInternal:
+		return internal
+	}
+	return true
+}
+
+type listenAddr struct {
+	routes routeSet
+	addr   string
+}
+
+// listenAddrs implements flag.Value for repeated [ROUTES=]ADDRESS flags. An
+// address without a route set serves all routes, or only the public ones if
+// there is an internal listener.
+type listenAddrs []listenAddr
+
+func (l *listenAddrs) String() string {
+	s := make([]string, len(*l))
+	for i, a := range *l {
+		s[i] = string(a.routes) + "=" + a.addr
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *listenAddrs) Set(v string) error {
+	a := listenAddr{routes: routesAll, addr: v}
+	if routes, addr, ok := strings.Cut(v, "="); ok {
+		a = listenAddr{routes: routeSet(routes), addr: addr}
+		if !slices.Contains([]routeSet{routesAll, routesPublic, routesInternal}, a.routes) {
+			return fmt.Errorf("unknown route set %q: expected all, public or internal", routes)
+		}
+	}
+	if _, _, err := net.SplitHostPort(a.addr); err != nil {
+		return fmt.Errorf("invalid address %q: %w", a.addr, err)
+	}
+	*l = append(*l, a)
+	return nil
+}
+
+// resolve narrows listeners serving all routes to the public ones if an
+// internal listener exists.
+func (l listenAddrs) resolve() listenAddrs {
+	if !slices.ContainsFunc(l, func(a listenAddr) bool { return a.routes == routesInternal }) {
+		return l
+	}
+	resolved := slices.Clone(l)
+	for i := range resolved {
+		if resolved[i].routes == routesAll {
+			resolved[i].routes = routesPublic
+		}
+	}
+	return resolved
+}
+
+// listenAll binds all addresses, or none if one of them fails.
+func listenAll(addrs listenAddrs) ([]net.Listener, error) {
+	var lns []net.Listener
+	for _, a := range addrs {
+		ln, err := net.Listen("tcp", a.addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("error listening on %s for %s routes: %w", a.addr, a.routes, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxLookupBytes limits the size of lookup request bodies, overriding the
+	// configured default.
+	maxLookupBytes = 1 << 20
+	// maxLookupTimestamps limits the number of timestamps per lookup.
+	maxLookupTimestamps = 10000
+)
+
+// parseInstant parses Unix seconds or an RFC 3339 timestamp.
+func parseInstant(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid instant %q: expected Unix seconds or RFC 3339 timestamp", v)
+}
+
+// parseInstantJSON parses a JSON number of Unix seconds or a JSON string
+// accepted by parseInstant.
+func parseInstantJSON(v json.RawMessage) (time.Time, error) {
+	var n int64
+	var str string
+	switch {
+	case json.Unmarshal(v, &n) == nil:
+		return time.Unix(n, 0), nil
+	case json.Unmarshal(v, &str) == nil:
+		return parseInstant(str)
+	default:
+		return time.Time{}, fmt.Errorf("expected Unix seconds or RFC 3339 string, got %s", v)
+	}
+}
+
+// handleLookup returns the price of the slot containing each timestamp of a
+// JSON array of Unix seconds or RFC 3339 strings, in the order given. Entries
+// outside the cached slots are null.
+func (s *server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	_, div, err := s.parseUnit(w, r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		if !bodyTooLarge(w, err) {
+			badRequest(w, fmt.Errorf("expected a JSON array of timestamps: %w", err))
+		}
+		return
+	}
+	if len(raw) > maxLookupTimestamps {
+		writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("at most %d timestamps per request", maxLookupTimestamps))
+		return
+	}
+
+	instants := make([]time.Time, len(raw))
+	for i, v := range raw {
+		if instants[i], err = parseInstantJSON(v); err != nil {
+			badRequest(w, fmt.Errorf("timestamp %d: %w", i, err))
+			return
+		}
+	}
+
+	response := make([]any, len(instants))
+	if len(instants) > 0 {
+		first, last := slices.MinFunc(instants, time.Time.Compare), slices.MaxFunc(instants, time.Time.Compare)
+		points := s.store.points(first.Add(-maxSlotDuration), last.Add(time.Nanosecond))
+		for i, t := range instants {
+			if p, ok := slotContaining(points, t); ok {
+				response[i] = priced(p, div)
+			}
+		}
+	}
+	writeJSON(w, response)
+}
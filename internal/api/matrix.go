@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// matrixRow is the prices of one local day in handleMatrix.
+type matrixRow struct {
+	Date  string `json:"date"`
+	Hours int    `json:"hours"`
+	// DST marks the days of the daylight saving time changes: "short" for
+	// the 23-hour day in spring, "long" for the 25-hour day in autumn.
+	DST    string     `json:"dst,omitempty"`
+	Prices []*float64 `json:"prices"`
+}
+
+// handleMatrix lists the prices between start and end as a day-by-slot
+// matrix for heatmaps: one row per day in the market timezone, with one
+// column per slot from local midnight at the finest resolution of the
+// range. The range is widened to whole days. Columns without a cached slot
+// are null, and a slot longer than the resolution fills all columns it
+// covers.
+func (s *server) handleMatrix(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if start.IsZero() || end.IsZero() {
+		writeError(w, http.StatusBadRequest, codeInvalidRange, "start and end are required")
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	y, m, d := start.In(s.loc).Date()
+	start = time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+	y, m, d = end.In(s.loc).Date()
+	if last := time.Date(y, m, d, 0, 0, 0, 0, s.loc); last.Before(end) {
+		end = last.AddDate(0, 0, 1)
+	} else {
+		end = last
+	}
+	if !s.checkRange(w, start, end) {
+		return
+	}
+
+	points := s.store.points(start.Add(-maxSlotDuration), end)
+	res := resolution(points)
+	rows := []matrixRow{}
+	for day := start; day.Before(end); {
+		y, m, d := day.Date()
+		next := time.Date(y, m, d+1, 0, 0, 0, 0, s.loc)
+		row := matrixRow{
+			Date:   day.Format(time.DateOnly),
+			Hours:  int(next.Sub(day).Hours()),
+			Prices: make([]*float64, next.Sub(day)/res),
+		}
+		switch row.Hours {
+		case 23:
+			row.DST = "short"
+		case 25:
+			row.DST = "long"
+		}
+		for i := range row.Prices {
+			if p, ok := slotContaining(points, day.Add(time.Duration(i)*res)); ok {
+				price := p.Price / div
+				row.Prices[i] = &price
+			}
+		}
+		rows = append(rows, row)
+		day = next
+	}
+
+	writeJSON(w, struct {
+		Start       time.Time   `json:"start"`
+		End         time.Time   `json:"end"`
+		Unit        string      `json:"unit"`
+		Orientation string      `json:"orientation"`
+		Resolution  int         `json:"resolution_minutes"`
+		Rows        []matrixRow `json:"rows"`
+	}{
+		Start:       start.UTC(),
+		End:         end.UTC(),
+		Unit:        unit,
+		Orientation: "rows are " + marketTimezone + " days, columns are consecutive slots from local midnight",
+		Resolution:  int(res.Minutes()),
+		Rows:        rows,
+	})
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"maps"
+	"net/http"
+	"sync"
+)
+
+// memoSize is the number of responses kept by memoize.
+const memoSize = 256
+
+// memoKey identifies a response of an aggregate route for a cache
+// generation. The query is normalized by sorting its parameters.
+type memoKey struct {
+	generation uint64
+	path       string
+	query      string
+	accept     string
+}
+
+// memoEntry is a memoized response, or one being computed while done is
+// open.
+type memoEntry struct {
+	key  memoKey
+	done chan struct{}
+
+	// ok is set once the response has been kept; only successful responses
+	// are, as errors carry the ID of the request that caused them.
+	ok     bool
+	header http.Header
+	body   []byte
+
+	elem *list.Element
+}
+
+// memo keeps the responses of the most recently requested aggregates of the
+// current cache generation.
+type memo struct {
+	mu         sync.Mutex
+	generation uint64
+	entries    map[memoKey]*memoEntry
+	// lru orders the entries by last use, most recent first.
+	lru *list.List
+
+	requests *counterVec
+}
+
+func newMemo(reg *registry) *memo {
+	m := &memo{
+		entries: make(map[memoKey]*memoEntry),
+		lru:     list.New(),
+		requests: &counterVec{
+			name:   "energy_prices_memo_requests_total",
+			help:   "Requests of memoized aggregate routes by whether they were answered from the memo (hit), waited for an identical request in progress (shared) or computed (miss).",
+			labels: []string{"result"},
+		},
+	}
+	reg.register(m.requests)
+	return m
+}
+
+// get returns the entry for key and whether the caller has to compute it.
+// Responses of older generations are dropped once a newer one is asked for.
+func (m *memo) get(key memoKey) (e *memoEntry, compute bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key.generation > m.generation {
+		m.generation = key.generation
+		clear(m.entries)
+		m.lru.Init()
+	}
+	if e, ok := m.entries[key]; ok {
+		m.lru.MoveToFront(e.elem)
+		return e, false
+	}
+
+	e = &memoEntry{key: key, done: make(chan struct{})}
+	e.elem = m.lru.PushFront(e)
+	m.entries[key] = e
+	for m.lru.Len() > memoSize {
+		oldest := m.lru.Remove(m.lru.Back()).(*memoEntry)
+		delete(m.entries, oldest.key)
+	}
+	return e, true
+}
+
+// finish completes e with the response in buf, keeping it if it was
+// successful and dropping the entry otherwise.
+func (m *memo) finish(e *memoEntry, buf *bufferedResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if buf != nil && buf.status == http.StatusOK {
+		e.ok, e.header, e.body = true, buf.header, buf.body.Bytes()
+	} else if m.entries[e.key] == e {
+		m.lru.Remove(e.elem)
+		delete(m.entries, e.key)
+	}
+	close(e.done)
+}
+
+// memoize answers identical requests of the same cache generation with a
+// single computation of next: requests arriving while it runs wait for it,
+// later ones get the kept response. Only routes whose responses depend on
+// nothing but the cache contents and the request may be memoized.
+func (s *server) memoize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := memoKey{
+			generation: s.pinned(r).store.meta().Generation,
+			path:       r.URL.Path,
+			query:      r.URL.Query().Encode(),
+			accept:     r.Header.Get("Accept"),
+		}
+		e, compute := s.memo.get(key)
+		if !compute {
+			result := "hit"
+			select {
+			case <-e.done:
+			default:
+				result = "shared"
+				<-e.done
+			}
+			if e.ok {
+				s.memo.requests.inc(result)
+				// Headers set before memoize, such as the request ID, are
+				// this request's own.
+				for k, v := range e.header {
+					if _, ok := w.Header()[k]; !ok {
+						w.Header()[k] = v
+					}
+				}
+				w.Write(e.body)
+				return
+			}
+			// The response wasn't kept, so this request gets its own.
+			s.memo.requests.inc("miss")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.memo.requests.inc("miss")
+		var buf *bufferedResponse
+		// A panicking handler must not leave waiters blocked.
+		defer func() { s.memo.finish(e, buf) }()
+		b := &bufferedResponse{header: w.Header().Clone()}
+		next.ServeHTTP(b, r)
+		buf = b
+		b.writeTo(w)
+	})
+}
+
+// bufferedResponse is a response held in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTo sends the buffered response to w.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	maps.Copy(w.Header(), b.header)
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	w.Write(b.body.Bytes())
+}
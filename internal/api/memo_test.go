@@ -0,0 +1,127 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMemoInvalidation requests an average before and after merges, which
+// are answered from the memo until one changes the cache.
+func TestMemoInvalidation(t *testing.T) {
+	s := newTestServer(t, "-no-ondemand")
+	target := "/price/average?start=2026-01-12&end=2026-01-13"
+	type average struct {
+		Average *float64 `json:"average"`
+	}
+	want := func(avg float64, results map[string]float64) {
+		t.Helper()
+		res := get(t, s, target)
+		wantStatus(t, res, http.StatusOK)
+		if got := decode[average](t, res); got.Average == nil || math.Abs(*got.Average-avg) > 1e-9 {
+			t.Errorf("average %v, want %v", fmtPrice(got.Average), avg)
+		}
+		series := map[string]float64{}
+		for result, n := range results {
+			series[`energy_prices_memo_requests_total{result="`+result+`"}`] = n
+		}
+		wantMetrics(t, s.metrics, series)
+	}
+
+	// 0 to 23 on the first day.
+	want(11.5, map[string]float64{"miss": 1})
+	want(11.5, map[string]float64{"miss": 1, "hit": 1})
+
+	// Merging the same prices keeps the generation, and with it the memo.
+	first := testFirstDay.UTC()
+	s.store.merge(map[time.Time]float64{first: testPrice(testFirstDay)}, originRefresh, upstreamProvider)
+	want(11.5, map[string]float64{"miss": 1, "hit": 2})
+
+	// A revised price makes a generation, whose average is computed anew.
+	s.store.merge(map[time.Time]float64{first: testPrice(testFirstDay) + 24}, originRefresh, upstreamProvider)
+	want(12.5, map[string]float64{"miss": 2, "hit": 2})
+	want(12.5, map[string]float64{"miss": 2, "hit": 3})
+
+	// Removing slots makes a generation too, leaving 1 to 23.
+	if n := s.store.remove(first, first.Add(time.Hour)); n != 1 {
+		t.Fatalf("removed %d slots, want 1", n)
+	}
+	want(12, map[string]float64{"miss": 3, "hit": 3})
+}
+
+// TestMemoize sends identical requests concurrently, which share a single
+// computation, and more distinct ones than the memo keeps.
+func TestMemoize(t *testing.T) {
+	s := newTestServer(t)
+	var computed atomic.Int32
+	release := make(chan struct{})
+	h := s.memoize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if computed.Add(1) == 1 {
+			<-release
+		}
+		if r.URL.Query().Has("fail") {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	request := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	const concurrent = 8
+	var wg sync.WaitGroup
+	bodies := make([]string, concurrent)
+	for i := range concurrent {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = request("/?q=0").Body.String()
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if n := computed.Load(); n != 1 {
+		t.Errorf("computed %d times, want once", n)
+	}
+	for i, body := range bodies {
+		if body != "q=0" {
+			t.Errorf("request %d answered with %q", i, body)
+		}
+	}
+	shared, _ := metricValue(s.metrics, `energy_prices_memo_requests_total{result="shared"}`)
+	hit, _ := metricValue(s.metrics, `energy_prices_memo_requests_total{result="hit"}`)
+	if shared+hit != concurrent-1 {
+		t.Errorf("%v shared and %v hits, want %d together", shared, hit, concurrent-1)
+	}
+
+	// Errors aren't kept.
+	for range 2 {
+		if w := request("/?fail=1"); w.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", w.Code)
+		}
+	}
+	if n := computed.Load(); n != 3 {
+		t.Errorf("computed %d times after two failures, want 3", n)
+	}
+
+	// The least recently used response is dropped beyond memoSize.
+	computed.Store(1)
+	for i := range memoSize {
+		request("/?q=" + string(rune('a'+i%26)) + string(rune('a'+i/26)))
+	}
+	if n := computed.Load(); n != 1+memoSize {
+		t.Fatalf("computed %d times for %d distinct requests", n-1, memoSize)
+	}
+	request("/?q=aa")
+	request("/?q=0")
+	if n := computed.Load(); n != 2+memoSize {
+		t.Errorf("computed %d times, want only the dropped response again", n-1-memoSize)
+	}
+}
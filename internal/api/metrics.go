@@ -0,0 +1,276 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a metric family rendered in the Prometheus text exposition format.
+type metric interface {
+	write(w io.Writer)
+}
+
+type registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func (r *registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// counters returns the registered counters.
+func (r *registry) counters() []*counterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var counters []*counterVec
+	for _, m := range r.metrics {
+		if c, ok := m.(*counterVec); ok {
+			counters = append(counters, c)
+		}
+	}
+	return counters
+}
+
+func (r *registry) write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels renders label pairs as {a="x",b="y"}, or nothing if there are none.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = n + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// gaugeFunc is a gauge whose value is computed at scrape time.
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g gaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %g\n", g.name, g.fn())
+}
+
+// gaugeVecFunc is a gauge partitioned by label values whose series are
+// computed at scrape time. fn reports each series by calling emit, and
+// series it doesn't report are absent from the scrape.
+type gaugeVecFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func(emit func(v float64, labelValues ...string))
+}
+
+func (g gaugeVecFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	g.fn(func(v float64, labelValues ...string) {
+		fmt.Fprintf(w, "%s%s %g\n", g.name, formatLabels(g.labels, labelValues), v)
+	})
+}
+
+type gauge struct {
+	name string
+	help string
+
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *gauge) set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %g\n", g.name, g.value)
+}
+
+// counterVec is a counter partitioned by label values. Without labels it
+// is a single counter.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	// lifetime are the totals of earlier runs by label key. If set, they are
+	// exposed together with the current values as the _lifetime family.
+	lifetime map[string]float64
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.values == nil {
+		c.values = make(map[string]float64)
+	}
+	c.values[labelKey(labelValues)] += v
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+// value returns the count of the given label values.
+func (c *counterVec) value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[labelKey(labelValues)]
+}
+
+// total returns the sum over all label values.
+func (c *counterVec) total() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sum float64
+	for _, v := range c.values {
+		sum += v
+	}
+	return sum
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.values)) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, formatLabels(c.labels, splitKey(k, len(c.labels))), c.values[k])
+	}
+
+	if c.lifetime == nil {
+		return
+	}
+	name := c.name + "_lifetime"
+	writeHeader(w, name, c.help+" Including earlier runs.", "counter")
+	totals := c.totals()
+	if len(c.labels) == 0 && len(totals) == 0 {
+		fmt.Fprintf(w, "%s 0\n", name)
+	}
+	for _, k := range slices.Sorted(maps.Keys(totals)) {
+		fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(c.labels, splitKey(k, len(c.labels))), totals[k])
+	}
+}
+
+// totals returns the lifetime totals by label key. c.mu must be held.
+func (c *counterVec) totals() map[string]float64 {
+	totals := maps.Clone(c.lifetime)
+	if totals == nil {
+		totals = make(map[string]float64)
+	}
+	for k, v := range c.values {
+		totals[k] += v
+	}
+	return totals
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.series == nil {
+		h.series = make(map[string]*histogramSeries)
+	}
+	k := labelKey(labelValues)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, k := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[k]
+		values := splitKey(k, len(h.labels))
+		names := append(slices.Clip(h.labels), "le")
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, append(slices.Clip(values), formatFloat(b))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, append(slices.Clip(values), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, formatLabels(h.labels, values), s.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func splitKey(k string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.Split(k, "\xff")
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// responseRecorder captures the status code and the number of bytes written
+// by a handler.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type httpMetrics struct {
+	requests *counterVec
+	duration *histogramVec
+	bytes    *counterVec
+}
+
+func newHTTPMetrics(reg *registry) *httpMetrics {
+	m := &httpMetrics{
+		requests: &counterVec{
+			name:   "energy_prices_http_requests_total",
+			help:   "HTTP requests by route pattern and status class.",
+			labels: []string{"route", "code"},
+		},
+		duration: &histogramVec{
+			name:    "energy_prices_http_request_duration_seconds",
+			help:    "HTTP request durations by route pattern and status class.",
+			labels:  []string{"route", "code"},
+			buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		},
+		bytes: &counterVec{
+			name:   "energy_prices_http_response_bytes_total",
+			help:   "Response body bytes written by route pattern.",
+			labels: []string{"route"},
+		},
+	}
+	reg.register(m.requests)
+	reg.register(m.duration)
+	reg.register(m.bytes)
+	return m
+}
+
+// instrument records metrics for every request served by the mux. The route
+// label is the matched mux pattern rather than the raw path, which keeps the
+// label cardinality bounded.
+func (m *httpMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		class := fmt.Sprintf("%dxx", rec.status/100)
+
+		m.requests.inc(route, class)
+		m.duration.observe(time.Since(began).Seconds(), route, class)
+		m.bytes.add(float64(rec.bytes), route)
+	})
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// requestID makes sure every response carries a request ID, reusing the one
+// supplied by the client or a proxy if it looks sane.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps the request body at n bytes. Reading beyond it fails with
+// an *http.MaxBytesError, which handlers answer with bodyTooLarge.
+func limitBody(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitURI answers requests whose target exceeds n bytes with 414.
+func limitURI(n int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.RequestURI) > n {
+			writeError(w, http.StatusRequestURITooLong, codeURITooLong, fmt.Sprintf("request target exceeds %d bytes", n))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
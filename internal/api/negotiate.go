@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// formatTypes are the media types of the response formats.
+var formatTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatText:   "text/plain",
+	formatCSV:    "text/csv",
+	formatNDJSON: "application/x-ndjson",
+}
+
+// negotiateFormat picks the response format among those supported by the
+// handler, the first being the default. The format query parameter takes
+// precedence over the Accept header. If neither yields a supported format,
+// the error response has been written and ok is false.
+func negotiateFormat(w http.ResponseWriter, r *http.Request, supported ...string) (format string, ok bool) {
+	if !slices.Contains(w.Header().Values("Vary"), "Accept") {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	q := r.URL.Query()
+	if q.Has("format") {
+		format, err := parseFormat(q, supported...)
+		if err != nil {
+			badRequest(w, err)
+			return "", false
+		}
+		return format, true
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return supported[0], true
+	}
+	if format, ok := acceptedFormat(accept, supported); ok {
+		return format, true
+	}
+
+	types := make([]string, len(supported))
+	for i, f := range supported {
+		types[i] = formatTypes[f]
+	}
+	writeError(w, http.StatusNotAcceptable, codeNotAcceptable, fmt.Sprintf(
+		"none of the accepted media types is available, expected one of %s", strings.Join(types, ", "),
+	))
+	return "", false
+}
+
+// acceptedFormat returns the supported format preferred by an Accept header.
+// Among equally preferred formats, the earlier supported one wins.
+func acceptedFormat(accept string, supported []string) (string, bool) {
+	best, bestQ := "", 0.0
+	for _, f := range supported {
+		typ := formatTypes[f]
+		q, specificity := 0.0, -1
+		for _, entry := range strings.Split(accept, ",") {
+			mediaRange, params, _ := strings.Cut(entry, ";")
+			mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+			if vendorTypePattern.MatchString(mediaRange) {
+				// The version is picked separately by parseVersion.
+				mediaRange = formatTypes[formatJSON]
+			}
+
+			s := matchSpecificity(mediaRange, typ)
+			if s <= specificity {
+				continue
+			}
+			specificity, q = s, 1.0
+			for _, p := range strings.Split(params, ";") {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+					if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+						q = parsed
+					}
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// splitAccept returns the media ranges of an Accept header without their
+// parameters.
+func splitAccept(accept string) []string {
+	var ranges []string
+	for _, entry := range strings.Split(accept, ",") {
+		mediaRange, _, _ := strings.Cut(entry, ";")
+		if mediaRange = strings.ToLower(strings.TrimSpace(mediaRange)); mediaRange != "" {
+			ranges = append(ranges, mediaRange)
+		}
+	}
+	return ranges
+}
+
+// matchSpecificity returns how specifically a media range matches typ: 2 for
+// an exact match, 1 for type/*, 0 for */* and -1 for no match.
+func matchSpecificity(mediaRange, typ string) int {
+	switch {
+	case mediaRange == typ:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(typ, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
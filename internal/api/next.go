@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// handleNext finds the first slot that has not ended yet whose price is at or
+// below the given threshold, both expressed in the requested unit.
+func (s *server) handleNext(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	below, err := parseFloat(q, "below", 0)
+	if err == nil && q.Get("below") == "" {
+		err = errors.New("below: expected a number")
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	now := s.clock.Now()
+	var minPrice *float64
+	for _, p := range s.store.points(now.Add(-maxSlotDuration), time.Time{}) {
+		if !p.Start.Add(p.Duration).After(now) {
+			continue
+		}
+		price := p.Price / div
+		if price <= below {
+			writeJSON(w, struct {
+				Found     bool    `json:"found"`
+				Time      int64   `json:"time"`
+				Price     float64 `json:"price"`
+				Unit      string  `json:"unit"`
+				HoursAway float64 `json:"hours_away"`
+			}{true, p.Start.Unix(), price, unit, max(0, p.Start.Sub(now).Hours())})
+			return
+		}
+		if minPrice == nil || price < *minPrice {
+			minPrice = &price
+		}
+	}
+
+	writeJSON(w, struct {
+		Found    bool     `json:"found"`
+		MinPrice *float64 `json:"min_price"`
+		Unit     string   `json:"unit"`
+	}{false, minPrice, unit})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"net/http"
+	"time"
+)
+
+// upstreamContext returns the context for upstream calls made on behalf of
+// r. It is bounded separately from background refreshes, so that a slow
+// upstream can't hold clients for long.
+func (s *server) upstreamContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), s.cfg.onDemandTimeout)
+}
+
+// rangeSlots yields the slots of [start, end) like store.scan, but first
+// fetches the part of the range before the cached data from the upstream if
+// on-demand fetching is enabled and r isn't pinned to a snapshot, skipping
+// settled days. If the upstream fails, the error response has been written
+// and ok is false.
+func (s *server) rangeSlots(w http.ResponseWriter, r *http.Request, start, end time.Time) (slots iter.Seq[cachedSlot], ok bool) {
+	earliest := s.store.meta().Earliest
+	if !s.cfg.onDemand || isPinned(r) || start.IsZero() || earliest.IsZero() || !start.Before(earliest) {
+		return s.store.scan(start, end), true
+	}
+	missingEnd := earliest
+	if !end.IsZero() && end.Before(missingEnd) {
+		missingEnd = end
+	}
+	if missingEnd.Sub(start) > s.cfg.onDemandMax {
+		return s.store.scan(start, end), true
+	}
+
+	ctx, cancel := s.upstreamContext(r)
+	defer cancel()
+	prices, err := s.fetchUnsettled(ctx, start, missingEnd)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, codeUnavailable, "timed out fetching uncached prices from the upstream")
+			return nil, false
+		}
+		log.Printf("error fetching prices on demand: %v", err)
+		writeError(w, http.StatusBadGateway, codeUnavailable, fmt.Sprintf("error fetching uncached prices from the upstream: %v", err))
+		return nil, false
+	}
+
+	if s.cfg.onDemandMerge {
+		s.store.merge(prices, originOnDemand, upstreamProvider)
+		return s.store.scan(start, end), true
+	}
+
+	// Serve the fetched prices without keeping them. The fetched and the
+	// cached slots don't overlap, so they can be joined at earliest.
+	fetched := newStore()
+	fetched.merge(prices, originOnDemand, upstreamProvider)
+	return func(yield func(cachedSlot) bool) {
+		for c := range fetched.scan(start, missingEnd) {
+			if !yield(c) {
+				return
+			}
+		}
+		if end.IsZero() || end.After(earliest) {
+			for c := range s.store.scan(earliest, end) {
+				if !yield(c) {
+					return
+				}
+			}
+		}
+	}, true
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/mockupstream"
+)
+
+// TestOnDemandTimeout lists a range before the cached data from a mock
+// upstream with the latency given, which answers within -ondemand-timeout
+// or is given up on with 504.
+func TestOnDemandTimeout(t *testing.T) {
+	week := testFirstDay.AddDate(0, 0, -7)
+	prices := make(map[time.Time]float64)
+	for t := week; t.Before(testFirstDay); t = t.Add(time.Hour) {
+		prices[t.UTC()] = testPrice(t)
+	}
+	tests := []struct {
+		name    string
+		latency time.Duration
+		status  int
+	}{
+		{"fast upstream", 0, http.StatusOK},
+		{"slow upstream", 5 * time.Second, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, err := mockupstream.New(mockupstream.Config{
+				Fixture: fixturePrices(prices),
+				Latency: tt.latency,
+				Now:     func() time.Time { return testNow },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			up := httptest.NewServer(mock)
+			defer up.Close()
+			s := newTestServer(t, "-upstream-url", up.URL, "-ondemand-timeout", "100ms")
+			s.upstream.backoff = 0
+
+			began := time.Now()
+			res := get(t, s, "/price?start=2026-01-05&end=2026-01-06")
+			if tt.status != http.StatusOK {
+				wantError(t, res, tt.status, codeUnavailable, "timed out fetching uncached prices from the upstream")
+				if took := time.Since(began); took > tt.latency/2 {
+					t.Errorf("answered after %s, not at the deadline", took)
+				}
+				return
+			}
+			wantStatus(t, res, http.StatusOK)
+			if slots := decode[[]PricePoint](t, res); len(slots) != 24 {
+				t.Errorf("%d slots fetched on demand, want 24", len(slots))
+			}
+		})
+	}
+}
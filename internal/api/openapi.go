@@ -0,0 +1,35 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// openAPIDocument returns the embedded document with a servers block
+// pointing at basePath.
+func openAPIDocument(basePath string) ([]byte, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		return nil, err
+	}
+	url := basePath
+	if url == "" {
+		url = "/"
+	}
+	doc["servers"] = []map[string]string{{"url": url}}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func (s *server) handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	doc, err := openAPIDocument(s.cfg.basePath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// volatileFields are the fields of responses that differ between otherwise
+// equal requests, and aren't compared with the examples.
+var volatileFields = []string{"request_id"}
+
+// dropVolatile removes volatileFields from a decoded JSON value.
+func dropVolatile(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range volatileFields {
+			delete(v, name)
+		}
+		for k, field := range v {
+			v[k] = dropVolatile(field)
+		}
+	case []any:
+		for i, elem := range v {
+			v[i] = dropVolatile(elem)
+		}
+	}
+	return v
+}
+
+// schemaChecker checks JSON values against the subset of OpenAPI schemas
+// the document uses.
+type schemaChecker struct {
+	schemas map[string]map[string]any
+}
+
+// resolve follows the reference of schema, if it is one.
+func (c schemaChecker) resolve(schema map[string]any) map[string]any {
+	for {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		schema = c.schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	}
+}
+
+// properties returns the properties of an object schema, including those
+// of the schemas it is composed of with allOf.
+func (c schemaChecker) properties(schema map[string]any) map[string]any {
+	schema = c.resolve(schema)
+	props := make(map[string]any)
+	if p, ok := schema["properties"].(map[string]any); ok {
+		maps.Copy(props, p)
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			maps.Copy(props, c.properties(sub.(map[string]any)))
+		}
+	}
+	return props
+}
+
+// check returns how v violates schema, naming where in v by at. Objects
+// whose schema documents properties may have no others, unless the schema
+// is part of an allOf, whose properties are checked together.
+func (c schemaChecker) check(schema map[string]any, v any, at string, part bool) []string {
+	schema = c.resolve(schema)
+	if schema == nil {
+		return []string{at + ": unknown schema reference"}
+	}
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil && schema["allOf"] == nil && schema["oneOf"] == nil {
+			return nil
+		}
+		return []string{at + ": null, but not nullable"}
+	}
+
+	var errs []string
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			errs = append(errs, c.check(sub.(map[string]any), v, at, true)...)
+		}
+	}
+	if one, ok := schema["oneOf"].([]any); ok {
+		matched := slices.ContainsFunc(one, func(sub any) bool {
+			return len(c.check(sub.(map[string]any), v, at, part)) == 0
+		})
+		if !matched {
+			errs = append(errs, at+": matches none of oneOf")
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, v) {
+		errs = append(errs, fmt.Sprintf("%s: %v not in enum %v", at, v, enum))
+	}
+
+	typ, _ := schema["type"].(string)
+	switch v := v.(type) {
+	case map[string]any:
+		if typ != "" && typ != "object" {
+			return append(errs, fmt.Sprintf("%s: object, want %s", at, typ))
+		}
+		props := c.properties(schema)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			switch prop, ok := props[k].(map[string]any); {
+			case ok:
+				errs = append(errs, c.check(prop, v[k], at+"."+k, false)...)
+			case additional != nil:
+				errs = append(errs, c.check(additional, v[k], at+"."+k, false)...)
+			case !part && len(props) > 0 && schema["oneOf"] == nil:
+				errs = append(errs, at+"."+k+": not documented")
+			}
+		}
+	case []any:
+		if typ != "" && typ != "array" {
+			return append(errs, fmt.Sprintf("%s: array, want %s", at, typ))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, elem := range v {
+				errs = append(errs, c.check(items, elem, fmt.Sprintf("%s[%d]", at, i), false)...)
+			}
+		}
+	case string:
+		if typ != "" && typ != "string" {
+			errs = append(errs, fmt.Sprintf("%s: string, want %s", at, typ))
+		}
+	case float64:
+		if typ != "" && typ != "number" && (typ != "integer" || v != math.Trunc(v)) {
+			errs = append(errs, fmt.Sprintf("%s: number %v, want %s", at, v, typ))
+		}
+	case bool:
+		if typ != "" && typ != "boolean" {
+			errs = append(errs, fmt.Sprintf("%s: boolean, want %s", at, typ))
+		}
+	}
+	return errs
+}
+
+// TestOpenAPIExamples replays the request of every example named fixture in
+// the OpenAPI document against the fixture server, and checks that the
+// response has the documented status, content type and body, and that the
+// body conforms to the documented schema.
+func TestOpenAPIExamples(t *testing.T) {
+	type content struct {
+		Schema   map[string]any `json:"schema"`
+		Examples map[string]struct {
+			Summary string `json:"summary"`
+			Value   any    `json:"value"`
+		} `json:"examples"`
+	}
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	schemas := schemaChecker{doc.Components.Schemas}
+
+	s := newFixtureServer(t)
+	replayed := 0
+	for path, ops := range doc.Paths {
+		for method, raw := range ops {
+			var op struct {
+				Responses map[string]struct {
+					Content map[string]content `json:"content"`
+				} `json:"responses"`
+			}
+			if json.Unmarshal(raw, &op) != nil {
+				// Not an operation, such as parameters.
+				continue
+			}
+			for code, res := range op.Responses {
+				for contentType, c := range res.Content {
+					example, ok := c.Examples["fixture"]
+					if !ok {
+						continue
+					}
+					replayed++
+					t.Run(strings.ToUpper(method)+" "+path, func(t *testing.T) {
+						m, request, _ := strings.Cut(example.Summary, " ")
+						target, body, _ := strings.Cut(request, " ")
+						if m != strings.ToUpper(method) || !strings.HasPrefix(target, strings.SplitN(path, "{", 2)[0]) {
+							t.Fatalf("example request %q isn't one of %s %s", example.Summary, method, path)
+						}
+						status, err := strconv.Atoi(code)
+						if err != nil {
+							t.Fatalf("response %s: %v", code, err)
+						}
+
+						got := serve(t, s, m, target, body)
+						wantStatus(t, got, status)
+						if ct := got.Header.Get("Content-Type"); ct != contentType {
+							t.Errorf("Content-Type %q, want %q", ct, contentType)
+						}
+						gotBody := readBody(t, got)
+						var v any
+						if err := json.Unmarshal([]byte(gotBody), &v); err != nil {
+							t.Fatalf("invalid JSON body: %v", err)
+						}
+						if !reflect.DeepEqual(dropVolatile(v), dropVolatile(example.Value)) {
+							want, _ := json.Marshal(example.Value)
+							t.Errorf("response differs from the example:\n%s\nwant\n%s", gotBody, want)
+						}
+						if c.Schema == nil {
+							t.Fatal("no schema")
+						}
+						for _, err := range schemas.check(c.Schema, v, "body", false) {
+							t.Error(err)
+						}
+					})
+				}
+			}
+		}
+	}
+	if replayed == 0 {
+		t.Fatal("no examples named fixture")
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTime parses an RFC 3339 timestamp or a YYYY-MM-DD date, the latter
+// denoting midnight in loc.
+func parseTime(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, v, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 timestamp or YYYY-MM-DD date", v)
+}
+
+// parseRange reads the optional start and end query parameters. Missing
+// values are returned as the zero time.
+func parseRange(q url.Values, loc *time.Location) (start, end time.Time, err error) {
+	return parseNamedRange(q, loc, "start", "end")
+}
+
+// parseNamedRange is parseRange for parameters other than start and end.
+func parseNamedRange(q url.Values, loc *time.Location, startName, endName string) (start, end time.Time, err error) {
+	if v := q.Get(startName); v != "" {
+		if start, err = parseTime(v, loc); err != nil {
+			return start, end, fmt.Errorf("%s: %w", startName, err)
+		}
+	}
+	if v := q.Get(endName); v != "" {
+		if end, err = parseTime(v, loc); err != nil {
+			return start, end, fmt.Errorf("%s: %w", endName, err)
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return start, end, fmt.Errorf("%w: %s must be before %s", errInvalidRange, startName, endName)
+	}
+	return start, end, nil
+}
+
+// hasRelativeRange reports whether q has a range relative to the current
+// time, as resolved by resolveRelativeRange.
+func hasRelativeRange(q url.Values) bool {
+	if q.Has("last") || q.Has("next") {
+		return true
+	}
+	for _, name := range []string{"start", "end"} {
+		if v := q.Get(name); v == "now" || strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRelativeRange replaces a range relative to now in q with absolute
+// start and end parameters, so that the handlers only see the latter. The
+// range is either last and next, reaching the given durations before and
+// after now, or a start and end of now or an offset from it like -24h.
+func resolveRelativeRange(q url.Values, now time.Time) error {
+	now = now.Truncate(time.Second)
+	if q.Has("last") || q.Has("next") {
+		if q.Has("start") || q.Has("end") {
+			return fmt.Errorf("%w: last and next cannot be combined with start or end", errInvalidRange)
+		}
+		start, end := now, now
+		for _, name := range []string{"last", "next"} {
+			if !q.Has(name) {
+				continue
+			}
+			d, err := parseDuration(q.Get(name))
+			if err != nil || d <= 0 {
+				return fmt.Errorf("%s: expected a positive duration like 48h or 7d, got %q", name, q.Get(name))
+			}
+			if name == "last" {
+				start = now.Add(-d)
+			} else {
+				end = now.Add(d)
+			}
+		}
+		q.Del("last")
+		q.Del("next")
+		q.Set("start", start.Format(time.RFC3339))
+		q.Set("end", end.Format(time.RFC3339))
+		return nil
+	}
+
+	for _, name := range []string{"start", "end"} {
+		v := q.Get(name)
+		switch {
+		case v == "now":
+			q.Set(name, now.Format(time.RFC3339))
+		case strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+"):
+			d, err := parseDuration(strings.TrimPrefix(v, "+"))
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			q.Set(name, now.Add(d).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// parseFloat reads an optional numeric query parameter, returning def if it
+// is absent.
+func parseFloat(q url.Values, name string, def float64) (float64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%s: invalid number %q", name, v)
+	}
+	return f, nil
+}
+
+// parseDuration extends time.ParseDuration with a "d" suffix for whole days,
+// e.g. "30d".
+func parseDuration(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: expected e.g. 7d or 36h", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: expected e.g. 7d or 36h", v)
+	}
+	return d, nil
+}
+
+// validParams rejects requests with query parameters other than params when
+// strict checking is enabled, either by configuration or by the strict
+// parameter of the request itself.
+//
+// Routes taking start and end also accept ranges relative to the current
+// time, which are resolved before next sees the request. The resolved range
+// is stated in the X-Range-Start and X-Range-End headers, and successful
+// responses state how much of the range is cached, see markCoverage. Starts
+// before the dataset epoch are clamped to it, see clampToEpoch.
+func (s *server) validParams(params []string, next http.Handler) http.Handler {
+	accepted := append([]string{"strict", "generation"}, params...)
+	relative := slices.Contains(params, "start") && slices.Contains(params, "end")
+	if relative {
+		accepted = append(accepted, "last", "next")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		strict := s.cfg.strictParams
+		if v := q.Get("strict"); v != "" {
+			var err error
+			if strict, err = strconv.ParseBool(v); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("strict: expected true or false, got %q", v))
+				return
+			}
+		}
+
+		if strict {
+			for _, name := range slices.Sorted(maps.Keys(q)) {
+				if !slices.Contains(accepted, name) {
+					writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf(
+						"unknown parameter %q, accepted parameters are %s", name, strings.Join(accepted, ", "),
+					))
+					return
+				}
+			}
+		}
+
+		if relative && hasRelativeRange(q) {
+			if err := resolveRelativeRange(q, s.clock.Now()); err != nil {
+				badRequest(w, err)
+				return
+			}
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = q.Encode()
+			w.Header().Set("X-Range-Start", q.Get("start"))
+			w.Header().Set("X-Range-End", q.Get("end"))
+		}
+		if relative && !q.Has("at") {
+			clamped, ok := s.clampToEpoch(w, s.pinned(r).store, q)
+			if !ok {
+				return
+			}
+			if clamped {
+				r = r.Clone(r.Context())
+				r.URL.RawQuery = q.Encode()
+			}
+		}
+		if relative && !q.Has("at") {
+			// Prices fetched on demand are merged by the handler, so the
+			// coverage is computed once it responds.
+			st := s.pinned(r).store
+			w = &coverageWriter{ResponseWriter: w, mark: func(h http.Header) { s.markCoverage(h, st, q) }}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// formatDuration formats whole days as e.g. "366d" and anything else like
+// time.Duration.String.
+func formatDuration(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	}
+	return d.String()
+}
+
+const (
+	formatJSON = "json"
+	formatText = "txt"
+)
+
+// parseFormat reads the optional format query parameter, which defaults to
+// the first of the formats supported by the endpoint.
+func parseFormat(q url.Values, supported ...string) (string, error) {
+	f := q.Get("format")
+	if f == "" {
+		return supported[0], nil
+	}
+	if !slices.Contains(supported, f) {
+		return "", fmt.Errorf("format: expected one of %s, got %q", strings.Join(supported, ", "), f)
+	}
+	return f, nil
+}
+
+// parseBool reads an optional boolean query parameter, which is false if it
+// is absent.
+func parseBool(q url.Values, name string) (bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: expected true or false, got %q", name, v)
+	}
+	return b, nil
+}
+
+// parseInt reads an optional integer query parameter within [lo, hi],
+// returning def if it is absent.
+func parseInt(q url.Values, name string, def, lo, hi int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s: expected an integer between %d and %d, got %q", name, lo, hi, v)
+	}
+	return n, nil
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		v    string
+		want time.Time
+		err  string
+	}{
+		{"2026-01-13T14:30:00Z", time.Date(2026, time.January, 13, 14, 30, 0, 0, time.UTC), ""},
+		{"2026-01-13T15:30:00+01:00", time.Date(2026, time.January, 13, 14, 30, 0, 0, time.UTC), ""},
+		// Dates are Europe/Berlin midnights, in summer as in winter.
+		{"2026-01-13", time.Date(2026, time.January, 12, 23, 0, 0, 0, time.UTC), ""},
+		{"2026-07-13", time.Date(2026, time.July, 12, 22, 0, 0, 0, time.UTC), ""},
+		{"2026-01-13T14:30", time.Time{}, "expected RFC 3339 timestamp or YYYY-MM-DD date"},
+		{"13.01.2026", time.Time{}, "expected RFC 3339 timestamp or YYYY-MM-DD date"},
+		{"", time.Time{}, "invalid time"},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.v, testLoc)
+		if !got.Equal(tt.want) || !errorContains(err, tt.err) {
+			t.Errorf("parseTime(%q) = %s, %v, want %s, error containing %q", tt.v, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		query      string
+		start, end time.Time
+		err        string
+	}{
+		{"", time.Time{}, time.Time{}, ""},
+		{"start=2026-01-13", time.Date(2026, time.January, 12, 23, 0, 0, 0, time.UTC), time.Time{}, ""},
+		{"end=2026-01-13", time.Time{}, time.Date(2026, time.January, 12, 23, 0, 0, 0, time.UTC), ""},
+		{"start=2026-01-13&end=2026-01-14", time.Date(2026, time.January, 12, 23, 0, 0, 0, time.UTC), time.Date(2026, time.January, 13, 23, 0, 0, 0, time.UTC), ""},
+		{"start=2026-01-13&end=2026-01-13", time.Time{}, time.Time{}, "start must be before end"},
+		{"start=2026-01-14&end=2026-01-13", time.Time{}, time.Time{}, "start must be before end"},
+		{"start=tomorrow", time.Time{}, time.Time{}, "start: invalid time"},
+		{"end=tomorrow", time.Time{}, time.Time{}, "end: invalid time"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		start, end, err := parseRange(q, testLoc)
+		if !errorContains(err, tt.err) || tt.err == "" && (!start.Equal(tt.start) || !end.Equal(tt.end)) {
+			t.Errorf("parseRange(%q) = %s, %s, %v, want %s, %s, error containing %q", tt.query, start, end, err, tt.start, tt.end, tt.err)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		v    string
+		want time.Duration
+		err  bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"36h", 36 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"-2h", -2 * time.Hour, false},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"1w", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.v)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("parseDuration(%q) = %s, %v, want %s, error %t", tt.v, got, err, tt.want, tt.err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "expected e.g. 7d or 36h") {
+			t.Errorf("parseDuration(%q) error %q doesn't state the accepted format", tt.v, err)
+		}
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	floats := []struct {
+		query string
+		want  float64
+		err   bool
+	}{
+		{"", 7, false},
+		{"x=1.5", 1.5, false},
+		{"x=-2", -2, false},
+		{"x=1e3", 1000, false},
+		{"x=NaN", 0, true},
+		{"x=Inf", 0, true},
+		{"x=-Inf", 0, true},
+		{"x=one", 0, true},
+	}
+	for _, tt := range floats {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := parseFloat(q, "x", 7)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("parseFloat(%q) = %v, %v, want %v, error %t", tt.query, got, err, tt.want, tt.err)
+		}
+	}
+
+	ints := []struct {
+		query string
+		want  int
+		err   bool
+	}{
+		{"", 7, false},
+		{"x=1", 1, false},
+		{"x=10", 10, false},
+		{"x=0", 0, true},
+		{"x=11", 0, true},
+		{"x=1.5", 0, true},
+	}
+	for _, tt := range ints {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := parseInt(q, "x", 7, 1, 10)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("parseInt(%q) = %v, %v, want %v, error %t", tt.query, got, err, tt.want, tt.err)
+		}
+	}
+
+	bools := []struct {
+		query string
+		want  bool
+		err   bool
+	}{
+		{"", false, false},
+		{"x=true", true, false},
+		{"x=1", true, false},
+		{"x=false", false, false},
+		{"x=yes", false, true},
+	}
+	for _, tt := range bools {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := parseBool(q, "x")
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("parseBool(%q) = %v, %v, want %v, error %t", tt.query, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestResolveRelativeRange(t *testing.T) {
+	now := time.Date(2026, time.January, 13, 14, 30, 15, 500, time.UTC)
+	tests := []struct {
+		query string
+		want  string
+		err   string
+	}{
+		{"last=24h", "end=2026-01-13T14%3A30%3A15Z&start=2026-01-12T14%3A30%3A15Z", ""},
+		{"next=2d", "end=2026-01-15T14%3A30%3A15Z&start=2026-01-13T14%3A30%3A15Z", ""},
+		{"last=1h&next=1h", "end=2026-01-13T15%3A30%3A15Z&start=2026-01-13T13%3A30%3A15Z", ""},
+		{"start=-1h&end=now", "end=2026-01-13T14%3A30%3A15Z&start=2026-01-13T13%3A30%3A15Z", ""},
+		{"start=now&end=%2B1d", "end=2026-01-14T14%3A30%3A15Z&start=2026-01-13T14%3A30%3A15Z", ""},
+		{"start=-1h&end=2026-01-14", "end=2026-01-14&start=2026-01-13T13%3A30%3A15Z", ""},
+		{"last=24h&start=-1h", "", "last and next cannot be combined with start or end"},
+		{"last=0", "", "last: expected a positive duration"},
+		{"next=soon", "", "next: expected a positive duration"},
+		{"start=-1x", "", "start: invalid duration"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		err := resolveRelativeRange(q, now)
+		if !errorContains(err, tt.err) || tt.err == "" && q.Encode() != tt.want {
+			t.Errorf("resolveRelativeRange(%q) = %q, %v, want %q, error containing %q", tt.query, q.Encode(), err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestValidParams(t *testing.T) {
+	tests := []struct {
+		args   []string
+		target string
+		status int
+		code   errorCode
+		msg    string
+	}{
+		{nil, "/price?stat=1", http.StatusOK, "", ""},
+		{nil, "/price?stat=1&strict=true", http.StatusBadRequest, codeInvalidParameter, `unknown parameter "stat", accepted parameters are strict, generation, start, end`},
+		{nil, "/price?strict=maybe", http.StatusBadRequest, codeInvalidParameter, `strict: expected true or false, got "maybe"`},
+		{[]string{"-strict-params"}, "/price?stat=1", http.StatusBadRequest, codeInvalidParameter, `unknown parameter "stat"`},
+		{[]string{"-strict-params"}, "/price?stat=1&strict=false", http.StatusOK, "", ""},
+		{[]string{"-strict-params"}, "/price?start=2026-01-13&last=1h", http.StatusBadRequest, codeInvalidRange, "last and next cannot be combined"},
+		{[]string{"-strict-params"}, "/price?last=1h&format=txt", http.StatusOK, "", ""},
+		{[]string{"-strict-params"}, "/price/current?last=1h", http.StatusBadRequest, codeInvalidParameter, `unknown parameter "last"`},
+		{nil, "/price?start=13.01.2026", http.StatusBadRequest, codeInvalidParameter, "start: invalid time \"13.01.2026\": expected RFC 3339 timestamp or YYYY-MM-DD date"},
+		{nil, "/price?start=2026-01-14&end=2026-01-13", http.StatusBadRequest, codeInvalidRange, "start must be before end"},
+		{nil, "/price?format=xml", http.StatusBadRequest, codeInvalidParameter, "format: expected one of"},
+		{nil, "/price/current?unit=W", http.StatusBadRequest, codeInvalidParameter, "unknown unit \"W\": expected one of"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append(tt.args, tt.target), " "), func(t *testing.T) {
+			res := get(t, newTestServer(t, tt.args...), tt.target)
+			if tt.status == http.StatusOK {
+				wantStatus(t, res, http.StatusOK)
+				return
+			}
+			wantError(t, res, tt.status, tt.code, tt.msg)
+		})
+	}
+}
+
+// errorContains reports whether err contains msg, or is nil if msg is empty.
+func errorContains(err error, msg string) bool {
+	if err == nil {
+		return msg == ""
+	}
+	return msg != "" && strings.Contains(err.Error(), msg)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// cacheFileVersion is the version of the cache file format written by save.
+// Version 1 files have no version field and no metadata; they are only ever
+// written for the prices of biddingZone from the energy-charts upstream.
+const cacheFileVersion = 2
+
+// cacheFile is the on-disk representation of the store. The metadata
+// describes what the slots are prices of, and the parallel arrays mirror the
+// upstream response format.
+type cacheFile struct {
+	Version    int       `json:"version"`
+	Zone       string    `json:"zone"`
+	Provider   string    `json:"provider"`
+	Unit       string    `json:"unit"`
+	Resolution int       `json:"resolution_minutes,omitempty"`
+	SavedAt    time.Time `json:"saved_at"`
+	Timestamps []int64   `json:"unix_seconds"`
+	Prices     []float64 `json:"price"`
+	// Sources are the sources of the slots, parallel to the prices, if any
+	// slot isn't of Provider.
+	Sources []string `json:"sources,omitempty"`
+
+	// Validators are those of the conditional upstream fetches, so that the
+	// first refresh after a restart can be conditional too.
+	Validators []savedValidator `json:"upstream_validators,omitempty"`
+	// Settled are the starts of the settled days in Unix seconds.
+	Settled []int64 `json:"settled_days,omitempty"`
+}
+
+// errCacheMismatch is returned for cache files with prices other than those
+// of the running configuration.
+var errCacheMismatch = errors.New("cache file doesn't match the configuration")
+
+// save writes the cached slots to path together with the upstream
+// validators, the settled days and, if they aren't all of upstreamProvider,
+// the sources of the slots. The file is replaced atomically, so that an
+// interrupted save leaves the previous file intact.
+func (s *store) save(path string, now time.Time, validators []savedValidator) error {
+	// The slots are only ever replaced, not modified.
+	s.mu.RLock()
+	slots := s.slots
+	s.mu.RUnlock()
+	points := make([]pricePoint, len(slots))
+	for i := range slots {
+		points[i] = slotPoint(slots, i)
+	}
+	f := cacheFile{
+		Version:    cacheFileVersion,
+		Zone:       biddingZone,
+		Provider:   upstreamProvider,
+		Unit:       defaultUnit,
+		SavedAt:    now.UTC(),
+		Timestamps: make([]int64, len(points)),
+		Prices:     make([]float64, len(points)),
+		Validators: validators,
+		Settled:    s.settledDays(),
+	}
+	for i, p := range points {
+		f.Timestamps[i], f.Prices[i] = p.Start.Unix(), p.Price
+	}
+	if slices.ContainsFunc(slots, func(slot storedSlot) bool { return slot.Source != upstreamProvider }) {
+		f.Sources = make([]string, len(slots))
+		for i, slot := range slots {
+			f.Sources[i] = slot.Source
+		}
+	}
+	if len(points) > 0 {
+		f.Resolution = int(resolution(points).Minutes())
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error saving cache: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, so that an interrupted write
+// leaves the previous file intact.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCache reads the cache saved to path. A missing file is reported as
+// os.ErrNotExist. Files of the first version are migrated, and files whose
+// zone, provider or unit differ from the running configuration are refused
+// with errCacheMismatch unless force is set.
+func loadCache(path string, force bool) (cacheFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cacheFile{}, err
+	}
+	var f cacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return cacheFile{}, fmt.Errorf("error parsing cache file %s: %w", path, err)
+	}
+
+	switch {
+	case f.Version == 0:
+		log.Printf("migrating cache file %s from version 1 to %d", path, cacheFileVersion)
+		f.Zone, f.Provider, f.Unit = biddingZone, upstreamProvider, defaultUnit
+	case f.Version > cacheFileVersion:
+		return cacheFile{}, fmt.Errorf("cache file %s has version %d, newer than the supported %d", path, f.Version, cacheFileVersion)
+	}
+	if f.Zone != biddingZone || f.Provider != upstreamProvider || f.Unit != defaultUnit {
+		err := fmt.Errorf(
+			"%w: %s has prices of %s from %s in %s, expected %s from %s in %s",
+			errCacheMismatch, path, f.Zone, f.Provider, f.Unit, biddingZone, upstreamProvider, defaultUnit,
+		)
+		if !force {
+			return cacheFile{}, err
+		}
+		log.Printf("warning: importing anyway: %v", err)
+	}
+	if len(f.Timestamps) != len(f.Prices) {
+		return cacheFile{}, errors.New("cache file has unequal numbers of timestamps and prices")
+	}
+	if f.Sources != nil && len(f.Sources) != len(f.Timestamps) {
+		return cacheFile{}, errors.New("cache file has unequal numbers of timestamps and sources")
+	}
+	return f, nil
+}
+
+// prices returns the saved slots by source. Slots without a saved source
+// are of the provider of the file.
+func (f cacheFile) prices() map[string]map[time.Time]float64 {
+	bySource := make(map[string]map[time.Time]float64)
+	for i, t := range f.Timestamps {
+		source := f.Provider
+		if f.Sources != nil && f.Sources[i] != "" {
+			source = f.Sources[i]
+		}
+		if bySource[source] == nil {
+			bySource[source] = make(map[time.Time]float64)
+		}
+		bySource[source][time.Unix(t, 0)] = f.Prices[i]
+	}
+	return bySource
+}
+
+// settledDays returns the starts of the saved settled days.
+func (f cacheFile) settledDays() []time.Time {
+	days := make([]time.Time, len(f.Settled))
+	for i, d := range f.Settled {
+		days[i] = time.Unix(d, 0)
+	}
+	return days
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// loadStore loads the cache file at path into a new store like run does.
+func loadStore(t *testing.T, path string, force bool) (*store, cacheFile, error) {
+	t.Helper()
+	saved, err := loadCache(path, force)
+	if err != nil {
+		return nil, saved, err
+	}
+	st := newStore()
+	for source, prices := range saved.prices() {
+		st.merge(prices, originCacheFile, source)
+	}
+	st.settle(saved.settledDays()...)
+	return st, saved, nil
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	st := newStore()
+	st.merge(testPrices(), originRefresh, upstreamProvider)
+	// Slots of another source, one of them shadowed by the upstream's.
+	st.merge(map[time.Time]float64{
+		testFirstDay.AddDate(0, 0, testDays):                1.5,
+		testFirstDay.AddDate(0, 0, testDays).Add(time.Hour): -2.25,
+		testFirstDay: 7,
+	}, originSync, sourcePrimary)
+	st.settle(testFirstDay)
+	validators := []savedValidator{{Zone: biddingZone, Start: 1, End: 2, ETag: `"x"`}}
+	if err := st.save(path, testNow, validators); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, saved, err := loadStore(t, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != cacheFileVersion || saved.Zone != biddingZone || saved.Provider != upstreamProvider || saved.Unit != defaultUnit || saved.Resolution != 60 {
+		t.Errorf("metadata %d %s %s %s %d", saved.Version, saved.Zone, saved.Provider, saved.Unit, saved.Resolution)
+	}
+	if !saved.SavedAt.Equal(testNow) || !slices.Equal(saved.Validators, validators) {
+		t.Errorf("saved at %s with validators %v", saved.SavedAt, saved.Validators)
+	}
+	if !slices.Equal(loaded.settledDays(), st.settledDays()) {
+		t.Errorf("settled days %v, want %v", loaded.settledDays(), st.settledDays())
+	}
+	wantSlots(t, loaded, st)
+
+	// Saving the loaded cache again writes the same prices, and merging
+	// them into the original changes nothing.
+	again := filepath.Join(t.TempDir(), "again.json")
+	if err := loaded.save(again, testNow, validators); err != nil {
+		t.Fatal(err)
+	}
+	resaved, err := loadCache(again, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resaved.Timestamps, saved.Timestamps) || !slices.Equal(resaved.Prices, saved.Prices) || !slices.Equal(resaved.Sources, saved.Sources) {
+		t.Error("the loaded cache saves differently")
+	}
+	for source, prices := range resaved.prices() {
+		if res := st.merge(prices, originCacheFile, source); res.changed() {
+			t.Errorf("merging the saved %s prices changed the cache: %+v", source, res)
+		}
+	}
+}
+
+// wantSlots fails t unless got has the prices and sources of want.
+func wantSlots(t *testing.T, got, want *store) {
+	t.Helper()
+	if len(got.slots) != len(want.slots) {
+		t.Fatalf("%d slots, want %d", len(got.slots), len(want.slots))
+	}
+	for i, w := range want.slots {
+		g := got.slots[i]
+		if !g.Start.Equal(w.Start) || g.Price != w.Price || g.Source != w.Source {
+			t.Errorf("slot %d at %s priced %v from %s, want %s priced %v from %s", i, g.Start, g.Price, g.Source, w.Start, w.Price, w.Source)
+		}
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		force bool
+		err   error
+		slots int
+	}{
+		{"version 1", `{"unix_seconds":[1768172400,1768176000],"price":[1,2]}`, false, nil, 2},
+		{"current", `{"version":2,"zone":"DE-LU","provider":"energy-charts","unit":"EUR/MWh","unix_seconds":[1768172400],"price":[1]}`, false, nil, 1},
+		{"other zone", `{"version":2,"zone":"AT","provider":"energy-charts","unit":"EUR/MWh","unix_seconds":[1768172400],"price":[1]}`, false, errCacheMismatch, 0},
+		{"other zone forced", `{"version":2,"zone":"AT","provider":"energy-charts","unit":"EUR/MWh","unix_seconds":[1768172400],"price":[1]}`, true, nil, 1},
+		{"other provider", `{"version":2,"zone":"DE-LU","provider":"entsoe","unit":"EUR/MWh","unix_seconds":[],"price":[]}`, false, errCacheMismatch, 0},
+		{"other unit", `{"version":2,"zone":"DE-LU","provider":"energy-charts","unit":"ct/kWh","unix_seconds":[],"price":[]}`, false, errCacheMismatch, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			st, _, err := loadStore(t, path, tt.force)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if err == nil && len(st.slots) != tt.slots {
+				t.Errorf("%d slots, want %d", len(st.slots), tt.slots)
+			}
+		})
+	}
+
+	invalid := map[string]string{
+		"newer":    `{"version":3,"zone":"DE-LU","provider":"energy-charts","unit":"EUR/MWh"}`,
+		"unequal":  `{"unix_seconds":[1768172400,1768176000],"price":[1]}`,
+		"sources":  `{"unix_seconds":[1768172400],"price":[1],"sources":["a","b"]}`,
+		"not json": `{"unix_seconds":`,
+	}
+	for name, file := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.json")
+			if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadCache(path, true); err == nil {
+				t.Error("loaded even with force")
+			}
+		})
+	}
+
+	if _, err := loadCache(filepath.Join(t.TempDir(), "missing.json"), false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v for a missing file, want os.ErrNotExist", err)
+	}
+}
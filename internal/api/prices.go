@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"time"
+)
+
+// textTimeLayout is the slot time format of plain-text responses. Minute
+// precision with the UTC offset keeps lines short but unambiguous across DST
+// changes.
+const textTimeLayout = "2006-01-02T15:04Z07:00"
+
+// priceParams are the parameters of handlePrices, for GET /price and the
+// listing answered by GET / to clients other than browsers.
+var priceParams = []string{"start", "end", "at", "detail", "fields", "format", "locale", "unit", "currency", "v", "since_generation", "cursor", "cursor_every"}
+
+// handlePrices lists the cached slots in [start, end), sorted by time.
+// Explicit ranges are limited to the configured maximum; a request without
+// start and end yields the whole cache. Ranges before the cached data are
+// fetched on demand. With detail, JSON slots include their duration and
+// revision, fields selects the slot fields explicitly, and v selects the
+// response schema version. With since_generation, only the slots changed
+// since that generation are listed, see changedSince, and with cursor, only
+// those after it, see listingCursor. The streaming formats end with the
+// cursor to resume them from as a trailer, and with cursor_every, NDJSON
+// listings also carry it in-band every that many slots, see cursorRecord. With at, only the slot
+// containing that instant is returned. Prices are in the unit and currency
+// selected by parseUnit in every format.
+func (s *server) handlePrices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if q.Has("at") {
+		format, ok := negotiateFormat(w, r, formatJSON, formatText)
+		if !ok {
+			return
+		}
+		s.handlePriceAt(w, q.Get("at"), format, div, q.Has("start") || q.Has("end"))
+		return
+	}
+
+	format, ok := negotiateFormat(w, r, formatJSON, formatText, formatCSV, formatNDJSON)
+	if !ok {
+		return
+	}
+	detail, err := parseBool(q, "detail")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	fields := defaultSlotFields
+	if detail {
+		fields = slotFields
+	}
+	if fields, err = parseFields(q, fields); err != nil {
+		badRequest(w, err)
+		return
+	}
+	if q.Has("fields") && format != formatJSON && format != formatNDJSON {
+		badRequest(w, errors.New("fields: only supported for JSON and NDJSON listings"))
+		return
+	}
+	locale, err := parseCSVLocale(q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if q.Has("locale") && format != formatCSV {
+		badRequest(w, errors.New("locale: only supported for CSV listings"))
+		return
+	}
+	cursorEvery, err := parseInt(q, "cursor_every", 0, 1, maxCursorEvery)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if q.Has("cursor_every") && format != formatNDJSON {
+		badRequest(w, errors.New("cursor_every: only supported for NDJSON listings"))
+		return
+	}
+	version, err := parseVersion(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	// The default full listing is prebuilt, see fullDump.
+	if len(q) == 0 && format == formatJSON && version == 1 && s.dump.serve(w, r, s.store) {
+		return
+	}
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	if (!start.IsZero() || !end.IsZero()) && !s.checkRange(w, start, end) {
+		return
+	}
+	if start, ok = s.resume(w, q, start); !ok {
+		return
+	}
+	changed, all, ok := s.parseSinceGeneration(w, q)
+	if !ok {
+		return
+	}
+
+	// The streaming formats write the slots as they are scanned, so that
+	// exporting the whole history doesn't copy it.
+	var slots iter.Seq[cachedSlot]
+	if all {
+		if slots, ok = s.rangeSlots(w, r, start, end); !ok {
+			return
+		}
+	} else {
+		// Slots fetched on demand without merging them aren't of any
+		// generation, so differential listings are of the cache only.
+		slots = s.store.scanChanged(start, end, changed)
+	}
+	if div != 1 {
+		slots = convertedSlots(slots, div)
+	}
+	if format != formatJSON {
+		slots = s.trailCursor(w, slots)
+	}
+
+	switch format {
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for c := range slots {
+			fmt.Fprintf(bw, "%s %s\n", c.Start.In(s.loc).Format(textTimeLayout), formatFloat(c.Price))
+		}
+		bw.Flush()
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8; header=present")
+		w.Header().Set("Content-Language", locale.name)
+		cw := csv.NewWriter(w)
+		cw.Comma = locale.comma
+		cw.Write([]string{"time", "price"})
+		for c := range slots {
+			cw.Write([]string{c.Start.In(s.loc).Format(time.RFC3339), locale.formatFloat(c.Price)})
+		}
+		cw.Flush()
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		generation := s.store.meta().Generation
+		n := 0
+		for c := range slots {
+			enc.Encode(fieldSlot{fields, c})
+			if n++; cursorEvery > 0 && n%cursorEvery == 0 {
+				enc.Encode(cursorRecord{listingCursor{generation, c.Start}.String()})
+				// The cursor is of no use to the client until it arrives.
+				bw.Flush()
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+			}
+		}
+		bw.Flush()
+	default:
+		rendered := []any{}
+		for c := range slots {
+			rendered = append(rendered, fieldSlot{fields, c})
+		}
+		listingSerializers[version](w, listing{Unit: unit, Start: start, End: end, Slots: rendered})
+	}
+}
+
+// handlePriceAt answers a single-instant lookup of handlePrices, using the
+// same flooring to slot boundaries as handleLookup, with the price divided
+// by div.
+func (s *server) handlePriceAt(w http.ResponseWriter, v, format string, div float64, withRange bool) {
+	if withRange {
+		badRequest(w, errors.New("at: cannot be combined with start or end"))
+		return
+	}
+	t, err := parseInstant(v)
+	if err != nil {
+		badRequest(w, fmt.Errorf("at: %w", err))
+		return
+	}
+
+	p, ok := s.store.at(t)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("no cached slot contains %s", t.UTC().Format(time.RFC3339)))
+		return
+	}
+
+	if format == formatText {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s %s\n", p.Start.In(s.loc).Format(textTimeLayout), formatFloat(p.Price/div))
+		return
+	}
+	writeJSON(w, priced(p, div))
+}
+
+// convertedSlots yields slots with all their prices divided by div, the
+// divisor returned by parseUnit.
+func convertedSlots(slots iter.Seq[cachedSlot], div float64) iter.Seq[cachedSlot] {
+	return func(yield func(cachedSlot) bool) {
+		for c := range slots {
+			c.Price /= div
+			c.PreviousPrice /= div
+			c.ShadowedPrice /= div
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// checkRange answers with 400 and returns false if [start, end) exceeds the
+// configured maximum range. Open bounds extend to the edges of the cache.
+func (s *server) checkRange(w http.ResponseWriter, start, end time.Time) bool {
+	if s.cfg.maxRange <= 0 {
+		return true
+	}
+
+	m := s.store.meta()
+	if start.IsZero() {
+		start = m.Earliest
+	}
+	if end.IsZero() {
+		end = m.Through
+	}
+	if end.Sub(start) > s.cfg.maxRange {
+		writeError(w, http.StatusBadRequest, codeInvalidRange, fmt.Sprintf(
+			"range exceeds the maximum of %s, split it into several requests or use an aggregate endpoint",
+			formatDuration(s.cfg.maxRange),
+		))
+		return false
+	}
+	return true
+}
+
+// handleCurrent returns the price of the slot containing the current time.
+// The plain-text format is just the number followed by a newline.
+func (s *server) handleCurrent(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, ok := negotiateFormat(w, r, formatJSON, formatText)
+	if !ok {
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	cur, ok := s.store.at(s.clock.Now())
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "no price for the current slot")
+		return
+	}
+
+	if format == formatText {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, formatFloat(cur.Price/div))
+		return
+	}
+	writeJSON(w, struct {
+		Time  int64   `json:"time"`
+		Price float64 `json:"price"`
+		Unit  string  `json:"unit"`
+	}{cur.Start.Unix(), cur.Price / div, unit})
+}
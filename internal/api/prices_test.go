@@ -0,0 +1,103 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header []string
+	}{
+		{"price_day.txt", "/price?start=2026-01-13&end=2026-01-14&format=txt", nil},
+		{"price_day.txt", "/price?start=2026-01-13&end=2026-01-14", []string{"Accept", "text/plain"}},
+		{"price_fractional.txt", "/price?start=2026-01-14T10:00:00Z&end=2026-01-14T15:00:00Z&format=txt", nil},
+		{"price_empty.txt", "/price?start=2026-01-14T10:00:00Z&end=2026-01-14T15:00:00Z&since_generation=2&format=txt", nil},
+		{"price_at.txt", "/price?at=2026-01-13T14:59:59Z&format=txt", nil},
+		{"current.txt", "/price/current?format=txt", nil},
+		{"current_ct.txt", "/price/current?format=txt&unit=ct/kWh", nil},
+	}
+	s := newTestServer(t)
+	s.store.merge(map[time.Time]float64{
+		time.Date(2026, time.January, 14, 11, 0, 0, 0, time.UTC): -3.25,
+		time.Date(2026, time.January, 14, 12, 0, 0, 0, time.UTC): 0.01,
+		time.Date(2026, time.January, 14, 13, 0, 0, 0, time.UTC): 1234.5,
+	}, originRefresh, upstreamProvider)
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			res := get(t, s, tt.target, tt.header...)
+			wantStatus(t, res, http.StatusOK)
+			if ct := res.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("Content-Type %q", ct)
+			}
+			golden(t, tt.name, readBody(t, res))
+		})
+	}
+}
+
+// TestPricesUnit lists prices in another unit and currency in every format
+// and checks them against the listing in EUR/MWh.
+func TestPricesUnit(t *testing.T) {
+	const listing = "/price?start=2026-01-13T10:00:00Z&end=2026-01-13T13:00:00Z"
+	s := newTestServer(t, "-fx", "SEK=11.5@2026-01-12")
+	// 11.5 SEK per EUR, and 10 ct per EUR/MWh in a kWh.
+	const div = 10 / 11.5
+
+	base := decode[[]struct {
+		Time  int64   `json:"time"`
+		Price float64 `json:"price"`
+	}](t, get(t, s, listing))
+	if len(base) != 3 {
+		t.Fatalf("%d slots, want 3", len(base))
+	}
+	var text, csv, ndjson strings.Builder
+	csv.WriteString("time,price\n")
+	for _, p := range base {
+		at := time.Unix(p.Time, 0).In(testLoc)
+		fmt.Fprintf(&text, "%s %s\n", at.Format(textTimeLayout), formatFloat(p.Price/div))
+		fmt.Fprintf(&csv, "%s,%s\n", at.Format(time.RFC3339), formatFloat(p.Price/div))
+		fmt.Fprintf(&ndjson, "{\"time\":%d,\"price\":%s}\n", p.Time, formatFloat(p.Price/div))
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"text", listing + "&format=txt", text.String()},
+		{"csv", listing + "&format=csv", csv.String()},
+		{"ndjson", listing + "&format=ndjson", ndjson.String()},
+		{"json", listing, "[" + strings.Join(strings.Split(strings.TrimSpace(ndjson.String()), "\n"), ",") + "]"},
+		{"at", "/price?at=2026-01-13T10:30:00Z", strings.Split(ndjson.String(), "\n")[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := get(t, s, tt.target+"&unit=ct/kWh&currency=SEK")
+			wantStatus(t, res, http.StatusOK)
+			if got := res.Header.Get("X-FX-Rate"); got != "11.5" {
+				t.Errorf("X-FX-Rate %q, want 11.5", got)
+			}
+			if got := readBody(t, res); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	v2 := decode[struct {
+		Unit  string `json:"unit"`
+		Slots []struct {
+			Price float64 `json:"price"`
+		} `json:"slots"`
+	}](t, get(t, s, listing+"&v=2&unit=ct/kWh&currency=SEK"))
+	if v2.Unit != "SEK ct/kWh" || len(v2.Slots) != 3 || v2.Slots[0].Price != base[0].Price/div {
+		t.Errorf("v2 listing in %s with %v", v2.Unit, v2.Slots)
+	}
+
+	wantError(t, get(t, s, listing+"&unit=kWh"), http.StatusBadRequest, codeInvalidParameter, `unknown unit "kWh"`)
+	wantError(t, get(t, s, listing+"&currency=XXX"), http.StatusBadRequest, codeInvalidParameter, `unknown currency "XXX"`)
+}
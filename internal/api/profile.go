@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// localBuckets groups the prices of points into n buckets by the local time
+// in loc, as chosen by index. Points are split into steps of the given width
+// first: a point longer than width contributes its price once per step, so
+// hourly data can be combined with quarter-hourly data.
+func localBuckets(points []pricePoint, width time.Duration, loc *time.Location, n int, index func(t time.Time) int) [][]float64 {
+	buckets := make([][]float64, n)
+	for _, p := range points {
+		for offset := time.Duration(0); offset < p.Duration; offset += width {
+			i := index(p.Start.Add(offset).In(loc))
+			buckets[i] = append(buckets[i], p.Price)
+		}
+	}
+	return buckets
+}
+
+// timeOfDayBuckets groups the prices of points by local time of day in loc,
+// using buckets of the given width.
+func timeOfDayBuckets(points []pricePoint, width time.Duration, loc *time.Location) [][]float64 {
+	return localBuckets(points, width, loc, int(24*time.Hour/width), func(t time.Time) int {
+		minute := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		return int(minute / width)
+	})
+}
+
+// resolution returns the finest slot duration among points.
+func resolution(points []pricePoint) time.Duration {
+	res := maxSlotDuration
+	for _, p := range points {
+		res = min(res, p.Duration)
+	}
+	return res
+}
+
+type profileBucket struct {
+	Time   string   `json:"time"`
+	Count  int      `json:"count"`
+	Mean   *float64 `json:"mean"`
+	Median *float64 `json:"median"`
+	StdDev *float64 `json:"stddev"`
+}
+
+// handleProfile reports price statistics per local time of day. Buckets are
+// hourly, or quarter-hourly if the range contains quarter-hourly data.
+func (s *server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseRange(r.URL.Query(), s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	points := s.store.points(start, end)
+	width := resolution(points)
+
+	buckets := timeOfDayBuckets(points, width, s.loc)
+	profile := make([]profileBucket, len(buckets))
+	for i, prices := range buckets {
+		offset := time.Duration(i) * width
+		b := profileBucket{
+			Time:  fmt.Sprintf("%02d:%02d", int(offset.Hours()), int(offset.Minutes())%60),
+			Count: len(prices),
+		}
+		if len(prices) > 0 {
+			m, sd := mean(prices), stddev(prices)
+			med := median(prices)
+			b.Mean, b.Median, b.StdDev = &m, &med, &sd
+		}
+		profile[i] = b
+	}
+
+	writeJSON(w, struct {
+		Resolution int             `json:"resolution_minutes"`
+		Buckets    []profileBucket `json:"buckets"`
+	}{int(width.Minutes()), profile})
+}
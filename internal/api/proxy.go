@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the networks whose X-Forwarded-For and X-Real-IP
+// headers are believed. It implements flag.Value as a comma-separated list
+// of CIDR prefixes or addresses.
+type trustedProxies []netip.Prefix
+
+func (t *trustedProxies) String() string {
+	s := make([]string, len(*t))
+	for i, p := range *t {
+		s[i] = p.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (t *trustedProxies) Set(v string) error {
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			addr, err := netip.ParseAddr(f)
+			if err != nil {
+				return fmt.Errorf("invalid proxy address %q", f)
+			}
+			*t = append(*t, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(f)
+		if err != nil {
+			return fmt.Errorf("invalid proxy network %q", f)
+		}
+		*t = append(*t, p.Masked())
+	}
+	return nil
+}
+
+func (t trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range t {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP determines the address of the client behind any trusted proxies.
+// X-Forwarded-For is read from the right, as only the entries appended by
+// trusted proxies can be relied upon; the first untrusted hop is the client.
+// Headers sent by untrusted peers are ignored so that they can't be spoofed.
+func (t trustedProxies) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	peer = peer.Unmap()
+	if !t.contains(peer) {
+		return peer
+	}
+
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		client := peer
+		entries := strings.Split(strings.Join(hops, ","), ",")
+		for i := len(entries) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap()
+			if !t.contains(client) {
+				break
+			}
+		}
+		return client
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap()
+	}
+	return peer
+}
+
+type clientIPKey struct{}
+
+// withClientIP resolves the client address once per request, so that
+// everything handling the request agrees on it.
+func (t trustedProxies) withClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, t.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP returns the client address resolved by withClientIP.
+func clientIP(ctx context.Context) netip.Addr {
+	addr, _ := ctx.Value(clientIPKey{}).(netip.Addr)
+	return addr
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPublications is how many days of publication times are kept.
+const maxPublications = 30
+
+// publication is when the refresher first found the prices of a day cached.
+// It is at most a refresh interval after the upstream published them.
+type publication struct {
+	// Date is the Europe/Berlin day the prices are of.
+	Date       string    `json:"date"`
+	ObservedAt time.Time `json:"observed_at"`
+	// Seconds is the time from the local midnight before ObservedAt.
+	Seconds float64 `json:"seconds_after_midnight"`
+}
+
+// publicationLog keeps the publication times of the last days, observed
+// since the start of the process.
+type publicationLog struct {
+	mu   sync.Mutex
+	list []publication
+}
+
+// observe records that the prices of the day after now in loc were found
+// cached at now, unless that day has been recorded already.
+func (l *publicationLog) observe(now time.Time, loc *time.Location) {
+	y, m, d := now.In(loc).Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	p := publication{
+		Date:       midnight.AddDate(0, 0, 1).Format(time.DateOnly),
+		ObservedAt: now.UTC(),
+		Seconds:    now.Sub(midnight).Seconds(),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := len(l.list); n > 0 && l.list[n-1].Date == p.Date {
+		return
+	}
+	l.list = append(l.list, p)
+	if len(l.list) > maxPublications {
+		l.list = l.list[len(l.list)-maxPublications:]
+	}
+}
+
+// get returns the kept publication times, newest first.
+func (l *publicationLog) get() []publication {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]publication, len(l.list))
+	for i, p := range l.list {
+		list[len(list)-1-i] = p
+	}
+	return list
+}
+
+// latest returns the newest publication time.
+func (l *publicationLog) latest() (publication, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.list) == 0 {
+		return publication{}, false
+	}
+	return l.list[len(l.list)-1], true
+}
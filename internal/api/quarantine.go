@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minPlausiblePrice and maxPlausiblePrice are the harmonised minimum and
+// maximum clearing prices of the day-ahead market coupling in EUR/MWh.
+const (
+	minPlausiblePrice = -500
+	maxPlausiblePrice = 4000
+)
+
+// maxQuarantineListed is how many quarantined slots the report lists.
+const maxQuarantineListed = 32
+
+// Reasons of quarantinedSlot.
+const (
+	reasonNotFinite   = "not_finite"
+	reasonOutOfBounds = "out_of_bounds"
+	reasonMisaligned  = "misaligned"
+	reasonImplausible = "implausible_time"
+)
+
+// implausibility returns why a cached slot can't be a real price at now, or
+// "" if it can. Slots are checked like the prices of upstream responses,
+// and additionally for prices beyond the market's limits and starts off the
+// grid of the finest resolution.
+func implausibility(slot storedSlot, now time.Time) string {
+	switch {
+	case math.IsNaN(slot.Price) || math.IsInf(slot.Price, 0):
+		return reasonNotFinite
+	case slot.Start.Before(earliestPlausible) || slot.Start.After(now.Add(maxPublishedAhead)):
+		return reasonImplausible
+	case slot.Start.Unix()%int64(finestResolution.Seconds()) != 0:
+		return reasonMisaligned
+	case slot.Price < minPlausiblePrice || slot.Price > maxPlausiblePrice:
+		return reasonOutOfBounds
+	}
+	return ""
+}
+
+// quarantinedSlot is a cached slot removed for being implausible.
+type quarantinedSlot struct {
+	Time   time.Time `json:"time"`
+	Price  float64   `json:"price"`
+	Origin string    `json:"origin"`
+	Reason string    `json:"reason"`
+}
+
+// MarshalJSON renders prices that JSON can't represent as null.
+func (q quarantinedSlot) MarshalJSON() ([]byte, error) {
+	var price *float64
+	if !math.IsNaN(q.Price) && !math.IsInf(q.Price, 0) {
+		price = &q.Price
+	}
+	return json.Marshal(struct {
+		Time   time.Time `json:"time"`
+		Price  *float64  `json:"price"`
+		Origin string    `json:"origin"`
+		Reason string    `json:"reason"`
+	}{q.Time, price, q.Origin, q.Reason})
+}
+
+// quarantine removes the slots found implausible by check and returns them.
+// The ranges are left to the caller to invalidate, as not all of them can be
+// fetched again.
+func (s *store) quarantine(check func(slot storedSlot) string) []quarantinedSlot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bad []quarantinedSlot
+	kept := make([]storedSlot, 0, len(s.slots))
+	for _, slot := range s.slots {
+		if reason := check(slot); reason != "" {
+			bad = append(bad, quarantinedSlot{slot.Start.UTC(), slot.Price, slot.Origin, reason})
+			s.unsettle(slot.Start, slot.Start.Add(time.Second))
+			continue
+		}
+		kept = append(kept, slot)
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+
+	s.previous = s.frozen()
+	s.slots = kept
+	s.earliest, s.latest = time.Time{}, time.Time{}
+	if len(s.slots) > 0 {
+		s.earliest, s.latest = s.slots[0].Start, s.slots[len(s.slots)-1].Start
+	}
+	s.gaps = findGaps(s.slots)
+	s.sources = countSources(s.slots)
+	s.changed()
+	s.changelog = nil
+	return bad
+}
+
+// quarantineReport describes the implausible slots found in the cache at
+// startup and their repair, for /price/meta.
+type quarantineReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Count     int       `json:"count"`
+	// Slots are the first maxQuarantineListed quarantined slots.
+	Slots []quarantinedSlot `json:"slots"`
+	// Refetch are the days fetched again in place of the slots, as RFC 3339
+	// intervals. Slots at implausible times have no day to fetch.
+	Refetch []string `json:"refetch"`
+	// RepairedAt is set once the days were fetched, even if some failed;
+	// those are retried with the following refreshes.
+	RepairedAt *time.Time `json:"repaired_at"`
+	Failed     int        `json:"failed_ranges"`
+}
+
+// quarantineLog holds the report of the startup check, if it found any
+// implausible slots.
+type quarantineLog struct {
+	mu     sync.Mutex
+	report *quarantineReport
+}
+
+func (l *quarantineLog) get() *quarantineReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.report == nil {
+		return nil
+	}
+	r := *l.report
+	return &r
+}
+
+func (l *quarantineLog) set(r *quarantineReport) {
+	l.mu.Lock()
+	l.report = r
+	l.mu.Unlock()
+}
+
+func (l *quarantineLog) update(fn func(r *quarantineReport)) {
+	l.mu.Lock()
+	fn(l.report)
+	l.mu.Unlock()
+}
+
+// checkCache quarantines the implausible slots of the cache, e.g. left by an
+// older version in the cache file, so that they are never served, and
+// invalidates the Europe/Berlin days they are in for repairQuarantined. It
+// reports whether any slots were quarantined.
+func (s *server) checkCache() bool {
+	now := s.clock.Now()
+	bad := s.store.quarantine(func(slot storedSlot) string { return implausibility(slot, now) })
+	if len(bad) == 0 {
+		return false
+	}
+
+	var days []timeRange
+	var intervals []string
+	reasons := make(map[string]int)
+	for _, q := range bad {
+		reasons[q.Reason]++
+		if q.Reason == reasonImplausible {
+			continue
+		}
+		y, m, d := q.Time.In(s.loc).Date()
+		day := timeRange{time.Date(y, m, d, 0, 0, 0, 0, s.loc), time.Date(y, m, d+1, 0, 0, 0, 0, s.loc)}
+		if !slices.Contains(days, day) {
+			days = append(days, day)
+			intervals = append(intervals, day.Start.UTC().Format(time.RFC3339)+"/"+day.End.UTC().Format(time.RFC3339))
+		}
+	}
+	s.store.invalidate(days...)
+	log.Printf("warning: quarantined %d implausible cached slots %v, fetching %d days again", len(bad), reasons, len(days))
+
+	s.quarantine.set(&quarantineReport{
+		CheckedAt: now.UTC(),
+		Count:     len(bad),
+		Slots:     bad[:min(len(bad), maxQuarantineListed)],
+		Refetch:   intervals,
+	})
+	return true
+}
+
+// repairQuarantined fetches the days invalidated by checkCache without
+// waiting for the next refresh.
+func (r *refresher) repairQuarantined(ctx context.Context) {
+	r.refetchInvalidated(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	// The ranges that failed are invalidated again, for the next refresh.
+	failed := r.server.store.takeInvalidated()
+	r.server.store.invalidate(failed...)
+	now := r.server.clock.Now().UTC()
+	r.server.quarantine.update(func(rep *quarantineReport) { rep.RepairedAt, rep.Failed = &now, len(failed) })
+	log.Printf("repaired quarantined slots, %d of the days failed", len(failed))
+}
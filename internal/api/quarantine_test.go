@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestQuarantine loads a cache file with corrupt slots, which checkCache
+// quarantines and repairQuarantined fetches again from the upstream.
+func TestQuarantine(t *testing.T) {
+	outOfBounds := testFirstDay.Add(10 * time.Hour)
+	misaligned := testFirstDay.AddDate(0, 0, 1).Add(10*time.Hour + 7*time.Minute)
+	implausible := time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+	corrupt := testPrices()
+	corrupt[outOfBounds.UTC()] = 99999
+	corrupt[misaligned.UTC()] = 1
+	corrupt[implausible] = 1
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	st := newStore()
+	st.merge(corrupt, originRefresh, upstreamProvider)
+	if err := st.save(path, testNow, nil); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err := loadStore(t, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t)
+	s.upstream.backoff = 0
+	s.store = loaded
+	if !s.checkCache() {
+		t.Fatal("nothing quarantined")
+	}
+	rep := s.quarantine.get()
+	want := []quarantinedSlot{
+		{implausible, 1, originCacheFile, reasonImplausible},
+		{outOfBounds.UTC(), 99999, originCacheFile, reasonOutOfBounds},
+		{misaligned.UTC(), 1, originCacheFile, reasonMisaligned},
+	}
+	if rep.Count != len(want) || len(rep.Slots) != len(want) {
+		t.Fatalf("quarantined %d slots %v, want %v", rep.Count, rep.Slots, want)
+	}
+	for i, q := range rep.Slots {
+		if !q.Time.Equal(want[i].Time) || q.Price != want[i].Price || q.Origin != want[i].Origin || q.Reason != want[i].Reason {
+			t.Errorf("quarantined %+v, want %+v", q, want[i])
+		}
+	}
+	// The slot at an implausible time has no day to fetch again.
+	wantRefetch := []string{"2026-01-11T23:00:00Z/2026-01-12T23:00:00Z", "2026-01-12T23:00:00Z/2026-01-13T23:00:00Z"}
+	if len(rep.Refetch) != len(wantRefetch) || rep.Refetch[0] != wantRefetch[0] || rep.Refetch[1] != wantRefetch[1] {
+		t.Errorf("refetching %v, want %v", rep.Refetch, wantRefetch)
+	}
+	if _, ok := s.store.at(outOfBounds); ok {
+		t.Error("quarantined slot still cached")
+	}
+
+	r := newRefresher(s.upstream, s, s.metrics)
+	r.repairQuarantined(context.Background())
+	fresh := newStore()
+	fresh.merge(testPrices(), originRefresh, upstreamProvider)
+	wantSlots(t, s.store, fresh)
+	rep = s.quarantine.get()
+	if rep.RepairedAt == nil || !rep.RepairedAt.Equal(testNow) || rep.Failed != 0 {
+		t.Errorf("repaired at %v with %d failed ranges, want at %s with none", rep.RepairedAt, rep.Failed, testNow)
+	}
+
+	meta := decode[struct {
+		Quarantine *quarantineReport `json:"quarantine"`
+	}](t, get(t, s, "/price/meta"))
+	if meta.Quarantine == nil || meta.Quarantine.Count != len(want) || meta.Quarantine.RepairedAt == nil {
+		t.Errorf("/price/meta quarantine %+v", meta.Quarantine)
+	}
+	wantStatus(t, get(t, s, "/price?at="+outOfBounds.UTC().Format(time.RFC3339)), http.StatusOK)
+}
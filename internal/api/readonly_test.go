@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// computingPosts are the documented POST operations that only compute an
+// answer from the cache, so they are served in read-only mode. Any other
+// operation but GET mutates.
+var computingPosts = map[string]string{
+	"/price/lookup": `["2026-01-13T10:00:00Z"]`,
+	"/price/cost":   `[{"start":"2026-01-13T10:00:00Z","end":"2026-01-13T11:00:00Z","kwh":1}]`,
+}
+
+// TestReadOnly sweeps every operation of the OpenAPI document that isn't a
+// plain read, and all admin operations, on a read-only server with and
+// without the admin token. The admin operations answer 403 and the
+// computing ones are served, and the cache is left unchanged.
+func TestReadOnly(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]*server{
+		"no token": newTestServer(t, "-read-only"),
+		"token":    newTestServer(t, append(adminTokenArgs(t), "-read-only")...),
+	}
+	for name, s := range tokens {
+		t.Run(name, func(t *testing.T) {
+			before := s.store.meta()
+			swept := 0
+			for _, path := range slices.Sorted(maps.Keys(doc.Paths)) {
+				for method := range doc.Paths[path] {
+					method = strings.ToUpper(method)
+					admin := strings.HasPrefix(path, "/admin/")
+					body, computing := computingPosts[path]
+					if method == "PARAMETERS" || method == http.MethodGet && !admin {
+						continue
+					}
+					if method != http.MethodGet && !admin && !computing {
+						t.Errorf("%s %s isn't known to be read-only or admin, classify it in computingPosts", method, path)
+						continue
+					}
+					swept++
+					target := path + "?start=2026-01-13T00:00:00Z&end=2026-01-14T00:00:00Z"
+					if computing {
+						target = path
+					}
+					res := serve(t, s, method, target, body, "Authorization", "Bearer "+testAdminToken)
+					if admin {
+						wantError(t, res, http.StatusForbidden, codeForbidden, "read-only")
+					} else {
+						wantStatus(t, res, http.StatusOK)
+					}
+				}
+			}
+			if swept == 0 {
+				t.Fatal("no operations swept")
+			}
+
+			after := s.store.meta()
+			if after.Generation != before.Generation || after.Slots != before.Slots {
+				t.Errorf("cache changed from generation %d with %d slots to %d with %d", before.Generation, before.Slots, after.Generation, after.Slots)
+			}
+		})
+	}
+}
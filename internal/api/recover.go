@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// panicRestartDelay is how long a background task waits before restarting
+// after a panic, so that a task panicking right away doesn't spin.
+const panicRestartDelay = 10 * time.Second
+
+// panicDegradedFor is how long a background panic marks the service as
+// degraded in the health check.
+const panicDegradedFor = time.Hour
+
+// backgroundPanic is the last panic of a background task.
+type backgroundPanic struct {
+	Task  string    `json:"task"`
+	At    time.Time `json:"at"`
+	Value string    `json:"value"`
+}
+
+// panicLog records the last panic of each background task.
+type panicLog struct {
+	mu   sync.Mutex
+	last map[string]backgroundPanic
+}
+
+func (l *panicLog) record(p backgroundPanic) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == nil {
+		l.last = make(map[string]backgroundPanic)
+	}
+	l.last[p.Task] = p
+}
+
+// since returns the last panic of every task that panicked after t.
+func (l *panicLog) since(t time.Time) []backgroundPanic {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var recent []backgroundPanic
+	for _, p := range l.last {
+		if p.At.After(t) {
+			recent = append(recent, p)
+		}
+	}
+	slices.SortFunc(recent, func(a, b backgroundPanic) int { return strings.Compare(a.Task, b.Task) })
+	return recent
+}
+
+// recoverPanics answers requests whose handler panics with a 500 instead of
+// letting the panic reach the connection, and logs the stack trace together
+// with the request ID.
+func (s *server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			s.panics.inc("http")
+			log.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, w.Header().Get(requestIDHeader), v, debug.Stack())
+			if rec.status == 0 {
+				writeError(w, http.StatusInternalServerError, codeInternal, "internal error")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// supervise runs fn until it returns or ctx is done, restarting it after a
+// delay whenever it panics. Panics are logged, counted and reported by the
+// health check.
+func (s *server) supervise(ctx context.Context, task string, fn func(ctx context.Context)) {
+	for {
+		if !s.runRecovered(ctx, task, fn) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(panicRestartDelay):
+		}
+		log.Printf("restarting %s after panic", task)
+	}
+}
+
+// runRecovered runs fn and reports whether it panicked.
+func (s *server) runRecovered(ctx context.Context, task string, fn func(ctx context.Context)) (panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicked = true
+			s.panics.inc(task)
+			s.backgroundPanics.record(backgroundPanic{Task: task, At: s.clock.Now().UTC(), Value: fmt.Sprint(v)})
+			log.Printf("panic in %s: %v\n%s", task, v, debug.Stack())
+		}
+	}()
+	fn(ctx)
+	return false
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// refreshAhead is how far into the future fetches reach, so that the next
+// day's prices are picked up as soon as they are published.
+const refreshAhead = 48 * time.Hour
+
+// refreshBehind is how far into the past refreshes reach, so that late
+// corrections of recent slots are picked up.
+const refreshBehind = 7 * time.Hour
+
+// historyStart is the earliest day fetched into an empty cache.
+var historyStart = time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+// refresher periodically fetches recent and upcoming prices into the store.
+type refresher struct {
+	upstream *upstream
+	// primary is the instance to sync from instead of the upstream, if any.
+	primary  *primary
+	server   *server
+	interval time.Duration
+
+	refreshes *counterVec
+
+	consecutiveFailures *gauge
+	mergedSlots         *counterVec
+	lastRevisions       *gauge
+}
+
+func newRefresher(u *upstream, srv *server, reg *registry) *refresher {
+	r := &refresher{
+		upstream: u,
+		server:   srv,
+		interval: srv.cfg.refreshInterval,
+		consecutiveFailures: &gauge{
+			name: "energy_prices_refresh_consecutive_failures",
+			help: "Number of refreshes that failed since the last successful one.",
+		},
+		refreshes: &counterVec{
+			name:   "energy_prices_refreshes_total",
+			help:   "Refreshes by outcome.",
+			labels: []string{"outcome"},
+		},
+		mergedSlots: &counterVec{
+			name:   "energy_prices_refresh_slots_total",
+			help:   "Fetched slots by whether they were added, updated or unchanged in the cache.",
+			labels: []string{"result"},
+		},
+		lastRevisions: &gauge{
+			name: "energy_prices_refresh_last_revisions",
+			help: "Number of cached slots whose price was changed by the last successful refresh.",
+		},
+	}
+	reg.register(r.refreshes)
+	reg.register(r.consecutiveFailures)
+	reg.register(r.mergedSlots)
+	reg.register(r.lastRevisions)
+	if srv.cfg.syncFrom != "" {
+		r.primary = newPrimary(srv.cfg)
+	}
+	return r
+}
+
+// minRefreshBackoff is the delay before retrying the first failed refresh.
+// It doubles with every further failure, up to the refresh interval.
+const minRefreshBackoff = time.Minute
+
+// refreshStatus is the state of the refresh schedule, shared between the
+// refresher and the handlers reporting it.
+type refreshStatus struct {
+	mu    sync.Mutex
+	state refreshState
+}
+
+type refreshState struct {
+	// Backoff is the current retry delay, zero unless the last refresh failed.
+	Backoff             time.Duration `json:"-"`
+	BackoffSeconds      float64       `json:"backoff_seconds"`
+	NextAttempt         time.Time     `json:"next_attempt"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	LastError           string        `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time    `json:"last_error_at,omitempty"`
+	// Source is where the last successful refresh got its prices from.
+	Source string `json:"source,omitempty"`
+}
+
+func (s *refreshStatus) get() refreshState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *refreshStatus) update(fn func(st *refreshState)) refreshState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+	s.state.BackoffSeconds = s.state.Backoff.Seconds()
+	return s.state
+}
+
+// run refreshes the store every interval until ctx is done, retrying failed
+// refreshes with exponential backoff. If the systemd watchdog is enabled, the
+// loop also keeps it fed, so that systemd restarts the service if the loop
+// gets stuck.
+func (r *refresher) run(ctx context.Context) {
+	next := r.schedule(r.interval)
+
+	var watchdog <-chan time.Time
+	if d := watchdogInterval(); d > 0 {
+		t := r.server.clock.NewTicker(d / 2)
+		defer t.Stop()
+		watchdog = t.C()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-watchdog:
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("error notifying watchdog: %v", err)
+			}
+		case <-next:
+			next = r.schedule(r.completed(ctx, r.refresh(ctx)))
+		}
+	}
+}
+
+// schedule records and starts the delay until the next refresh.
+func (r *refresher) schedule(delay time.Duration) <-chan time.Time {
+	now := r.server.clock.Now()
+	r.server.refreshStatus.update(func(st *refreshState) { st.NextAttempt = now.Add(delay).UTC() })
+	return r.server.clock.After(delay)
+}
+
+// completed updates the refresh status after a refresh that returned err and
+// returns the delay until the next one. Permanent errors aren't retried
+// before the next regular refresh.
+func (r *refresher) completed(ctx context.Context, err error) time.Duration {
+	if ctx.Err() != nil {
+		return r.interval
+	}
+	now := r.server.clock.Now()
+	prev := r.server.refreshStatus.get()
+	st := r.server.refreshStatus.update(func(st *refreshState) {
+		if err == nil {
+			st.Backoff, st.ConsecutiveFailures = 0, 0
+			return
+		}
+		st.Backoff = min(max(2*st.Backoff, minRefreshBackoff), r.interval)
+		if isPermanent(err) {
+			// Asking again soon won't change the upstream's answer.
+			st.Backoff = r.interval
+		}
+		st.ConsecutiveFailures++
+		at := now.UTC()
+		st.LastError, st.LastErrorAt = err.Error(), &at
+	})
+	r.consecutiveFailures.set(float64(st.ConsecutiveFailures))
+
+	switch {
+	case err != nil && prev.ConsecutiveFailures == 0:
+		log.Printf("warning: refresh failed, backing off for %s: %v", st.Backoff, err)
+	case err != nil:
+		log.Printf("warning: refresh failed %d times in a row, backing off for %s: %v", st.ConsecutiveFailures, st.Backoff, err)
+	case prev.ConsecutiveFailures > 0:
+		log.Printf("refresh recovered after %d failures", prev.ConsecutiveFailures)
+	}
+
+	if err != nil {
+		return st.Backoff
+	}
+	return r.interval
+}
+
+func (r *refresher) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.server.cfg.refreshTimeout)
+	defer cancel()
+
+	now := r.server.clock.Now()
+	start, end := refreshWindow(now, r.server.loc)
+	if r.server.cfg.refreshDryRun {
+		r.dryRun(start, end)
+		r.refreshes.inc("dry_run")
+		return nil
+	}
+	rec := refreshRecord{StartedAt: now.UTC(), Start: start.UTC(), End: end.UTC()}
+	prices, origin, err := r.fetch(ctx, start, end, true)
+	rec.DurationSeconds = r.server.clock.Now().Sub(now).Seconds()
+	if errors.Is(err, errNotModified) {
+		r.refreshes.inc("not_modified")
+		r.server.store.touch()
+		rec.Source = sourceUpstream
+		r.server.refreshHistory.add(rec)
+		log.Print("refreshed prices: not modified since the last refresh")
+		r.refetchInvalidated(ctx)
+		return nil
+	}
+	if err != nil {
+		r.refreshes.inc("failure")
+		rec.Error = err.Error()
+		r.server.refreshHistory.add(rec)
+		return err
+	}
+	r.refreshes.inc("success")
+
+	wasAvailable := r.server.tomorrowAvailable()
+	res := r.server.store.merge(prices, origin, upstreamProvider)
+	r.mergedSlots.add(float64(res.Added), "added")
+	r.mergedSlots.add(float64(res.Updated), "updated")
+	r.mergedSlots.add(float64(res.Unchanged), "unchanged")
+	r.lastRevisions.set(float64(res.Updated))
+	rec.Source, rec.Added, rec.Updated = r.server.refreshStatus.get().Source, res.Added, res.Updated
+	r.server.refreshHistory.add(rec)
+	log.Printf("refreshed prices: %d added, %d updated, %d unchanged", res.Added, res.Updated, res.Unchanged)
+
+	r.refetchInvalidated(ctx)
+	if res.changed() {
+		r.server.refreshed(wasAvailable, res)
+	}
+	return nil
+}
+
+// dryRun logs what fetch would request for [start, end) without calling it:
+// the window, the slots expected in it at the current resolution of the
+// cache, and where they would be fetched from.
+func (r *refresher) dryRun(start, end time.Time) {
+	_, res := r.server.store.coverage()
+	source := upstreamProvider
+	if r.primary != nil {
+		source = r.server.cfg.syncFrom + ", falling back to " + upstreamProvider
+	}
+	log.Printf(
+		"dry run: would fetch %s to %s for %s from %s, %d slots at %s resolution",
+		start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), biddingZone, source, int(end.Sub(start)/res), res,
+	)
+}
+
+// refetchInvalidated fetches the ranges removed from the store since the
+// last refresh. Ranges that fail are retried with the next refresh.
+func (r *refresher) refetchInvalidated(ctx context.Context) {
+	for _, tr := range r.server.store.takeInvalidated() {
+		prices, origin, err := r.fetch(ctx, tr.Start, tr.End, false)
+		if err != nil {
+			log.Printf("error fetching removed range %s to %s: %v", tr.Start.UTC().Format(time.RFC3339), tr.End.UTC().Format(time.RFC3339), err)
+			r.server.store.invalidate(tr)
+			continue
+		}
+		res := r.server.store.merge(prices, origin, upstreamProvider)
+		log.Printf("fetched removed range %s to %s: %d added", tr.Start.UTC().Format(time.RFC3339), tr.End.UTC().Format(time.RFC3339), res.Added)
+	}
+}
+
+// refreshWindow returns the window fetched by a refresh at now. It reaches
+// refreshBehind into the past and refreshAhead into the future, widened to
+// whole days in loc, so that the refreshes of a day request the same window
+// and can be conditional on the last response for it.
+func refreshWindow(now time.Time, loc *time.Location) (start, end time.Time) {
+	y, m, d := now.Add(-refreshBehind).In(loc).Date()
+	start = time.Date(y, m, d, 0, 0, 0, 0, loc)
+	y, m, d = now.Add(refreshAhead).In(loc).Date()
+	end = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	return start, end
+}
+
+// fetch retrieves the prices between start and end from the primary if one
+// is configured, falling back to the upstream if the primary fails. It
+// returns the origin to merge the prices with and records their source in the
+// refresh status. If conditional is set, the upstream is asked with
+// fetchIfModified and errNotModified can be returned.
+func (r *refresher) fetch(ctx context.Context, start, end time.Time, conditional bool) (map[time.Time]float64, string, error) {
+	source, origin := sourceUpstream, originRefresh
+	var prices map[time.Time]float64
+	var err error
+	if r.primary != nil {
+		if prices, err = r.primary.fetch(ctx, start, end); err == nil {
+			source, origin = sourcePrimary, originSync
+		} else if ctx.Err() == nil {
+			log.Printf("warning: error syncing from %s, falling back to the upstream: %v", r.primary.base, err)
+		}
+	}
+	if prices == nil {
+		fetch := r.upstream.fetch
+		if conditional {
+			fetch = r.upstream.fetchIfModified
+		}
+		if prices, err = fetch(ctx, start, end); err != nil && !errors.Is(err, errNotModified) {
+			return nil, "", err
+		}
+	}
+	r.server.refreshStatus.update(func(st *refreshState) { st.Source = source })
+	return prices, origin, err
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRefresher returns a refresher of a server at testNow on a fake
+// clock, with an upstream serving the mock unless status is set to another
+// code to answer with.
+func newTestRefresher(t *testing.T, args ...string) (*refresher, *fakeClock, *atomic.Int32) {
+	t.Helper()
+	status := &atomic.Int32{}
+	status.Store(http.StatusOK)
+	mock := newTestUpstream(t)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := int(status.Load()); code != http.StatusOK {
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(up.Close)
+
+	s := newTestServer(t, append([]string{"-upstream-url", up.URL}, args...)...)
+	// Retries wait on the fake clock, so they mustn't wait at all.
+	s.upstream.backoff = 0
+	return newRefresher(s.upstream, s, s.metrics), s.clock.(*fakeClock), status
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	r, _, _ := newTestRefresher(t, "-refresh-interval", "6h")
+	transient := &statusError{code: http.StatusServiceUnavailable}
+	permanent := permanentError{&statusError{code: http.StatusBadRequest}}
+
+	// Failures double the backoff from a minute up to the interval, and a
+	// permanent one jumps to it.
+	steps := []struct {
+		err      error
+		delay    time.Duration
+		failures int
+	}{
+		{transient, time.Minute, 1},
+		{transient, 2 * time.Minute, 2},
+		{transient, 4 * time.Minute, 3},
+		{nil, 6 * time.Hour, 0},
+		{transient, time.Minute, 1},
+		{permanent, 6 * time.Hour, 2},
+		{transient, 6 * time.Hour, 3},
+		{nil, 6 * time.Hour, 0},
+	}
+	for i, step := range steps {
+		if delay := r.completed(context.Background(), step.err); delay != step.delay {
+			t.Errorf("step %d: delay %s, want %s", i, delay, step.delay)
+		}
+		if st := r.server.refreshStatus.get(); st.ConsecutiveFailures != step.failures {
+			t.Errorf("step %d: %d consecutive failures, want %d", i, st.ConsecutiveFailures, step.failures)
+		}
+	}
+
+	// The backoff is capped at the interval: 256 minutes is the last
+	// doubling below six hours.
+	r.completed(context.Background(), nil)
+	var delay time.Duration
+	for range 9 {
+		delay = r.completed(context.Background(), transient)
+	}
+	if delay != 256*time.Minute {
+		t.Errorf("delay after 9 failures %s, want 256m", delay)
+	}
+	if delay = r.completed(context.Background(), transient); delay != 6*time.Hour {
+		t.Errorf("delay after 10 failures %s, want 6h", delay)
+	}
+
+	// Refreshes canceled by shutdown aren't failures.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if delay := r.completed(ctx, context.Canceled); delay != 6*time.Hour {
+		t.Errorf("delay after cancellation %s, want 6h", delay)
+	}
+	if st := r.server.refreshStatus.get(); st.ConsecutiveFailures != 10 {
+		t.Errorf("%d consecutive failures after cancellation, want 10", st.ConsecutiveFailures)
+	}
+}
+
+// TestRefresherSchedule runs the refresher on a fake clock through an
+// outage of the upstream and its recovery.
+func TestRefresherSchedule(t *testing.T) {
+	r, c, status := newTestRefresher(t, "-refresh-interval", "1h")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// advance moves the clock to the next attempt and waits until the one
+	// after it is scheduled, returning the refresh status then.
+	advance := func() refreshState {
+		t.Helper()
+		c.WaitTimers(t, 1)
+		st := r.server.refreshStatus.get()
+		c.Set(st.NextAttempt)
+		deadline := time.Now().Add(5 * time.Second)
+		for r.server.refreshStatus.get().NextAttempt.Equal(st.NextAttempt) {
+			if time.Now().After(deadline) {
+				t.Fatal("no refresh attempt scheduled after the previous one")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		c.WaitTimers(t, 1)
+		return r.server.refreshStatus.get()
+	}
+	wantNext := func(st refreshState, d time.Duration, failures int) {
+		t.Helper()
+		if want := c.Now().Add(d); !st.NextAttempt.Equal(want) || st.ConsecutiveFailures != failures {
+			t.Errorf("next attempt %s after %d failures, want %s after %d", st.NextAttempt, st.ConsecutiveFailures, want, failures)
+		}
+	}
+
+	// Tomorrow's prices are published at the first refresh.
+	r.server.store.remove(testFirstDay.AddDate(0, 0, 2), testFirstDay.AddDate(0, 0, 3))
+	r.server.store.takeInvalidated()
+	if r.server.tomorrowAvailable() {
+		t.Fatal("tomorrow available before the refresh")
+	}
+	wantNext(advance(), time.Hour, 0)
+	if !r.server.tomorrowAvailable() {
+		t.Error("tomorrow unavailable after the refresh")
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	wantNext(advance(), time.Minute, 1)
+	wantNext(advance(), 2*time.Minute, 2)
+	st := advance()
+	wantNext(st, 4*time.Minute, 3)
+	if st.LastErrorAt == nil || !st.LastErrorAt.Equal(c.Now()) {
+		t.Errorf("last error at %v, want %s", st.LastErrorAt, c.Now())
+	}
+
+	// The prices haven't changed during the outage.
+	status.Store(http.StatusOK)
+	wantNext(advance(), time.Hour, 0)
+	wantMetrics(t, r.server.metrics, map[string]float64{
+		`energy_prices_refreshes_total{outcome="success"}`:      1,
+		`energy_prices_refreshes_total{outcome="not_modified"}`: 1,
+		`energy_prices_refreshes_total{outcome="failure"}`:      3,
+		`energy_prices_refresh_consecutive_failures`:            0,
+	})
+
+	// A rejected request isn't retried before the next regular refresh.
+	status.Store(http.StatusBadRequest)
+	wantNext(advance(), time.Hour, 1)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// dayChange is the data of day_changed events.
+type dayChange struct {
+	// Date is the Europe/Berlin day that has begun.
+	Date              string `json:"date"`
+	TomorrowAvailable bool   `json:"tomorrow_available"`
+}
+
+// watchDays publishes a day_changed event whenever the current time enters
+// another Europe/Berlin day, until ctx is done, so that clients showing
+// today's and tomorrow's prices know when to refetch them. Responses that
+// are kept, the memoized aggregates and the full listing, are of absolute
+// ranges and so don't change with the day.
+//
+// Midnight is computed in the market location for every day, so DST
+// transitions shift it with the wall clock. Like watchSlots, the date is
+// looked up from the wall clock at least every slotCheckInterval, and after
+// a suspend across one or more midnights a single event announces the day
+// the clock is in by then.
+func (s *server) watchDays(ctx context.Context) {
+	var current string
+	for first := true; ; first = false {
+		now := s.clock.Now().In(s.loc)
+		y, m, d := now.Date()
+		if date := now.Format(time.DateOnly); date != current {
+			if !first {
+				s.events.publish(event{Name: "day_changed", Data: dayChange{date, s.tomorrowAvailable()}})
+			}
+			current = date
+		}
+
+		wait := min(slotCheckInterval, time.Date(y, m, d+1, 0, 0, 0, 0, s.loc).Sub(now))
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(wait):
+		}
+	}
+}
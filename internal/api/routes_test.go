@@ -0,0 +1,247 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// jsonShape describes the structure of a decoded JSON value: objects by
+// their sorted keys and the shapes of their values, arrays by the shape of
+// their first element, and other values by their type.
+func jsonShape(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		fields := make([]string, 0, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			fields = append(fields, k+":"+jsonShape(v[k]))
+		}
+		return "{" + strings.Join(fields, ",") + "}"
+	case []any:
+		if len(v) == 0 {
+			return "[]"
+		}
+		return "[" + jsonShape(v[0]) + "]"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// TestEndpoints requests every endpoint of the test server, checking the
+// status, content type and headers of the response and the shape of its
+// JSON body.
+func TestEndpoints(t *testing.T) {
+	const (
+		dataHeaders = "Link X-Cache-Generation X-Data-Through X-Last-Refresh"
+		listing     = dataHeaders + " Last-Modified X-Range-Complete"
+		errorShape  = "{error:{code:string,message:string,request_id:string}}"
+	)
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		accept      string
+		status      int
+		contentType string
+		// headers are the names of headers the response must have.
+		headers string
+		// shape is the jsonShape of a JSON body, empty for bodies that
+		// aren't checked.
+		shape string
+	}{
+		{"prices", http.MethodGet, "/price?start=2026-01-13T10:00:00Z&end=2026-01-13T12:00:00Z", "", "", http.StatusOK, "application/json", listing, `[{price:number,time:number}]`},
+		{"prices detail", http.MethodGet, "/price?start=2026-01-13T10:00:00Z&end=2026-01-13T12:00:00Z&detail=true", "", "", http.StatusOK, "application/json", listing, `[{duration_minutes:number,previous_price:null,price:number,revised:bool,source:string,time:number}]`},
+		{"prices v2", http.MethodGet, "/price?start=2026-01-13T10:00:00Z&end=2026-01-13T12:00:00Z&v=2", "", "", http.StatusOK, "application/vnd.energy-prices.v2+json", listing, `{count:number,end:string,license:{attribution_url:string,license:string,license_url:string,source:string},slots:[{price:number,time:number}],start:string,unit:string,version:number}`},
+		{"prices at", http.MethodGet, "/price?at=2026-01-13T10:30:00Z", "", "", http.StatusOK, "application/json", dataHeaders, `{price:number,time:number}`},
+		{"prices at uncached", http.MethodGet, "/price?at=2020-01-13T10:30:00Z", "", "", http.StatusNotFound, "application/json", dataHeaders, errorShape},
+		{"prices csv", http.MethodGet, "/price?start=2026-01-13&end=2026-01-14&format=csv", "", "", http.StatusOK, "text/csv; charset=utf-8; header=present", listing + " Content-Language", ""},
+		{"prices ndjson", http.MethodGet, "/price?start=2026-01-13&end=2026-01-14&format=ndjson", "", "", http.StatusOK, "application/x-ndjson", listing, ""},
+		{"prices invalid", http.MethodGet, "/price?start=yesterday", "", "", http.StatusBadRequest, "application/json", dataHeaders, errorShape},
+		{"index", http.MethodGet, "/", "", "", http.StatusOK, "application/json", dataHeaders, `[{price:number,time:number}]`},
+		{"index html", http.MethodGet, "/", "", "text/html", http.StatusOK, "text/html; charset=utf-8", dataHeaders, ""},
+		{"profile", http.MethodGet, "/price/profile?start=2026-01-12&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, ""},
+		{"weekday profile", http.MethodGet, "/price/weekday-profile?start=2026-01-12&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, `{days:[{count:number,max:number,mean:number,median:number,min:number,name:string,weekday:number}],unit:string,weekend:{count:number,max:null,mean:null,median:null,min:null},weekend_discount:null,workday:{count:number,max:number,mean:number,median:number,min:number}}`},
+		{"spread", http.MethodGet, "/price/spread?start=2026-01-13&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, `{days:[{date:string,effective_spread:number,max:number,max_time:number,min:number,min_time:number,spread:number}],efficiency:number,unit:string}`},
+		{"blocks", http.MethodGet, "/price/blocks?start=2026-01-13&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, `{days:[{base:number,date:string,off_peak:number,peak:number}],peak_hours:{end_hour:number,start_hour:number,weekends:bool},unit:string}`},
+		{"bands", http.MethodGet, "/price/bands?window=7d", "", "", http.StatusOK, "application/json", dataHeaders + " X-Range-Start X-Range-End", `{bands:[{count:number,hour:number,p10:number,p25:number,p50:number,p75:number,p90:number}],end:string,start:string,unit:string,window:string}`},
+		{"histogram", http.MethodGet, "/price/histogram?start=2026-01-13&end=2026-01-14&bucket=50", "", "", http.StatusOK, "application/json", listing, `{buckets:[{count:number,from:null,to:number}],unit:string}`},
+		{"compare", http.MethodGet, "/price/compare?a_start=2026-01-12&a_end=2026-01-13&b_start=2026-01-13&b_end=2026-01-14", "", "", http.StatusOK, "application/json", dataHeaders + " Last-Modified", `{a:{end:string,hours_negative:number,max:number,mean:number,median:number,min:number,slots:number,start:string},b:{end:string,hours_negative:number,max:number,mean:number,median:number,min:number,slots:number,start:string},delta:{hours_negative:{absolute:number,percent:null},max:{absolute:number,percent:number},mean:{absolute:number,percent:number},median:{absolute:number,percent:number},min:{absolute:number,percent:null}},length_mismatch:bool,unit:string,warnings:null}`},
+		{"average", http.MethodGet, "/price/average?start=2026-01-13&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, `{average:number,complete:bool,covered_seconds:number,end:string,start:string,unit:string}`},
+		{"checksum", http.MethodGet, "/price/checksum?start=2026-01-13&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, `{algorithm:string,checksum:string,count:number,end:string,generation:number,start:string}`},
+		{"matrix", http.MethodGet, "/price/matrix?start=2026-01-13&end=2026-01-14", "", "", http.StatusOK, "application/json", listing, `{end:string,orientation:string,resolution_minutes:number,rows:[{date:string,hours:number,prices:[number]}],start:string,unit:string}`},
+		{"current", http.MethodGet, "/price/current", "", "", http.StatusOK, "application/json", dataHeaders, `{price:number,time:number,unit:string}`},
+		{"lookup", http.MethodPost, "/price/lookup", `["2026-01-13T10:00:00Z","2020-01-01T00:00:00Z"]`, "", http.StatusOK, "application/json", dataHeaders, `[{price:number,time:number}]`},
+		{"lookup invalid", http.MethodPost, "/price/lookup", `{}`, "", http.StatusBadRequest, "application/json", dataHeaders, errorShape},
+		{"cost", http.MethodPost, "/price/cost", `[{"start":"2026-01-13T10:00:00Z","end":"2026-01-13T11:00:00Z","kwh":2}]`, "", http.StatusOK, "application/json", dataHeaders, `{currency:string,entries:[{average_price:number,cost:number,end:string,kwh:number,start:string}],total:number,uncosted:number}`},
+		{"chart", http.MethodGet, "/price/chart.svg", "", "", http.StatusOK, "image/svg+xml", dataHeaders, ""},
+		{"meta", http.MethodGet, "/price/meta", "", "", http.StatusOK, "application/json", dataHeaders, `{earliest:string,generation:number,last_refresh:string,latest:string,license:{attribution_url:string,license:string,license_url:string,source:string},refresh:{backoff_seconds:number,consecutive_failures:number,next_attempt:string},slots:number,sources:{energy-charts:number},stale:bool,tomorrow_available:bool,tomorrow_published:[]}`},
+		{"coverage", http.MethodGet, "/price/coverage", "", "", http.StatusOK, "application/json", dataHeaders, `{dataset_epoch:string,earliest:string,gaps:[],history_start:string,latest:string,resolution_minutes:number,through:string,tomorrow_available:bool,zone:string}`},
+		{"next", http.MethodGet, "/price/next?below=120", "", "", http.StatusOK, "application/json", dataHeaders, `{found:bool,hours_away:number,price:number,time:number,unit:string}`},
+		{"context", http.MethodGet, "/price/context", "", "", http.StatusOK, "application/json", dataHeaders, `{percentile:number,price:number,samples:number,time:number,trailing_mean:number,trailing_median:number,unit:string,window:string}`},
+		{"extremes", http.MethodGet, "/price/extremes", "", "", http.StatusOK, "application/json", dataHeaders, `{slots:[{is_max:bool,is_min:bool,price:number,time:number}],unit:string,window:string}`},
+		{"signal", http.MethodGet, "/price/signal?strategy=below_daily_median", "", "", http.StatusOK, "application/json", dataHeaders, `{consume:bool,until:string}`},
+		{"tomorrow", http.MethodGet, "/price/tomorrow", "", "", http.StatusOK, "application/json", dataHeaders, `{date:string,slots:[{price:number,time:number}],unit:string}`},
+		{"openapi", http.MethodGet, "/openapi.json", "", "", http.StatusOK, "application/json", "", ""},
+		{"health", http.MethodGet, "/healthz", "", "", http.StatusOK, "application/json", "", `{earliest:string,generation:number,last_refresh:string,latest:string,license:{attribution_url:string,license:string,license_url:string,source:string},refresh:{backoff_seconds:number,consecutive_failures:number,next_attempt:string},slots:number,sources:{energy-charts:number},stale:bool,status:string,tomorrow_available:bool,tomorrow_published:[]}`},
+		{"metrics", http.MethodGet, "/metrics", "", "", http.StatusOK, "text/plain; version=0.0.4", "", ""},
+		{"admin cache", http.MethodGet, "/admin/cache?start=2026-01-13T10:00:00Z&end=2026-01-13T11:00:00Z", "", "", http.StatusOK, "application/json", "", `[{duration_minutes:number,merged_at:string,origin:string,previous_price:null,price:number,revised:bool,shadowed_price:null,shadowed_source:null,source:string,time:number}]`},
+		{"admin refreshes", http.MethodGet, "/admin/refreshes", "", "", http.StatusOK, "application/json", "", `[]`},
+		{"admin diff", http.MethodGet, "/admin/diff", "", "", http.StatusNotFound, "application/json", "", errorShape},
+		{"admin delete", http.MethodDelete, "/admin/cache?start=2026-01-14T10:00:00Z&end=2026-01-14T11:00:00Z", "", "", http.StatusOK, "application/json", "", `{removed:number}`},
+		{"unknown route", http.MethodGet, "/prices", "", "", http.StatusNotFound, "application/json", "", errorShape},
+		{"wrong method", http.MethodPut, "/price", "", "", http.StatusMethodNotAllowed, "application/json", "Allow", errorShape},
+	}
+	s := newTestServer(t, adminTokenArgs(t)...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serve(t, s, tt.method, tt.target, tt.body, "Accept", tt.accept, "Authorization", "Bearer "+testAdminToken)
+			body := readBody(t, res)
+			if res.StatusCode != tt.status {
+				t.Errorf("status %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type %q, want %q", ct, tt.contentType)
+			}
+			for _, name := range strings.Fields(tt.headers + " X-Request-Id") {
+				if res.Header.Get(name) == "" {
+					t.Errorf("no %s header", name)
+				}
+			}
+			if tt.shape == "" {
+				return
+			}
+			var v any
+			if err := json.Unmarshal([]byte(body), &v); err != nil {
+				t.Fatalf("invalid JSON body: %v", err)
+			}
+			if got := jsonShape(v); got != tt.shape {
+				t.Errorf("body shape\n%s\nwant\n%s", got, tt.shape)
+			}
+		})
+	}
+}
+
+// TestRequestLimits checks the structured errors for request bodies beyond
+// the limit of their route and for request targets beyond -max-uri.
+func TestRequestLimits(t *testing.T) {
+	s := newTestServer(t, "-max-uri", "100")
+	// A JSON array padded beyond the limit, so that it is only refused once
+	// the limit is read.
+	padded := "[" + strings.Repeat(" ", 1<<20) + "]"
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   errorCode
+		msg    string
+	}{
+		{"lookup body", http.MethodPost, "/price/lookup", padded, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body exceeds 1048576 bytes"},
+		{"cost body", http.MethodPost, "/price/cost", padded, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body exceeds 1048576 bytes"},
+		{"long target", http.MethodGet, "/price?start=2026-01-13&end=2026-01-14&strict=false&x=" + strings.Repeat("a", 50), "", http.StatusRequestURITooLong, codeURITooLong, "request target exceeds 100 bytes"},
+		{"long target of an unknown route", http.MethodGet, "/" + strings.Repeat("a", 100), "", http.StatusRequestURITooLong, codeURITooLong, "request target exceeds 100 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantError(t, serve(t, s, tt.method, tt.target, tt.body), tt.status, tt.code, tt.msg)
+		})
+	}
+
+	// Targets of exactly the limit are served.
+	target := "/price?start=2026-01-13&end=2026-01-14&strict=false&x="
+	wantStatus(t, get(t, s, target+strings.Repeat("a", 100-len(target))), http.StatusOK)
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"/", "", true},
+		{"//", "", true},
+		{"/energy", "/energy", true},
+		{"energy", "/energy", true},
+		{"energy/", "/energy", true},
+		{"/energy//", "/energy", true},
+		{"/energy/prices/", "/energy/prices", true},
+		{"/energy?x", "", false},
+		{"/{energy}", "", false},
+		{"/en ergy", "", false},
+	} {
+		got, err := normalizeBasePath(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("normalizeBasePath(%q) = %q, %v, want %q and ok %t", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestBasePath serves with base paths given with and without slashes, and
+// without one, and checks that all routes and the links to them are below
+// it and nothing is served unprefixed.
+func TestBasePath(t *testing.T) {
+	for _, tt := range []struct {
+		flag, base string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"/energy", "/energy"},
+		{"energy/", "/energy"},
+		{"/energy//", "/energy"},
+	} {
+		t.Run(fmt.Sprintf("%q", tt.flag), func(t *testing.T) {
+			s := newTestServer(t, "-base-path", tt.flag)
+			for _, path := range []string{"/price/current", "/price/meta", "/healthz", "/metrics", "/openapi.json", "/"} {
+				wantStatus(t, get(t, s, tt.base+path), http.StatusOK)
+				if tt.base != "" {
+					wantStatus(t, get(t, s, path), http.StatusNotFound)
+				}
+			}
+			// The mux redirects to the index with a trailing slash.
+			if tt.base != "" {
+				res := get(t, s, tt.base)
+				if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") != tt.base+"/" {
+					t.Errorf("GET %s: %s to %q, want a redirect to %s/", tt.base, res.Status, res.Header.Get("Location"), tt.base)
+				}
+			}
+
+			var doc struct {
+				Servers []struct {
+					URL string `json:"url"`
+				} `json:"servers"`
+			}
+			if err := json.Unmarshal([]byte(readBody(t, get(t, s, tt.base+"/openapi.json"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.base
+			if want == "" {
+				want = "/"
+			}
+			if len(doc.Servers) != 1 || doc.Servers[0].URL != want {
+				t.Errorf("servers %+v, want the URL %s", doc.Servers, want)
+			}
+
+			index := readBody(t, get(t, s, tt.base+"/", "Accept", "text/html"))
+			if link := `href="` + tt.base + `/price/current"`; !strings.Contains(index, link) || strings.Contains(index, `href="`+tt.base+"//") {
+				t.Errorf("index doesn't link to %s:\n%s", link, index)
+			}
+		})
+	}
+
+	if _, err := parseConfig([]string{"-base-path", "/energy?x"}); err == nil {
+		t.Error("parsed the base path /energy?x")
+	}
+}
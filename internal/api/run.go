@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// Main runs the service, or the check subcommand, with the command-line
+// arguments args until ctx is done, and returns the exit status.
+func Main(ctx context.Context, args []string, stdout io.Writer) int {
+	if len(args) > 0 && args[0] == "check" {
+		return runCheck(ctx, args[1:], stdout)
+	}
+
+	cfg, err := parseConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if cfg.printConfig {
+		if err := cfg.writeSettings(stdout); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := run(ctx, cfg); !errors.Is(err, context.Canceled) && !errors.Is(err, errUpgraded) {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// run serves until ctx is done, then shuts down gracefully.
+func run(ctx context.Context, cfg config) (err error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	loc, err := loadMarketLocation()
+	if err != nil {
+		return err
+	}
+
+	reg := &registry{}
+	if cfg.memoryLimit > 0 {
+		debug.SetMemoryLimit(cfg.memoryLimit)
+	}
+	st := newStore()
+	up := newUpstream(cfg, reg)
+	srv := newServer(cfg, st, up, loc, reg)
+
+	started := srv.clock.Now()
+	fetchStart := historyStart
+	var quarantined bool
+	if cfg.cacheFile != "" {
+		saved, err := loadCache(cfg.cacheFile, cfg.forceImport)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			for source, prices := range saved.prices() {
+				st.merge(prices, originCacheFile, source)
+			}
+			up.restoreValidators(saved.Validators)
+			st.settle(saved.settledDays()...)
+			quarantined = srv.checkCache()
+			if latest := st.meta().Latest; !latest.IsZero() {
+				fetchStart = latest
+				if started.Before(latest) {
+					fetchStart = started
+				}
+				fetchStart = fetchStart.Add(-refreshBehind)
+			}
+			log.Printf(
+				"loaded %d slots saved %s ago, catching up on %s",
+				len(saved.Timestamps), started.Sub(saved.SavedAt).Round(time.Second), started.Add(refreshAhead).Sub(fetchStart).Round(time.Hour),
+			)
+		}
+	}
+
+	ref := newRefresher(up, srv, reg)
+	var counters string
+	if cfg.persistCounters {
+		counters = countersPath(cfg.cacheFile)
+		restoreCounters(reg, counters)
+	}
+	// In read-only mode, the cache and counters are loaded but never saved.
+	saveFile := cfg.cacheFile
+	if cfg.readOnly {
+		saveFile = ""
+	}
+	if cfg.refreshDryRun {
+		ref.dryRun(fetchStart, started.Add(refreshAhead))
+	} else {
+		prices, origin, err := ref.fetch(ctx, fetchStart, started.Add(refreshAhead), false)
+		if err != nil {
+			return fmt.Errorf("error fetching prices: %w", err)
+		}
+		st.merge(prices, origin, upstreamProvider)
+	}
+	if quarantined {
+		go srv.supervise(ctx, "cache_repair", ref.repairQuarantined)
+	}
+	go srv.supervise(ctx, "slot_watcher", srv.watchSlots)
+	go srv.supervise(ctx, "day_watcher", srv.watchDays)
+	go srv.supervise(ctx, "stale_alert", srv.watchStaleness)
+
+	// The refresher is stopped explicitly during shutdown, after requests
+	// have been drained and before the cache is saved.
+	refreshCtx, stopRefresh := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopRefresh()
+	refreshDone := make(chan struct{})
+	go func() {
+		defer close(refreshDone)
+		srv.supervise(refreshCtx, "refresher", ref.run)
+	}()
+
+	// Sockets passed by an upgrading process or by systemd replace the
+	// configured listeners.
+	addrs, lns, upgraded, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	if lns == nil {
+		addrs = cfg.listeners
+		ln, err := activationListener()
+		if err != nil {
+			return err
+		}
+		if ln != nil {
+			addrs, lns = listenAddrs{{routes: routesAll, addr: ln.Addr().String()}}, []net.Listener{ln}
+		} else if lns, err = listenAll(addrs); err != nil {
+			return err
+		}
+	}
+
+	// Before the listeners are logged, as upgrade signals may follow at once.
+	upgrades := notifyUpgrades()
+
+	servers := make([]*http.Server, len(lns))
+	conns := newOpenConns()
+	for i, ln := range lns {
+		servers[i] = &http.Server{
+			Handler:     srv.routes(addrs[i].routes),
+			BaseContext: func(net.Listener) context.Context { return ctx },
+			ConnState:   conns.track,
+		}
+		log.Printf("serving %s routes on %s\n", addrs[i].routes, ln.Addr())
+	}
+
+	if upgraded != nil {
+		upgraded()
+	}
+	if cfg.ready != nil {
+		cfg.ready(lns)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("error notifying readiness: %v", err)
+	}
+	go watchUpgrades(ctx, cancel, upgrades, addrs, lns, func() error {
+		return saveState(st, up, saveFile, reg, counters, srv.clock.Now())
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		sdNotify("STOPPING=1")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+		defer cancel()
+		if errors.Is(context.Cause(ctx), errUpgraded) {
+			handOver(shutdownCtx, servers, lns, conns)
+		}
+		if err := shutdown(shutdownCtx, servers, stopRefresh, refreshDone, st, up, saveFile, reg, counters, srv.clock); err != nil {
+			log.Printf("error shutting down: %v", err)
+		}
+		log.Printf(
+			"stopped after %s: %.0f requests served, %.0f refreshes performed, %.0f failed",
+			srv.clock.Now().Sub(started).Round(time.Second), srv.http.requests.total(), ref.refreshes.total(), ref.refreshes.value("failure"),
+		)
+	}()
+
+	served := make(chan error, len(servers))
+	for i, s := range servers {
+		go func() {
+			// After an upgrade, handOver closes the listeners before the shutdown.
+			if err := s.Serve(lns[i]); !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+				served <- fmt.Errorf("error serving on %s: %w", lns[i].Addr(), err)
+				return
+			}
+			served <- nil
+		}()
+	}
+	for range servers {
+		if err := <-served; err != nil {
+			return err
+		}
+	}
+	<-stopped
+
+	return context.Cause(ctx)
+}
+
+// flushTimeout bounds stopping the refresher and saving the cache after
+// draining requests took up all of the shutdown timeout.
+const flushTimeout = 5 * time.Second
+
+// shutdown stops accepting requests on all servers and drains the active
+// ones, then stops the refresher and saves the cache and, if countersFile is
+// set, the counters, giving up on each step once ctx is done. Requests
+// still active when ctx is done are cut off, and the refresher is stopped
+// and the cache saved regardless, within flushTimeout.
+func shutdown(
+	ctx context.Context,
+	servers []*http.Server,
+	stopRefresh func(),
+	refreshDone <-chan struct{},
+	st *store,
+	up *upstream,
+	cacheFile string,
+	reg *registry,
+	countersFile string,
+	clk clock,
+) error {
+	var errs []error
+	drained := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() { drained <- s.Shutdown(ctx) }()
+	}
+	for range servers {
+		if err := <-drained; err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, fmt.Errorf("error draining requests: %w", err))
+		}
+	}
+	if ctx.Err() != nil {
+		for _, s := range servers {
+			s.Close()
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), flushTimeout)
+		defer cancel()
+	}
+
+	stopRefresh()
+	select {
+	case <-refreshDone:
+	case <-ctx.Done():
+		errs = append(errs, errors.New("timed out stopping the refresher"))
+	}
+
+	if cacheFile == "" {
+		return errors.Join(errs...)
+	}
+	saved := make(chan error, 1)
+	go func() { saved <- saveState(st, up, cacheFile, reg, countersFile, clk.Now()) }()
+	select {
+	case err := <-saved:
+		errs = append(errs, err)
+	case <-ctx.Done():
+		errs = append(errs, errors.New("timed out saving the cache"))
+	}
+	return errors.Join(errs...)
+}
+
+// saveState saves the cache to cacheFile, if set, and the counters to
+// countersFile, if set, as of now.
+func saveState(st *store, up *upstream, cacheFile string, reg *registry, countersFile string, now time.Time) error {
+	if cacheFile == "" {
+		return nil
+	}
+	if err := st.save(cacheFile, now, up.savedValidators()); err != nil {
+		return err
+	}
+	if countersFile != "" {
+		return saveCounters(reg, countersFile, now)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestShutdownDrainTimeout shuts down while a request is still in flight
+// at the deadline, and checks that the refresher is stopped and the cache
+// saved anyway.
+func TestShutdownDrainTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})}
+	go srv.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+	<-entered
+
+	st := newStore()
+	st.merge(testPrices(), originRefresh, upstreamProvider)
+	reg := &registry{}
+	up := newUpstream(config{}, reg)
+	path := filepath.Join(t.TempDir(), "cache.json")
+	stopped := false
+	refreshDone := make(chan struct{})
+	stopRefresh := func() {
+		stopped = true
+		close(refreshDone)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = shutdown(ctx, []*http.Server{srv}, stopRefresh, refreshDone, st, up, path, reg, "", newFakeClock(testNow))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown returned %v, want the drain to time out", err)
+	}
+	if !stopped {
+		t.Error("refresher not stopped")
+	}
+	loaded, _, err := loadStore(t, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSlots(t, loaded, st)
+}
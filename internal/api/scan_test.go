@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uniformStore returns a store of hourly slots from testFirstDay on, all
+// priced p.
+func uniformStore(slots int, p float64) *store {
+	st := newStore()
+	st.merge(uniformPrices(slots, p), originRefresh, upstreamProvider)
+	return st
+}
+
+func uniformPrices(slots int, p float64) map[time.Time]float64 {
+	prices := make(map[time.Time]float64, slots)
+	for i := range slots {
+		prices[testFirstDay.Add(time.Duration(i)*time.Hour)] = p
+	}
+	return prices
+}
+
+// TestScanAllocs checks that scanning doesn't allocate per slot.
+func TestScanAllocs(t *testing.T) {
+	st := uniformStore(24*366, 1)
+	for _, slots := range []int{24, 24 * 366} {
+		end := testFirstDay.Add(time.Duration(slots) * time.Hour)
+		n := 0
+		allocs := testing.AllocsPerRun(10, func() {
+			for range st.scan(testFirstDay, end) {
+				n++
+			}
+		})
+		if allocs > 2 {
+			t.Errorf("scanning %d slots allocates %v times", slots, allocs)
+		}
+	}
+}
+
+// TestScanConcurrentMerge scans while merges replace every price, checking
+// that each scan sees the prices of a single merge in order.
+func TestScanConcurrentMerge(t *testing.T) {
+	const slots = 24 * 30
+	st := uniformStore(slots, 0)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for p := 1.0; p <= 200; p++ {
+			st.merge(uniformPrices(slots, p), originRefresh, upstreamProvider)
+		}
+	}()
+
+	for range 200 {
+		var first cachedSlot
+		n := 0
+		for c := range st.scan(time.Time{}, time.Time{}) {
+			if n == 0 {
+				first = c
+			} else if c.Price != first.Price || !c.Start.Equal(first.Start.Add(time.Duration(n)*time.Hour)) {
+				t.Fatalf("slot %d at %s priced %v after slot 0 at %s priced %v", n, c.Start, c.Price, first.Start, first.Price)
+			}
+			n++
+		}
+		if n != slots {
+			t.Fatalf("scan yielded %d slots, want %d", n, slots)
+		}
+	}
+	wg.Wait()
+}
+
+// BenchmarkScan scans ranges of growing size. The allocations per scan stay
+// the same regardless of the size.
+func BenchmarkScan(b *testing.B) {
+	st := uniformStore(24*366*6, 1)
+	for _, days := range []int{1, 366, 366 * 6} {
+		b.Run(fmt.Sprintf("days=%d", days), func(b *testing.B) {
+			end := testFirstDay.AddDate(0, 0, days)
+			b.ReportAllocs()
+			for range b.N {
+				for range st.scan(testFirstDay, end) {
+				}
+			}
+		})
+	}
+}
+
+// discardWriter is a ResponseWriter that doesn't keep the body, so that
+// benchmarks measure the handler's memory rather than the response's.
+type discardWriter struct{ h http.Header }
+
+func (w discardWriter) Header() http.Header       { return w.h }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
+
+// BenchmarkExport exports growing ranges of six years of hourly prices as
+// the streaming formats. The allocations per slot, those of formatting it,
+// stay about constant, as the slots are streamed rather than copied for
+// every request.
+func BenchmarkExport(b *testing.B) {
+	s := newTestServer(b, "-max-range", "0", "-no-ondemand")
+	s.store = uniformStore(24*366*6, 1)
+	h := s.routes(routesAll)
+	for _, format := range []string{formatCSV, formatNDJSON} {
+		for _, days := range []int{1, 366, 366 * 6} {
+			b.Run(fmt.Sprintf("format=%s/days=%d", format, days), func(b *testing.B) {
+				target := fmt.Sprintf("/price?format=%s&start=%s&end=%s", format,
+					testFirstDay.UTC().Format(time.RFC3339), testFirstDay.AddDate(0, 0, days).UTC().Format(time.RFC3339))
+				wantStatus(b, get(b, s, target), http.StatusOK)
+				b.ReportAllocs()
+				for range b.N {
+					h.ServeHTTP(discardWriter{http.Header{}}, httptest.NewRequest(http.MethodGet, target, nil))
+				}
+				b.ReportMetric(float64(testing.AllocsPerRun(1, func() {
+					h.ServeHTTP(discardWriter{http.Header{}}, httptest.NewRequest(http.MethodGet, target, nil))
+				}))/float64(days*24), "allocs/slot")
+			})
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type server struct {
+	cfg      config
+	store    *store
+	upstream *upstream
+	events   *broker
+	metrics  *registry
+	http     *httpMetrics
+	loc      *time.Location
+	clock    clock
+
+	refreshStatus  *refreshStatus
+	refreshHistory *refreshHistory
+
+	panics           *counterVec
+	backgroundPanics *panicLog
+
+	memo *memo
+	dump *fullDump
+
+	publications *publicationLog
+	shedder      *loadShedder
+	quarantine   *quarantineLog
+	skippedDays  *counterVec
+}
+
+func newServer(cfg config, st *store, up *upstream, loc *time.Location, reg *registry) *server {
+	s := &server{
+		cfg:      cfg,
+		store:    st,
+		upstream: up,
+		events:   newBroker(reg),
+		metrics:  reg,
+		http:     newHTTPMetrics(reg),
+		loc:      loc,
+		clock:    systemClock{},
+
+		refreshStatus:  &refreshStatus{},
+		refreshHistory: newRefreshHistory(cfg.refreshHistory),
+
+		panics: &counterVec{
+			name:   "energy_prices_panics_total",
+			help:   "Recovered panics by where they occurred, http for request handlers.",
+			labels: []string{"where"},
+		},
+		backgroundPanics: &panicLog{},
+
+		memo: newMemo(reg),
+		dump: newFullDump(reg),
+
+		publications: &publicationLog{},
+		shedder:      newLoadShedder(cfg, reg),
+		quarantine:   &quarantineLog{},
+		skippedDays: &counterVec{
+			name: "energy_prices_settled_days_skipped_total",
+			help: "Settled days not fetched from the upstream again for on-demand requests.",
+		},
+	}
+	s.metrics.register(s.panics)
+	s.metrics.register(s.skippedDays)
+	s.metrics.register(gaugeFunc{
+		name: "energy_prices_settled_days",
+		help: "Past days known to be complete that aren't fetched on demand again.",
+		fn:   func() float64 { return float64(len(st.settledDays())) },
+	})
+	var clk clock = systemClock{}
+	if cfg.clock != nil {
+		clk = cfg.clock
+	}
+	if cfg.correctClock {
+		clk = correctedClock{clk, up.skew}
+	}
+	s.setClock(clk)
+	s.metrics.register(gaugeFunc{
+		name: "energy_prices_clock_skew_seconds",
+		help: "How far the upstream's clock was ahead of the host's by the Date header of its last response.",
+		fn: func() float64 {
+			offset, _, _ := up.skew.get()
+			return offset.Seconds()
+		},
+	})
+
+	s.metrics.register(gaugeFunc{
+		name: "energy_prices_tomorrow_available",
+		help: "Whether the cache covers the whole next Europe/Berlin day (1) or not (0).",
+		fn: func() float64 {
+			if s.tomorrowAvailable() {
+				return 1
+			}
+			return 0
+		},
+	})
+
+	s.metrics.register(gaugeVecFunc{
+		name: "energy_prices_tomorrow_published_seconds",
+		help: "Seconds after Europe/Berlin midnight at which the next day's prices were last found cached. Absent until observed.",
+		fn: func(emit func(v float64, labelValues ...string)) {
+			if p, ok := s.publications.latest(); ok {
+				emit(p.Seconds)
+			}
+		},
+	})
+
+	s.metrics.register(gaugeVecFunc{
+		name: "energy_prices_upcoming_price",
+		help: "Price in EUR/MWh of the slot containing the time the given number of hours from now. " +
+			"This flattens future prices into present samples, as Prometheus can't ingest future timestamps. " +
+			"Hours beyond the cached prices are absent.",
+		labels: []string{"hours_ahead"},
+		fn: func(emit func(v float64, labelValues ...string)) {
+			now := s.clock.Now()
+			points := s.store.points(now, now.Add((upcomingHours+1)*time.Hour))
+			for h := 1; h <= upcomingHours; h++ {
+				if p, ok := slotContaining(points, now.Add(time.Duration(h)*time.Hour)); ok {
+					emit(p.Price, strconv.Itoa(h))
+				}
+			}
+		},
+	})
+
+	return s
+}
+
+// setClock makes c the source of "now" of the server, its store and its
+// upstream.
+func (s *server) setClock(c clock) {
+	s.clock = c
+	s.store.clock = c
+	s.upstream.clock = c
+}
+
+// upcomingHours is the number of hours ahead reported by the upcoming price
+// gauges.
+const upcomingHours = 24
+
+// routes returns the handler of the routes in rs.
+func (s *server) routes(rs routeSet) http.Handler {
+	mux := http.NewServeMux()
+	// bodyLimits override the configured request body limit per route.
+	bodyLimits := map[string]int64{
+		"POST /price/lookup": maxLookupBytes,
+		"POST /price/cost":   maxCostBytes,
+	}
+	// expensive are the routes whose responses grow with their range, which
+	// are shed under memory pressure, with a test for their requests that
+	// are cheap nonetheless.
+	expensive := map[string]func(r *http.Request) bool{
+		"GET /price":        func(r *http.Request) bool { return r.URL.Query().Has("at") },
+		"GET /price/matrix": nil,
+	}
+	// register registers h for pattern below the configured base path.
+	register := func(pattern string, h http.Handler) {
+		limit, ok := bodyLimits[pattern]
+		if !ok {
+			limit = s.cfg.maxBody
+		}
+		if cheap, ok := expensive[pattern]; ok {
+			h = s.shedding(cheap, h)
+		}
+		method, path, _ := strings.Cut(pattern, " ")
+		mux.Handle(method+" "+s.cfg.basePath+path, limitBody(limit, h))
+	}
+	handle := func(pattern string, h http.Handler) {
+		if rs.includes(false) {
+			register(pattern, h)
+		}
+	}
+	internal := func(pattern string, h http.Handler) {
+		if rs.includes(true) {
+			register(pattern, h)
+		}
+	}
+	price := func(pattern string, h pinnedHandler, params ...string) {
+		handle(pattern, s.dataHeaders(s.validParams(params, s.bind(h))))
+	}
+	// Responses of cached routes depend on nothing but the cache contents and
+	// the request, so they can be validated against the time of the last merge.
+	cached := func(pattern string, h pinnedHandler, params ...string) {
+		handle(pattern, s.dataHeaders(s.conditional(s.validParams(params, s.bind(h)))))
+	}
+	// Aggregates are expensive to compute and small, so identical requests
+	// share their responses until the cache changes.
+	aggregate := func(pattern string, h pinnedHandler, params ...string) {
+		handle(pattern, s.dataHeaders(s.conditional(s.validParams(params, s.memoize(s.bind(h))))))
+	}
+	cached("GET /price", (*server).handlePrices, priceParams...)
+	aggregate("GET /price/profile", (*server).handleProfile, "start", "end")
+	aggregate("GET /price/weekday-profile", (*server).handleWeekdayProfile, "start", "end", "unit", "currency")
+	aggregate("GET /price/spread", (*server).handleSpread, "start", "end", "unit", "currency", "efficiency", "order")
+	aggregate("GET /price/blocks", (*server).handleBlocks, "start", "end", "unit", "currency", "peak_start", "peak_end", "weekend_peak")
+	// Bands are aggregates over a window of whole days before today, which
+	// is resolved to the range memoized.
+	handle("GET /price/bands", s.dataHeaders(s.validParams([]string{"window", "unit", "currency"},
+		s.trailingDays(s.memoize(s.bind((*server).handleBands))))))
+	aggregate("GET /price/histogram", (*server).handleHistogram, "start", "end", "unit", "currency", "bucket", "min", "max")
+	aggregate("GET /price/compare", (*server).handleCompare, "a_start", "a_end", "b_start", "b_end", "unit", "currency")
+	aggregate("GET /price/average", (*server).handleAverage, "start", "end", "unit", "currency")
+	aggregate("GET /price/checksum", (*server).handleChecksum, "start", "end")
+	aggregate("GET /price/matrix", (*server).handleMatrix, "start", "end", "unit", "currency")
+	handle("GET /{$}", s.dataHeaders(s.bind((*server).handleIndex)))
+	price("GET /price/current", (*server).handleCurrent, "unit", "currency", "format")
+	price("POST /price/lookup", (*server).handleLookup, "unit", "currency")
+	price("POST /price/cost", (*server).handleCost, "currency")
+	price("GET /price/chart.svg", (*server).handleChart, "start", "end", "width", "height")
+	price("GET /price/meta", (*server).handleMeta)
+	price("GET /price/coverage", (*server).handleCoverage)
+	price("GET /price/next", (*server).handleNext, "below", "unit", "currency")
+	price("GET /price/context", (*server).handleContext, "window", "unit", "currency")
+	price("GET /price/extremes", (*server).handleExtremes, "window", "unit", "currency")
+	price("GET /price/signal", (*server).handleSignal, "strategy", "n", "threshold", "unit", "currency")
+	price("GET /price/tomorrow", (*server).handleTomorrow, "unit", "currency", "wait")
+	price("GET /price/events", (*server).handleEvents)
+	handle("GET /openapi.json", http.HandlerFunc(s.handleOpenAPI))
+	register("GET /healthz", http.HandlerFunc(s.handleHealth))
+	internal("GET /metrics", http.HandlerFunc(s.handleMetrics))
+	if s.cfg.adminToken != "" || s.cfg.readOnly {
+		internal("GET /admin/cache", s.admin(s.handleCacheInspect))
+		internal("DELETE /admin/cache", s.admin(s.handleCacheDelete))
+		internal("GET /admin/refreshes", s.admin(s.handleRefreshHistory))
+		internal("GET /admin/diff", s.admin(s.handleCacheDiff))
+	}
+	var h http.Handler = s.recoverPanics(limitURI(s.cfg.maxURI, structuredMuxErrors(mux)))
+	if s.cfg.gzipLevel > 0 || s.cfg.brotliLevel > 0 {
+		h = newCompressor(s.cfg.gzipLevel, s.cfg.brotliLevel, s.cfg.compressMinSize).wrap(h)
+	}
+	return requestID(s.cfg.trustedProxies.withClientIP(s.http.instrument(h)))
+}
+
+// dataHeaders annotates price responses with the time of the last successful
+// refresh and the start of the newest cached slot, whatever their format,
+// and link the license of the data. They also name the cache generation
+// they are computed from, which requests can be pinned to with the
+// generation parameter, see pinGeneration.
+//
+// Once the newest slot has ended, responses are additionally marked as stale
+// together with the age of the last refresh. They are still served, as an
+// old price is more useful to most clients than none.
+func (s *server) dataHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"license\"", dataLicense.LicenseURL))
+		r, ok := s.pinGeneration(w, r)
+		if !ok {
+			return
+		}
+		m := s.pinned(r).store.meta()
+		w.Header().Set(generationHeader, strconv.FormatUint(m.Generation, 10))
+		if !m.LastRefresh.IsZero() {
+			w.Header().Set("X-Last-Refresh", m.LastRefresh.UTC().Format(time.RFC3339))
+		}
+		if !m.Latest.IsZero() {
+			w.Header().Set("X-Data-Through", m.Latest.UTC().Format(time.RFC3339))
+		}
+		if now := s.clock.Now(); !m.Through.After(now) {
+			w.Header().Set("Warning", `110 - "response is stale"`)
+			w.Header().Set("X-Data-Stale", "true")
+			if !m.LastRefresh.IsZero() {
+				w.Header().Set("X-Data-Age", strconv.Itoa(int(now.Sub(m.LastRefresh).Seconds())))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// conditional sets Last-Modified to the modification time of the cache and
+// answers with 304 if it hasn't changed since the time in If-Modified-Since.
+// Responses for ranges relative to the current time change even with the
+// cache unchanged, so they aren't conditional.
+func (s *server) conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modified := s.pinned(r).store.meta().Modified
+		if !modified.IsZero() && !hasRelativeRange(r.URL.Query()) {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+			since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+			if err == nil && !modified.After(since) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) tomorrowAvailable() bool {
+	return tomorrowAvailable(s.store.meta().Latest, s.clock.Now(), s.loc)
+}
+
+// refreshed is called after a refresh has merged new prices into the store,
+// with the result of the merge. wasAvailable is the tomorrow availability
+// from before the merge. A single cache_updated event announces all slots
+// the refresh changed, however many there are.
+func (s *server) refreshed(wasAvailable bool, res mergeResult) {
+	s.dump.rebuild(s.store)
+	generation := s.store.meta().Generation
+	s.events.publish(event{
+		Name: "cache_updated",
+		Data: struct {
+			Generation uint64 `json:"generation"`
+			Added      int    `json:"added"`
+			Updated    int    `json:"updated"`
+		}{generation, res.Added, res.Updated},
+		Generation: generation,
+	})
+	if !wasAvailable && s.tomorrowAvailable() {
+		s.publications.observe(s.clock.Now(), s.loc)
+		s.events.publish(event{
+			Name: "tomorrow_available",
+			Data: struct {
+				Latest time.Time `json:"latest"`
+			}{s.store.meta().Latest.UTC()},
+		})
+	}
+}
+
+type metaResponse struct {
+	Slots       int       `json:"slots"`
+	Earliest    time.Time `json:"earliest"`
+	Latest      time.Time `json:"latest"`
+	LastRefresh time.Time `json:"last_refresh"`
+	Generation  uint64    `json:"generation"`
+	// Sources counts the cached slots by the provider they are from.
+	Sources           map[string]int `json:"sources"`
+	TomorrowAvailable bool           `json:"tomorrow_available"`
+	Stale             bool           `json:"stale"`
+	Refresh           refreshState   `json:"refresh"`
+	SyncFrom          string         `json:"sync_from,omitempty"`
+	License           license        `json:"license"`
+	// Published are the times tomorrow's prices were found cached on the
+	// last days, newest first.
+	Published []publication `json:"tomorrow_published"`
+	// Quarantine reports the implausible slots removed from the cache at
+	// startup, if there were any.
+	Quarantine *quarantineReport `json:"quarantine,omitempty"`
+
+	// LastSuccess and LastFailure are the last refresh attempts by outcome.
+	LastSuccess *refreshRecord `json:"last_successful_refresh,omitempty"`
+	LastFailure *refreshRecord `json:"last_failed_refresh,omitempty"`
+}
+
+func (s *server) meta() metaResponse {
+	m := s.store.meta()
+	success, failure := s.refreshHistory.last()
+	return metaResponse{
+		Slots:             m.Slots,
+		Earliest:          m.Earliest.UTC(),
+		Latest:            m.Latest.UTC(),
+		LastRefresh:       m.LastRefresh.UTC(),
+		Generation:        m.Generation,
+		Sources:           m.Sources,
+		TomorrowAvailable: tomorrowAvailable(m.Latest, s.clock.Now(), s.loc),
+		Stale:             !m.Through.After(s.clock.Now()),
+		Refresh:           s.refreshStatus.get(),
+		SyncFrom:          s.cfg.syncFrom,
+		License:           dataLicense,
+		Published:         s.publications.get(),
+		Quarantine:        s.quarantine.get(),
+		LastSuccess:       success,
+		LastFailure:       failure,
+	}
+}
+
+func (s *server) handleMeta(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.meta())
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	m := s.meta()
+	panics := s.backgroundPanics.since(s.clock.Now().Add(-panicDegradedFor))
+	offset, measured, skewed := s.upstream.skew.get()
+	status := "ok"
+	if m.Stale || len(panics) > 0 || skewed && !s.cfg.correctClock {
+		status = "degraded"
+	}
+	var skew *float64
+	if measured {
+		seconds := offset.Seconds()
+		skew = &seconds
+	}
+	writeJSON(w, struct {
+		Status string `json:"status"`
+		metaResponse
+		// Panics are the recent panics of background tasks.
+		Panics []backgroundPanic `json:"panics,omitempty"`
+		// ClockSkew is how far the upstream's clock is ahead of the
+		// host's, and ClockCorrected whether "now" follows the upstream's.
+		ClockSkew      *float64 `json:"clock_skew_seconds,omitempty"`
+		ClockCorrected bool     `json:"clock_corrected,omitempty"`
+	}{status, m, panics, skew, skewed && s.cfg.correctClock})
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.write(w)
+}
+
+// handleEvents streams broker events to the client as server-sent events.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "streaming not supported")
+		return
+	}
+
+	events, unsubscribe := s.events.subscribe(s.store.meta().Generation)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(bytes)
+}
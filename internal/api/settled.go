@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Past days don't change once the market has settled them, so days older
+// than settleAfter that were fetched completely, or that are before the
+// upstream's history, are settled: the on-demand fetches skip them, which
+// keeps clients listing the same ranges before the cached data from asking
+// the upstream again and again. Days of which the upstream had only some
+// slots, or none amid others, aren't settled, so that they are fetched again.
+// The settled days are saved with the cache. Removing cached slots, by the
+// admin endpoint or the quarantine, unsettles their days.
+
+// settle records the Europe/Berlin days starting at days as settled.
+func (s *store) settle(days ...time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.settled == nil {
+		s.settled = make(map[int64]bool)
+	}
+	for _, d := range days {
+		s.settled[d.Unix()] = true
+	}
+}
+
+// isSettled reports whether the day starting at day is settled.
+func (s *store) isSettled(day time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.settled[day.Unix()]
+}
+
+// unsettle forgets the settled days overlapping [start, end). Without the
+// location, days are taken to last up to 25 hours, so that a neighbouring
+// day may be unsettled too. The caller must hold the lock.
+func (s *store) unsettle(start, end time.Time) {
+	for day := range s.settled {
+		if day < end.Unix() && day+25*60*60 > start.Unix() {
+			delete(s.settled, day)
+		}
+	}
+}
+
+// settledDays returns the starts of the settled days in order.
+func (s *store) settledDays() []int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(s.settled))
+}
+
+// unsettledRanges returns the parts of [start, end) that aren't in settled
+// days, or in days newer than settleAfter which are always included.
+func (s *server) unsettledRanges(start, end time.Time) []timeRange {
+	horizon := s.clock.Now().Add(-s.cfg.settleAfter)
+	var ranges []timeRange
+	for day := localMidnight(start, s.loc); day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		if !next.After(horizon) && s.store.isSettled(day) {
+			s.skippedDays.inc()
+			continue
+		}
+		from, to := day, next
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].End.Equal(from) {
+			ranges[n-1].End = to
+		} else {
+			ranges = append(ranges, timeRange{from, to})
+		}
+	}
+	return ranges
+}
+
+// fetchUnsettled fetches the prices of [start, end) from the upstream,
+// skipping settled days, and settles the whole days fetched that are older
+// than settleAfter and were complete, or empty before the first price.
+func (s *server) fetchUnsettled(ctx context.Context, start, end time.Time) (map[time.Time]float64, error) {
+	prices := make(map[time.Time]float64)
+	for _, tr := range s.unsettledRanges(start, end) {
+		fetched, err := s.upstream.fetch(ctx, tr.Start, tr.End)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(prices, fetched)
+
+		var first time.Time
+		for t := range fetched {
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+		}
+		horizon := s.clock.Now().Add(-s.cfg.settleAfter)
+		var settled []time.Time
+		for day := localMidnight(tr.Start, s.loc); day.Before(tr.End); day = day.AddDate(0, 0, 1) {
+			next := day.AddDate(0, 0, 1)
+			if day.Before(tr.Start) || next.After(tr.End) || next.After(horizon) {
+				continue
+			}
+			times := timesIn(fetched, day, next)
+			if len(times) == 0 && (first.IsZero() || !next.After(first)) || dayComplete(times, day, next) {
+				settled = append(settled, day)
+			}
+		}
+		s.store.settle(settled...)
+	}
+	return prices, nil
+}
+
+// timesIn returns the sorted times of prices in [start, end).
+func timesIn(prices map[time.Time]float64, start, end time.Time) []time.Time {
+	var times []time.Time
+	for t := range prices {
+		if !t.Before(start) && t.Before(end) {
+			times = append(times, t)
+		}
+	}
+	slices.SortFunc(times, time.Time.Compare)
+	return times
+}
+
+// dayComplete reports whether the sorted times cover [start, end) at a
+// constant resolution.
+func dayComplete(times []time.Time, start, end time.Time) bool {
+	if len(times) < 2 || !times[0].Equal(start) {
+		return false
+	}
+	res := times[1].Sub(times[0])
+	for i := 2; i < len(times); i++ {
+		if times[i].Sub(times[i-1]) != res {
+			return false
+		}
+	}
+	return times[len(times)-1].Add(res).Equal(end)
+}
+
+// localMidnight returns the start of the day of t in loc.
+func localMidnight(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
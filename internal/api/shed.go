@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"runtime/metrics"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// estimatedSlotBytes is roughly how much memory a listed slot takes while
+// its response is built: the points copied from the store, their encoding
+// and the buffers it is written through.
+const estimatedSlotBytes = 256
+
+// shedRetryAfter is how long shed requests are asked to wait.
+const shedRetryAfter = 5 * time.Second
+
+// heapObjectsMetric is the runtime metric of the bytes of heap objects,
+// live or not yet swept.
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// loadShedder rejects expensive requests, such as long listings, while the
+// process is short of memory, so that concurrent full-history requests can't
+// exhaust it, while cheap endpoints keep being served. A request is shed if
+// the heap is above heapLimit, or if the responses in flight would together
+// exceed inflightLimit with it. Each admitted request accounts for the
+// response estimated from the slots it covers until it is done. A single
+// request is always admitted if no other one is in flight, so that the
+// limit doesn't make any range unavailable. Zero limits disable either
+// check.
+type loadShedder struct {
+	inflightLimit int64
+	heapLimit     uint64
+	inflight      atomic.Int64
+	// heap returns the current heap size.
+	heap func() uint64
+
+	shed *counterVec
+}
+
+func newLoadShedder(cfg config, reg *registry) *loadShedder {
+	l := &loadShedder{
+		inflightLimit: cfg.shedInflightBytes,
+		heap:          heapObjectsBytes,
+		shed: &counterVec{
+			name:   "energy_prices_shed_requests_total",
+			help:   "Expensive requests rejected with 503 under memory pressure by route pattern.",
+			labels: []string{"route"},
+		},
+	}
+	if cfg.memoryLimit > 0 {
+		l.heapLimit = uint64(cfg.memoryLimit) / 10 * 9
+	}
+	reg.register(l.shed)
+	reg.register(gaugeFunc{
+		name: "energy_prices_inflight_response_bytes",
+		help: "Estimated memory taken by the expensive responses being built.",
+		fn:   func() float64 { return float64(l.inflight.Load()) },
+	})
+	return l
+}
+
+// admit accounts for a response of the estimated cost, and reports whether
+// it may be served. Admitted costs are given back with release.
+func (l *loadShedder) admit(cost int64) bool {
+	if l.heapLimit > 0 && l.heap() > l.heapLimit {
+		return false
+	}
+	n := l.inflight.Add(cost)
+	if l.inflightLimit > 0 && n > l.inflightLimit && n != cost {
+		l.inflight.Add(-cost)
+		return false
+	}
+	return true
+}
+
+func (l *loadShedder) release(cost int64) {
+	l.inflight.Add(-cost)
+}
+
+func heapObjectsBytes() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// shedding guards the expensive route next with the load shedder, estimating
+// its cost from the cached slots in the range of start and end. Requests
+// for which cheap reports true aren't accounted for.
+func (s *server) shedding(cheap func(r *http.Request) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cheap != nil && cheap(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Ranges that don't parse, including the relative ones resolved
+		// later, are estimated as the whole cache; the handler reports
+		// errors.
+		start, end, err := parseRange(r.URL.Query(), s.loc)
+		if err != nil {
+			start, end = time.Time{}, time.Time{}
+		}
+		cost := int64(s.store.count(start, end)) * estimatedSlotBytes
+		if !s.shedder.admit(cost) {
+			s.shedder.shed.inc(r.Pattern)
+			w.Header().Set("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, "the service is short of memory, retry later or request a shorter range")
+			return
+		}
+		defer s.shedder.release(cost)
+		next.ServeHTTP(w, r)
+	})
+}
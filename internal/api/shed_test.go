@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// TestLoadShedding simulates memory pressure with an injected heap size and
+// checks that expensive requests are shed with 503 while it lasts, and
+// cheap ones are served throughout.
+func TestLoadShedding(t *testing.T) {
+	s := newTestServer(t, "-memory-limit", "1000000")
+	var heap atomic.Uint64
+	s.shedder.heap = heap.Load
+
+	const (
+		listing = "/price?start=2026-01-12&end=2026-01-15"
+		matrix  = "/price/matrix?start=2026-01-12&end=2026-01-15"
+	)
+	cheap := []string{"/price?at=2026-01-13T10:00:00Z", "/price/current", "/price/tomorrow", "/price/meta"}
+	wantServed := func(t *testing.T, targets ...string) {
+		t.Helper()
+		for _, target := range targets {
+			if res := get(t, s, target); res.StatusCode != http.StatusOK {
+				t.Errorf("GET %s: status %d, want %d: %s", target, res.StatusCode, http.StatusOK, readBody(t, res))
+			}
+		}
+	}
+	wantShed := func(t *testing.T, targets ...string) {
+		t.Helper()
+		for _, target := range targets {
+			res := get(t, s, target)
+			if got := res.Header.Get("Retry-After"); got != "5" {
+				t.Errorf("GET %s: Retry-After %q, want 5", target, got)
+			}
+			wantError(t, res, http.StatusServiceUnavailable, codeUnavailable, "short of memory")
+		}
+	}
+
+	heap.Store(800000)
+	wantServed(t, append(cheap, listing, matrix)...)
+
+	// Above 90% of the memory limit.
+	heap.Store(950000)
+	wantShed(t, listing, matrix)
+	wantServed(t, cheap...)
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_shed_requests_total{route="GET /price"}`:        1,
+		`energy_prices_shed_requests_total{route="GET /price/matrix"}`: 1,
+	})
+
+	heap.Store(800000)
+	wantServed(t, listing, matrix)
+}
+
+// TestLoadSheddingInflight simulates responses in flight with injected
+// accounting and checks that expensive requests are shed when they would
+// take the estimate above the limit, except when they are the only one.
+func TestLoadSheddingInflight(t *testing.T) {
+	// The listing of 72 slots is estimated at 72*256 bytes.
+	const listing = "/price?start=2026-01-12&end=2026-01-15"
+	s := newTestServer(t, "-shed-inflight-bytes", "20000")
+
+	wantStatus(t, get(t, s, listing), http.StatusOK)
+	wantMetrics(t, s.metrics, map[string]float64{"energy_prices_inflight_response_bytes": 0})
+
+	// Another listing in flight.
+	s.shedder.inflight.Add(72 * estimatedSlotBytes)
+	wantError(t, get(t, s, listing), http.StatusServiceUnavailable, codeUnavailable, "short of memory")
+	wantStatus(t, get(t, s, "/price/current"), http.StatusOK)
+	// A shorter range still fits.
+	wantStatus(t, get(t, s, "/price?start=2026-01-13T10:00:00Z&end=2026-01-13T12:00:00Z"), http.StatusOK)
+	wantMetrics(t, s.metrics, map[string]float64{"energy_prices_inflight_response_bytes": 72 * estimatedSlotBytes})
+	s.shedder.inflight.Add(-72 * estimatedSlotBytes)
+
+	// A single request is admitted however expensive.
+	s = newTestServer(t, "-shed-inflight-bytes", "1000")
+	wantStatus(t, get(t, s, listing), http.StatusOK)
+	s.shedder.inflight.Add(1)
+	wantError(t, get(t, s, listing), http.StatusServiceUnavailable, codeUnavailable, "short of memory")
+}
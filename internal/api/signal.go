@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/schedule"
+)
+
+// A signalStrategy builds the schedule strategy requested by the parameters.
+type signalStrategy func(q url.Values, div float64) (schedule.Strategy, error)
+
+var signalStrategies = map[string]signalStrategy{
+	"below_daily_median": belowDailyMedian,
+	"cheapest_n_today":   cheapestNToday,
+	"below_absolute":     belowAbsolute,
+}
+
+func belowDailyMedian(url.Values, float64) (schedule.Strategy, error) {
+	return schedule.BelowDailyMedian(), nil
+}
+
+func cheapestNToday(q url.Values, _ float64) (schedule.Strategy, error) {
+	n, err := strconv.Atoi(q.Get("n"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("n: expected a non-negative integer, got %q", q.Get("n"))
+	}
+	return schedule.CheapestN(n), nil
+}
+
+func belowAbsolute(q url.Values, div float64) (schedule.Strategy, error) {
+	threshold, err := parseFloat(q, "threshold", 0)
+	if err == nil && q.Get("threshold") == "" {
+		err = errors.New("threshold: expected a number")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return schedule.BelowAbsolute(threshold * div), nil
+}
+
+// handleSignal answers whether now is a good time to consume according to
+// the requested strategy, and until when that answer holds given the cached
+// prices.
+func (s *server) handleSignal(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	_, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	strategy, ok := signalStrategies[q.Get("strategy")]
+	if !ok {
+		valid := make([]string, 0, len(signalStrategies))
+		for name := range signalStrategies {
+			valid = append(valid, name)
+		}
+		slices.Sort(valid)
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("strategy: expected one of %s", strings.Join(valid, ", ")))
+		return
+	}
+	decide, err := strategy(q, div)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	now := s.clock.Now().In(s.loc)
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+	decision, ok := schedule.Signal(s.store.points(today, time.Time{}), decide, now)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "no price for the current slot")
+		return
+	}
+
+	writeJSON(w, struct {
+		Consume bool      `json:"consume"`
+		Until   time.Time `json:"until"`
+	}{decision.Consume, decision.Until.UTC()})
+}
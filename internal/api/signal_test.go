@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignal(t *testing.T) {
+	// At testNow the slot of 15:00 costs 115 and the day's median is 111.5.
+	tests := []struct {
+		query   string
+		consume bool
+		until   time.Time
+	}{
+		// Expensive until the cheaper half of the next day starts.
+		{"strategy=below_daily_median", false, time.Date(2026, time.January, 13, 23, 0, 0, 0, time.UTC)},
+		{"strategy=cheapest_n_today&n=3", false, time.Date(2026, time.January, 13, 23, 0, 0, 0, time.UTC)},
+		// Answers that hold for the rest of the prices hold until they end.
+		{"strategy=cheapest_n_today&n=24", true, time.Date(2026, time.January, 14, 23, 0, 0, 0, time.UTC)},
+		{"strategy=below_absolute&threshold=115", false, time.Date(2026, time.January, 14, 23, 0, 0, 0, time.UTC)},
+		{"strategy=below_absolute&threshold=115.5", true, time.Date(2026, time.January, 13, 15, 0, 0, 0, time.UTC)},
+		{"strategy=below_absolute&threshold=11.55&unit=ct/kWh", true, time.Date(2026, time.January, 13, 15, 0, 0, 0, time.UTC)},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res := get(t, s, "/price/signal?"+tt.query)
+			wantStatus(t, res, http.StatusOK)
+			got := decode[struct {
+				Consume bool      `json:"consume"`
+				Until   time.Time `json:"until"`
+			}](t, res)
+			if got.Consume != tt.consume || !got.Until.Equal(tt.until) {
+				t.Errorf("got consume %t until %s, want %t until %s", got.Consume, got.Until, tt.consume, tt.until)
+			}
+		})
+	}
+}
+
+func TestSignalInvalid(t *testing.T) {
+	tests := []struct {
+		query string
+		msg   string
+	}{
+		{"", "strategy: expected one of below_absolute, below_daily_median, cheapest_n_today"},
+		{"strategy=cheapest", "strategy: expected one of below_absolute, below_daily_median, cheapest_n_today"},
+		{"strategy=cheapest_n_today", "n: expected a non-negative integer"},
+		{"strategy=cheapest_n_today&n=-1", "n: expected a non-negative integer"},
+		{"strategy=below_absolute", "threshold: expected a number"},
+		{"strategy=below_absolute&threshold=NaN", "threshold: invalid number"},
+		{"strategy=below_absolute&threshold=cheap", "threshold: invalid number"},
+		{"strategy=below_daily_median&unit=W", "unknown unit"},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			wantError(t, get(t, s, "/price/signal?"+tt.query), http.StatusBadRequest, codeInvalidParameter, tt.msg)
+		})
+	}
+}
+
+func TestSignalUncovered(t *testing.T) {
+	s := newTestServer(t)
+	s.setClock(newFakeClock(testNow.AddDate(0, 0, 3)))
+	wantError(t, get(t, s, "/price/signal?strategy=below_daily_median"), http.StatusNotFound, codeNotFound, "no price")
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxSkewRoundTrip is the longest upstream round trip whose Date header is
+// used to estimate the clock skew; slower responses say too little about
+// when the upstream read its clock.
+const maxSkewRoundTrip = 10 * time.Second
+
+// estimateSkew returns how far the upstream's clock, as given by the Date
+// header of a response to a request sent at sent and received at received,
+// is ahead of the host's. The header has a resolution of a second, so the
+// upstream is taken to have read its clock in the middle of that second and
+// of the round trip.
+func estimateSkew(sent, received time.Time, date string) (time.Duration, bool) {
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return 0, false
+	}
+	rtt := received.Sub(sent)
+	if rtt < 0 || rtt > maxSkewRoundTrip {
+		return 0, false
+	}
+	return t.Add(time.Second / 2).Sub(sent.Add(rtt / 2)), true
+}
+
+// clockSkew keeps the last estimate of how far the upstream's clock is
+// ahead of the host's, warning while it exceeds threshold.
+type clockSkew struct {
+	threshold time.Duration
+
+	mu       sync.Mutex
+	offset   time.Duration
+	measured bool
+	warned   bool
+}
+
+// observe records a new estimate.
+func (c *clockSkew) observe(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset, c.measured = offset, true
+	switch exceeded := c.exceeded(); {
+	case exceeded && !c.warned:
+		direction := "behind"
+		if offset < 0 {
+			direction = "ahead of"
+		}
+		log.Printf("warning: the host clock is %s %s the upstream's; current prices, day boundaries and staleness are off by as much unless -correct-clock is set",
+			abs(offset).Round(time.Second), direction)
+		c.warned = true
+	case !exceeded && c.warned:
+		log.Printf("the host clock is back within %s of the upstream's", c.threshold)
+		c.warned = false
+	}
+}
+
+// exceeded reports whether the last estimate exceeds the threshold. c.mu
+// must be held.
+func (c *clockSkew) exceeded() bool {
+	return c.measured && abs(c.offset) > c.threshold
+}
+
+// get returns the last estimate, whether there is one and whether it
+// exceeds the threshold.
+func (c *clockSkew) get() (offset time.Duration, measured, exceeded bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset, c.measured, c.exceeded()
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// skewTransport estimates the clock skew from the responses it passes on.
+type skewTransport struct {
+	next http.RoundTripper
+	skew *clockSkew
+}
+
+func (t skewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if offset, ok := estimateSkew(sent, time.Now(), res.Header.Get("Date")); ok {
+		t.skew.observe(offset)
+	}
+	return res, nil
+}
+
+// correctedClock is a clock whose Now follows the upstream's rather than
+// the host's while the skew between them exceeds the threshold. Below it
+// the estimate, which has a resolution of about a second, is left alone.
+// Durations aren't affected by the skew.
+type correctedClock struct {
+	clock
+	skew *clockSkew
+}
+
+func (c correctedClock) Now() time.Time {
+	now := c.clock.Now()
+	if offset, _, exceeded := c.skew.get(); exceeded {
+		return now.Add(offset)
+	}
+	return now
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateSkew(t *testing.T) {
+	sent := time.Date(2026, time.January, 13, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		received time.Time
+		date     string
+		skew     time.Duration
+		ok       bool
+	}{
+		// The upstream read its clock at 12:00:00.5 by the header, and at
+		// 12:00:00.2 by the round trip.
+		{"in sync", sent.Add(400 * time.Millisecond), "Tue, 13 Jan 2026 12:00:00 GMT", 300 * time.Millisecond, true},
+		{"upstream ahead", sent.Add(400 * time.Millisecond), "Tue, 13 Jan 2026 12:05:00 GMT", 5*time.Minute + 300*time.Millisecond, true},
+		{"upstream behind", sent.Add(2 * time.Second), "Tue, 13 Jan 2026 11:59:00 GMT", -time.Minute - 500*time.Millisecond, true},
+		{"slowest round trip", sent.Add(maxSkewRoundTrip), "Tue, 13 Jan 2026 12:00:05 GMT", 500 * time.Millisecond, true},
+		{"slow round trip", sent.Add(maxSkewRoundTrip + time.Millisecond), "Tue, 13 Jan 2026 12:00:05 GMT", 0, false},
+		{"received before sent", sent.Add(-time.Millisecond), "Tue, 13 Jan 2026 12:00:00 GMT", 0, false},
+		{"no date", sent.Add(time.Second), "", 0, false},
+		{"invalid date", sent.Add(time.Second), "2026-01-13T12:00:00Z", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skew, ok := estimateSkew(sent, tt.received, tt.date)
+			if skew != tt.skew || ok != tt.ok {
+				t.Errorf("skew %s, %t, want %s, %t", skew, ok, tt.skew, tt.ok)
+			}
+		})
+	}
+}
+
+// TestClockSkew observes estimates around the threshold, which are warned
+// about once while exceeded and correct the clock while they are.
+func TestClockSkew(t *testing.T) {
+	logged := captureLog(t)
+	skew := &clockSkew{threshold: time.Minute}
+	clk := correctedClock{newFakeClock(testNow), skew}
+	if offset, measured, exceeded := skew.get(); offset != 0 || measured || exceeded {
+		t.Errorf("before an estimate: %s, measured %t, exceeded %t", offset, measured, exceeded)
+	}
+
+	steps := []struct {
+		offset   time.Duration
+		exceeded bool
+		log      string
+	}{
+		{30 * time.Second, false, ""},
+		{time.Minute, false, ""},
+		{-2 * time.Minute, true, "warning: the host clock is 2m0s ahead of the upstream's"},
+		// Still exceeded, so not warned about again.
+		{90 * time.Minute, true, ""},
+		{-time.Second, false, "the host clock is back within 1m0s of the upstream's"},
+		{2 * time.Minute, true, "warning: the host clock is 2m0s behind the upstream's"},
+	}
+	for _, step := range steps {
+		before := len(logged.String())
+		skew.observe(step.offset)
+		if offset, measured, exceeded := skew.get(); offset != step.offset || !measured || exceeded != step.exceeded {
+			t.Errorf("after observing %s: %s, measured %t, exceeded %t", step.offset, offset, measured, exceeded)
+		}
+		if got := logged.String()[before:]; step.log == "" && got != "" || !strings.Contains(got, step.log) {
+			t.Errorf("after observing %s, logged %q, want %q", step.offset, got, step.log)
+		}
+		want := testNow
+		if step.exceeded {
+			want = testNow.Add(step.offset)
+		}
+		if now := clk.Now(); !now.Equal(want) {
+			t.Errorf("after observing %s, now is %s, want %s", step.offset, now, want)
+		}
+	}
+}
+
+// TestClockSkewHealth fetches from an upstream whose Date header is five
+// minutes ahead, which degrades /healthz unless the clock is corrected.
+func TestClockSkewHealth(t *testing.T) {
+	mock := newTestUpstream(t)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(5*time.Minute).UTC().Format(http.TimeFormat))
+		mock.ServeHTTP(w, r)
+	}))
+	defer up.Close()
+
+	type health struct {
+		Status         string   `json:"status"`
+		ClockSkew      *float64 `json:"clock_skew_seconds"`
+		ClockCorrected bool     `json:"clock_corrected"`
+	}
+	for _, tt := range []struct {
+		name    string
+		correct bool
+		status  string
+	}{
+		{"uncorrected", false, "degraded"},
+		{"corrected", true, "ok"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-upstream-url", up.URL}
+			if tt.correct {
+				args = append(args, "-correct-clock")
+			}
+			s := newTestServer(t, args...)
+			if h := decode[health](t, get(t, s, "/healthz")); h.Status != "ok" || h.ClockSkew != nil || h.ClockCorrected {
+				t.Errorf("before fetching: %+v", h)
+			}
+			if _, err := s.upstream.fetchOnce(context.Background(), testFirstDay, testFirstDay.AddDate(0, 0, 1), nil); err != nil {
+				t.Fatal(err)
+			}
+			h := decode[health](t, get(t, s, "/healthz"))
+			if h.Status != tt.status || h.ClockSkew == nil || *h.ClockSkew < 4*60 || *h.ClockSkew > 6*60 || h.ClockCorrected != tt.correct {
+				t.Errorf("after fetching: status %s, skew %v, corrected %t, want %s, about 300 and %t", h.Status, h.ClockSkew, h.ClockCorrected, tt.status, tt.correct)
+			}
+			if v, _ := metricValue(s.metrics, "energy_prices_clock_skew_seconds"); v < 4*60 || v > 6*60 {
+				t.Errorf("energy_prices_clock_skew_seconds = %v, want about 300", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// slotCheckInterval bounds the wait for the next slot boundary. Timers don't
+// advance while the host is suspended, so the current slot is looked up from
+// the wall clock at least this often instead of trusting a single long timer.
+const slotCheckInterval = time.Minute
+
+// slotChange is the data of slot_changed events. PreviousPrice is null if the
+// price before the change was unknown.
+type slotChange struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Price         float64   `json:"price"`
+	PreviousPrice *float64  `json:"previous_price"`
+}
+
+// watchSlots publishes a slot_changed event whenever the current time enters
+// another cached slot, and a price_unknown event when it enters a time that
+// isn't cached, until ctx is done.
+//
+// Slots are tracked by their start instant rather than by local time, so DST
+// transitions need no special handling. After a suspend or a late timer, a
+// single event announces the slot the clock is in by then.
+func (s *server) watchSlots(ctx context.Context) {
+	var current *pricePoint
+	unknown := false
+	for first := true; ; first = false {
+		now := s.clock.Now()
+		wait := slotCheckInterval
+
+		p, ok := s.slotAt(now)
+		switch {
+		case ok && (current == nil || !p.Start.Equal(current.Start)):
+			if !first {
+				e := slotChange{Start: p.Start.UTC(), End: p.Start.Add(p.Duration).UTC(), Price: p.Price}
+				if current != nil {
+					e.PreviousPrice = &current.Price
+				}
+				s.events.publish(event{Name: "slot_changed", Data: e})
+			}
+			current, unknown = &p, false
+		case !ok && !unknown:
+			if !first {
+				e := struct {
+					Time          time.Time `json:"time"`
+					PreviousPrice *float64  `json:"previous_price"`
+				}{Time: now.UTC()}
+				if current != nil {
+					e.PreviousPrice = &current.Price
+				}
+				s.events.publish(event{Name: "price_unknown", Data: e})
+			}
+			current, unknown = nil, true
+		}
+		if ok {
+			wait = min(wait, p.Start.Add(p.Duration).Sub(now))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(wait):
+		}
+	}
+}
+
+// slotAt returns the cached slot containing t.
+func (s *server) slotAt(t time.Time) (pricePoint, bool) {
+	return s.store.at(t)
+}
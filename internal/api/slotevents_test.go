@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// slotEvent is the data of slot_changed and price_unknown events, with the
+// clock time at which it was published.
+type slotEvent struct {
+	Name          string
+	At            time.Time
+	Start         time.Time `json:"start"`
+	Time          time.Time `json:"time"`
+	Price         float64   `json:"price"`
+	PreviousPrice *float64  `json:"previous_price"`
+}
+
+// slotWatcher runs watchSlots on a server with prices on a fake clock
+// starting at now.
+type slotWatcher struct {
+	t      *testing.T
+	s      *server
+	clk    *fakeClock
+	events <-chan event
+}
+
+func newSlotWatcher(t *testing.T, now time.Time, prices map[time.Time]float64) *slotWatcher {
+	s := newTestServer(t, "-no-ondemand")
+	s.store.merge(prices, originRefresh, upstreamProvider)
+	clk := newFakeClock(now)
+	s.setClock(clk)
+	events, unsubscribe := s.events.subscribe(0)
+	t.Cleanup(unsubscribe)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.watchSlots(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	clk.WaitTimers(t, 1)
+	return &slotWatcher{t, s, clk, events}
+}
+
+// step moves the clock to t, and waits for the watcher to wait again.
+func (w *slotWatcher) step(t time.Time) {
+	w.t.Helper()
+	w.clk.Set(t)
+	w.clk.WaitTimers(w.t, 1)
+}
+
+// published returns the events published so far.
+func (w *slotWatcher) published() []slotEvent {
+	w.t.Helper()
+	var got []slotEvent
+	for {
+		select {
+		case e := <-w.events:
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				w.t.Fatal(err)
+			}
+			se := slotEvent{Name: e.Name, At: w.clk.Now()}
+			if err := json.Unmarshal(data, &se); err != nil {
+				w.t.Fatal(err)
+			}
+			got = append(got, se)
+		default:
+			return got
+		}
+	}
+}
+
+// wantSlotEvents fails t unless got are the events of want.
+func wantSlotEvents(t *testing.T, got []slotEvent, want []slotEvent) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i, g := range got {
+		w := want[i]
+		if g.Name != w.Name || !g.At.Equal(w.At) || !g.Start.Equal(w.Start) || !g.Time.Equal(w.Time) || g.Price != w.Price || fmtPrice(g.PreviousPrice) != fmtPrice(w.PreviousPrice) {
+			t.Errorf("event %d: %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+// TestSlotEventsDST steps through the switches to and from summer time a
+// minute at a time, which announce each slot once at its start, whether it
+// starts at a skipped or a repeated local hour.
+func TestSlotEventsDST(t *testing.T) {
+	price := func(p float64) *float64 { return &p }
+	tests := []struct {
+		name string
+		// first is the start of the first of five hourly slots, priced 0
+		// to 4, from half an hour into which the clock runs 5 hours.
+		first time.Time
+	}{
+		// From 01:00 CET, announcing 03:00, 04:00, 05:00 and 06:00 CEST.
+		{"summer time", time.Date(2026, time.March, 29, 0, 0, 0, 0, time.UTC)},
+		// From 01:00 CEST, announcing 02:00 CEST, 02:00, 03:00 and 04:00 CET.
+		{"winter time", time.Date(2026, time.October, 24, 23, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices := map[time.Time]float64{}
+			for i := range 5 {
+				prices[tt.first.Add(time.Duration(i)*time.Hour)] = float64(i)
+			}
+			w := newSlotWatcher(t, tt.first.Add(30*time.Minute), prices)
+			var got []slotEvent
+			for now := w.clk.Now(); now.Before(tt.first.Add(5*time.Hour + 30*time.Minute)); {
+				now = now.Add(time.Minute)
+				w.step(now)
+				got = append(got, w.published()...)
+			}
+			wantSlotEvents(t, got, []slotEvent{
+				{Name: "slot_changed", At: tt.first.Add(time.Hour), Start: tt.first.Add(time.Hour), Price: 1, PreviousPrice: price(0)},
+				{Name: "slot_changed", At: tt.first.Add(2 * time.Hour), Start: tt.first.Add(2 * time.Hour), Price: 2, PreviousPrice: price(1)},
+				{Name: "slot_changed", At: tt.first.Add(3 * time.Hour), Start: tt.first.Add(3 * time.Hour), Price: 3, PreviousPrice: price(2)},
+				{Name: "slot_changed", At: tt.first.Add(4 * time.Hour), Start: tt.first.Add(4 * time.Hour), Price: 4, PreviousPrice: price(3)},
+				// Once, when the prices run out.
+				{Name: "price_unknown", At: tt.first.Add(5 * time.Hour), Time: tt.first.Add(5 * time.Hour), PreviousPrice: price(4)},
+			})
+		})
+	}
+}
+
+// TestSlotEventsSuspend jumps the clock forward as a host resuming from
+// suspend sees it, which announces only the slot it is in by then, and
+// checks that the watcher waits for the next boundary again.
+func TestSlotEventsSuspend(t *testing.T) {
+	price := func(p float64) *float64 { return &p }
+	w := newSlotWatcher(t, testNow, nil)
+
+	// From 15:30 to 18:40 local time.
+	resumed := testNow.Add(3*time.Hour + 10*time.Minute)
+	w.step(resumed)
+	slot := resumed.Truncate(time.Hour)
+	wantSlotEvents(t, w.published(), []slotEvent{
+		{Name: "slot_changed", At: resumed, Start: slot, Price: testPrice(slot), PreviousPrice: price(testPrice(testNow.Truncate(time.Hour)))},
+	})
+	next := slot.Add(time.Hour)
+	for now := resumed; now.Before(next); {
+		now = now.Add(time.Minute)
+		w.step(now)
+	}
+	wantSlotEvents(t, w.published(), []slotEvent{
+		{Name: "slot_changed", At: next, Start: next, Price: testPrice(next), PreviousPrice: price(testPrice(slot))},
+	})
+
+	// Resuming after the cached prices end announces that once, and the
+	// prices merged in the meantime at the next check.
+	resumed = testFirstDay.AddDate(0, 0, testDays+1).Add(30 * time.Second).UTC()
+	w.step(resumed)
+	w.s.store.merge(map[time.Time]float64{resumed.Truncate(time.Hour): 42}, originRefresh, upstreamProvider)
+	got := w.published()
+	checked := resumed.Add(slotCheckInterval)
+	w.step(checked)
+	wantSlotEvents(t, append(got, w.published()...), []slotEvent{
+		{Name: "price_unknown", At: resumed, Time: resumed, PreviousPrice: price(testPrice(next))},
+		{Name: "slot_changed", At: checked, Start: resumed.Truncate(time.Hour), Price: 42},
+	})
+}
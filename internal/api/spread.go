@@ -0,0 +1,86 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+type daySpread struct {
+	Date            string  `json:"date"`
+	Min             float64 `json:"min"`
+	MinTime         int64   `json:"min_time"`
+	Max             float64 `json:"max"`
+	MaxTime         int64   `json:"max_time"`
+	Spread          float64 `json:"spread"`
+	EffectiveSpread float64 `json:"effective_spread"`
+}
+
+// handleSpread reports the difference between the highest and the lowest
+// price of each day. The effective spread is what remains of buying at the
+// minimum and selling at the maximum with the given round-trip efficiency.
+func (s *server) handleSpread(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	efficiency := 1.0
+	if v := q.Get("efficiency"); v != "" {
+		efficiency, err = strconv.ParseFloat(v, 64)
+		if err != nil || efficiency <= 0 || efficiency > 1 {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("efficiency: expected a number in (0, 1], got %q", v))
+			return
+		}
+	}
+
+	order := q.Get("order")
+	if order != "" && order != "date" && order != "spread" {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("order: expected date or spread, got %q", order))
+		return
+	}
+
+	days := groupByDay(s.store.points(start, end), s.loc)
+	spreads := make([]daySpread, 0, len(days))
+	for _, d := range days {
+		lo, hi := d.Points[0], d.Points[0]
+		for _, p := range d.Points {
+			if p.Price < lo.Price {
+				lo = p
+			}
+			if p.Price > hi.Price {
+				hi = p
+			}
+		}
+		spreads = append(spreads, daySpread{
+			Date:            d.Date,
+			Min:             lo.Price / div,
+			MinTime:         lo.Start.Unix(),
+			Max:             hi.Price / div,
+			MaxTime:         hi.Start.Unix(),
+			Spread:          (hi.Price - lo.Price) / div,
+			EffectiveSpread: (hi.Price*efficiency - lo.Price) / div,
+		})
+	}
+
+	if order == "spread" {
+		slices.SortStableFunc(spreads, func(a, b daySpread) int {
+			return cmp.Compare(b.Spread, a.Spread)
+		})
+	}
+
+	writeJSON(w, struct {
+		Unit       string      `json:"unit"`
+		Efficiency float64     `json:"efficiency"`
+		Days       []daySpread `json:"days"`
+	}{unit, efficiency, spreads})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// staleCheckInterval is how often watchStaleness evaluates the cache.
+	staleCheckInterval = time.Minute
+	// staleAlertRepeat is the minimum time between two warnings about the
+	// same stale condition.
+	staleAlertRepeat = time.Hour
+)
+
+// staleAlert tracks whether the cache reaches far enough into the future,
+// deciding which log lines to emit for it.
+type staleAlert struct {
+	horizon time.Duration
+
+	active   bool
+	lastWarn time.Time
+}
+
+// check evaluates the cache at now and returns the line to log, if any: a
+// warning when the data ends before now plus the horizon, repeated at most
+// every staleAlertRepeat, and a notice once it reaches far enough again.
+func (a *staleAlert) check(now time.Time, m storeMeta, st refreshState) string {
+	if m.Through.After(now.Add(a.horizon)) {
+		if !a.active {
+			return ""
+		}
+		a.active = false
+		return fmt.Sprintf("cached prices reach through %s again, %s ahead", m.Through.UTC().Format(time.RFC3339), m.Through.Sub(now).Round(time.Minute))
+	}
+
+	if a.active && now.Sub(a.lastWarn) < staleAlertRepeat {
+		return ""
+	}
+	a.active, a.lastWarn = true, now
+
+	msg := "warning: no cached prices"
+	if !m.Through.IsZero() {
+		msg = fmt.Sprintf("warning: cached prices end at %s", m.Through.UTC().Format(time.RFC3339))
+		if left := m.Through.Sub(now); left > 0 {
+			msg += fmt.Sprintf(", %s ahead", left.Round(time.Minute))
+		} else {
+			msg += fmt.Sprintf(", %s ago", (-left).Round(time.Minute))
+		}
+	}
+	msg += fmt.Sprintf(", less than the alert horizon of %s", formatDuration(a.horizon))
+	if !m.LastRefresh.IsZero() {
+		msg += fmt.Sprintf("; last refresh %s ago", now.Sub(m.LastRefresh).Round(time.Second))
+	}
+	if st.ConsecutiveFailures > 0 {
+		msg += "; last refresh error: " + st.LastError
+	}
+	return msg
+}
+
+// watchStaleness logs when the cached prices stop covering the configured
+// alert horizon ahead of now, until ctx is done.
+func (s *server) watchStaleness(ctx context.Context) {
+	alert := &staleAlert{horizon: s.cfg.staleHorizon}
+	for {
+		if msg := alert.check(s.clock.Now(), s.store.meta(), s.refreshStatus.get()); msg != "" {
+			log.Print(msg)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(staleCheckInterval):
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWatchStaleness runs the staleness watcher on a fakeClock while the
+// cache ends within the alert horizon, which is warned about at most once
+// an hour, and until prices are added that reach beyond it, which clears
+// the alert.
+func TestWatchStaleness(t *testing.T) {
+	s := newTestServer(t, "-stale-alert-horizon", "36h")
+	clk := newFakeClock(testNow)
+	s.setClock(clk)
+	logged := captureLog(t)
+	// lines returns the logged lines starting with prefix.
+	lines := func(prefix string) []string {
+		var matched []string
+		for _, line := range strings.Split(logged.String(), "\n") {
+			if _, msg, ok := strings.Cut(line, " "+prefix); ok {
+				matched = append(matched, prefix+msg)
+			}
+		}
+		return matched
+	}
+	const warning = "warning: cached prices end at "
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.watchStaleness(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// testPrices end 32h30m after testNow, within the horizon.
+	clk.WaitTimers(t, 1)
+	want := "warning: cached prices end at 2026-01-14T23:00:00Z, 32h30m0s ahead, less than the alert horizon of 36h0m0s"
+	if got := lines(warning); len(got) != 1 || !strings.HasPrefix(got[0], want) {
+		t.Fatalf("logged %q, want a warning %q", got, want)
+	}
+
+	// The warning isn't repeated within the hour, but once it has passed.
+	for range 59 {
+		clk.Advance(staleCheckInterval)
+		clk.WaitTimers(t, 1)
+	}
+	if got := lines(warning); len(got) != 1 {
+		t.Fatalf("after 59 minutes, %d warnings logged, want 1: %q", len(got), got)
+	}
+	clk.Advance(staleCheckInterval)
+	clk.WaitTimers(t, 1)
+	want = "warning: cached prices end at 2026-01-14T23:00:00Z, 31h30m0s ahead"
+	if got := lines(warning); len(got) != 2 || !strings.HasPrefix(got[1], want) {
+		t.Fatalf("after an hour, logged %q, want a second warning %q", got, want)
+	}
+
+	// Prices of the next day reach beyond the horizon, which clears the
+	// alert at the next check, and no more is logged after.
+	next := make(map[time.Time]float64)
+	for t := testFirstDay.AddDate(0, 0, testDays); t.Before(testFirstDay.AddDate(0, 0, testDays+1)); t = t.Add(time.Hour) {
+		next[t.UTC()] = testPrice(t)
+	}
+	s.store.merge(next, originRefresh, upstreamProvider)
+	clk.Advance(staleCheckInterval)
+	clk.WaitTimers(t, 1)
+	cleared := "cached prices reach through 2026-01-15T23:00:00Z again, 55h29m0s ahead"
+	if got := lines("cached prices reach through "); len(got) != 1 || got[0] != cleared {
+		t.Fatalf("logged %q, want %q", got, cleared)
+	}
+	before := logged.String()
+	for range 2 * 60 {
+		clk.Advance(staleCheckInterval)
+		clk.WaitTimers(t, 1)
+	}
+	if after := logged.String(); after != before {
+		t.Errorf("logged after the alert cleared: %q", strings.TrimPrefix(after, before))
+	}
+}
@@ -0,0 +1,580 @@
+package api
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/schedule"
+)
+
+// store is the in-memory price cache shared by the refresher and the handlers.
+type store struct {
+	mu sync.RWMutex
+
+	// slots are sorted by start time, without two slots starting at the
+	// same instant. The slice is never modified in place, only replaced, so
+	// that scan can keep reading a snapshot of it without the lock.
+	slots []storedSlot
+
+	earliest    time.Time
+	latest      time.Time
+	lastRefresh time.Time
+
+	// generation counts the merges that changed the cache.
+	generation uint64
+	// previous is a frozen copy of the cache from before the last change,
+	// kept so that requests pinned to its generation can still be answered.
+	previous *store
+	// changelog lists the slots changed by each of the last
+	// changelogGenerations generations, oldest first, see changedSince.
+	changelog []generationChange
+
+	// gaps are the holes in slots, kept up to date by every change so that
+	// reading them is cheap.
+	gaps []gap
+	// sources counts the slots by their source, recomputed whenever the
+	// slots are replaced.
+	sources map[string]int
+	// settled are the starts of the settled days in Unix seconds, see
+	// fetchUnsettled.
+	settled map[int64]bool
+
+	// invalidated are ranges removed from the cache that the next refresh
+	// fetches again.
+	invalidated []timeRange
+
+	// modified is the HTTP Last-Modified time of the cache. It has second
+	// granularity and increases with every merge that changes the cache, even
+	// with several merges within the same second, so that If-Modified-Since
+	// can't miss updates.
+	modified time.Time
+
+	// clock is the source of the merge, refresh and modification times.
+	clock clock
+}
+
+func newStore() *store {
+	return &store{sources: map[string]int{}, clock: systemClock{}}
+}
+
+// storedSlot is a cached price together with its metadata.
+type storedSlot struct {
+	Start time.Time
+	Price float64
+	slotMeta
+}
+
+// find returns the index of the slot starting at t, or where it would be
+// inserted, and whether it exists. The caller must hold the lock.
+func (s *store) find(t time.Time) (int, bool) {
+	return slices.BinarySearchFunc(s.slots, t, func(slot storedSlot, t time.Time) int { return slot.Start.Compare(t) })
+}
+
+// slotMeta records where and when the price of a slot was last changed, and
+// whether it replaced a different price.
+type slotMeta struct {
+	Origin   string
+	MergedAt time.Time
+	// Source is the provider the price is from, such as upstreamProvider.
+	Source string
+
+	// Revised is set once a merge changed the price of the slot, e.g. when
+	// the upstream corrected a provisional value. PreviousPrice is the price
+	// before the latest revision.
+	Revised       bool
+	PreviousPrice float64
+
+	// ShadowedSource is set if another source than Source had a different
+	// price for the slot, ShadowedPrice, which was overridden, see merge.
+	ShadowedSource string
+	ShadowedPrice  float64
+}
+
+// Origins of slotMeta.
+const (
+	originRefresh   = "refresh"
+	originSync      = "sync"
+	originOnDemand  = "ondemand"
+	originCacheFile = "cache_file"
+)
+
+// timeRange is the half-open interval [Start, End).
+type timeRange struct {
+	Start, End time.Time
+}
+
+// mergeResult counts the slots of a merge by their effect on the cache.
+type mergeResult struct {
+	Added     int
+	Updated   int
+	Unchanged int
+}
+
+// changed reports whether the merge modified the cache.
+func (r mergeResult) changed() bool {
+	return r.Added > 0 || r.Updated > 0
+}
+
+// merge adds prices of source from origin to the cache. Between prices of the
+// same source, the last write wins: a price for an instant already cached
+// replaces the cached one, and is reported as an update if it differs.
+//
+// Between sources, upstreamProvider wins: its prices replace those of other
+// sources, and prices of other sources don't replace its own. In both cases
+// a different price of the losing source is recorded as shadowed. Between
+// other sources than upstreamProvider, the last write wins again, shadowing
+// the price it replaces. The
+// generation and modification time only advance if a slot was added or its
+// price changed.
+//
+// Map keys in different locations can denote the same instant. Of the
+// prices of such keys, the highest is merged, so that the result doesn't
+// depend on the order of the map. Slots are kept in UTC.
+//
+// The prices are sorted and then merged with the sorted slots in one pass,
+// in O(n+m) for n slots and m prices besides the sorting.
+func (s *store) merge(prices map[time.Time]float64, origin, source string) mergeResult {
+	in := make([]pricePoint, 0, len(prices))
+	for t, p := range prices {
+		in = append(in, pricePoint{Start: t.UTC(), Price: p})
+	}
+	slices.SortFunc(in, func(a, b pricePoint) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.Price, b.Price))
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res mergeResult
+	// shadowed is set if only the metadata of a slot changed.
+	var shadowed bool
+	var changed []time.Time
+	now := s.clock.Now()
+	merged := make([]storedSlot, 0, len(s.slots)+len(in))
+	i, j := 0, 0
+	for i < len(s.slots) || j < len(in) {
+		switch {
+		case j == len(in) || i < len(s.slots) && s.slots[i].Start.Before(in[j].Start):
+			merged = append(merged, s.slots[i])
+			i++
+		case j+1 < len(in) && in[j+1].Start.Equal(in[j].Start):
+			// The last of the prices of an instant is the highest.
+			j++
+		case i < len(s.slots) && s.slots[i].Start.Equal(in[j].Start):
+			old := s.slots[i]
+			switch {
+			case old.Price == in[j].Price:
+				res.Unchanged++
+				merged = append(merged, old)
+			case old.Source == upstreamProvider && source != upstreamProvider:
+				res.Unchanged++
+				old.ShadowedSource, old.ShadowedPrice = source, in[j].Price
+				merged = append(merged, old)
+				shadowed = true
+			default:
+				res.Updated++
+				slot := storedSlot{Start: old.Start, Price: in[j].Price, slotMeta: slotMeta{Origin: origin, MergedAt: now, Source: source}}
+				slot.Revised, slot.PreviousPrice = true, old.Price
+				if old.Source != source {
+					slot.ShadowedSource, slot.ShadowedPrice = old.Source, old.Price
+				}
+				merged = append(merged, slot)
+				changed = append(changed, slot.Start)
+			}
+			i++
+			j++
+		default:
+			res.Added++
+			merged = append(merged, storedSlot{Start: in[j].Start, Price: in[j].Price, slotMeta: slotMeta{Origin: origin, MergedAt: now, Source: source}})
+			changed = append(changed, in[j].Start)
+			j++
+		}
+	}
+	if res.changed() {
+		s.previous = s.frozen()
+	}
+	if res.changed() || shadowed {
+		s.slots = merged
+		s.earliest, s.latest = merged[0].Start, merged[len(merged)-1].Start
+		s.sources = countSources(merged)
+	}
+	if res.Added > 0 {
+		s.gaps = findGaps(s.slots)
+	}
+	s.lastRefresh = now
+	if res.changed() {
+		s.changed()
+		s.logChange(changed)
+	}
+	return res
+}
+
+// touch records a refresh that found the cached prices up to date.
+func (s *store) touch() {
+	s.mu.Lock()
+	s.lastRefresh = s.clock.Now()
+	s.mu.Unlock()
+}
+
+// changed advances the generation and modification time. The caller must
+// hold the write lock.
+func (s *store) changed() {
+	s.generation++
+	modified := s.clock.Now().Truncate(time.Second)
+	if !modified.After(s.modified) {
+		modified = s.modified.Add(time.Second)
+	}
+	s.modified = modified
+}
+
+// frozen returns a copy of the cache that later changes don't affect. It
+// shares the slots and gaps, which are only ever replaced. The caller must
+// hold the lock.
+func (s *store) frozen() *store {
+	return &store{
+		slots:       s.slots,
+		earliest:    s.earliest,
+		latest:      s.latest,
+		lastRefresh: s.lastRefresh,
+		generation:  s.generation,
+		gaps:        s.gaps,
+		sources:     s.sources,
+		modified:    s.modified,
+	}
+}
+
+// countSources returns the number of slots of each source.
+func countSources(slots []storedSlot) map[string]int {
+	counts := make(map[string]int)
+	for _, slot := range slots {
+		counts[slot.Source]++
+	}
+	return counts
+}
+
+// snapshot returns a frozen copy of the cache as of generation, which is
+// either the current one or the one before the last change.
+func (s *store) snapshot(generation uint64) (*store, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch {
+	case generation == s.generation:
+		return s.frozen(), true
+	case s.previous != nil && generation == s.previous.generation:
+		return s.previous, true
+	}
+	return nil, false
+}
+
+// remove drops the slots starting in [start, end) and returns their number.
+// The range is remembered for takeInvalidated.
+func (s *store) remove(start, end time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lo, _ := s.find(start)
+	hi, _ := s.find(end)
+	n := hi - lo
+	if n <= 0 {
+		return 0
+	}
+
+	s.previous = s.frozen()
+	s.slots = slices.Concat(s.slots[:lo], s.slots[hi:])
+	s.earliest, s.latest = time.Time{}, time.Time{}
+	if len(s.slots) > 0 {
+		s.earliest, s.latest = s.slots[0].Start, s.slots[len(s.slots)-1].Start
+	}
+	s.gaps = findGaps(s.slots)
+	s.sources = countSources(s.slots)
+	s.unsettle(start, end)
+	s.invalidated = append(s.invalidated, timeRange{start, end})
+	s.changed()
+	s.changelog = nil
+	return n
+}
+
+// gap is a hole in the cached slots.
+type gap struct {
+	timeRange
+	// Missing is the number of slots the hole is wide.
+	Missing int
+}
+
+// findGaps returns the holes between slots. Across a hole, slots are expected
+// at the wider spacing of the slots next to it, at most maxSlotDuration, so
+// that a change of the market's resolution isn't taken for a hole.
+func findGaps(slots []storedSlot) []gap {
+	spacing := func(i int) time.Duration {
+		if i < 0 || i+1 >= len(slots) {
+			return maxSlotDuration
+		}
+		return slots[i+1].Start.Sub(slots[i].Start)
+	}
+	var gaps []gap
+	for i := 1; i < len(slots); i++ {
+		res := min(max(spacing(i-2), spacing(i)), maxSlotDuration)
+		if d := spacing(i - 1); d > res {
+			start := slots[i-1].Start.Add(res)
+			gaps = append(gaps, gap{timeRange{start, slots[i].Start}, int(slots[i].Start.Sub(start) / res)})
+		}
+	}
+	return gaps
+}
+
+// coverage returns the holes in the cached slots and the spacing of the two
+// newest slots, maxSlotDuration with fewer.
+func (s *store) coverage() (gaps []gap, resolution time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resolution = maxSlotDuration
+	if n := len(s.slots); n >= 2 {
+		resolution = min(resolution, s.slots[n-1].Start.Sub(s.slots[n-2].Start))
+	}
+	return slices.Clone(s.gaps), resolution
+}
+
+// missing returns the parts of [start, end) not covered by cached slots:
+// before the earliest slot, the holes between slots and after the end of the
+// newest one. Open bounds extend to the edges of the cache.
+func (s *store) missing(start, end time.Time) []timeRange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.slots) == 0 {
+		if start.IsZero() || end.IsZero() {
+			return nil
+		}
+		return []timeRange{{start, end}}
+	}
+	through := s.through()
+	if start.IsZero() {
+		start = s.earliest
+	}
+	if end.IsZero() {
+		end = through
+	}
+
+	var missing []timeRange
+	add := func(from, to time.Time) {
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if from.Before(to) {
+			missing = append(missing, timeRange{from, to})
+		}
+	}
+	add(start, s.earliest)
+	for _, g := range s.gaps {
+		add(g.Start, g.End)
+	}
+	add(through, end)
+	return missing
+}
+
+// takeInvalidated returns and forgets the ranges removed since the last call.
+func (s *store) takeInvalidated() []timeRange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.invalidated
+	s.invalidated = nil
+	return r
+}
+
+// invalidate remembers ranges for takeInvalidated again, e.g. after fetching
+// them failed.
+func (s *store) invalidate(ranges ...timeRange) {
+	s.mu.Lock()
+	s.invalidated = append(s.invalidated, ranges...)
+	s.mu.Unlock()
+}
+
+// cachedSlot is a slot together with its metadata.
+type cachedSlot struct {
+	pricePoint
+	slotMeta
+}
+
+// describe adds the metadata of the cached slots to points. Points that
+// aren't cached have zero metadata.
+func (s *store) describe(points []pricePoint) []cachedSlot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	slots := make([]cachedSlot, len(points))
+	for i, p := range points {
+		slots[i] = cachedSlot{pricePoint: p}
+		if j, ok := s.find(p.Start); ok {
+			slots[i].slotMeta = s.slots[j].slotMeta
+		}
+	}
+	return slots
+}
+
+type storeMeta struct {
+	Slots       int
+	Earliest    time.Time
+	Latest      time.Time
+	LastRefresh time.Time
+	Generation  uint64
+	Modified    time.Time
+	// Sources counts the slots by source. The map must not be modified.
+	Sources map[string]int
+
+	// Through is the end of the newest slot.
+	Through time.Time
+}
+
+func (s *store) meta() storeMeta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return storeMeta{
+		Slots:       len(s.slots),
+		Earliest:    s.earliest,
+		Latest:      s.latest,
+		LastRefresh: s.lastRefresh,
+		Generation:  s.generation,
+		Modified:    s.modified,
+		Sources:     s.sources,
+		Through:     s.through(),
+	}
+}
+
+// through returns the end of the newest slot, assuming it lasts as long as
+// the gap to the slot before it.
+func (s *store) through() time.Time {
+	if s.latest.IsZero() {
+		return time.Time{}
+	}
+	for _, d := range []time.Duration{15 * time.Minute, 30 * time.Minute} {
+		if _, ok := s.find(s.latest.Add(-d)); ok {
+			return s.latest.Add(d)
+		}
+	}
+	return s.latest.Add(maxSlotDuration)
+}
+
+// tomorrowAvailable reports whether a cache whose newest slot starts at latest
+// covers the whole day after now, i.e. reaches the last hour of that day in loc.
+func tomorrowAvailable(latest, now time.Time, loc *time.Location) bool {
+	y, m, d := now.In(loc).Date()
+	lastHour := time.Date(y, m, d+1, 23, 0, 0, 0, loc)
+	return !latest.Before(lastHour)
+}
+
+// pricePoint is a single price slot, of the type the schedule algorithms
+// take.
+type pricePoint = schedule.PricePoint
+
+// maxSlotDuration is the coarsest resolution the upstream publishes.
+const maxSlotDuration = time.Hour
+
+// points returns the cached slots starting in [start, end) sorted by start
+// time. A zero start or end leaves that side of the range open.
+//
+// Slot durations are not stored; a slot lasts until the closer of its
+// neighbours, capped at maxSlotDuration so that gaps in the data don't
+// stretch the slots around them.
+func (s *store) points(start, end time.Time) []pricePoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lo, hi := s.bounds(start, end)
+	if lo > hi {
+		return nil
+	}
+
+	points := make([]pricePoint, hi-lo)
+	for i := lo; i < hi; i++ {
+		points[i-lo] = slotPoint(s.slots, i)
+	}
+	return points
+}
+
+// count returns the number of cached slots starting in [start, end), with
+// zero bounds open like for points.
+func (s *store) count(start, end time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lo, hi := s.bounds(start, end)
+	return max(hi-lo, 0)
+}
+
+// bounds returns the index range of the slots starting in [start, end), with
+// zero bounds open like for points. The caller must hold the lock.
+func (s *store) bounds(start, end time.Time) (lo, hi int) {
+	lo, hi = 0, len(s.slots)
+	if !start.IsZero() {
+		lo, _ = s.find(start)
+	}
+	if !end.IsZero() {
+		hi, _ = s.find(end)
+	}
+	return lo, hi
+}
+
+// slotPoint returns slots[i] as a point lasting until the closer of its
+// neighbours, capped at maxSlotDuration.
+func slotPoint(slots []storedSlot, i int) pricePoint {
+	d := maxSlotDuration
+	if i > 0 {
+		d = min(d, slots[i].Start.Sub(slots[i-1].Start))
+	}
+	if i < len(slots)-1 {
+		d = min(d, slots[i+1].Start.Sub(slots[i].Start))
+	}
+	return pricePoint{Start: slots[i].Start, Duration: d, Price: slots[i].Price}
+}
+
+// scan yields the cached slots starting in [start, end) like points, with
+// their metadata, but without copying them. It reads the slots as they were
+// when iteration began; merges while iterating don't affect it.
+func (s *store) scan(start, end time.Time) iter.Seq[cachedSlot] {
+	return func(yield func(cachedSlot) bool) {
+		s.mu.RLock()
+		slots := s.slots
+		lo, hi := s.bounds(start, end)
+		s.mu.RUnlock()
+
+		for i := lo; i < hi; i++ {
+			if !yield(cachedSlot{pricePoint: slotPoint(slots, i), slotMeta: slots[i].slotMeta}) {
+				return
+			}
+		}
+	}
+}
+
+// at returns the slot containing t. Slots last as for points, from their
+// start up to but excluding their end, so only those starting up to
+// maxSlotDuration before t can contain it.
+func (s *store) at(t time.Time) (pricePoint, bool) {
+	return slotContaining(s.points(t.Add(-maxSlotDuration), t.Add(time.Nanosecond)), t)
+}
+
+// slotContaining floors t to the start of its slot among points, which must
+// be sorted by time. It reports false if no slot contains t.
+func slotContaining(points []pricePoint, t time.Time) (pricePoint, bool) {
+	i, found := slices.BinarySearchFunc(points, t, func(p pricePoint, t time.Time) int { return p.Start.Compare(t) })
+	if !found {
+		if i == 0 {
+			return pricePoint{}, false
+		}
+		i--
+	}
+	p := points[i]
+	if !t.Before(p.Start.Add(p.Duration)) {
+		return pricePoint{}, false
+	}
+	return p, true
+}
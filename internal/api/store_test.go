@@ -0,0 +1,267 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestMergeProperties merges random batches of prices, out of order, with
+// duplicates and interleaved with the cached ones, and checks after each
+// that the cache is sorted, without duplicates and holds the last price
+// written for every instant, or the highest of a batch, as reported by the
+// merge.
+func TestMergeProperties(t *testing.T) {
+	const slots = 200
+	base := testFirstDay.UTC()
+	other := time.FixedZone("UTC+5", 5*60*60)
+
+	for seed := range uint64(20) {
+		rng := rand.New(rand.NewPCG(seed, 153))
+		s := newStore()
+		want := make(map[int64]float64)
+		for batch := range 30 {
+			prices := make(map[time.Time]float64)
+			for range rng.IntN(slots) {
+				ts := base.Add(time.Duration(rng.IntN(slots)) * time.Hour)
+				prices[ts] = float64(rng.IntN(5))
+			}
+			// Some instants again in another location, of which the
+			// highest price is merged.
+			highest := make(map[int64]float64)
+			for _, ts := range slices.Collect(maps.Keys(prices)) {
+				highest[ts.Unix()] = prices[ts]
+				if rng.IntN(10) == 0 {
+					p := float64(rng.IntN(5))
+					prices[ts.In(other)] = p
+					highest[ts.Unix()] = max(p, prices[ts])
+				}
+			}
+
+			var added, updated, unchanged int
+			for ts, p := range highest {
+				old, ok := want[ts]
+				switch {
+				case !ok:
+					added++
+				case old != p:
+					updated++
+				default:
+					unchanged++
+				}
+				want[ts] = p
+			}
+
+			generation := s.meta().Generation
+			res := s.merge(prices, originRefresh, upstreamProvider)
+			if res != (mergeResult{added, updated, unchanged}) {
+				t.Fatalf("seed %d, batch %d: merge reported %+v, want %+v", seed, batch, res, mergeResult{added, updated, unchanged})
+			}
+			if advanced := s.meta().Generation != generation; advanced != res.changed() {
+				t.Fatalf("seed %d, batch %d: generation advanced %t after merge %+v", seed, batch, advanced, res)
+			}
+
+			if len(s.slots) != len(want) {
+				t.Fatalf("seed %d, batch %d: %d slots, want %d", seed, batch, len(s.slots), len(want))
+			}
+			for i, slot := range s.slots {
+				if i > 0 && !s.slots[i-1].Start.Before(slot.Start) {
+					t.Fatalf("seed %d, batch %d: slot %d at %s not after %s", seed, batch, i, slot.Start, s.slots[i-1].Start)
+				}
+				if p, ok := want[slot.Start.Unix()]; !ok || p != slot.Price {
+					t.Fatalf("seed %d, batch %d: slot at %s priced %v, want %v (cached: %t)", seed, batch, slot.Start, slot.Price, p, ok)
+				}
+			}
+			if len(s.slots) > 0 && (!s.earliest.Equal(s.slots[0].Start) || !s.latest.Equal(s.slots[len(s.slots)-1].Start)) {
+				t.Fatalf("seed %d, batch %d: bounds %s to %s, want %s to %s", seed, batch, s.earliest, s.latest, s.slots[0].Start, s.slots[len(s.slots)-1].Start)
+			}
+		}
+	}
+}
+
+// TestMergeDuplicateInstants merges an instant given in several locations
+// with different prices many times, as the order of the map varies between
+// iterations, and checks that the highest price in UTC is kept every time.
+func TestMergeDuplicateInstants(t *testing.T) {
+	ts := testFirstDay.UTC()
+	prices := map[time.Time]float64{
+		ts:                                      2,
+		ts.In(testLoc):                          5,
+		ts.In(time.FixedZone("UTC+5", 5*60*60)): -1,
+		ts.Add(time.Hour).In(testLoc):           7,
+	}
+	for i := range 100 {
+		s := newStore()
+		if res := s.merge(prices, originRefresh, upstreamProvider); res != (mergeResult{Added: 2}) {
+			t.Fatalf("merge %d reported %+v, want 2 added", i, res)
+		}
+		for j, want := range []float64{5, 7} {
+			slot := s.slots[j]
+			if slot.Price != want || slot.Start.Location() != time.UTC || !slot.Start.Equal(ts.Add(time.Duration(j)*time.Hour)) {
+				t.Fatalf("merge %d: slot %d priced %v at %s, want %v at %s", i, j, slot.Price, slot.Start, want, ts.Add(time.Duration(j)*time.Hour))
+			}
+		}
+		// Merging them again changes nothing.
+		if res := s.merge(prices, originRefresh, upstreamProvider); res != (mergeResult{Unchanged: 2}) {
+			t.Fatalf("merging again %d reported %+v, want 2 unchanged", i, res)
+		}
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+	ts := testFirstDay.UTC()
+	tests := []struct {
+		name string
+		// The price of each merge, of the given source.
+		merges   []float64
+		sources  []string
+		price    float64
+		source   string
+		shadowed string
+	}{
+		{"upstream replaces primary", []float64{1, 2}, []string{sourcePrimary, upstreamProvider}, 2, upstreamProvider, sourcePrimary},
+		{"primary doesn't replace upstream", []float64{1, 2}, []string{upstreamProvider, sourcePrimary}, 1, upstreamProvider, sourcePrimary},
+		{"equal prices shadow nothing", []float64{1, 1}, []string{upstreamProvider, sourcePrimary}, 1, upstreamProvider, ""},
+		{"last upstream write wins", []float64{1, 2, 3}, []string{upstreamProvider, upstreamProvider, upstreamProvider}, 3, upstreamProvider, ""},
+		{"last write wins between others", []float64{1, 2}, []string{sourcePrimary, "other"}, 2, "other", sourcePrimary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore()
+			for i, p := range tt.merges {
+				s.merge(map[time.Time]float64{ts: p}, originRefresh, tt.sources[i])
+			}
+			slot := s.slots[0]
+			if len(s.slots) != 1 || slot.Price != tt.price || slot.Source != tt.source || slot.ShadowedSource != tt.shadowed {
+				t.Errorf("slots %+v, want one priced %v from %s shadowing %q", s.slots, tt.price, tt.source, tt.shadowed)
+			}
+		})
+	}
+}
+
+// TestAt checks the slot containing instants at and around the boundaries
+// of hourly and quarter-hourly slots and a gap: an instant belongs to the
+// slot starting at it, and one nanosecond before to the previous one.
+func TestAt(t *testing.T) {
+	base := testFirstDay.UTC()
+	at := func(h, m int) time.Time { return base.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	s := newStore()
+	s.merge(map[time.Time]float64{
+		at(8, 0): 1, at(9, 0): 2,
+		at(10, 0): 3, at(10, 15): 4, at(10, 30): 5, at(10, 45): 6,
+		// The hour from 11:00 is missing.
+		at(12, 0): 7, at(13, 0): 8,
+	}, originRefresh, upstreamProvider)
+
+	hour := func(h int, p float64) pricePoint {
+		return pricePoint{Start: at(h, 0), Duration: time.Hour, Price: p}
+	}
+	quarter := func(m int, p float64) pricePoint {
+		return pricePoint{Start: at(10, m), Duration: 15 * time.Minute, Price: p}
+	}
+	tests := []struct {
+		t    time.Time
+		want pricePoint
+		ok   bool
+	}{
+		{at(8, 0).Add(-time.Nanosecond), pricePoint{}, false},
+		{at(8, 0), hour(8, 1), true},
+		{at(9, 0).Add(-time.Nanosecond), hour(8, 1), true},
+		{at(9, 0), hour(9, 2), true},
+		{at(10, 0).Add(-time.Nanosecond), hour(9, 2), true},
+		{at(10, 0), quarter(0, 3), true},
+		{at(10, 15).Add(-time.Nanosecond), quarter(0, 3), true},
+		{at(10, 15), quarter(15, 4), true},
+		{at(10, 45), quarter(45, 6), true},
+		{at(11, 0).Add(-time.Nanosecond), quarter(45, 6), true},
+		{at(11, 0), pricePoint{}, false},
+		{at(12, 0).Add(-time.Nanosecond), pricePoint{}, false},
+		{at(12, 0), hour(12, 7), true},
+		{at(13, 0), hour(13, 8), true},
+		{at(14, 0).Add(-time.Nanosecond), hour(13, 8), true},
+		{at(14, 0), pricePoint{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := s.at(tt.t)
+		if ok != tt.ok || !samePoint(got, tt.want) {
+			t.Errorf("at(%s) = %+v, %t, want %+v, %t", tt.t.Format(time.RFC3339Nano), got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Every instant agrees with the points it falls into.
+	points := s.points(time.Time{}, time.Time{})
+	rng := rand.New(rand.NewPCG(177, 0))
+	for range 1000 {
+		ts := at(7, 0).Add(time.Duration(rng.Int64N(int64(8 * time.Hour))))
+		want, wantOK := pricePoint{}, false
+		for _, p := range points {
+			if !ts.Before(p.Start) && ts.Before(p.Start.Add(p.Duration)) {
+				want, wantOK = p, true
+			}
+		}
+		if got, ok := s.at(ts); ok != wantOK || !samePoint(got, want) {
+			t.Fatalf("at(%s) = %+v, %t, want %+v, %t", ts.Format(time.RFC3339Nano), got, ok, want, wantOK)
+		}
+	}
+}
+
+func samePoint(a, b pricePoint) bool {
+	return a.Start.Equal(b.Start) && a.Duration == b.Duration && a.Price == b.Price
+}
+
+// scanAt is the linear scan store.at replaced, for BenchmarkAt.
+func scanAt(s *store, t time.Time) (pricePoint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var cur pricePoint
+	var next time.Time
+	found := false
+	for _, slot := range s.slots {
+		if slot.Start.After(t) {
+			next = slot.Start
+			break
+		}
+		cur, found = pricePoint{Start: slot.Start, Price: slot.Price}, true
+	}
+	if !found {
+		return pricePoint{}, false
+	}
+	cur.Duration = maxSlotDuration
+	if !next.IsZero() {
+		cur.Duration = min(cur.Duration, next.Sub(cur.Start))
+	}
+	if !t.Before(cur.Start.Add(cur.Duration)) {
+		return pricePoint{}, false
+	}
+	return cur, true
+}
+
+// BenchmarkAt looks up instants early, in the middle and late in 60000
+// hourly slots by binary search and by the linear scan it replaced.
+func BenchmarkAt(b *testing.B) {
+	const slots = 60000
+	s := uniformStore(slots, 1)
+	for _, lookup := range []struct {
+		name string
+		at   func(*store, time.Time) (pricePoint, bool)
+	}{
+		{"search", (*store).at},
+		{"scan", scanAt},
+	} {
+		for _, pos := range []int{0, slots / 2, slots - 1} {
+			b.Run(fmt.Sprintf("%s/slot=%d", lookup.name, pos), func(b *testing.B) {
+				ts := testFirstDay.Add(time.Duration(pos)*time.Hour + 30*time.Minute)
+				if _, ok := lookup.at(s, ts); !ok {
+					b.Fatalf("no slot contains %s", ts)
+				}
+				b.ReportAllocs()
+				for range b.N {
+					lookup.at(s, ts)
+				}
+			})
+		}
+	}
+}
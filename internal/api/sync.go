@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Sources of refreshState.
+const (
+	sourceUpstream = "upstream"
+	sourcePrimary  = "primary"
+)
+
+// syncChunk is the longest range requested from a primary at once, well
+// below the default listing limit of a year.
+const syncChunk = 90 * 24 * time.Hour
+
+// maxPrimaryStaleness is how long ago a primary may have last refreshed for
+// its prices to be used. A primary failing to refresh for longer is treated
+// like an unreachable one.
+const maxPrimaryStaleness = 12 * time.Hour
+
+// primary fetches prices from the listing of another instance of this
+// service instead of the upstream. As the primary fetches them from the
+// upstream, they are cached as prices of upstreamProvider.
+type primary struct {
+	base    string
+	client  *http.Client
+	maxBody int64
+}
+
+func newPrimary(cfg config) *primary {
+	return &primary{base: cfg.syncFrom, client: http.DefaultClient, maxBody: cfg.upstreamMaxBody}
+}
+
+// fetch retrieves the prices between start and end in chunks of at most
+// syncChunk. A zero start fetches from historyStart.
+func (p *primary) fetch(ctx context.Context, start, end time.Time) (map[time.Time]float64, error) {
+	if start.IsZero() {
+		start = historyStart
+	}
+	prices := make(map[time.Time]float64)
+	for from := start; from.Before(end); from = from.Add(syncChunk) {
+		to := from.Add(syncChunk)
+		if to.After(end) {
+			to = end
+		}
+		if err := p.fetchChunk(ctx, from, to, prices); err != nil {
+			return nil, err
+		}
+	}
+	return prices, nil
+}
+
+// fetchChunk adds the NDJSON listing of the primary between start and end to
+// prices.
+func (p *primary) fetchChunk(ctx context.Context, start, end time.Time, prices map[time.Time]float64) error {
+	q := url.Values{}
+	q.Set("start", start.UTC().Format(time.RFC3339))
+	q.Set("end", end.UTC().Format(time.RFC3339))
+	q.Set("format", formatNDJSON)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.base+"/price?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching prices from primary: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching prices from primary: %w", &statusError{code: res.StatusCode, status: res.Status})
+	}
+	if v := res.Header.Get("X-Last-Refresh"); v != "" {
+		refreshed, err := time.Parse(time.RFC3339, v)
+		if err == nil && time.Since(refreshed) > maxPrimaryStaleness {
+			return fmt.Errorf("primary last refreshed at %s", v)
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, p.maxBody+1))
+	if err != nil {
+		return fmt.Errorf("error reading response of primary: %w", err)
+	}
+	if int64(len(body)) > p.maxBody {
+		return fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, p.maxBody)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var slot PricePoint
+		if err := dec.Decode(&slot); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("error parsing response of primary: %w", err)
+		}
+		prices[slot.Start] = slot.Price
+	}
+	return nil
+}
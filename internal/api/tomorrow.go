@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxTomorrowWait caps how long a request to /price/tomorrow can be held open.
+const maxTomorrowWait = time.Hour
+
+// handleTomorrow returns the slots of the next local day. Until they are
+// published, the request either fails with 404 or, with the wait parameter,
+// is held open until a refresh brings them or the wait expires with 204.
+func (s *server) handleTomorrow(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	wait, err := parseWait(q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	// Subscribe before checking, so that a refresh in between isn't missed.
+	events, unsubscribe := s.events.subscribe(s.store.meta().Generation)
+	defer unsubscribe()
+
+	if !s.tomorrowAvailable() {
+		// A snapshot won't get tomorrow's prices by waiting.
+		if wait == 0 || isPinned(r) {
+			writeError(w, http.StatusNotFound, codeNotFound, "tomorrow's prices are not published yet")
+			return
+		}
+		if !s.waitForTomorrow(r.Context(), events, wait) {
+			if r.Context().Err() == nil {
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+	}
+
+	y, m, d := s.clock.Now().In(s.loc).Date()
+	start := time.Date(y, m, d+1, 0, 0, 0, 0, s.loc)
+	slots := []any{}
+	for _, p := range s.store.points(start, start.AddDate(0, 0, 1)) {
+		slots = append(slots, priced(p, div))
+	}
+	writeJSON(w, struct {
+		Date  string `json:"date"`
+		Unit  string `json:"unit"`
+		Slots []any  `json:"slots"`
+	}{start.Format(time.DateOnly), unit, slots})
+}
+
+// waitForTomorrow waits at most wait for the tomorrow_available event and
+// reports whether it arrived.
+func (s *server) waitForTomorrow(ctx context.Context, events <-chan event, wait time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case e := <-events:
+			switch e.Name {
+			case "tomorrow_available":
+				return true
+			case resyncEventName:
+				// The event may have been missed.
+				if s.tomorrowAvailable() {
+					return true
+				}
+			}
+		}
+	}
+}
+
+// parseWait reads the optional wait query parameter, clamped to
+// maxTomorrowWait.
+func parseWait(q url.Values) (time.Duration, error) {
+	v := q.Get("wait")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := parseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("wait: invalid duration %q: expected e.g. 55m", v)
+	}
+	return min(d, maxTomorrowWait), nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zoneinfoDirs are the directories the time package looks for a timezone
+// database in on Linux before the embedded one: those of the system, and
+// that of the Go installation the test was built with.
+var zoneinfoDirs = []string{"/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ", "/etc/zoneinfo", filepath.Join(runtime.GOROOT(), "lib", "time")}
+
+// TestMarketLocationEmbedded runs this test again in a mount namespace in
+// which zoneinfoDirs and TZDIR are empty directories, so that the market
+// timezone can only come from the embedded database.
+func TestMarketLocationEmbedded(t *testing.T) {
+	if os.Getenv("TEST_EMPTY_ZONEINFO") == "1" {
+		for _, dir := range zoneinfoDirs {
+			if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+				t.Fatalf("%s isn't empty", dir)
+			}
+		}
+		loc, err := loadMarketLocation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range []struct {
+			t      time.Time
+			offset int
+		}{
+			{time.Date(2026, time.January, 13, 12, 0, 0, 0, time.UTC), 3600},
+			{time.Date(2026, time.July, 13, 12, 0, 0, 0, time.UTC), 7200},
+			// The switch to summer time on the last Sunday of March.
+			{time.Date(2026, time.March, 29, 0, 59, 59, 0, time.UTC), 3600},
+			{time.Date(2026, time.March, 29, 1, 0, 0, 0, time.UTC), 7200},
+		} {
+			if _, offset := tt.t.In(loc).Zone(); offset != tt.offset {
+				t.Errorf("offset at %s is %ds, want %ds", tt.t.Format(time.RFC3339), offset, tt.offset)
+			}
+		}
+		return
+	}
+
+	if err := exec.Command("unshare", "--map-root-user", "--mount", "true").Run(); err != nil {
+		t.Skipf("can't create a mount namespace: %v", err)
+	}
+	empty := t.TempDir()
+	script := `for dir in "$@"; do if [ -d "$dir" ]; then mount --bind "$EMPTY" "$dir" || exit 1; fi; done; exec "$TEST_BINARY" -test.run='^TestMarketLocationEmbedded$' -test.v`
+	cmd := exec.Command("unshare", append([]string{"--map-root-user", "--mount", "sh", "-c", script, "sh"}, zoneinfoDirs...)...)
+	cmd.Env = append(os.Environ(), "TEST_EMPTY_ZONEINFO=1", "EMPTY="+empty, "TZDIR="+empty, "ZONEINFO=", "TEST_BINARY="+os.Args[0])
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "--- PASS: TestMarketLocationEmbedded") {
+		t.Fatalf("without a system timezone database: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultUnit is the unit prices are stored and served in.
+const defaultUnit = "EUR/MWh"
+
+// unitDivisors convert a price in EUR/MWh to the keyed unit. Dividing rather
+// than multiplying by the inverse keeps e.g. 83.2 EUR/MWh at exactly 8.32 ct/kWh.
+var unitDivisors = map[string]float64{
+	"EUR/MWh": 1,
+	"EUR/kWh": 1000,
+	"ct/kWh":  10,
+}
+
+// fromUnit returns a function converting prices reported in unit, one of the
+// keys of unitDivisors, to defaultUnit. Spaces around the slash are ignored,
+// so that e.g. "EUR / MWh" is understood too.
+//
+// Converted prices are rounded to millionths, which keeps e.g. 8.32 ct/kWh at
+// exactly 83.2 EUR/MWh instead of the product's rounding error.
+func fromUnit(unit string) (func(price float64) float64, error) {
+	div, ok := unitDivisors[strings.ReplaceAll(unit, " ", "")]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", unit)
+	}
+	if div == 1 {
+		return func(price float64) float64 { return price }, nil
+	}
+	return func(price float64) float64 { return math.Round(price*div*1e6) / 1e6 }, nil
+}
+
+// parseUnit reads the optional unit and currency query parameters and
+// returns the label of the resulting unit together with its divisor from
+// EUR/MWh. Prices are converted to other currencies only when serialized, so
+// the response headers are set to state the conversion rate used.
+func (s *server) parseUnit(w http.ResponseWriter, q url.Values) (string, float64, error) {
+	u := q.Get("unit")
+	if u == "" {
+		u = defaultUnit
+	}
+	div, ok := unitDivisors[u]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown unit %q: expected one of %s", u, strings.Join(slices.Sorted(maps.Keys(unitDivisors)), ", "))
+	}
+
+	currency := q.Get("currency")
+	if currency == "" || currency == baseCurrency {
+		return u, div, nil
+	}
+	fx, ok := s.cfg.fx[currency]
+	if !ok {
+		valid := append([]string{baseCurrency}, slices.Sorted(maps.Keys(s.cfg.fx))...)
+		return "", 0, fmt.Errorf("unknown currency %q: expected one of %s", currency, strings.Join(valid, ", "))
+	}
+
+	w.Header().Set("X-Currency", currency)
+	w.Header().Set("X-FX-Rate", formatFloat(fx.Rate))
+	if !fx.AsOf.IsZero() {
+		w.Header().Set("X-FX-As-Of", fx.AsOf.Format(time.DateOnly))
+	}
+
+	label := strings.Replace(u, baseCurrency, currency, 1)
+	if label == u {
+		// Subunits such as ct don't name the currency.
+		label = currency + " " + u
+	}
+	return label, div / fx.Rate, nil
+}
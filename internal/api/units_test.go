@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromUnit(t *testing.T) {
+	tests := []struct {
+		unit  string
+		price float64
+		want  float64
+	}{
+		{"EUR/MWh", 83.2, 83.2},
+		{"EUR / MWh", -0.01, -0.01},
+		{"EUR/kWh", 0.0832, 83.2},
+		{"EUR / kWh", -0.00001, -0.01},
+		{"ct/kWh", 8.32, 83.2},
+		{" ct / kWh ", 0.1, 1},
+		{"ct/kWh", 12.345678, 123.45678},
+		{"ct/kWh", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			convert, err := fromUnit(tt.unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Exactly, as the conversion rounds away the error of the product.
+			if got := convert(tt.price); got != tt.want {
+				t.Errorf("%v %s is %v EUR/MWh, want %v", tt.price, tt.unit, got, tt.want)
+			}
+		})
+	}
+
+	// Every unit prices are served in converts back to the stored price.
+	for unit, div := range unitDivisors {
+		convert, err := fromUnit(unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := convert(83.2 / div); got != 83.2 {
+			t.Errorf("83.2 EUR/MWh served in %s is fetched as %v", unit, got)
+		}
+	}
+
+	for _, unit := range []string{"", "eur/mwh", "EUR/Wh", "EUR/MWh/h", "USD/MWh", "EURMWh"} {
+		if _, err := fromUnit(unit); err == nil || !strings.Contains(err.Error(), "unknown unit") {
+			t.Errorf("unit %q: %v, want it to be unknown", unit, err)
+		}
+	}
+}
+
+// TestUpstreamUnits fetches prices reported in each unit, which are stored
+// in EUR/MWh, and in an unknown one, which fails the fetch for good.
+func TestUpstreamUnits(t *testing.T) {
+	start := testFirstDay.Add(12 * time.Hour)
+	tests := []struct {
+		unit   string
+		prices string
+	}{
+		{"EUR / MWh", "12,13"},
+		{"EUR/kWh", "0.012,0.013"},
+		{"ct/kWh", "1.2,1.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			u, _ := newFixedUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"unix_seconds":[%d,%d],"price":[%s],"unit":%q,"deprecated":false}`, start.Unix(), start.Add(time.Hour).Unix(), tt.prices, tt.unit)
+			})
+			prices, err := u.fetchOnce(context.Background(), start, start.Add(2*time.Hour), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(prices) != 2 {
+				t.Fatalf("fetched %v, want 2 prices", prices)
+			}
+			for ts, p := range prices {
+				if want := 12 + ts.Sub(start).Hours(); p != want {
+					t.Errorf("fetched %v at %s, want %v EUR/MWh", p, ts, want)
+				}
+			}
+		})
+	}
+
+	u, _ := newFixedUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"unix_seconds":[%d],"price":[12],"unit":"EUR/Wh","deprecated":false}`, start.Unix())
+	})
+	_, err := u.fetchOnce(context.Background(), start, start.Add(time.Hour), nil)
+	if !errors.As(err, new(permanentError)) || !strings.Contains(err.Error(), `unknown unit "EUR/Wh"`) {
+		t.Errorf("fetching prices in EUR/Wh: %v, want a permanent error", err)
+	}
+}
+
+// TestDefaultUnit checks that prices are served in EUR/MWh unless another
+// unit is asked for.
+func TestDefaultUnit(t *testing.T) {
+	s := newTestServer(t)
+	type current struct {
+		Unit  string  `json:"unit"`
+		Price float64 `json:"price"`
+	}
+	for target, want := range map[string]current{
+		"/price/current":             {"EUR/MWh", 115},
+		"/price/current?unit=ct/kWh": {"ct/kWh", 11.5},
+	} {
+		res := get(t, s, target)
+		wantStatus(t, res, http.StatusOK)
+		if got := decode[current](t, res); got != want {
+			t.Errorf("GET %s: %+v, want %+v", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upgrades replace the running process with the binary now at its path,
+// without closing the listening sockets or dropping requests: on
+// upgradeSignal, the cache is saved if there is a cache file, and the new
+// binary is started with the same arguments and the listeners. Once it is
+// ready to serve, the old process stops accepting connections, answers
+// those it accepted already, see handOver, drains its requests like for an
+// interrupt and exits. If the new process fails to start or exits before it is ready,
+// the old one keeps serving. Under systemd, the service needs
+// NotifyAccess=all so that the new process can take over as its main
+// process.
+//
+// Requests arriving while both processes are running are accepted by
+// either of them.
+
+// upgradeRoutesEnv passes the route sets of the inherited listeners to the
+// new process, comma-separated in the order of their file descriptors,
+// which start at listenFDsStart and are followed by the readiness pipe.
+const upgradeRoutesEnv = "ENERGY_PRICES_UPGRADE_ROUTES"
+
+// upgradeReadyTimeout bounds the startup of the new process, including its
+// initial fetch.
+const upgradeReadyTimeout = 2 * time.Minute
+
+// errUpgraded is the cause of the shutdown of a process replaced by an
+// upgrade.
+var errUpgraded = errors.New("replaced by an upgraded process")
+
+// notifyUpgrades returns a channel receiving upgradeSignal, or nil where
+// there is none. The runtime ignores the signal while nothing is notified of
+// it, so it has to be called before the process announces that it is ready.
+func notifyUpgrades() chan os.Signal {
+	if upgradeSignal == nil {
+		return nil
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, upgradeSignal)
+	return signals
+}
+
+// watchUpgrades upgrades on every signal of notifyUpgrades until an upgrade
+// succeeds, which cancels ctx with errUpgraded, or ctx is done. save saves
+// the state the new process starts from.
+func watchUpgrades(ctx context.Context, cancel context.CancelCauseFunc, signals chan os.Signal, addrs listenAddrs, lns []net.Listener, save func() error) {
+	if signals == nil {
+		return
+	}
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+		log.Print("upgrading")
+		if err := upgrade(ctx, addrs, lns, save); err != nil {
+			log.Printf("warning: upgrade failed, serving on: %v", err)
+			continue
+		}
+		cancel(errUpgraded)
+		return
+	}
+}
+
+// upgrade starts the new process and waits until it is ready.
+func upgrade(ctx context.Context, addrs listenAddrs, lns []net.Listener, save func() error) error {
+	if err := save(); err != nil {
+		return err
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	routes := make([]string, len(lns))
+	for i, ln := range lns {
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("can't pass listener on %s", ln.Addr())
+		}
+		f, err := tcp.File()
+		if err != nil {
+			return fmt.Errorf("error passing listener on %s: %w", ln.Addr(), err)
+		}
+		files = append(files, f)
+		routes[i] = string(addrs[i].routes)
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), upgradeRoutesEnv+"="+strings.Join(routes, ","))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %w", bin, err)
+	}
+	// Only the new process may hold the write end, so that reading it ends
+	// when the process does.
+	readyW.Close()
+	files = files[:len(files)-1]
+	go cmd.Wait()
+
+	readied := make(chan error, 1)
+	go func() {
+		b, err := io.ReadAll(ready)
+		if err == nil && string(b) != "READY" {
+			err = errors.New("the new process exited before it was ready")
+		}
+		readied <- err
+	}()
+	timeout := time.NewTimer(upgradeReadyTimeout)
+	defer timeout.Stop()
+	select {
+	case err := <-readied:
+		if err != nil {
+			return err
+		}
+	case <-timeout.C:
+		cmd.Process.Kill()
+		return fmt.Errorf("the new process wasn't ready after %s", upgradeReadyTimeout)
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return context.Cause(ctx)
+	}
+
+	log.Printf("upgraded process %d is ready, shutting down", cmd.Process.Pid)
+	if err := sdNotify("MAINPID=" + strconv.Itoa(cmd.Process.Pid)); err != nil {
+		log.Printf("error notifying the new main process: %v", err)
+	}
+	return nil
+}
+
+// inheritedListeners returns the listeners passed by an upgrading process
+// and their route sets, or nil if the process wasn't started by an upgrade.
+// The returned function reports that the process is ready to serve.
+func inheritedListeners() (listenAddrs, []net.Listener, func(), error) {
+	v, ok := os.LookupEnv(upgradeRoutesEnv)
+	if !ok {
+		return nil, nil, nil, nil
+	}
+	// The variable must not leak to later upgrades.
+	os.Unsetenv(upgradeRoutesEnv)
+
+	routes := strings.Split(v, ",")
+	addrs := make(listenAddrs, len(routes))
+	lns := make([]net.Listener, len(routes))
+	for i, rs := range routes {
+		f := os.NewFile(uintptr(listenFDsStart+i), "upgrade-socket")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error using inherited socket: %w", err)
+		}
+		addrs[i], lns[i] = listenAddr{routes: routeSet(rs), addr: ln.Addr().String()}, ln
+	}
+	ready := os.NewFile(uintptr(listenFDsStart+len(routes)), "upgrade-ready")
+	return addrs, lns, func() {
+		ready.WriteString("READY")
+		ready.Close()
+	}, nil
+}
+
+// handOverTimeout bounds how long a process replaced by an upgrade waits
+// for the first requests of the connections it accepted. Like for
+// http.Server.Shutdown, a client that connected longer ago without sending
+// one is taken to be idle.
+const handOverTimeout = 5 * time.Second
+
+// openConns tracks the open connections of servers.
+type openConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func newOpenConns() *openConns {
+	return &openConns{conns: make(map[net.Conn]bool)}
+}
+
+// track is the http.Server.ConnState hook.
+func (c *openConns) track(conn net.Conn, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		c.conns[conn] = true
+	case http.StateClosed, http.StateHijacked:
+		delete(c.conns, conn)
+	}
+}
+
+func (c *openConns) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+// handOver stops the servers of a process replaced by an upgrade from
+// accepting connections on lns, which the new process serves on, and waits
+// until those accepted already are closed, each after its current request
+// as keep-alives are disabled, or until ctx is done or handOverTimeout has
+// passed. Shutting the servers down right away would drop the requests of
+// connections accepted but not read yet, which clients don't retry.
+func handOver(ctx context.Context, servers []*http.Server, lns []net.Listener, conns *openConns) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+	for _, s := range servers {
+		s.SetKeepAlivesEnabled(false)
+	}
+
+	timeout := time.NewTimer(handOverTimeout)
+	defer timeout.Stop()
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for conns.len() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			log.Printf("warning: %d connections still open after handing over, shutting down", conns.len())
+			return
+		case <-tick.C:
+		}
+	}
+}
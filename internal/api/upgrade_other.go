@@ -0,0 +1,8 @@
+//go:build !unix
+
+package api
+
+import "os"
+
+// upgradeSignal is nil where there is no signal to start an upgrade with.
+var upgradeSignal os.Signal
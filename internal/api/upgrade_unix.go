@@ -0,0 +1,11 @@
+//go:build unix
+
+package api
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal starts an upgrade, see upgrade.go.
+var upgradeSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,200 @@
+//go:build unix
+
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/t-arik/energy-market-prices/internal/mockupstream"
+)
+
+// serviceProcess is a running service binary and its log lines, which
+// include those of the processes it upgrades to.
+type serviceProcess struct {
+	cmd *exec.Cmd
+
+	mu    sync.Mutex
+	lines []string
+}
+
+// startService builds the service and runs it with args, returning once it
+// is serving with the address it serves on. An upgrade replaces the
+// process by one that isn't its child, so the test kills the remaining one
+// by its PID when done.
+func startService(t *testing.T, args ...string) (*serviceProcess, string) {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "energy-market-prices")
+	build := exec.Command("go", "build", "-o", bin, "github.com/t-arik/energy-market-prices")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("error building the service: %v\n%s", err, out)
+	}
+
+	// The log is read from a pipe of its own rather than cmd's, which Wait
+	// closes, as the processes upgraded to write to it as well.
+	stderr, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &serviceProcess{cmd: exec.Command(bin, args...)}
+	p.cmd.Stderr = w
+	err = p.cmd.Start()
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer stderr.Close()
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			t.Log(sc.Text())
+			p.mu.Lock()
+			p.lines = append(p.lines, sc.Text())
+			p.mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() { p.cmd.Process.Kill() })
+
+	m := p.waitForLine(t, regexp.MustCompile(`serving all routes on (\S+)`), 1)
+	return p, m[1]
+}
+
+// waitForLine waits until n log lines match re and returns the submatches
+// of the last of them. Processes starting from a large cache can take a
+// while when the machine is busy, so it waits as long as an upgrade waits
+// for the new process to be ready.
+func (p *serviceProcess) waitForLine(t *testing.T, re *regexp.Regexp, n int) []string {
+	t.Helper()
+	for deadline := time.Now().Add(upgradeReadyTimeout); ; time.Sleep(10 * time.Millisecond) {
+		if m := p.matchLine(re, n); m != nil {
+			return m
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d log lines matching %s", n, re)
+		}
+	}
+}
+
+// matchLine returns the submatches of the n-th log line matching re, or nil
+// if fewer match.
+func (p *serviceProcess) matchLine(re *regexp.Regexp, n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	matched := 0
+	for _, line := range p.lines {
+		if sub := re.FindStringSubmatch(line); sub != nil {
+			if matched++; matched == n {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// TestUpgrade upgrades a running service in place while it is being
+// requested, and checks that no request fails, the old process exits and
+// the new one serves on from the saved cache.
+func TestUpgrade(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the service")
+	}
+	mock, err := mockupstream.New(mockupstream.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := httptest.NewServer(mock)
+	defer up.Close()
+
+	old, addr := startService(t,
+		"-listen", "127.0.0.1:0",
+		"-upstream-url", up.URL,
+		"-cache-file", filepath.Join(t.TempDir(), "cache.json"),
+	)
+	url := "http://" + addr + "/price/current"
+	// Every request on a new connection, so that requests reach both
+	// processes while they overlap.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 10 * time.Second}
+	if res, err := client.Get(url); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s before upgrading: %v, %v", url, res, err)
+	}
+
+	var served, failed atomic.Int32
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	// The requests are stopped before the test ends however it does, as
+	// they fail it when they fail.
+	stopRequests := sync.OnceFunc(func() {
+		close(stop)
+		wg.Wait()
+	})
+	defer stopRequests()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				res, err := client.Get(url)
+				if err != nil || res.StatusCode != http.StatusOK {
+					t.Errorf("GET %s while upgrading: %v, %v", url, res, err)
+					failed.Add(1)
+					continue
+				}
+				res.Body.Close()
+				served.Add(1)
+			}
+		}()
+	}
+
+	if err := old.cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	m := old.waitForLine(t, regexp.MustCompile(`upgraded process (\d+) is ready`), 1)
+	pid, _ := strconv.Atoi(m[1])
+	t.Cleanup(func() {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	})
+	exited := make(chan error, 1)
+	go func() { exited <- old.cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("old process: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("old process still running after the upgrade")
+	}
+
+	// The new process serves on alone.
+	before := served.Load()
+	waitFor(t, "requests served after the upgrade", func() bool { return served.Load() > before+20 })
+	stopRequests()
+	if n := failed.Load(); n > 0 {
+		t.Errorf("%d of %d requests failed", n, n+served.Load())
+	}
+
+	// The new process started from the cache saved by the old one, and
+	// shuts down cleanly on an interrupt.
+	if err := syscall.Kill(pid, syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	old.waitForLine(t, regexp.MustCompile(`stopped after`), 2)
+	old.waitForLine(t, regexp.MustCompile(`loaded \d+ slots`), 1)
+}
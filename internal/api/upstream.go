@@ -0,0 +1,560 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// The market timezone has to be available on hosts and in containers
+	// without a timezone database.
+	_ "time/tzdata"
+)
+
+// biddingZone is the market area whose prices are fetched.
+const biddingZone = "DE-LU"
+
+// marketTimezone is the timezone of the bidding zone, which defines its days.
+const marketTimezone = "Europe/Berlin"
+
+// loadMarketLocation resolves marketTimezone. It is called once at startup;
+// everything else uses the resolved location. The system's timezone
+// database is preferred, with the one embedded in the binary as the
+// fallback.
+func loadMarketLocation() (*time.Location, error) {
+	loc, err := time.LoadLocation(marketTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("error loading market timezone %s (ZONEINFO=%q): %w", marketTimezone, os.Getenv("ZONEINFO"), err)
+	}
+	return loc, nil
+}
+
+// upstreamProvider names the upstream in cache files.
+const upstreamProvider = "energy-charts"
+
+// license is the attribution required by the license of price data.
+type license struct {
+	Source     string `json:"source"`
+	License    string `json:"license"`
+	LicenseURL string `json:"license_url"`
+	URL        string `json:"attribution_url"`
+}
+
+// dataLicense is the license of the prices fetched by upstream.
+var dataLicense = license{
+	Source:     "Bundesnetzagentur | SMARD.de, via energy-charts.info",
+	License:    "CC BY 4.0",
+	LicenseURL: "https://creativecommons.org/licenses/by/4.0/",
+	URL:        "https://www.smard.de/",
+}
+
+// upstream fetches prices from the energy-charts API.
+type upstream struct {
+	client  *http.Client
+	baseURL string
+	retries int
+	backoff time.Duration
+	// maxBody limits the size of response bodies.
+	maxBody int64
+	// skew is estimated from the Date headers of the responses.
+	skew *clockSkew
+	// clock is the source of "now" for checking responses and of the
+	// delays between retries. The skew and durations follow the host's.
+	clock clock
+
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+	// validators are the cache validators of the last responses to
+	// conditional fetches, by window.
+	validators map[flightKey]validator
+
+	fetchDuration *histogramVec
+	statusCodes   *counterVec
+	retriesTotal  *counterVec
+	coalesced     *counterVec
+	lastSlots     *gauge
+	missingSlots  *counterVec
+	bodyBytes     *histogramVec
+}
+
+// flightKey identifies the upstream requests that can be shared.
+type flightKey struct {
+	zone       string
+	start, end int64
+}
+
+// flight is an upstream fetch shared by all callers asking for the same
+// window while it is in progress.
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	prices map[time.Time]float64
+	err    error
+}
+
+func newUpstream(cfg config, reg *registry) *upstream {
+	skew := &clockSkew{threshold: cfg.clockSkewThreshold}
+	u := &upstream{
+		client:  &http.Client{Transport: skewTransport{http.DefaultTransport, skew}},
+		baseURL: cfg.upstreamURL,
+		retries: 2,
+		backoff: 5 * time.Second,
+		maxBody: cfg.upstreamMaxBody,
+		skew:    skew,
+		clock:   systemClock{},
+		fetchDuration: &histogramVec{
+			name:    "energy_prices_upstream_fetch_duration_seconds",
+			help:    "Duration of upstream fetch attempts by outcome.",
+			labels:  []string{"outcome"},
+			buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		statusCodes: &counterVec{
+			name:   "energy_prices_upstream_unexpected_status_total",
+			help:   "Upstream responses with a status other than 200 by status code.",
+			labels: []string{"code"},
+		},
+		retriesTotal: &counterVec{
+			name: "energy_prices_upstream_retries_total",
+			help: "Upstream fetch attempts that were retries of a failed attempt.",
+		},
+		coalesced: &counterVec{
+			name: "energy_prices_upstream_coalesced_fetches_total",
+			help: "Fetches that shared an upstream request already in progress for the same window.",
+		},
+		lastSlots: &gauge{
+			name: "energy_prices_upstream_last_fetch_slots",
+			help: "Number of slots returned by the last successful upstream fetch.",
+		},
+		missingSlots: &counterVec{
+			name:   "energy_prices_upstream_missing_slots_total",
+			help:   "Slots of requested windows missing from successful upstream responses, by whether they start in the past or the future.",
+			labels: []string{"when"},
+		},
+		bodyBytes: &histogramVec{
+			name:    "energy_prices_upstream_response_bytes",
+			help:    "Size of upstream response bodies read by fetch attempts.",
+			buckets: []float64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20},
+		},
+	}
+	reg.register(u.fetchDuration)
+	reg.register(u.statusCodes)
+	reg.register(u.retriesTotal)
+	reg.register(u.coalesced)
+	reg.register(u.lastSlots)
+	reg.register(u.missingSlots)
+	reg.register(u.bodyBytes)
+	return u
+}
+
+// fetch retrieves the prices between start and end. Concurrent calls for the
+// same window share a single upstream request and its result, so the returned
+// map must not be modified. The shared request is only canceled once every
+// caller waiting for it has given up.
+func (u *upstream) fetch(ctx context.Context, start, end time.Time) (map[time.Time]float64, error) {
+	key := flightKey{zone: biddingZone, start: start.Unix(), end: end.Unix()}
+
+	u.mu.Lock()
+	f, ok := u.flights[key]
+	if ok {
+		u.coalesced.inc()
+	} else {
+		if u.flights == nil {
+			u.flights = make(map[flightKey]*flight)
+		}
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		u.flights[key] = f
+
+		go func() {
+			defer cancel()
+			f.prices, f.err = u.fetchWithRetries(flightCtx, start, end, nil)
+
+			u.mu.Lock()
+			if u.flights[key] == f {
+				delete(u.flights, key)
+			}
+			u.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	u.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.prices, f.err
+	case <-ctx.Done():
+		u.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			// Later callers must not join the canceled request.
+			f.cancel()
+			if u.flights[key] == f {
+				delete(u.flights, key)
+			}
+		}
+		u.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// fetchWithRetries retrieves the prices between start and end, retrying
+// failed attempts after a growing delay unless the error is permanent. With cond, the attempts are
+// conditional like for fetchOnce.
+func (u *upstream) fetchWithRetries(ctx context.Context, start, end time.Time, cond *validator) (map[time.Time]float64, error) {
+	for attempt := 0; ; attempt++ {
+		prices, err := u.fetchOnce(ctx, start, end, cond)
+		if err == nil || errors.Is(err, errNotModified) || isPermanent(err) || attempt == u.retries || ctx.Err() != nil {
+			return prices, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-u.clock.After(u.backoff * time.Duration(attempt+1)):
+		}
+		u.retriesTotal.inc()
+	}
+}
+
+// errUnexpectedStatus is returned for upstream responses other than 200.
+var errUnexpectedStatus = errors.New("unexpected response status")
+
+// permanentError marks upstream errors that repeating the same request won't
+// fix, such as the upstream rejecting it.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// errBodyTooLarge is returned for upstream responses exceeding the body size
+// limit.
+var errBodyTooLarge = errors.New("response body too large")
+
+// fetchOnce makes a single upstream request for the prices between start and
+// end. With cond, the request is conditional on its validators as described
+// for fetchPrices.
+func (u *upstream) fetchOnce(ctx context.Context, start, end time.Time, cond *validator) (map[time.Time]float64, error) {
+	began := time.Now()
+	prices, size, err := fetchPrices(ctx, u.client, u.baseURL, start, end, u.clock.Now(), u.maxBody, cond)
+	if size > 0 {
+		u.bodyBytes.observe(float64(size))
+	}
+
+	outcome := "success"
+	switch {
+	case errors.Is(err, errNotModified):
+		outcome = "not_modified"
+	case errors.Is(err, errUnexpectedStatus):
+		outcome = "status"
+	case errors.Is(err, errBodyTooLarge):
+		outcome = "too_large"
+	case err != nil:
+		outcome = "error"
+	default:
+		u.lastSlots.set(float64(len(prices)))
+		log.Printf("fetched %d slots, %d bytes", len(prices), size)
+		u.checkShortfall(start, end, prices, u.clock.Now())
+	}
+	u.fetchDuration.observe(time.Since(began).Seconds(), outcome)
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		u.statusCodes.inc(strconv.Itoa(statusErr.code))
+	}
+	return prices, err
+}
+
+// checkShortfall compares the prices of a response for [start, end) with
+// the slots the window should have. Slots missing in the future are expected,
+// as day-ahead prices are only published around noon for the next day, but
+// holes in the past are logged as a warning.
+func (u *upstream) checkShortfall(start, end time.Time, prices map[time.Time]float64, now time.Time) {
+	past, future, expected := shortfall(start, end, prices, now)
+	if future > 0 {
+		u.missingSlots.add(float64(future), "future")
+	}
+	if past > 0 {
+		u.missingSlots.add(float64(past), "past")
+		log.Printf(
+			"warning: upstream response for %s to %s misses %d of %d slots before now",
+			start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), past, expected,
+		)
+	} else if future > 0 {
+		log.Printf("upstream response has %d of %d slots, %d aren't published yet", len(prices), expected, future)
+	}
+}
+
+// shortfall counts the slots of [start, end) missing from prices, separately
+// for slots starting before and after now, together with the number of slots
+// expected. Across a hole, slots are expected at the wider spacing of the
+// prices next to it, so that a change of the market's resolution within the
+// window doesn't count as missing slots. Open bounds are taken from the
+// prices.
+func shortfall(start, end time.Time, prices map[time.Time]float64, now time.Time) (past, future, expected int) {
+	unix := make([]int64, 0, len(prices))
+	for t := range prices {
+		unix = append(unix, t.Unix())
+	}
+	slices.Sort(unix)
+	unix = slices.Compact(unix)
+
+	// spacing returns the gap after the i-th price, or maxSlotDuration
+	// outside of the prices.
+	spacing := func(i int) int64 {
+		if i < 0 || i+1 >= len(unix) {
+			return int64(maxSlotDuration / time.Second)
+		}
+		return unix[i+1] - unix[i]
+	}
+	// missing counts the slots of res seconds in [from, to).
+	missing := func(from, to, res int64) {
+		for t := from; t < to; t += res {
+			if t < now.Unix() {
+				past++
+			} else {
+				future++
+			}
+		}
+	}
+
+	if len(unix) == 0 {
+		if !start.IsZero() && !end.IsZero() {
+			res := int64(maxSlotDuration / time.Second)
+			lo := (start.Unix() + res - 1) / res * res
+			missing(lo, end.Unix(), res)
+		}
+		return past, future, past + future
+	}
+
+	if !start.IsZero() {
+		res := spacing(0)
+		missing(unix[0]-(unix[0]-start.Unix())/res*res, unix[0], res)
+	}
+	for i := 1; i < len(unix); i++ {
+		res := max(spacing(i-2), spacing(i))
+		missing(unix[i-1]+res, unix[i], res)
+	}
+	if !end.IsZero() {
+		res := spacing(len(unix) - 2)
+		missing(unix[len(unix)-1]+res, end.Unix(), res)
+	}
+	return past, future, len(unix) + past + future
+}
+
+// maxErrorBody is how much of the body of an upstream error response is
+// included in the error.
+const maxErrorBody = 512
+
+type statusError struct {
+	code   int
+	status string
+	// body is the start of the response body, and url the request URL for
+	// errors that aren't retried.
+	body string
+	url  string
+}
+
+func (e *statusError) Error() string {
+	msg := fmt.Sprintf("%s: %s", errUnexpectedStatus, e.status)
+	if e.url != "" {
+		msg += " for GET " + e.url
+	}
+	if e.body != "" {
+		msg += ": " + e.body
+	}
+	return msg
+}
+
+// retryable reports whether the status may be temporary: timeouts, rate
+// limiting and server errors. Other client errors mean the upstream rejected
+// the request.
+func retryable(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooEarly || code == http.StatusTooManyRequests || code >= 500
+}
+
+// newStatusError describes the unexpected response res to a request for u,
+// reading the start of its body. Rejected requests are permanent errors.
+func newStatusError(res *http.Response, u string) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody+1))
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if len(body) > maxErrorBody {
+		text = strings.ToValidUTF8(text[:min(len(text), maxErrorBody)], "") + "..."
+	}
+	err := &statusError{code: res.StatusCode, status: res.Status, body: text}
+	if retryable(res.StatusCode) {
+		return err
+	}
+	err.url = u
+	return permanentError{err}
+}
+
+func (e *statusError) Unwrap() error {
+	return errUnexpectedStatus
+}
+
+// fetchPrices fetches the prices between start and end from the API at
+// baseURL, checking them as of now like parsePrices. It also returns the
+// number of body bytes read, which is never more than maxBody+1.
+//
+// If cond is set, its validators are sent with the request, errNotModified
+// is returned if the upstream answers 304, and cond is replaced with the
+// validators of a successful response.
+func fetchPrices(
+	ctx context.Context,
+	client *http.Client,
+	baseURL string,
+	start time.Time,
+	end time.Time,
+	now time.Time,
+	maxBody int64,
+	cond *validator,
+) (map[time.Time]float64, int64, error) {
+	q := url.Values{}
+	if !start.IsZero() {
+		q.Set("start", start.Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		q.Set("end", end.Format(time.RFC3339))
+	}
+
+	// The data is licensed as described by dataLicense.
+	u := baseURL + "/price?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cond != nil {
+		cond.apply(req.Header)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching prices: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && cond != nil {
+		return nil, 0, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, newStatusError(res, u)
+	}
+
+	// Reading one byte more than allowed tells a body of exactly maxBody
+	// bytes from a longer one.
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxBody+1))
+	size := int64(len(body))
+	if err != nil {
+		return nil, size, fmt.Errorf("error reading response body: %w", err)
+	}
+	if size > maxBody {
+		return nil, size, fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, maxBody)
+	}
+
+	prices, err := parsePrices(body, u, start, end, now)
+	if err != nil {
+		return nil, size, err
+	}
+	if cond != nil {
+		*cond = validatorOf(res.Header)
+	}
+
+	return prices, size, nil
+}
+
+// earliestPlausible and maxPublishedAhead bound the timestamps accepted
+// from the upstream. The market has no prices before the first, nor are
+// prices published further ahead than the second.
+var earliestPlausible = time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+const maxPublishedAhead = 7 * 24 * time.Hour
+
+// finestResolution is the shortest slot the market has, which bounds the
+// number of slots a response can have for its window.
+const finestResolution = 15 * time.Minute
+
+// parsePrices decodes and checks an upstream response body to the request
+// for u of the window [start, end), where open bounds are those of the plausible timestamps at
+// now. A response with more slots than the window can have at the finest
+// resolution, or with any timestamp outside of the plausible ones or not
+// at the start of a slot of the finest resolution, is refused as a whole,
+// so nothing of it is merged. Null prices are slots the upstream has no
+// price for, which are left out like missing ones. Fields other than those
+// of marketPrices are ignored.
+func parsePrices(body []byte, u string, start, end, now time.Time) (map[time.Time]float64, error) {
+	var payload marketPrices
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("error parsing repsponse body: %w", err)
+	}
+
+	convert, err := fromUnit(payload.Unit)
+	if err != nil {
+		return nil, permanentError{fmt.Errorf("unexpected price unit in response for GET %s: %w", u, err)}
+	}
+
+	if payload.Deprecated {
+		return nil, permanentError{fmt.Errorf("api for %s is marked deprecated", u)}
+	}
+
+	if len(payload.Timestamps) != len(payload.Prices) {
+		return nil, fmt.Errorf(
+			"expected equal number of timestamps and prices in response, got %d and %d",
+			len(payload.Timestamps), len(payload.Prices),
+		)
+	}
+
+	earliest, latest := earliestPlausible, now.Add(maxPublishedAhead)
+	from, to := earliest, latest
+	if !start.IsZero() {
+		from = start
+	}
+	if !end.IsZero() {
+		to = end
+	}
+	if limit := int(max(to.Sub(from), 0)/finestResolution) + 1; len(payload.Timestamps) > limit {
+		return nil, fmt.Errorf("expected at most %d slots in response, got %d", limit, len(payload.Timestamps))
+	}
+
+	prices := make(map[time.Time]float64, len(payload.Timestamps))
+	for i, unix := range payload.Timestamps {
+		t := time.Unix(unix, 0)
+		if t.Before(earliest) || t.After(latest) {
+			return nil, fmt.Errorf(
+				"implausible timestamp %d in response, expected %s to %s",
+				unix, earliest.Format(time.RFC3339), latest.UTC().Format(time.RFC3339),
+			)
+		}
+		if unix%int64(finestResolution/time.Second) != 0 {
+			return nil, fmt.Errorf("timestamp %d in response isn't at the start of a %s slot", unix, finestResolution)
+		}
+		if p := payload.Prices[i]; p != nil {
+			prices[t] = convert(*p)
+		}
+	}
+	return prices, nil
+}
+
+type marketPrices struct {
+	Timestamps []int64    `json:"unix_seconds"`
+	Prices     []*float64 `json:"price"`
+	Unit       string
+	Deprecated bool
+}
@@ -0,0 +1,614 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamMetrics(t *testing.T) {
+	var failing atomic.Bool
+	mock := newTestUpstream(t)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		mock.ServeHTTP(w, r)
+	}))
+	defer up.Close()
+
+	s := newTestServer(t, "-upstream-url", up.URL)
+	// Retries wait on the fake clock, so they mustn't wait at all.
+	s.upstream.backoff = 0
+	r := newRefresher(s.upstream, s, s.metrics)
+	refresh := func() { r.completed(context.Background(), r.refresh(context.Background())) }
+
+	failing.Store(true)
+	refresh()
+	refresh()
+	wantMetrics(t, s.metrics, map[string]float64{
+		// Each refresh makes an attempt and two retries.
+		`energy_prices_upstream_unexpected_status_total{code="503"}`:            6,
+		`energy_prices_upstream_retries_total`:                                  4,
+		`energy_prices_upstream_fetch_duration_seconds_count{outcome="status"}`: 6,
+		`energy_prices_refreshes_total{outcome="failure"}`:                      2,
+		`energy_prices_refresh_consecutive_failures`:                            2,
+	})
+
+	failing.Store(false)
+	refresh()
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_upstream_unexpected_status_total{code="503"}`:             6,
+		`energy_prices_upstream_retries_total`:                                   4,
+		`energy_prices_upstream_fetch_duration_seconds_count{outcome="success"}`: 1,
+		`energy_prices_upstream_last_fetch_slots`:                                48,
+		`energy_prices_refreshes_total{outcome="success"}`:                       1,
+		`energy_prices_refresh_consecutive_failures`:                             0,
+	})
+}
+
+// TestFetchIfModified refreshes from an upstream with validators: a 200
+// stores them, a 304 for them keeps the cache as it is, and a new response
+// after the prices changed replaces them.
+func TestFetchIfModified(t *testing.T) {
+	published := testFirstDay.AddDate(0, 0, testDays)
+	type version struct {
+		etag, lastModified string
+		end                time.Time
+	}
+	versions := []version{
+		{`"v1"`, "Tue, 13 Jan 2026 12:00:00 GMT", published},
+		// The first slot of the day after is added.
+		{`"v2"`, "Tue, 13 Jan 2026 14:00:00 GMT", published.Add(time.Hour)},
+	}
+	var current atomic.Int32
+	var asked []validator
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := versions[current.Load()]
+		asked = append(asked, validator{ETag: r.Header.Get("If-None-Match"), LastModified: r.Header.Get("If-Modified-Since")})
+		if r.Header.Get("If-None-Match") == v.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		start, err := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+		if err != nil {
+			t.Errorf("start: %v", err)
+		}
+		w.Header().Set("ETag", v.etag)
+		w.Header().Set("Last-Modified", v.lastModified)
+		io.WriteString(w, upstreamBody(start, v.end))
+	}))
+	defer up.Close()
+
+	s := newTestServer(t, "-upstream-url", up.URL)
+	s.upstream.backoff = 0
+	r := newRefresher(s.upstream, s, s.metrics)
+	refresh := func(want validator) {
+		t.Helper()
+		asked = nil
+		if err := r.refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(asked) != 1 || asked[0] != want {
+			t.Errorf("upstream asked with validators %+v, want %+v", asked, want)
+		}
+	}
+	wantValidators := func(want version) {
+		t.Helper()
+		saved := s.upstream.savedValidators()
+		if len(saved) != 1 || saved[0].ETag != want.etag || saved[0].LastModified != want.lastModified {
+			t.Errorf("validators %+v, want ETag %s and Last-Modified %s", saved, want.etag, want.lastModified)
+		}
+	}
+	v1 := validator{ETag: versions[0].etag, LastModified: versions[0].lastModified}
+
+	// The first refresh isn't conditional, and its 200 stores the validators.
+	refresh(validator{})
+	wantValidators(versions[0])
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_refreshes_total{outcome="success"}`:  1,
+		`energy_prices_refresh_slots_total{result="added"}`: 0,
+	})
+	slots := len(s.store.points(time.Time{}, time.Time{}))
+
+	// The next is conditional on them, and the 304 keeps the cache.
+	refresh(v1)
+	wantValidators(versions[0])
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_refreshes_total{outcome="not_modified"}`:                       1,
+		`energy_prices_upstream_fetch_duration_seconds_count{outcome="not_modified"}`: 1,
+	})
+	if got := len(s.store.points(time.Time{}, time.Time{})); got != slots {
+		t.Errorf("%d slots cached after a 304, want %d", got, slots)
+	}
+	wantStatus(t, get(t, s, "/price/current"), http.StatusOK)
+
+	// Once the prices change, the upstream answers the old validators with
+	// the new prices and validators, which the refresh after is conditional
+	// on.
+	current.Store(1)
+	refresh(v1)
+	wantValidators(versions[1])
+	wantMetrics(t, s.metrics, map[string]float64{
+		`energy_prices_refreshes_total{outcome="success"}`:  2,
+		`energy_prices_refresh_slots_total{result="added"}`: 1,
+	})
+	if got := len(s.store.points(time.Time{}, time.Time{})); got != slots+1 {
+		t.Errorf("%d slots cached after the change, want %d", got, slots+1)
+	}
+	refresh(validator{ETag: versions[1].etag, LastModified: versions[1].lastModified})
+	wantMetrics(t, s.metrics, map[string]float64{`energy_prices_refreshes_total{outcome="not_modified"}`: 2})
+}
+
+// TestUpstreamBodyTooLarge refreshes from an upstream whose response is
+// one byte beyond -upstream-max-body, which fails every attempt, and then
+// exactly as large, which is accepted.
+func TestUpstreamBodyTooLarge(t *testing.T) {
+	start, _ := refreshWindow(testNow, testLoc)
+	body := upstreamBody(start, testFirstDay.AddDate(0, 0, testDays))
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer up.Close()
+
+	for _, tt := range []struct {
+		name    string
+		maxBody int
+		err     string
+		metrics map[string]float64
+	}{
+		{"beyond the limit", len(body) - 1, fmt.Sprintf("response body too large: more than %d bytes", len(body)-1), map[string]float64{
+			`energy_prices_upstream_fetch_duration_seconds_count{outcome="too_large"}`: 3,
+			`energy_prices_upstream_response_bytes_count`:                              3,
+			`energy_prices_upstream_response_bytes_sum`:                                3 * float64(len(body)),
+			`energy_prices_upstream_retries_total`:                                     2,
+			`energy_prices_refreshes_total{outcome="failure"}`:                         1,
+			`energy_prices_refresh_consecutive_failures`:                               1,
+		}},
+		{"at the limit", len(body), "", map[string]float64{
+			`energy_prices_upstream_fetch_duration_seconds_count{outcome="success"}`: 1,
+			`energy_prices_refreshes_total{outcome="success"}`:                       1,
+			`energy_prices_refresh_consecutive_failures`:                             0,
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "-upstream-url", up.URL, "-upstream-max-body", strconv.Itoa(tt.maxBody))
+			s.upstream.backoff = 0
+			r := newRefresher(s.upstream, s, s.metrics)
+			err := r.refresh(context.Background())
+			r.completed(context.Background(), err)
+			if tt.err == "" && err != nil || tt.err != "" && (!errors.Is(err, errBodyTooLarge) || err.Error() != tt.err) {
+				t.Errorf("refresh error %v, want %q", err, tt.err)
+			}
+			wantMetrics(t, s.metrics, tt.metrics)
+		})
+	}
+}
+
+// blockingUpstream serves the mock once release is closed, counting the
+// requests it received and signaling each on started.
+type blockingUpstream struct {
+	mock     http.Handler
+	release  chan struct{}
+	started  chan struct{}
+	requests atomic.Int32
+	canceled atomic.Int32
+}
+
+func newBlockingUpstream(t *testing.T) (*blockingUpstream, *upstream) {
+	t.Helper()
+	b := &blockingUpstream{mock: newTestUpstream(t), release: make(chan struct{}), started: make(chan struct{}, 10)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.requests.Add(1)
+		b.started <- struct{}{}
+		select {
+		case <-b.release:
+			b.mock.ServeHTTP(w, r)
+		case <-r.Context().Done():
+			b.canceled.Add(1)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() {
+		select {
+		case <-b.release:
+		default:
+			close(b.release)
+		}
+	})
+
+	cfg, err := parseConfig([]string{"-upstream-url", srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := newUpstream(cfg, &registry{})
+	u.backoff = time.Millisecond
+	return b, u
+}
+
+// waitFor waits until cond holds, failing t if it doesn't within a few
+// seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+type fetchResult struct {
+	prices map[time.Time]float64
+	err    error
+}
+
+func startFetch(ctx context.Context, u *upstream, start, end time.Time) <-chan fetchResult {
+	c := make(chan fetchResult, 1)
+	go func() {
+		prices, err := u.fetch(ctx, start, end)
+		c <- fetchResult{prices, err}
+	}()
+	return c
+}
+
+func TestFetchCoalesced(t *testing.T) {
+	b, u := newBlockingUpstream(t)
+	start, end := testFirstDay, testFirstDay.AddDate(0, 0, 1)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	first := startFetch(canceled, u, start, end)
+	<-b.started
+	second := startFetch(context.Background(), u, start, end)
+	third := startFetch(context.Background(), u, start, end)
+	waitFor(t, "the fetches to join", func() bool { return u.coalesced.total() == 2 })
+
+	// The canceled caller returns without waiting, and without canceling
+	// the shared request for the others.
+	cancel()
+	if res := <-first; !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("canceled fetch returned %v", res.err)
+	}
+	close(b.release)
+	for _, c := range []<-chan fetchResult{second, third} {
+		if res := <-c; res.err != nil || len(res.prices) != 24 {
+			t.Errorf("fetch returned %d prices, %v, want 24", len(res.prices), res.err)
+		}
+	}
+	if n := b.requests.Load(); n != 1 {
+		t.Errorf("%d upstream requests, want 1", n)
+	}
+
+	// Later fetches make a request of their own.
+	if _, err := u.fetch(context.Background(), start, end); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.requests.Load(); n != 2 {
+		t.Errorf("%d upstream requests, want 2", n)
+	}
+}
+
+func TestFetchAllCanceled(t *testing.T) {
+	b, u := newBlockingUpstream(t)
+	start, end := testFirstDay, testFirstDay.AddDate(0, 0, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := startFetch(ctx, u, start, end)
+	<-b.started
+	second := startFetch(ctx, u, start, end)
+	waitFor(t, "the fetches to join", func() bool { return u.coalesced.total() == 1 })
+
+	// Once every caller has given up, so does the shared request, and a new
+	// caller doesn't join it.
+	cancel()
+	for _, c := range []<-chan fetchResult{first, second} {
+		if res := <-c; !errors.Is(res.err, context.Canceled) {
+			t.Errorf("canceled fetch returned %v", res.err)
+		}
+	}
+	waitFor(t, "the upstream request to be canceled", func() bool { return b.canceled.Load() == 1 })
+
+	third := startFetch(context.Background(), u, start, end)
+	<-b.started
+	close(b.release)
+	if res := <-third; res.err != nil || len(res.prices) != 24 {
+		t.Errorf("fetch returned %d prices, %v, want 24", len(res.prices), res.err)
+	}
+	if n := u.coalesced.total(); n != 1 {
+		t.Errorf("%v coalesced fetches, want 1", n)
+	}
+}
+
+func TestFetchCoalescedError(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		http.Error(w, "bad window", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	cfg, _ := parseConfig([]string{"-upstream-url", srv.URL})
+	u := newUpstream(cfg, &registry{})
+	start, end := testFirstDay, testFirstDay.AddDate(0, 0, 1)
+
+	first := startFetch(context.Background(), u, start, end)
+	waitFor(t, "the request", func() bool { return requests.Load() == 1 })
+	second := startFetch(context.Background(), u, start, end)
+	waitFor(t, "the fetches to join", func() bool { return u.coalesced.total() == 1 })
+	close(release)
+
+	// Both callers get the error of the single request.
+	for _, c := range []<-chan fetchResult{first, second} {
+		if res := <-c; !isPermanent(res.err) || !strings.Contains(res.err.Error(), "bad window") {
+			t.Errorf("fetch returned %v, want the permanent error of the upstream", res.err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d upstream requests, want 1", n)
+	}
+}
+
+// FuzzParsePrices checks that parsePrices accepts only prices at plausible
+// times, each the converted last price the body gives for its timestamp.
+// The seeds are written in the shape of the api.energy-charts.info /price
+// response, as served by the mock upstream, since the tests can't reach the
+
+// TestParsePricesGaps checks that null prices are left out as missing slots
+// rather than merged as zero, and that a response with a timestamp within a
+// slot is refused.
+func TestParsePricesGaps(t *testing.T) {
+	at := func(minutes int) time.Time { return time.Unix(1768300200, 0).Add(time.Duration(minutes) * time.Minute) }
+	tests := []struct {
+		name string
+		body string
+		want map[time.Time]float64
+		err  string
+	}{
+		{
+			"null in the middle",
+			`{"unix_seconds":[1768300200,1768301100,1768302000],"price":[9.5,null,8],"unit":"EUR / MWh"}`,
+			map[time.Time]float64{at(0): 9.5, at(30): 8},
+			"",
+		},
+		{"only nulls", `{"unix_seconds":[1768300200,1768301100],"price":[null,null],"unit":"EUR / MWh"}`, map[time.Time]float64{}, ""},
+		{"misaligned", `{"unix_seconds":[1768300200,1768301160],"price":[9.5,8],"unit":"EUR / MWh"}`, nil, "isn't at the start of a 15m0s slot"},
+		{"misaligned by a second", `{"unix_seconds":[1768300201],"price":[9.5],"unit":"EUR / MWh"}`, nil, "isn't at the start of a 15m0s slot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices, err := parsePrices([]byte(tt.body), "/price", time.Time{}, time.Time{}, testNow)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) || prices != nil {
+					t.Fatalf("prices %v, error %v, want an error containing %q", prices, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(prices, tt.want) {
+				t.Errorf("prices %v, want %v", prices, tt.want)
+			}
+		})
+	}
+}
+
+// real API.
+func FuzzParsePrices(f *testing.F) {
+	res := httptest.NewRecorder()
+	newTestUpstream(f).ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/price?bzn=DE-LU&start=2026-01-13&end=2026-01-15", nil))
+	if res.Code != http.StatusOK {
+		f.Fatalf("mock upstream status %d: %s", res.Code, res.Body)
+	}
+	f.Add(res.Body.Bytes(), false)
+	f.Add(res.Body.Bytes(), true)
+	for _, body := range []string{
+		`{"license_info":"CC BY 4.0 (creativecommons.org/licenses/by/4.0) from Bundesnetzagentur | SMARD.de","unix_seconds":[1768258800,1768262400,1768266000],"price":[84.12,-3.5,0.0],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[1768300200,1768301100,1768302000,1768302900],"price":[9.611,9.02,8.75,8.1],"unit":"ct / kWh","deprecated":false}`,
+		`{"unix_seconds":[1768300200],"price":[0.09611],"unit":"EUR/kWh","deprecated":false}`,
+		`{"unix_seconds":[1768300200,1768300200],"price":[1,2],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[1768300200],"price":[null],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[],"price":[],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[1768300200],"price":[1],"unit":"EUR / MWh","deprecated":true}`,
+		`{"unix_seconds":[1768300200,1768301100],"price":[1],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[1768300200],"price":[1],"unit":"USD / MWh","deprecated":false}`,
+		`{"unix_seconds":[1262304000],"price":[1],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[1893456000],"price":[1],"unit":"EUR / MWh","deprecated":false}`,
+		`{"unix_seconds":[1.7683002e9],"price":[1],"unit":"EUR / MWh"}`,
+		`{"unix_seconds":[1768300200],"price":[1e308],"unit":"EUR/kWh"}`,
+		`{"unix_seconds":"1768300200","price":1}`,
+		`[]`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(body), false)
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte, ranged bool) {
+		var start, end time.Time
+		if ranged {
+			start, end = testNow.Add(-24*time.Hour), testNow.Add(48*time.Hour)
+		}
+		prices, err := parsePrices(body, "/price", start, end, testNow)
+		if err != nil {
+			if prices != nil {
+				t.Fatalf("prices %v with error %v", prices, err)
+			}
+			return
+		}
+
+		var payload marketPrices
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("parsed a body that doesn't decode: %v", err)
+		}
+		convert, err := fromUnit(payload.Unit)
+		if err != nil {
+			t.Fatalf("parsed a body of unknown unit %q", payload.Unit)
+		}
+		if payload.Deprecated {
+			t.Fatal("parsed a deprecated response")
+		}
+		want := make(map[time.Time]float64)
+		for i, unix := range payload.Timestamps {
+			if unix%int64(finestResolution/time.Second) != 0 {
+				t.Fatalf("parsed a timestamp %d within a slot", unix)
+			}
+			if p := payload.Prices[i]; p != nil {
+				want[time.Unix(unix, 0)] = convert(*p)
+			}
+		}
+		if len(prices) != len(want) {
+			t.Fatalf("%d prices, want %d", len(prices), len(want))
+		}
+		for ts, p := range prices {
+			if ts.Before(earliestPlausible) || ts.After(testNow.Add(maxPublishedAhead)) {
+				t.Errorf("implausible time %s", ts.UTC().Format(time.RFC3339))
+			}
+			if w, ok := want[ts]; !ok || p != w && !(math.IsNaN(p) && math.IsNaN(w)) {
+				t.Errorf("price %v at %s, want %v", p, ts.UTC().Format(time.RFC3339), w)
+			}
+		}
+	})
+}
+
+// newFixedUpstream returns an upstream answering all requests with handler,
+// at testNow on a fakeClock, and the registry of its metrics.
+func newFixedUpstream(t *testing.T, handler http.HandlerFunc) (*upstream, *registry) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cfg, err := parseConfig([]string{"-upstream-url", srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &registry{}
+	u := newUpstream(cfg, reg)
+	u.clock = newFakeClock(testNow)
+	u.backoff = 0
+	return u, reg
+}
+
+// upstreamBody returns an upstream response body with the hourly slots of
+// [start, end) priced by testPrice, leaving out those starting at skipped.
+func upstreamBody(start, end time.Time, skipped ...time.Time) string {
+	var unix, prices []string
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		if slices.ContainsFunc(skipped, t.Equal) {
+			continue
+		}
+		unix = append(unix, strconv.FormatInt(t.Unix(), 10))
+		prices = append(prices, strconv.FormatFloat(testPrice(t), 'f', -1, 64))
+	}
+	return fmt.Sprintf(`{"unix_seconds":[%s],"price":[%s],"unit":"EUR / MWh","deprecated":false}`, strings.Join(unix, ","), strings.Join(prices, ","))
+}
+
+// logBuffer holds what is logged, and can be read while goroutines log.
+type logBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+// captureLog returns a buffer with what is logged until the end of the test.
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	var b logBuffer
+	prev := log.Writer()
+	log.SetOutput(&b)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return &b
+}
+
+// TestCheckShortfall fetches responses missing slots of the window, at
+// 15:30 on testNow's Europe/Berlin day, and checks what is logged and
+// counted. Only the holes before now are warned about.
+func TestCheckShortfall(t *testing.T) {
+	today := testFirstDay.AddDate(0, 0, 1)
+	tomorrow := today.AddDate(0, 0, 1)
+	tests := []struct {
+		name       string
+		start, end time.Time
+		body       string
+		log        string
+		metrics    map[string]float64
+	}{
+		{
+			// The 24 slots of tomorrow are all in the future.
+			"tomorrow not published",
+			today, tomorrow.AddDate(0, 0, 1),
+			upstreamBody(today, tomorrow),
+			"upstream response has 24 of 48 slots, 24 aren't published yet",
+			map[string]float64{`energy_prices_upstream_missing_slots_total{when="future"}`: 24},
+		},
+		{
+			// The slots from 03:00 to 06:00 are missing.
+			"hole in the middle",
+			today, tomorrow,
+			upstreamBody(today, tomorrow, today.Add(3*time.Hour), today.Add(4*time.Hour), today.Add(5*time.Hour)),
+			"warning: upstream response for 2026-01-12T23:00:00Z to 2026-01-13T23:00:00Z misses 3 of 24 slots before now",
+			map[string]float64{`energy_prices_upstream_missing_slots_total{when="past"}`: 3},
+		},
+		{
+			// The 16 slots from midnight to 15:00 have started, the 8 after
+			// not.
+			"empty 200",
+			today, tomorrow,
+			upstreamBody(today, today),
+			"warning: upstream response for 2026-01-12T23:00:00Z to 2026-01-13T23:00:00Z misses 16 of 24 slots before now",
+			map[string]float64{
+				`energy_prices_upstream_missing_slots_total{when="past"}`:   16,
+				`energy_prices_upstream_missing_slots_total{when="future"}`: 8,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, reg := newFixedUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.body)
+			})
+			logged := captureLog(t)
+			if _, err := u.fetchOnce(context.Background(), tt.start, tt.end, nil); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(logged.String(), tt.log+"\n") {
+				t.Errorf("logged %q, want a line %q", logged, tt.log)
+			}
+			wantMetrics(t, reg, tt.metrics)
+			for _, when := range []string{"past", "future"} {
+				series := `energy_prices_upstream_missing_slots_total{when="` + when + `"}`
+				if _, ok := tt.metrics[series]; !ok {
+					if v, ok := metricValue(reg, series); ok {
+						t.Errorf("%s = %v, want it unwritten", series, v)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// vendorTypePattern matches the versioned media types of JSON listings,
+// e.g. application/vnd.energy-prices.v2+json.
+var vendorTypePattern = regexp.MustCompile(`^application/vnd\.energy-prices\.v(\d+)\+json$`)
+
+// listing is what listing serializers render.
+type listing struct {
+	Unit       string
+	Start, End time.Time
+	Slots      []any
+}
+
+// listingSerializers render JSON listings by response schema version.
+// Version 1 is the original bare array.
+var listingSerializers = map[int]func(w http.ResponseWriter, l listing){
+	1: func(w http.ResponseWriter, l listing) {
+		writeJSON(w, l.Slots)
+	},
+	2: func(w http.ResponseWriter, l listing) {
+		w.Header().Set("Content-Type", "application/vnd.energy-prices.v2+json")
+		writeJSON(w, struct {
+			Version int        `json:"version"`
+			Unit    string     `json:"unit"`
+			Start   *time.Time `json:"start"`
+			End     *time.Time `json:"end"`
+			Count   int        `json:"count"`
+			Slots   []any      `json:"slots"`
+			License license    `json:"license"`
+		}{2, l.Unit, optionalTime(l.Start), optionalTime(l.End), len(l.Slots), l.Slots, dataLicense})
+	},
+}
+
+// optionalTime returns nil for the zero time and t in UTC otherwise.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// parseVersion returns the response schema version requested by the v query
+// parameter or, without it, by a versioned media type in the Accept header.
+// It defaults to version 1.
+func parseVersion(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("v")
+	if v == "" {
+		for _, entry := range splitAccept(r.Header.Get("Accept")) {
+			if m := vendorTypePattern.FindStringSubmatch(entry); m != nil {
+				v = m[1]
+				break
+			}
+		}
+	}
+	if v == "" {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if _, ok := listingSerializers[n]; err != nil || !ok {
+		return 0, fmt.Errorf("v: unknown response version %q, expected 1 or 2", v)
+	}
+	return n, nil
+}
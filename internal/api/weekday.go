@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// weekdayBuckets groups the prices of points by ISO weekday of their local
+// date in loc, Monday first, sharing the splitting of timeOfDayBuckets.
+func weekdayBuckets(points []pricePoint, width time.Duration, loc *time.Location) [][]float64 {
+	return localBuckets(points, width, loc, 7, func(t time.Time) int {
+		return (int(t.Weekday()) + 6) % 7
+	})
+}
+
+type weekdayStats struct {
+	Count  int      `json:"count"`
+	Mean   *float64 `json:"mean"`
+	Median *float64 `json:"median"`
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
+}
+
+// newWeekdayStats summarizes prices, which it sorts in place.
+func newWeekdayStats(prices []float64, div float64) weekdayStats {
+	st := weekdayStats{Count: len(prices)}
+	if len(prices) == 0 {
+		return st
+	}
+	m, med := mean(prices)/div, median(prices)/div
+	lo, hi := slices.Min(prices)/div, slices.Max(prices)/div
+	st.Mean, st.Median, st.Min, st.Max = &m, &med, &lo, &hi
+	return st
+}
+
+// handleWeekdayProfile reports price statistics per ISO weekday of the
+// Europe/Berlin date, and compares working days with weekends.
+func (s *server) handleWeekdayProfile(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, end, err := parseRange(q, s.loc)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	unit, div, err := s.parseUnit(w, q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	points := s.store.points(start, end)
+	buckets := weekdayBuckets(points, resolution(points), s.loc)
+
+	type weekdayProfile struct {
+		Weekday int    `json:"weekday"`
+		Name    string `json:"name"`
+		weekdayStats
+	}
+	days := make([]weekdayProfile, len(buckets))
+	var workdays, weekends []float64
+	for i, prices := range buckets {
+		if i < 5 {
+			workdays = append(workdays, prices...)
+		} else {
+			weekends = append(weekends, prices...)
+		}
+		days[i] = weekdayProfile{i + 1, time.Weekday((i + 1) % 7).String(), newWeekdayStats(prices, div)}
+	}
+
+	workday, weekend := newWeekdayStats(workdays, div), newWeekdayStats(weekends, div)
+	var discount *float64
+	if workday.Mean != nil && weekend.Mean != nil {
+		d := *workday.Mean - *weekend.Mean
+		discount = &d
+	}
+
+	writeJSON(w, struct {
+		Unit     string           `json:"unit"`
+		Days     []weekdayProfile `json:"days"`
+		Workday  weekdayStats     `json:"workday"`
+		Weekend  weekdayStats     `json:"weekend"`
+		Discount *float64         `json:"weekend_discount"`
+	}{unit, days, workday, weekend, discount})
+}
@@ -0,0 +1,367 @@
+package brotli
+
+import (
+	"errors"
+	"fmt"
+)
+
+// decode decompresses a Brotli stream following RFC 7932, as far as it
+// is needed to check those of the Writer: it rejects streams with several
+// block types, context maps or references to the static dictionary.
+func decode(data []byte) ([]byte, error) {
+	r := &bitReader{data: data}
+	wbits := 16
+	if r.read(1) == 1 {
+		if n := r.read(3); n != 0 {
+			wbits = 17 + int(n)
+		} else if n = r.read(3); n == 1 {
+			return nil, errors.New("large window")
+		} else if n != 0 {
+			wbits = 8 + int(n)
+		} else {
+			wbits = 17
+		}
+	}
+	maxDistance := 1<<wbits - 16
+
+	var out []byte
+	// dists is the distance ring buffer, the last distance first.
+	dists := []int{4, 11, 15, 16}
+	for {
+		last := r.read(1) == 1
+		if last && r.read(1) == 1 {
+			break
+		}
+		nibbles := int(r.read(2)) + 4
+		if nibbles == 7 {
+			if last {
+				return nil, errors.New("last metadata block")
+			}
+			if r.read(1) != 0 {
+				return nil, errors.New("reserved bit set")
+			}
+			skipBytes := int(r.read(2))
+			skip := 0
+			for i := range skipBytes {
+				b := int(r.read(8))
+				if i == skipBytes-1 && i > 0 && b == 0 {
+					return nil, errors.New("MSKIPLEN with a zero last byte")
+				}
+				skip |= b << (8 * i)
+			}
+			if skipBytes > 0 {
+				skip++
+			}
+			if err := r.align(); err != nil {
+				return nil, err
+			}
+			if r.pos += 8 * skip; r.pos > 8*len(r.data) {
+				return nil, errors.New("metadata beyond the end")
+			}
+			continue
+		}
+		length := int(r.read(uint(4 * nibbles)))
+		if nibbles > 4 && length>>(4*nibbles-4) == 0 {
+			return nil, errors.New("MLEN with a zero last nibble")
+		}
+		length++
+		if !last && r.read(1) == 1 {
+			if err := r.align(); err != nil {
+				return nil, err
+			}
+			start := r.pos / 8
+			if start+length > len(r.data) {
+				return nil, errors.New("uncompressed block beyond the end")
+			}
+			out = append(out, r.data[start:start+length]...)
+			r.pos += 8 * length
+			continue
+		}
+
+		for range 3 {
+			if n := r.readCount(); n != 1 {
+				return nil, fmt.Errorf("%d block types", n)
+			}
+		}
+		npostfix := uint(r.read(2))
+		ndirect := int(r.read(4)) << npostfix
+		r.read(2)
+		for range 2 {
+			if n := r.readCount(); n != 1 {
+				return nil, fmt.Errorf("%d prefix codes", n)
+			}
+		}
+		literals, err := r.readPrefixCode(256)
+		if err != nil {
+			return nil, fmt.Errorf("literal code: %w", err)
+		}
+		lengths, err := r.readPrefixCode(704)
+		if err != nil {
+			return nil, fmt.Errorf("insert-and-copy length code: %w", err)
+		}
+		distances, err := r.readPrefixCode(16 + ndirect + 48<<npostfix)
+		if err != nil {
+			return nil, fmt.Errorf("distance code: %w", err)
+		}
+
+		for length > 0 {
+			code := lengths.decode(r)
+			insertCode, copyCode := code>>3&7, code&7
+			if code >= 128 {
+				// The codes from 128 on come in ranges of 64 by the ranges of
+				// eight insert and copy length codes they combine.
+				b := [9][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {0, 2}, {2, 0}, {1, 2}, {2, 1}, {2, 2}}[(code-128)>>6]
+				insertCode, copyCode = b[0]<<3|insertCode, b[1]<<3|copyCode
+			} else {
+				copyCode |= code >> 6 << 3
+			}
+			insert := insertOffsets[insertCode] + int(r.read(insertBits[insertCode]))
+			copyLength := copyOffsets[copyCode] + int(r.read(copyBits[copyCode]))
+			if insert > length {
+				return nil, errors.New("insert beyond the meta-block")
+			}
+			for range insert {
+				out = append(out, byte(literals.decode(r)))
+			}
+			if length -= insert; length == 0 {
+				break
+			}
+
+			dcode := 0
+			if code >= 128 {
+				dcode = distances.decode(r)
+			}
+			var distance int
+			switch {
+			case dcode < 16:
+				delta := [16]int{0, 0, 0, 0, -1, 1, -2, 2, -3, 3, -1, 1, -2, 2, -3, 3}[dcode]
+				index := [16]int{0, 1, 2, 3, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1}[dcode]
+				if distance = dists[index] + delta; distance <= 0 {
+					return nil, errors.New("distance below 1")
+				}
+			case dcode < 16+ndirect:
+				distance = dcode - 15
+			default:
+				hcode := (dcode - ndirect - 16) >> npostfix
+				lcode := (dcode - ndirect - 16) & (1<<npostfix - 1)
+				nbits := uint(1 + hcode>>1)
+				offset := (2+hcode&1)<<nbits - 4
+				distance = (offset+int(r.read(nbits)))<<npostfix + lcode + ndirect + 1
+			}
+			if distance > min(len(out), maxDistance) {
+				return nil, fmt.Errorf("distance %d beyond the window, a dictionary reference", distance)
+			}
+			if dcode != 0 {
+				dists = append([]int{distance}, dists[:3]...)
+			}
+			if copyLength > length {
+				return nil, errors.New("copy beyond the meta-block")
+			}
+			for range copyLength {
+				out = append(out, out[len(out)-distance])
+			}
+			length -= copyLength
+		}
+		if r.pos > 8*len(r.data) {
+			return nil, errors.New("unexpected end")
+		}
+		if last {
+			break
+		}
+	}
+	if err := r.align(); err != nil {
+		return nil, err
+	}
+	if r.pos != 8*len(r.data) {
+		return nil, fmt.Errorf("%d bytes after the stream", len(r.data)-r.pos/8)
+	}
+	return out, nil
+}
+
+// bitReader reads bits starting with the least significant bit of each
+// byte, past the end as zeros.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := range n {
+		if r.pos/8 < len(r.data) {
+			v |= uint32(r.data[r.pos/8]>>(r.pos%8)&1) << i
+		}
+		r.pos++
+	}
+	return v
+}
+
+// align skips to the next byte, requiring the bits skipped to be zero.
+func (r *bitReader) align() error {
+	if r.pos%8 != 0 && r.read(uint(8-r.pos%8)) != 0 {
+		return errors.New("nonzero padding")
+	}
+	return nil
+}
+
+// readCount reads a number of block types or prefix codes, from 1 to 256.
+func (r *bitReader) readCount() int {
+	if r.read(1) == 0 {
+		return 1
+	}
+	n := uint(r.read(3))
+	return 1<<n + int(r.read(n)) + 1
+}
+
+// decodingCode decodes the symbols of a canonical prefix code.
+type decodingCode struct {
+	// counts has the number of codes of each length, and symbols the
+	// symbols by their codes.
+	counts  [maxCodeLength + 1]int
+	symbols []int
+}
+
+func newDecodingCode(lengths []int) *decodingCode {
+	c := &decodingCode{}
+	for _, l := range lengths {
+		c.counts[l]++
+	}
+	c.counts[0] = 0
+	for l := 1; l <= maxCodeLength; l++ {
+		for sym, sl := range lengths {
+			if sl == l {
+				c.symbols = append(c.symbols, sym)
+			}
+		}
+	}
+	return c
+}
+
+func (c *decodingCode) decode(r *bitReader) int {
+	if len(c.symbols) == 1 {
+		return c.symbols[0]
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeLength; l++ {
+		code |= int(r.read(1))
+		if code-first < c.counts[l] {
+			return c.symbols[index+code-first]
+		}
+		index += c.counts[l]
+		first = (first + c.counts[l]) << 1
+		code <<= 1
+	}
+	// Codes are complete, so this is past the end of the input.
+	r.pos = 8*len(r.data) + 1
+	return 0
+}
+
+func (r *bitReader) readPrefixCode(alphabetSize int) (*decodingCode, error) {
+	lengths := make([]int, alphabetSize)
+	skip := int(r.read(2))
+	if skip == 1 {
+		n := int(r.read(2)) + 1
+		alphabetBits := uint(0)
+		for 1<<alphabetBits < alphabetSize {
+			alphabetBits++
+		}
+		syms := make([]int, n)
+		for i := range syms {
+			if syms[i] = int(r.read(alphabetBits)); syms[i] >= alphabetSize {
+				return nil, fmt.Errorf("symbol %d beyond the alphabet", syms[i])
+			}
+			for _, s := range syms[:i] {
+				if s == syms[i] {
+					return nil, fmt.Errorf("symbol %d listed twice", s)
+				}
+			}
+		}
+		shape := [][]int{{0}, {1, 1}, {1, 2, 2}, {2, 2, 2, 2}}[n-1]
+		if n == 4 && r.read(1) == 1 {
+			shape = []int{1, 2, 3, 3}
+		}
+		for i, s := range syms {
+			lengths[s] = shape[i]
+		}
+		if n == 1 {
+			return &decodingCode{symbols: syms}, nil
+		}
+		return newDecodingCode(lengths), nil
+	}
+
+	var clLengths [18]int
+	space, codes := 32, 0
+	for _, sym := range codeLengthOrder[skip:] {
+		var l int
+		switch r.read(2) {
+		case 0:
+			l = 0
+		case 1:
+			l = 4
+		case 2:
+			l = 3
+		default:
+			if r.read(1) == 0 {
+				l = 2
+			} else if r.read(1) == 0 {
+				l = 1
+			} else {
+				l = 5
+			}
+		}
+		clLengths[sym] = l
+		if l != 0 {
+			codes++
+			if space -= 32 >> l; space <= 0 {
+				break
+			}
+		}
+	}
+	if codes != 1 && space != 0 {
+		return nil, errors.New("incomplete code of code lengths")
+	}
+	clCode := newDecodingCode(clLengths[:])
+
+	previous, repeat, repeatLength := 8, 0, 0
+	space = 1 << maxCodeLength
+	for sym := 0; sym < alphabetSize && space > 0; {
+		l := clCode.decode(r)
+		if l < 16 {
+			lengths[sym] = l
+			sym++
+			repeat = 0
+			if l != 0 {
+				previous = l
+				space -= 1 << maxCodeLength >> l
+			}
+			continue
+		}
+		extraBits, newLength := uint(2), previous
+		if l == 17 {
+			extraBits, newLength = 3, 0
+		}
+		if repeatLength != newLength {
+			repeat, repeatLength = 0, newLength
+		}
+		old := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extraBits
+		}
+		repeat += int(r.read(extraBits)) + 3
+		delta := repeat - old
+		if sym+delta > alphabetSize {
+			return nil, errors.New("repetition beyond the alphabet")
+		}
+		for range delta {
+			lengths[sym] = repeatLength
+			sym++
+		}
+		if repeatLength != 0 {
+			space -= delta * (1 << maxCodeLength >> repeatLength)
+		}
+	}
+	if space != 0 {
+		return nil, errors.New("incomplete code")
+	}
+	return newDecodingCode(lengths), nil
+}
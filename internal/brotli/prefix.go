@@ -0,0 +1,342 @@
+package brotli
+
+import (
+	"cmp"
+	"slices"
+)
+
+// maxCodeLength is the length of the longest prefix code of a symbol, and
+// maxCodeLengthCodeLength that of the code of a code length.
+const (
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 5
+)
+
+// codeLengthOrder is the order the lengths of the codes of code lengths
+// are written in.
+var codeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// prefixCode is a canonical prefix code over an alphabet. The codes are
+// bit-reversed, as they are written starting with their first bit.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func newPrefixCode(alphabetSize int) *prefixCode {
+	return &prefixCode{lengths: make([]uint8, alphabetSize), codes: make([]uint16, alphabetSize)}
+}
+
+func (c *prefixCode) write(b *bitWriter, sym int) {
+	b.write(uint(c.lengths[sym]), uint64(c.codes[sym]))
+}
+
+// assignCodes assigns the canonical codes of the lengths.
+func (c *prefixCode) assignCodes() {
+	var count [maxCodeLength + 1]int
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 1]int
+	code := 0
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for sym, l := range c.lengths {
+		if l == 0 {
+			c.codes[sym] = 0
+			continue
+		}
+		c.codes[sym] = reverseBits(next[l], l)
+		next[l]++
+	}
+}
+
+func reverseBits(code int, length uint8) uint16 {
+	var r uint16
+	for range length {
+		r = r<<1 | uint16(code&1)
+		code >>= 1
+	}
+	return r
+}
+
+// scratch holds what is built while writing prefix codes, reused for the
+// next ones.
+type scratch struct {
+	nodes   []huffmanNode
+	depths  []int
+	symbols []codeLength
+	clCode  *prefixCode
+}
+
+// huffmanNode is a node of a Huffman tree.
+type huffmanNode struct {
+	count uint32
+	// sym is the symbol of a leaf, and -1 for the others.
+	sym         int
+	left, right int
+}
+
+// buildLengths sets the lengths of c to those of a prefix code of at most
+// maxLen bits for the symbol counts hist, with at least two symbols
+// counted. Until the lengths fit, the smallest counts are raised.
+func (c *prefixCode) buildLengths(hist []uint32, maxLen int, s *scratch) {
+	for minCount := uint32(1); ; minCount *= 2 {
+		if s.huffmanLengths(hist, minCount, c.lengths) <= maxLen {
+			return
+		}
+	}
+}
+
+// huffmanLengths sets lengths to those of a Huffman code for hist, counting
+// each symbol counted at least minCount times, and returns the longest.
+func (s *scratch) huffmanLengths(hist []uint32, minCount uint32, lengths []uint8) int {
+	nodes := s.nodes[:0]
+	for sym, n := range hist {
+		if n > 0 {
+			nodes = append(nodes, huffmanNode{count: max(n, minCount), sym: sym})
+		}
+	}
+	slices.SortFunc(nodes, func(a, b huffmanNode) int {
+		if c := cmp.Compare(a.count, b.count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.sym, b.sym)
+	})
+	clear(lengths)
+	leaves := len(nodes)
+	if leaves == 1 {
+		lengths[nodes[0].sym] = 1
+		return 1
+	}
+	// The leaves and the inner nodes, appended as they come, are both in
+	// order of their counts, so the two smallest nodes are at their fronts.
+	nextLeaf, nextInner := 0, leaves
+	smallest := func() int {
+		if nextLeaf < leaves && (nextInner == len(nodes) || nodes[nextLeaf].count <= nodes[nextInner].count) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextInner++
+		return nextInner - 1
+	}
+	for range leaves - 1 {
+		left, right := smallest(), smallest()
+		nodes = append(nodes, huffmanNode{count: nodes[left].count + nodes[right].count, sym: -1, left: left, right: right})
+	}
+	s.nodes = nodes
+	depths := slices.Grow(s.depths[:0], len(nodes))[:len(nodes)]
+	clear(depths)
+	s.depths = depths
+	longest := 0
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if n := nodes[i]; n.sym >= 0 {
+			lengths[n.sym] = uint8(min(depths[i], 255))
+			longest = max(longest, depths[i])
+		} else {
+			depths[n.left], depths[n.right] = depths[i]+1, depths[i]+1
+		}
+	}
+	return longest
+}
+
+// writePrefixCode sets c to a prefix code for the symbol counts hist and
+// writes its description to b. alphabetBits is the number of bits a
+// symbol of the alphabet is written with.
+func writePrefixCode(b *bitWriter, hist []uint32, alphabetBits uint, c *prefixCode, s *scratch) {
+	var buf [5]int
+	used := buf[:0]
+	for sym, n := range hist {
+		if n > 0 {
+			if used = append(used, sym); len(used) > 4 {
+				break
+			}
+		}
+	}
+	if len(used) > 4 {
+		c.buildLengths(hist, maxCodeLength, s)
+		c.assignCodes()
+		s.writeComplexPrefixCode(b, c.lengths)
+		return
+	}
+
+	// A simple prefix code lists its up to four symbols, with lengths by
+	// their number, the most frequent symbols first.
+	if len(used) == 0 {
+		used = append(used, 0)
+	}
+	slices.SortStableFunc(used, func(a, b int) int { return cmp.Compare(hist[b], hist[a]) })
+	clear(c.lengths)
+	treeSelect := uint64(0)
+	switch len(used) {
+	case 1:
+		// The only symbol takes no bits at all.
+	case 2:
+		c.lengths[used[0]], c.lengths[used[1]] = 1, 1
+	case 3:
+		c.lengths[used[0]], c.lengths[used[1]], c.lengths[used[2]] = 1, 2, 2
+	case 4:
+		// Either all symbols take two bits, or one, two, three and three.
+		n0, n1, n23 := hist[used[0]], hist[used[1]], hist[used[2]]+hist[used[3]]
+		if uint64(n0)+2*uint64(n1)+3*uint64(n23) < 2*(uint64(n0)+uint64(n1)+uint64(n23)) {
+			treeSelect = 1
+			c.lengths[used[0]], c.lengths[used[1]], c.lengths[used[2]], c.lengths[used[3]] = 1, 2, 3, 3
+		} else {
+			for _, sym := range used {
+				c.lengths[sym] = 2
+			}
+		}
+	}
+	c.assignCodes()
+	b.write(2, 1)
+	b.write(2, uint64(len(used)-1))
+	for _, sym := range used {
+		b.write(alphabetBits, uint64(sym))
+	}
+	if len(used) == 4 {
+		b.write(1, treeSelect)
+	}
+}
+
+// codeLength is a symbol of the code of code lengths, a length or a
+// repetition of one, with its extra bits.
+type codeLength struct {
+	sym   uint8
+	extra uint8
+}
+
+// codeLengthExtraBits are the numbers of extra bits of the repetitions of
+// the previous length and of zeros.
+var codeLengthExtraBits = [18]uint8{16: 2, 17: 3}
+
+// writeComplexPrefixCode writes the description of the prefix code with
+// the lengths, which form a complete code, as the lengths of a code of
+// code lengths followed by the lengths coded with it.
+func (s *scratch) writeComplexPrefixCode(b *bitWriter, lengths []uint8) {
+	symbols := appendCodeLengthSymbols(s.symbols[:0], lengths)
+	s.symbols = symbols
+	var hist [18]uint32
+	for _, s := range symbols {
+		hist[s.sym]++
+	}
+	if s.clCode == nil {
+		s.clCode = newPrefixCode(18)
+	}
+	clCode := s.clCode
+	headerLengths := clCode.lengths
+	used := 0
+	for _, n := range hist {
+		if n > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		// A single code length takes no bits, whatever its length in the
+		// header, which then lists all the lengths.
+		clear(clCode.lengths)
+		clCode.assignCodes()
+		headerLengths = make([]uint8, len(clCode.lengths))
+		for sym, n := range hist {
+			if n > 0 {
+				headerLengths[sym] = 1
+			}
+		}
+	} else {
+		clCode.buildLengths(hist[:], maxCodeLengthCodeLength, s)
+		clCode.assignCodes()
+	}
+
+	skip := 0
+	if headerLengths[codeLengthOrder[0]] == 0 && headerLengths[codeLengthOrder[1]] == 0 {
+		skip = 2
+		if headerLengths[codeLengthOrder[2]] == 0 {
+			skip = 3
+		}
+	}
+	b.write(2, uint64(skip))
+	space := 32
+	for _, sym := range codeLengthOrder[skip:] {
+		l := headerLengths[sym]
+		b.write(uint(staticCodeLengthBits[l]), uint64(staticCodeLengthCodes[l]))
+		if l != 0 {
+			if space -= 32 >> l; space <= 0 {
+				break
+			}
+		}
+	}
+	for _, s := range symbols {
+		clCode.write(b, int(s.sym))
+		b.write(uint(codeLengthExtraBits[s.sym]), uint64(s.extra))
+	}
+}
+
+// staticCodeLengthCodes are the codes the lengths of the code of code
+// lengths are written with, and staticCodeLengthBits their lengths.
+var (
+	staticCodeLengthCodes = [6]uint8{0, 7, 3, 2, 1, 15}
+	staticCodeLengthBits  = [6]uint8{2, 4, 3, 2, 2, 4}
+)
+
+// appendCodeLengthSymbols appends the lengths to symbols, run-length coded
+// as symbols of the code of code lengths. Trailing zeros are left out, as
+// the code is complete with the last length.
+func appendCodeLengthSymbols(symbols []codeLength, lengths []uint8) []codeLength {
+	n := len(lengths)
+	for n > 0 && lengths[n-1] == 0 {
+		n--
+	}
+	// previous is the last nonzero length, those repetitions repeat.
+	previous := uint8(8)
+	for i := 0; i < n; {
+		l := lengths[i]
+		reps := 1
+		for i+reps < n && lengths[i+reps] == l {
+			reps++
+		}
+		i += reps
+		if l == 0 {
+			symbols = appendRepetitions(symbols, 0, reps, 17)
+			continue
+		}
+		if l != previous {
+			symbols = append(symbols, codeLength{sym: l})
+			reps--
+			previous = l
+		}
+		symbols = appendRepetitions(symbols, l, reps, 16)
+	}
+	return symbols
+}
+
+// appendRepetitions appends reps repetitions of the length l, as the
+// symbol repeat if it is worth it. Successive repeat symbols repeat their
+// combined count, the first the most significant extra bits.
+func appendRepetitions(symbols []codeLength, l uint8, reps int, repeat uint8) []codeLength {
+	bits := codeLengthExtraBits[repeat]
+	// Eleven zeros or seven lengths take fewer bits as one more literal
+	// length and a single repetition.
+	if reps == 11 && repeat == 17 || reps == 7 && repeat == 16 {
+		symbols = append(symbols, codeLength{sym: l})
+		reps--
+	}
+	if reps < 3 {
+		for range reps {
+			symbols = append(symbols, codeLength{sym: l})
+		}
+		return symbols
+	}
+	start := len(symbols)
+	reps -= 3
+	for {
+		symbols = append(symbols, codeLength{sym: repeat, extra: uint8(reps & (1<<bits - 1))})
+		if reps >>= bits; reps == 0 {
+			break
+		}
+		reps--
+	}
+	slices.Reverse(symbols[start:])
+	return symbols
+}
@@ -0,0 +1,558 @@
+// Package brotli implements a Brotli encoder, of the format of RFC 7932,
+// for compressing responses to clients preferring it to gzip. It finds
+// matches in a window of 256 KiB with hash chains searched more
+// thoroughly at higher levels, and codes every meta-block with a single
+// prefix code for each of its literals, commands and distances, leaving
+// out the context modeling and static dictionary of the format.
+package brotli
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// The compression levels. Like those of the reference encoder, they range
+// from 1 to 11, though their output differs.
+const (
+	BestSpeed          = 1
+	BestCompression    = 11
+	DefaultCompression = 6
+)
+
+// windowBits is the base-2 logarithm of the window size, of which a match
+// may be at most maxDistance bytes back.
+const (
+	windowBits  = 18
+	windowSize  = 1 << windowBits
+	maxDistance = windowSize - 16
+)
+
+// blockSize is the amount of input coded as a meta-block, unless flushed
+// before.
+const blockSize = 1 << 16
+
+const (
+	hashBits = 16
+	minMatch = 4
+	// minLastDistanceMatch is the shortest match at the last distance,
+	// which takes no distance code.
+	minLastDistanceMatch = 3
+)
+
+// levels are the parameters of the levels: how many earlier positions with
+// the same hash are tried for a match, the length of a match good enough to
+// stop trying, whether a match is given up for a better one at the next
+// position, and how many positions within a match are hashed.
+var levels = [...]struct {
+	chain, nice int
+	lazy        bool
+	hashed      int
+}{
+	1:  {chain: 1, nice: 16, hashed: 4},
+	2:  {chain: 4, nice: 32, hashed: 8},
+	3:  {chain: 8, nice: 32, hashed: 16},
+	4:  {chain: 8, nice: 64, lazy: true, hashed: 1 << 30},
+	5:  {chain: 16, nice: 64, lazy: true, hashed: 1 << 30},
+	6:  {chain: 32, nice: 128, lazy: true, hashed: 1 << 30},
+	7:  {chain: 64, nice: 128, lazy: true, hashed: 1 << 30},
+	8:  {chain: 128, nice: 256, lazy: true, hashed: 1 << 30},
+	9:  {chain: 256, nice: 256, lazy: true, hashed: 1 << 30},
+	10: {chain: 512, nice: 512, lazy: true, hashed: 1 << 30},
+	11: {chain: 1024, nice: 1024, lazy: true, hashed: 1 << 30},
+}
+
+// A Writer compresses what is written to it to an underlying writer. Like
+// a gzip.Writer, it is initialized by NewWriterLevel or reused by Reset,
+// and has to be closed to complete the stream.
+type Writer struct {
+	w     io.Writer
+	level int
+	err   error
+
+	// buf holds the window of input already coded followed by the input at
+	// pos on still to be coded.
+	buf []byte
+	pos int
+	// head holds the last position plus one with each hash, and prev the
+	// previous position plus one with the hash of each position of the
+	// window, both as offsets in buf.
+	head []int32
+	prev []int32
+	// lastDistance is the distance of the last match with a distance code.
+	lastDistance int
+
+	bw       bitWriter
+	commands []command
+	literals *prefixCode
+	lengths  *prefixCode
+	dists    *prefixCode
+	scratch  scratch
+	closed   bool
+}
+
+// NewWriterLevel returns a Writer compressing to w at level, from
+// BestSpeed to BestCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, fmt.Errorf("brotli: invalid compression level: %d", level)
+	}
+	z := &Writer{
+		level:    level,
+		head:     make([]int32, 1<<hashBits),
+		prev:     make([]int32, windowSize),
+		literals: newPrefixCode(256),
+		lengths:  newPrefixCode(704),
+		dists:    newPrefixCode(64),
+	}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the state of z and makes it write a new stream to w, at
+// its level.
+func (z *Writer) Reset(w io.Writer) {
+	z.w, z.err, z.closed = w, nil, false
+	z.buf, z.pos = z.buf[:0], 0
+	clear(z.head)
+	// The distance ring buffer starts with 4 as the last distance.
+	z.lastDistance = 4
+	z.bw.reset()
+	// The window size: 1 and then WBITS - 17 in three bits.
+	z.bw.write(1, 1)
+	z.bw.write(3, windowBits-17)
+}
+
+// Write compresses p, coding it once a meta-block of input is buffered.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, fmt.Errorf("brotli: write after close")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), blockSize-(len(z.buf)-z.pos))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+		if len(z.buf)-z.pos == blockSize {
+			if err := z.code(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush codes the buffered input and writes it out to the byte, ending
+// it with an empty metadata block, so that what has been written so far
+// can be decompressed.
+func (z *Writer) Flush() error {
+	if z.err != nil || z.closed {
+		return z.err
+	}
+	if err := z.code(); err != nil {
+		return err
+	}
+	// An empty metadata block: ISLAST 0, MNIBBLES 0, a reserved bit and
+	// MSKIPBYTES 0, then padding.
+	z.bw.write(1, 0)
+	z.bw.write(2, 3)
+	z.bw.write(1, 0)
+	z.bw.write(2, 0)
+	z.bw.align()
+	return z.output()
+}
+
+// Close codes the buffered input and ends the stream, without closing the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil || z.closed {
+		return z.err
+	}
+	if err := z.code(); err != nil {
+		return err
+	}
+	z.closed = true
+	// An empty last meta-block: ISLAST and ISLASTEMPTY.
+	z.bw.write(1, 1)
+	z.bw.write(1, 1)
+	z.bw.align()
+	return z.output()
+}
+
+// code codes the input still to be coded as a meta-block and writes out
+// the whole bytes of it.
+func (z *Writer) code() error {
+	if z.pos == len(z.buf) {
+		return nil
+	}
+	z.codeBlock(z.pos, len(z.buf))
+	z.pos = len(z.buf)
+	if z.pos >= 3*windowSize {
+		z.slide()
+	}
+	return z.output()
+}
+
+func (z *Writer) output() error {
+	if len(z.bw.out) == 0 {
+		return nil
+	}
+	_, z.err = z.w.Write(z.bw.out)
+	z.bw.out = z.bw.out[:0]
+	return z.err
+}
+
+// slide drops the input before the window of the input still to be
+// coded, by a multiple of the window size so that the positions keep
+// their entries in prev.
+func (z *Writer) slide() {
+	shift := (z.pos - windowSize) &^ (windowSize - 1)
+	z.buf = z.buf[:copy(z.buf, z.buf[shift:])]
+	z.pos -= shift
+	for _, table := range [][]int32{z.head, z.prev} {
+		for i, v := range table {
+			table[i] = max(v-int32(shift), 0)
+		}
+	}
+}
+
+// command inserts literals and then copies an earlier part of the output,
+// unless it ends the meta-block.
+type command struct {
+	insert, copy int
+	// distance is how far back the copy is, 0 after the last command.
+	distance int
+	// code is the insert-and-copy length code, and distanceSym the distance
+	// code unless the command implies the last distance, or is the last.
+	code        uint16
+	distanceSym int16
+}
+
+func hash(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b) * 0x1e35a7bd >> (32 - hashBits)
+}
+
+// insertHash adds the position i to the hash chains.
+func (z *Writer) insertHash(i int) {
+	h := hash(z.buf[i:])
+	z.prev[i&(windowSize-1)] = z.head[h]
+	z.head[h] = int32(i + 1)
+}
+
+// score rates a match of the length at the distance, by the bits it saves,
+// preferring a match at the last distance, which needs no distance code.
+func (z *Writer) score(length, distance int) int {
+	if distance == z.lastDistance {
+		return 135*length + 15
+	}
+	return 135*length - 30*bits.Len(uint(distance))
+}
+
+// longestMatch returns the best scored match for the input at i, not
+// hashed yet, of those ending by end, or a length of 0 if there is none.
+func (z *Writer) longestMatch(i, end int) (length, distance int) {
+	buf := z.buf[:end]
+	best := 0
+	if d := z.lastDistance; d <= i && d <= maxDistance {
+		if l := matchLength(buf, i-d, i); l >= minLastDistanceMatch {
+			length, distance, best = l, d, z.score(l, d)
+		}
+	}
+	if i+minMatch > end {
+		return length, distance
+	}
+	level := levels[z.level]
+	cand := int(z.head[hash(buf[i:])]) - 1
+	for chain := level.chain; chain > 0 && cand >= 0 && cand < i && length < level.nice; chain-- {
+		d := i - cand
+		if d > maxDistance {
+			break
+		}
+		// Only candidates longer than the best match so far are measured.
+		if length == 0 || i+length < end && buf[cand+length] == buf[i+length] {
+			if l := matchLength(buf, cand, i); l >= minMatch {
+				if s := z.score(l, d); s > best {
+					length, distance, best = l, d, s
+				}
+			}
+		}
+		next := int(z.prev[cand&(windowSize-1)]) - 1
+		if next >= cand {
+			break
+		}
+		cand = next
+	}
+	return length, distance
+}
+
+// matchLength returns how many bytes from j on equal those from i < j.
+func matchLength(buf []byte, i, j int) int {
+	n := 0
+	for j+n+8 <= len(buf) {
+		if x := binary.LittleEndian.Uint64(buf[i+n:]) ^ binary.LittleEndian.Uint64(buf[j+n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for j+n < len(buf) && buf[i+n] == buf[j+n] {
+		n++
+	}
+	return n
+}
+
+// findCommands sets z.commands to those coding the input from start to end.
+func (z *Writer) findCommands(start, end int) {
+	z.commands = z.commands[:0]
+	hashEnd := end - minMatch + 1
+	level := levels[z.level]
+	literals := start
+	for i := start; i < end; {
+		length, distance := z.longestMatch(i, end)
+		if i < hashEnd {
+			z.insertHash(i)
+		}
+		if length == 0 {
+			i++
+			continue
+		}
+		// Give the match up for a better one at the next position, which is
+		// attempted as long as it pays for the literal it takes.
+		for level.lazy && i+1 < end && length < 1<<10 {
+			l, d := z.longestMatch(i+1, end)
+			if l == 0 || z.score(l, d) < z.score(length, distance)+175 {
+				break
+			}
+			i++
+			if i < hashEnd {
+				z.insertHash(i)
+			}
+			length, distance = l, d
+		}
+		z.commands = append(z.commands, command{insert: i - literals, copy: length, distance: distance})
+		z.lastDistance = distance
+		for j := i + 1; j < min(i+length, i+level.hashed, hashEnd); j++ {
+			z.insertHash(j)
+		}
+		i += length
+		literals = i
+	}
+	if literals < end {
+		z.commands = append(z.commands, command{insert: end - literals})
+	}
+}
+
+// The prefixes of insert lengths by their codes and their numbers of extra
+// bits, and those of copy lengths.
+var (
+	insertOffsets = [24]int{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	insertBits    = [24]uint{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	copyOffsets   = [24]int{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	copyBits      = [24]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+)
+
+// commandCodeBases are the first insert-and-copy length codes of the
+// combinations of ranges of eight insert and copy length codes, which need
+// a distance code.
+var commandCodeBases = [3][3]uint16{{128, 192, 384}, {256, 320, 512}, {448, 576, 640}}
+
+// insertLengthCode returns the code of an insert length.
+func insertLengthCode(n int) int {
+	switch {
+	case n < 6:
+		return n
+	case n < 130:
+		nbits := bits.Len(uint(n-2)) - 2
+		return nbits<<1 + (n-2)>>nbits + 2
+	case n < 2114:
+		return bits.Len(uint(n-66)) + 9
+	case n < 6210:
+		return 21
+	case n < 22594:
+		return 22
+	}
+	return 23
+}
+
+// copyLengthCode returns the code of a copy length.
+func copyLengthCode(n int) int {
+	switch {
+	case n < 10:
+		return n - 2
+	case n < 134:
+		nbits := bits.Len(uint(n-6)) - 2
+		return nbits<<1 + (n-6)>>nbits + 4
+	case n < 2118:
+		return bits.Len(uint(n-70)) + 11
+	}
+	return 23
+}
+
+// commandCode returns the insert-and-copy length code of the insert and
+// copy length codes, implying the last distance with lastDistance if
+// there's such a code.
+func commandCode(insertCode, copyCode int, lastDistance bool) uint16 {
+	if lastDistance && insertCode < 8 && copyCode < 16 {
+		return uint16(copyCode>>3)<<6 | uint16(insertCode)<<3 | uint16(copyCode&7)
+	}
+	return commandCodeBases[insertCode>>3][copyCode>>3] | uint16(insertCode&7)<<3 | uint16(copyCode&7)
+}
+
+// distanceCode returns the distance code of a distance, without direct
+// codes or postfix bits, and its extra bits.
+func distanceCode(distance int) (sym int, extra uint64, nbits uint) {
+	x := distance + 3
+	nbits = uint(bits.Len(uint(x)) - 2)
+	return 16 + 2*(int(nbits)-1) + x>>nbits - 2, uint64(x & (1<<nbits - 1)), nbits
+}
+
+// codeBlock codes the input from start to end as a meta-block, compressed
+// unless that takes more space than the input itself.
+func (z *Writer) codeBlock(start, end int) {
+	lastDistance := z.lastDistance
+	z.findCommands(start, end)
+
+	var literalHist [256]uint32
+	var lengthHist [704]uint32
+	var distHist [64]uint32
+	prevDistance := lastDistance
+	i := start
+	for k := range z.commands {
+		c := &z.commands[k]
+		for _, b := range z.buf[i : i+c.insert] {
+			literalHist[b]++
+		}
+		i += c.insert + c.copy
+		insertCode, copyCode := insertLengthCode(c.insert), 0
+		if c.distance == 0 {
+			c.code = commandCode(insertCode, copyCode, true)
+			lengthHist[c.code]++
+			continue
+		}
+		copyCode = copyLengthCode(c.copy)
+		c.code = commandCode(insertCode, copyCode, c.distance == prevDistance)
+		c.distanceSym = -1
+		if c.code >= 128 {
+			if c.distance == prevDistance {
+				c.distanceSym = 0
+			} else {
+				sym, _, _ := distanceCode(c.distance)
+				c.distanceSym = int16(sym)
+			}
+			distHist[c.distanceSym]++
+		}
+		lengthHist[c.code]++
+		prevDistance = c.distance
+	}
+
+	mark := z.bw.mark()
+	z.bw.write(1, 0)
+	writeLength(&z.bw, end-start)
+	z.bw.write(1, 0)
+	// One block type of literals, insert-and-copy lengths and distances,
+	// NPOSTFIX 0, NDIRECT 0, the context mode and one prefix code of
+	// literals and distances, without context maps.
+	z.bw.write(3, 0)
+	z.bw.write(6, 0)
+	z.bw.write(2, 0)
+	z.bw.write(2, 0)
+	writePrefixCode(&z.bw, literalHist[:], 8, z.literals, &z.scratch)
+	writePrefixCode(&z.bw, lengthHist[:], 10, z.lengths, &z.scratch)
+	writePrefixCode(&z.bw, distHist[:], 6, z.dists, &z.scratch)
+
+	i = start
+	for _, c := range z.commands {
+		z.lengths.write(&z.bw, int(c.code))
+		insertCode := insertLengthCode(c.insert)
+		z.bw.write(insertBits[insertCode], uint64(c.insert-insertOffsets[insertCode]))
+		if c.distance == 0 {
+			// The copy length code 0 has no extra bits.
+			z.bw.write(0, 0)
+		} else {
+			copyCode := copyLengthCode(c.copy)
+			z.bw.write(copyBits[copyCode], uint64(c.copy-copyOffsets[copyCode]))
+		}
+		for _, b := range z.buf[i : i+c.insert] {
+			z.literals.write(&z.bw, int(b))
+		}
+		i += c.insert + c.copy
+		if c.distanceSym >= 0 && c.distance != 0 {
+			z.dists.write(&z.bw, int(c.distanceSym))
+			if c.distanceSym >= 16 {
+				_, extra, nbits := distanceCode(c.distance)
+				z.bw.write(nbits, extra)
+			}
+		}
+	}
+
+	if z.bw.since(mark) <= 8*(end-start)+32 {
+		return
+	}
+	z.bw.restore(mark)
+	z.lastDistance = lastDistance
+	z.bw.write(1, 0)
+	writeLength(&z.bw, end-start)
+	z.bw.write(1, 1)
+	z.bw.align()
+	z.bw.out = append(z.bw.out, z.buf[start:end]...)
+}
+
+// writeLength writes the length of a meta-block, MLEN - 1 in the fewest
+// nibbles.
+func writeLength(b *bitWriter, n int) {
+	nibbles := 4
+	for n-1 >= 1<<(4*nibbles) {
+		nibbles++
+	}
+	b.write(2, uint64(nibbles-4))
+	b.write(uint(4*nibbles), uint64(n-1))
+}
+
+// bitWriter writes bits starting with the least significant bit of each
+// byte.
+type bitWriter struct {
+	out  []byte
+	bits uint64
+	n    uint
+}
+
+func (b *bitWriter) reset() {
+	b.out, b.bits, b.n = b.out[:0], 0, 0
+}
+
+// write writes the n < 32 low bits of v.
+func (b *bitWriter) write(n uint, v uint64) {
+	b.bits |= v << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.n -= 8
+	}
+}
+
+// align pads the bits written to a whole byte with zeros.
+func (b *bitWriter) align() {
+	if b.n > 0 {
+		b.write(8-b.n, 0)
+	}
+}
+
+// bitMark is a position of a bitWriter to return to.
+type bitMark struct {
+	len  int
+	bits uint64
+	n    uint
+}
+
+func (b *bitWriter) mark() bitMark { return bitMark{len(b.out), b.bits, b.n} }
+
+func (b *bitWriter) restore(m bitMark) { b.out, b.bits, b.n = b.out[:m.len], m.bits, m.n }
+
+// since returns the number of bits written since m.
+func (b *bitWriter) since(m bitMark) int {
+	return 8*(len(b.out)-m.len) + int(b.n) - int(m.n)
+}
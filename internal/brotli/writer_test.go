@@ -0,0 +1,190 @@
+package brotli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// listingSlots is the start of a listing, as the reference encoder
+// compressed it in the streams of TestDecodeReference.
+const listingSlots = `{"start":"2026-01-12T00:00:00+01:00","price":101.5},{"start":"2026-01-12T01:00:00+01:00","price":102.25},{"start":"2026-01-12T02:00:00+01:00","price":99.75}`
+
+// TestDecodeReference checks the decoder the Writer is tested with on
+// streams of the reference encoder, at quality 0 to 2.
+func TestDecodeReference(t *testing.T) {
+	for _, stream := range []string{
+		"8b4d000080aaaaaaeaff74e7ab2ec022006a06c697b31e2e0a0a7ad09b81aade0cecffaefaf7dc5bdd33aacbdb18ee8a170b0b0b1b1bab7a99511d7cf8f06330188ce34c56d56030180c068339d419918ad2281c16da49f69ee0cb1ff669eb4070fa9568c9bc92dac157034d2422de35fb808c96cb1fc3bde8e65e913d7bc0d7b8bb1727cf93eff20f",
+		"8b4d000080aaaaaaeaff74e7aba90ab030809a82cae56c878b82821dec66a0a63703055bdcc5bc98a261986040f8b031e6b84264fce35b7d2e04449d999e5d1719aaf4a0078984773ee34638ddca4e1dcedde8e0640a3b0a7cad592d7b",
+		"1b9b000080aaaaaaeaff74e7aba92a3033809a82cae56c878b81811dec66a0a637031d8083b1201293c40077da44f68f6f9e6322607acf54960f32902ac546058984773cd78d1055ea4afa9191ecccc528195893de4bab0b",
+	} {
+		b, err := hex.DecodeString(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := decode(b); err != nil || string(got) != listingSlots {
+			t.Errorf("decoded %q (%v), want %q", got, err, listingSlots)
+		}
+	}
+}
+
+// testInputs are inputs compressing in different ways: not at all, to
+// almost nothing, or like listings.
+func testInputs() map[string][]byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	random := make([]byte, 100000)
+	for i := range random {
+		random[i] = byte(r.IntN(256))
+	}
+	few := make([]byte, 1000)
+	for i := range few {
+		few[i] = "ab"[r.IntN(2)]
+	}
+	var listing bytes.Buffer
+	for i := range 20000 {
+		fmt.Fprintf(&listing, `{"start":"2026-01-%02dT%02d:00:00+01:00","price":%.2f},`, i/24%28+1, i%24, float64(r.IntN(20000))/100-20)
+	}
+	return map[string][]byte{
+		"empty":       nil,
+		"byte":        []byte("a"),
+		"repeated":    bytes.Repeat([]byte("abc"), 10),
+		"few symbols": few,
+		"random":      random,
+		"zeros":       make([]byte, 3*blockSize+1),
+		// Longer than three windows, so that the window slides.
+		"listing": listing.Bytes(),
+	}
+}
+
+// TestWriter checks that what is written decompresses to the input at
+// each level, and that incompressible input takes hardly more space.
+func TestWriter(t *testing.T) {
+	for name, in := range testInputs() {
+		for level := BestSpeed; level <= BestCompression; level++ {
+			t.Run(fmt.Sprintf("%s/level=%d", name, level), func(t *testing.T) {
+				var b bytes.Buffer
+				z, err := NewWriterLevel(&b, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Write in pieces not aligned with the meta-blocks.
+				for p := in; len(p) > 0; {
+					n := min(len(p), 10007)
+					if _, err := z.Write(p[:n]); err != nil {
+						t.Fatal(err)
+					}
+					p = p[n:]
+				}
+				if err := z.Close(); err != nil {
+					t.Fatal(err)
+				}
+				got, err := decode(b.Bytes())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, in) {
+					t.Fatalf("decompressed %d bytes differing from the %d written", len(got), len(in))
+				}
+				if max := len(in) + len(in)/blockSize*8 + 8; b.Len() > max {
+					t.Errorf("compressed %d bytes to %d, more than %d", len(in), b.Len(), max)
+				}
+			})
+		}
+	}
+}
+
+// TestWriterFlush checks that what is written before a flush can be
+// decompressed without the rest of the stream.
+func TestWriterFlush(t *testing.T) {
+	in := testInputs()["listing"]
+	var b bytes.Buffer
+	z, err := NewWriterLevel(&b, DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := 0
+	for _, n := range []int{100, 3 * blockSize, len(in)} {
+		if _, err := z.Write(in[written:n]); err != nil {
+			t.Fatal(err)
+		}
+		written = n
+		if err := z.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		// The flushed stream ends on a byte, where an empty last meta-block
+		// ends it: the bits ISLAST and ISLASTEMPTY.
+		got, err := decode(append(bytes.Clone(b.Bytes()), 0b11))
+		if err != nil {
+			t.Fatalf("after flushing %d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, in[:n]) {
+			t.Fatalf("after flushing %d bytes, decompressed %d", n, len(got))
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decode(b.Bytes()); err != nil || !bytes.Equal(got, in) {
+		t.Errorf("decompressed %d bytes (%v), want %d", len(got), err, len(in))
+	}
+}
+
+// TestWriterReset checks that a reset Writer writes the same stream as a
+// new one.
+func TestWriterReset(t *testing.T) {
+	inputs := testInputs()
+	var reused bytes.Buffer
+	z, err := NewWriterLevel(&reused, BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Write(inputs["random"])
+	z.Close()
+	for _, name := range []string{"listing", "repeated", "listing"} {
+		reused.Reset()
+		z.Reset(&reused)
+		z.Write(inputs[name])
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var fresh bytes.Buffer
+		zf, _ := NewWriterLevel(&fresh, BestCompression)
+		zf.Write(inputs[name])
+		zf.Close()
+		if !bytes.Equal(reused.Bytes(), fresh.Bytes()) {
+			t.Errorf("%s: reset Writer wrote %d bytes, a new one %d", name, reused.Len(), fresh.Len())
+		}
+	}
+}
+
+func TestNewWriterLevel(t *testing.T) {
+	for _, level := range []int{-1, 0, 12} {
+		if _, err := NewWriterLevel(&bytes.Buffer{}, level); err == nil {
+			t.Errorf("level %d accepted", level)
+		}
+	}
+}
+
+// BenchmarkWriter compresses a listing at each level, reporting the
+// compressed size.
+func BenchmarkWriter(b *testing.B) {
+	in := testInputs()["listing"]
+	for _, level := range []int{BestSpeed, 4, DefaultCompression, 9, BestCompression} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			var out bytes.Buffer
+			z, _ := NewWriterLevel(&out, level)
+			b.ResetTimer()
+			b.SetBytes(int64(len(in)))
+			b.ReportAllocs()
+			for range b.N {
+				out.Reset()
+				z.Reset(&out)
+				z.Write(in)
+				z.Close()
+			}
+			b.ReportMetric(float64(out.Len()), "bytes/op")
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package mockupstream serves the day-ahead prices of the energy-charts API
+// from generated or fixture data, so that the service can be run and tested
+// without the real API. It serves GET /price in the shape of
+// https://api.energy-charts.info, with start and end parameters and
+// conditional requests by ETag, and can be made slow, unreliable, late with
+// the next day's prices or marked deprecated.
+package mockupstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Zone is the only bidding zone served.
+const Zone = "DE-LU"
+
+// quarterHourlyFrom is when the generated prices change from hourly to
+// quarter-hourly slots, like those of the market did, at the Europe/Berlin
+// midnight starting 2025-10-01.
+var quarterHourlyFrom = time.Date(2025, time.September, 30, 22, 0, 0, 0, time.UTC)
+
+// historyStart is the start of the generated prices.
+var historyStart = time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+// Prices are prices in the shape of the API's responses, as read from
+// fixture files.
+type Prices struct {
+	Timestamps []int64   `json:"unix_seconds"`
+	Prices     []float64 `json:"price"`
+}
+
+// LoadFixture reads prices from a file in the shape of the API's responses,
+// such as a recorded one.
+func LoadFixture(path string) (*Prices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Prices
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("error parsing fixture %s: %w", path, err)
+	}
+	if len(p.Timestamps) != len(p.Prices) {
+		return nil, fmt.Errorf("fixture %s has unequal numbers of timestamps and prices", path)
+	}
+	return &p, nil
+}
+
+// Config configures the mock. The zero value serves generated prices
+// without delay or errors, publishing the next day's at midnight.
+type Config struct {
+	// Fixture replaces the generated prices if set.
+	Fixture *Prices
+	// Latency delays every response.
+	Latency time.Duration
+	// ErrorRate is the fraction of requests answered with ErrorStatus, 503
+	// if zero.
+	ErrorRate   float64
+	ErrorStatus int
+	// PublishAt is the time after Europe/Berlin midnight from which the
+	// next day's prices are served. MissingTomorrow never serves them.
+	PublishAt       time.Duration
+	MissingTomorrow bool
+	// Deprecated marks the responses as of a deprecated API.
+	Deprecated bool
+	// Unit is the unit of the prices, "EUR / MWh" if empty.
+	Unit string
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// Mock is the handler of the mock API.
+type Mock struct {
+	cfg Config
+	loc *time.Location
+}
+
+// New returns a mock configured by cfg.
+func New(cfg Config) (*Mock, error) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ErrorStatus == 0 {
+		cfg.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if cfg.Unit == "" {
+		cfg.Unit = "EUR / MWh"
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Mock{cfg: cfg, loc: loc}, nil
+}
+
+func (m *Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.RequestURI())
+	if r.URL.Path != "/price" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := sleep(r.Context(), m.cfg.Latency); err != nil {
+		return
+	}
+	if m.cfg.ErrorRate > 0 && rand.Float64() < m.cfg.ErrorRate {
+		http.Error(w, "injected error", m.cfg.ErrorStatus)
+		return
+	}
+
+	q := r.URL.Query()
+	if bzn := q.Get("bzn"); bzn != "" && bzn != Zone {
+		http.Error(w, fmt.Sprintf("unknown bidding zone %q, only %s is served", bzn, Zone), http.StatusBadRequest)
+		return
+	}
+	now := m.cfg.Now().In(m.loc)
+	y, mo, d := now.Date()
+	today := time.Date(y, mo, d, 0, 0, 0, 0, m.loc)
+	start, end := today, today.AddDate(0, 0, 1)
+	var err error
+	if v := q.Get("start"); v != "" {
+		if start, err = m.parseTime(v); err != nil {
+			http.Error(w, "start: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("end"); v != "" {
+		if end, err = m.parseTime(v); err != nil {
+			http.Error(w, "end: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	published := today.AddDate(0, 0, 1)
+	if !m.cfg.MissingTomorrow && now.Sub(today) >= m.cfg.PublishAt {
+		published = today.AddDate(0, 0, 2)
+	}
+	if end.After(published) {
+		end = published
+	}
+
+	timestamps, prices := m.prices(start, end)
+	body, err := json.Marshal(struct {
+		LicenseInfo string    `json:"license_info"`
+		Timestamps  []int64   `json:"unix_seconds"`
+		Prices      []float64 `json:"price"`
+		Unit        string    `json:"unit"`
+		Deprecated  bool      `json:"deprecated"`
+	}{
+		"CC BY 4.0 (creativecommons.org/licenses/by/4.0) from Bundesnetzagentur | SMARD.de (mock data)",
+		timestamps, prices, m.cfg.Unit, m.cfg.Deprecated,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h := fnv.New64a()
+	h.Write(body)
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// parseTime reads an RFC 3339 timestamp or a date, which is the start of
+// that Europe/Berlin day.
+func (m *Mock) parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, v, m.loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	}
+	return t, nil
+}
+
+// prices returns the slots starting in [start, end).
+func (m *Mock) prices(start, end time.Time) ([]int64, []float64) {
+	timestamps, prices := []int64{}, []float64{}
+	if f := m.cfg.Fixture; f != nil {
+		for i, unix := range f.Timestamps {
+			if t := time.Unix(unix, 0); !t.Before(start) && t.Before(end) {
+				timestamps, prices = append(timestamps, unix), append(prices, f.Prices[i])
+			}
+		}
+		return timestamps, prices
+	}
+
+	t := start
+	if t.Before(historyStart) {
+		t = historyStart
+	}
+	step := time.Hour
+	if !t.Before(quarterHourlyFrom) {
+		step = 15 * time.Minute
+	}
+	t = t.Truncate(step)
+	if t.Before(start) {
+		t = t.Add(step)
+	}
+	for ; t.Before(end); t = t.Add(step) {
+		if step == time.Hour && !t.Before(quarterHourlyFrom) {
+			step = 15 * time.Minute
+		}
+		timestamps, prices = append(timestamps, t.Unix()), append(prices, m.generate(t))
+	}
+	return timestamps, prices
+}
+
+// generate returns a plausible price for the slot starting at t: high in
+// the morning and evening, low around noon and on weekends, with noise
+// that is the same for every request.
+func (m *Mock) generate(t time.Time) float64 {
+	local := t.In(m.loc)
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	p := 75 + 25*math.Cos(2*math.Pi*(hour-19)/24) - 35*math.Exp(-(hour-13)*(hour-13)/8)
+	if wd := local.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		p -= 15
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, t.Unix())
+	p += (float64(h.Sum64()%2001)/1000 - 1) * 12
+	return math.Round(p*100) / 100
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
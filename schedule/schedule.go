@@ -0,0 +1,207 @@
+// Package schedule implements the algorithms the service uses to decide when
+// to consume, as pure functions over price slots, so that controllers can
+// run them on prices they already have without asking the service.
+//
+// All functions take slots sorted by start that don't overlap, such as those
+// of a listing. Slots may be missing, which is a gap: windows never span
+// one, and an answer never holds across one.
+//
+// Ties between equal prices are always broken by the earlier slot.
+package schedule
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// PricePoint is a single price slot.
+type PricePoint struct {
+	Start    time.Time
+	Duration time.Duration
+	Price    float64
+}
+
+// End returns when the slot ends.
+func (p PricePoint) End() time.Time {
+	return p.Start.Add(p.Duration)
+}
+
+// contiguous reports whether b starts when a ends.
+func contiguous(a, b PricePoint) bool {
+	return b.Start.Equal(a.End())
+}
+
+// Window is a span of contiguous slots.
+type Window struct {
+	Start time.Time
+	End   time.Time
+	// Average is the price averaged over the window by time.
+	Average float64
+	// Points are the slots overlapping the window. The last one may end
+	// after the window if the duration isn't a multiple of the slots'.
+	Points []PricePoint
+}
+
+// CheapestWindow returns the window of duration d with the lowest average
+// price that starts with a slot and is covered by contiguous slots, the
+// earliest of equally cheap ones. It reports false if d isn't positive or no
+// stretch of points without gaps is long enough.
+func CheapestWindow(points []PricePoint, d time.Duration) (Window, bool) {
+	if d <= 0 {
+		return Window{}, false
+	}
+	var best Window
+	var found bool
+	// sum is the time-weighted price of points[i:j], which are contiguous
+	// and end within the window starting at points[i].
+	var sum float64
+	j := 0
+	for i, p := range points {
+		if j <= i {
+			j, sum = i, 0
+		}
+		end := p.Start.Add(d)
+		for j < len(points) && (j == i || contiguous(points[j-1], points[j])) && !points[j].End().After(end) {
+			sum += points[j].Price * points[j].Duration.Seconds()
+			j++
+		}
+
+		total, last := sum, j
+		switch {
+		case j > i && points[j-1].End().Equal(end):
+		case j < len(points) && (j == i || contiguous(points[j-1], points[j])):
+			// The window ends within points[j].
+			total += points[j].Price * end.Sub(points[j].Start).Seconds()
+			last = j + 1
+		default:
+			last = -1
+		}
+		if last >= 0 {
+			if avg := total / d.Seconds(); !found || avg < best.Average {
+				best = Window{p.Start, end, avg, points[i:last]}
+				found = true
+			}
+		}
+
+		if j > i {
+			sum -= p.Price * p.Duration.Seconds()
+		}
+	}
+	return best, found
+}
+
+// CheapestSlots returns the n cheapest points in their order, all of them
+// if there are at most n. Gaps between them don't matter.
+func CheapestSlots(points []PricePoint, n int) []PricePoint {
+	picked := cheapest(points, n)
+	slots := make([]PricePoint, 0, len(points))
+	for i, p := range points {
+		if picked[i] {
+			slots = append(slots, p)
+		}
+	}
+	return slots
+}
+
+// cheapest marks the n cheapest points.
+func cheapest(points []PricePoint, n int) []bool {
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(points[a].Price, points[b].Price)
+	})
+	picked := make([]bool, len(points))
+	for _, i := range order[:max(0, min(n, len(order)))] {
+		picked[i] = true
+	}
+	return picked
+}
+
+// A Strategy decides for every slot of a single day whether it is a good
+// time to consume.
+type Strategy func(day []PricePoint) []bool
+
+// BelowDailyMedian consumes in the slots priced below the median of their
+// day, so that about half of a day is consumed in, fewer if prices repeat.
+func BelowDailyMedian() Strategy {
+	return func(day []PricePoint) []bool {
+		prices := make([]float64, len(day))
+		for i, p := range day {
+			prices[i] = p.Price
+		}
+		slices.Sort(prices)
+		var m float64
+		if n := len(prices); n%2 == 1 {
+			m = prices[n/2]
+		} else if n > 0 {
+			m = (prices[n/2-1] + prices[n/2]) / 2
+		}
+
+		decisions := make([]bool, len(day))
+		for i, p := range day {
+			decisions[i] = p.Price < m
+		}
+		return decisions
+	}
+}
+
+// CheapestN consumes in the n cheapest slots of every day.
+func CheapestN(n int) Strategy {
+	return func(day []PricePoint) []bool {
+		return cheapest(day, n)
+	}
+}
+
+// BelowAbsolute consumes in the slots priced below threshold.
+func BelowAbsolute(threshold float64) Strategy {
+	return func(day []PricePoint) []bool {
+		decisions := make([]bool, len(day))
+		for i, p := range day {
+			decisions[i] = p.Price < threshold
+		}
+		return decisions
+	}
+}
+
+// Decision is the answer of Signal.
+type Decision struct {
+	Consume bool
+	// Until is when the answer changes, or when points end or have a gap.
+	Until time.Time
+}
+
+// Signal decides whether to consume at now by strategy, applied to the
+// points of each calendar day in now's location separately. It reports
+// false if no point covers now.
+func Signal(points []PricePoint, strategy Strategy, now time.Time) (Decision, bool) {
+	var decisions []bool
+	for start := 0; start < len(points); {
+		date := dateIn(points[start].Start, now.Location())
+		end := start + 1
+		for end < len(points) && dateIn(points[end].Start, now.Location()) == date {
+			end++
+		}
+		decisions = append(decisions, strategy(points[start:end])...)
+		start = end
+	}
+
+	cur := slices.IndexFunc(points, func(p PricePoint) bool {
+		return !now.Before(p.Start) && now.Before(p.End())
+	})
+	if cur < 0 {
+		return Decision{}, false
+	}
+	i := cur
+	for i+1 < len(points) && decisions[i+1] == decisions[cur] && contiguous(points[i], points[i+1]) {
+		i++
+	}
+	return Decision{decisions[cur], points[i].End()}, true
+}
+
+func dateIn(t time.Time, loc *time.Location) [3]int {
+	y, m, d := t.In(loc).Date()
+	return [3]int{y, int(m), d}
+}
@@ -0,0 +1,232 @@
+package schedule
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// t0 is the start of the slots built by hourly.
+var t0 = time.Date(2026, time.January, 13, 0, 0, 0, 0, time.UTC)
+
+// hourly returns contiguous hourly slots from t0 with prices.
+func hourly(prices ...float64) []PricePoint {
+	points := make([]PricePoint, len(prices))
+	for i, p := range prices {
+		points[i] = PricePoint{t0.Add(time.Duration(i) * time.Hour), time.Hour, p}
+	}
+	return points
+}
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		prices   []float64
+		want     []bool
+	}{
+		{"median odd", BelowDailyMedian(), []float64{3, 1, 2}, []bool{false, true, false}},
+		{"median even", BelowDailyMedian(), []float64{4, 1, 3, 2}, []bool{false, true, false, true}},
+		{"median repeated", BelowDailyMedian(), []float64{2, 2, 2, 1}, []bool{false, false, false, true}},
+		{"median negative", BelowDailyMedian(), []float64{-5, 10, -1}, []bool{true, false, false}},
+		{"median empty", BelowDailyMedian(), nil, []bool{}},
+		{"cheapest", CheapestN(2), []float64{3, 1, 4, 1, 5}, []bool{false, true, false, true, false}},
+		{"cheapest ties earlier", CheapestN(1), []float64{2, 1, 1}, []bool{false, true, false}},
+		{"cheapest none", CheapestN(0), []float64{1, 2}, []bool{false, false}},
+		{"cheapest more than all", CheapestN(5), []float64{1, 2}, []bool{true, true}},
+		{"cheapest negative n", CheapestN(-1), []float64{1, 2}, []bool{false, false}},
+		{"absolute", BelowAbsolute(2), []float64{1, 2, 3}, []bool{true, false, false}},
+		{"absolute negative", BelowAbsolute(0), []float64{-0.5, 0, 0.5}, []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.strategy(hourly(tt.prices...)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// randomPoints returns up to 60 sorted slots of 15 minutes or an hour from
+// t0 with occasional gaps. The prices are small integers, so that ties are
+// common and time-weighted sums are exact.
+func randomPoints(r *rand.Rand) []PricePoint {
+	points := make([]PricePoint, r.IntN(61))
+	at := t0
+	for i := range points {
+		if r.IntN(10) == 0 {
+			at = at.Add(time.Duration(1+r.IntN(12)) * 15 * time.Minute)
+		}
+		d := 15 * time.Minute
+		if r.IntN(2) == 0 {
+			d = time.Hour
+		}
+		points[i] = PricePoint{at, d, float64(r.IntN(26) - 5)}
+		at = at.Add(d)
+	}
+	return points
+}
+
+// bruteCheapestWindow tries every window of duration d starting with a slot.
+func bruteCheapestWindow(points []PricePoint, d time.Duration) (start time.Time, average float64, ok bool) {
+	for i, p := range points {
+		end := p.Start.Add(d)
+		var total float64
+		for j := i; j < len(points) && (j == i || contiguous(points[j-1], points[j])); j++ {
+			q := points[j]
+			overlap := q.Duration
+			if q.End().After(end) {
+				overlap = end.Sub(q.Start)
+			}
+			total += q.Price * overlap.Seconds()
+			if !q.End().Before(end) {
+				if avg := total / d.Seconds(); !ok || avg < average {
+					start, average, ok = p.Start, avg, true
+				}
+				break
+			}
+		}
+	}
+	return start, average, ok
+}
+
+// TestCheapestWindowProperties checks CheapestWindow on random slots against
+// trying every window: it never finds a cheaper one than the brute force,
+// nor misses one, and its points cover the window without gaps.
+func TestCheapestWindowProperties(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 97))
+	durations := []time.Duration{0, 15 * time.Minute, 30 * time.Minute, time.Hour, 90 * time.Minute, 3 * time.Hour, 8 * time.Hour}
+	for range 2000 {
+		points := randomPoints(r)
+		d := durations[r.IntN(len(durations))]
+		w, ok := CheapestWindow(points, d)
+		start, average, want := bruteCheapestWindow(points, d)
+		if d <= 0 {
+			want = false
+		}
+		if ok != want {
+			t.Fatalf("%v for %s: found %t, want %t", points, d, ok, want)
+		}
+		if !ok {
+			continue
+		}
+		if w.Average != average || !w.Start.Equal(start) {
+			t.Fatalf("%v for %s: window at %s averaging %g, want %s averaging %g", points, d, w.Start, w.Average, start, average)
+		}
+		if !w.End.Equal(w.Start.Add(d)) {
+			t.Fatalf("window %s to %s isn't %s long", w.Start, w.End, d)
+		}
+		if !w.Points[0].Start.Equal(w.Start) || w.Points[len(w.Points)-1].End().Before(w.End) {
+			t.Fatalf("points %v don't cover the window %s to %s", w.Points, w.Start, w.End)
+		}
+		if n := len(w.Points); n > 1 && !w.Points[n-2].End().Before(w.End) {
+			t.Fatalf("points %v extend beyond the window %s to %s", w.Points, w.Start, w.End)
+		}
+		lo, hi := w.Points[0].Price, w.Points[0].Price
+		for i, p := range w.Points {
+			if i > 0 && !contiguous(w.Points[i-1], p) {
+				t.Fatalf("points %v of the window have a gap", w.Points)
+			}
+			lo, hi = min(lo, p.Price), max(hi, p.Price)
+		}
+		if w.Average < lo || w.Average > hi {
+			t.Fatalf("average %g outside the prices %g to %g of the window", w.Average, lo, hi)
+		}
+	}
+}
+
+// TestCheapestSlotsProperties checks on random slots that CheapestSlots
+// picks min(n, len) points in their order, none priced above one left out,
+// and of equally priced ones the earlier.
+func TestCheapestSlotsProperties(t *testing.T) {
+	r := rand.New(rand.NewPCG(2, 97))
+	for range 2000 {
+		points := randomPoints(r)
+		n := r.IntN(len(points)+5) - 2
+		got := CheapestSlots(points, n)
+		if want := max(0, min(n, len(points))); len(got) != want {
+			t.Fatalf("%d of %d points for n = %d, want %d", len(got), len(points), n, want)
+		}
+
+		picked := make([]bool, len(points))
+		i := 0
+		for _, p := range got {
+			for i < len(points) && points[i] != p {
+				i++
+			}
+			if i == len(points) {
+				t.Fatalf("%v isn't a subsequence of %v", got, points)
+			}
+			picked[i] = true
+			i++
+		}
+		for i, p := range points {
+			for j, q := range points {
+				if picked[i] && !picked[j] && (p.Price > q.Price || p.Price == q.Price && i > j) {
+					t.Fatalf("picked %v over %v", p, q)
+				}
+			}
+		}
+	}
+}
+
+// TestSignalProperties checks Signal at random times over random slots
+// against the strategy's decisions for each day: the answer is the
+// decision of the slot covering now, and Until ends the run of slots from
+// it with that decision that are contiguous.
+func TestSignalProperties(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 97))
+	// The days of a zone east of UTC start in the evening before t0.
+	loc := time.FixedZone("UTC+5", 5*60*60)
+	strategies := []struct {
+		name     string
+		strategy Strategy
+	}{
+		{"median", BelowDailyMedian()},
+		{"cheapest", CheapestN(3)},
+		{"absolute", BelowAbsolute(5)},
+	}
+	for _, tt := range strategies {
+		strategy := tt.strategy
+		t.Run(tt.name, func(t *testing.T) {
+			for range 1000 {
+				points := randomPoints(r)
+				decisions := make([]bool, len(points))
+				for start := 0; start < len(points); {
+					end := start
+					for end < len(points) && dateIn(points[end].Start, loc) == dateIn(points[start].Start, loc) {
+						end++
+					}
+					copy(decisions[start:], strategy(points[start:end]))
+					start = end
+				}
+
+				now := t0.Add(time.Duration(r.IntN(24*60)-60) * time.Minute).In(loc)
+				got, ok := Signal(points, strategy, now)
+				cur := slices.IndexFunc(points, func(p PricePoint) bool {
+					return !now.Before(p.Start) && now.Before(p.End())
+				})
+				if ok != (cur >= 0) {
+					t.Fatalf("%v at %s: found %t, want %t", points, now, ok, cur >= 0)
+				}
+				if !ok {
+					continue
+				}
+				if got.Consume != decisions[cur] {
+					t.Fatalf("%v at %s: consume %t, want %t", points, now, got.Consume, decisions[cur])
+				}
+				last := cur
+				for !points[last].End().Equal(got.Until) {
+					if last+1 == len(points) || !contiguous(points[last], points[last+1]) || decisions[last+1] != got.Consume {
+						t.Fatalf("%v at %s: until %s, past the run of slots from %s", points, now, got.Until, points[cur].Start)
+					}
+					last++
+				}
+				if last+1 < len(points) && contiguous(points[last], points[last+1]) && decisions[last+1] == got.Consume {
+					t.Fatalf("%v at %s: until %s, before the run of slots from %s ends", points, now, got.Until, points[cur].Start)
+				}
+			}
+		})
+	}
+}